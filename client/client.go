@@ -2,6 +2,7 @@ package client
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
 	"net"
@@ -9,6 +10,7 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -19,24 +21,169 @@ import (
 	"github.com/heewa/bento/server"
 )
 
-// Client handles communicating with a Server
+// resumableMethods are RPC methods that are safe to retry on a new connection
+// if one drops in the middle of a call, because they don't change anything.
+// Calls that are already resumable by args, like Tail, pick up where they
+// left off.
+var resumableMethods = map[string]bool{
+	"Server.Version":    true,
+	"Server.ServerInfo": true,
+	"Server.List":       true,
+	"Server.Info":       true,
+	"Server.Tail":       true,
+	"Server.Wait":       true,
+
+	"Server.LoadProgress": true,
+	"Server.StopProgress": true,
+}
+
+// longRunningMethods are resumable methods that can go on for a long time,
+// like following output or waiting on a service, so they keep trying to
+// reconnect, like while the server is restarted, instead of only once.
+var longRunningMethods = map[string]bool{
+	"Server.Tail": true,
+	"Server.Wait": true,
+
+	"Server.LoadProgress": true,
+	"Server.StopProgress": true,
+}
+
+// ReconnectPolicy is how a Client reconnects when the connection drops during
+// a call.
+type ReconnectPolicy struct {
+	// Attempts is how many times a long-running call tries to reconnect,
+	// while other calls only try once. 0 means never reconnect.
+	Attempts int
+
+	// The wait between attempts starts at MinDelay, and doubles each time,
+	// up to MaxDelay
+	MinDelay time.Duration
+	MaxDelay time.Duration
+}
+
+// DefaultReconnectPolicy keeps trying for about half a minute, long enough
+// for a server to be restarted.
+var DefaultReconnectPolicy = ReconnectPolicy{
+	Attempts: 10,
+	MinDelay: 250 * time.Millisecond,
+	MaxDelay: 5 * time.Second,
+}
+
+// Client handles communicating with a Server. It keeps one connection open
+// across calls, which can be made concurrently, and reconnects if the
+// connection drops.
 type Client struct {
+	conn *connection
+
+	// If set, calls give up when it's done, see WithContext
+	ctx context.Context
+
+	// Reconnect is how calls reconnect when the connection drops
+	Reconnect ReconnectPolicy
+
+	// Notes is where notes for a person go, like about the client & server
+	// versions not matching, or output from a server it started. If nil,
+	// they're dropped.
+	Notes io.Writer
+}
+
+// connection is the connection to a server, shared by a Client and the ones
+// made from it with WithContext.
+type connection struct {
+	// Locks the connection, which can be replaced on reconnect, but not
+	// concurrent use of it, which rpc.Client handles.
+	lock   sync.RWMutex
 	client *rpc.Client
 
-	// ServerVersion is reported by the server from an RPC call right after
-	// connect
-	ServerVersion semver.Version
+	// Address of the server's socket, and how to connect to it
+	address string
+	dial    func() (net.Conn, error)
+
+	// Reported by the server from an RPC call right after connect
+	serverVersion semver.Version
+
+	// RPC methods the server said it supports, or nil if it's too old to say
+	serverMethods map[string]bool
 }
 
-// New creates a new Client
+// New creates a new Client for the server that config says to use, which can
+// start it if it's not running (see Connect).
 func New() (*Client, error) {
 	// Resolve the net address to make sure it's valid
-	_, err := net.ResolveUnixAddr("unix", config.FifoPath)
+	_, err := net.ResolveUnixAddr("unix", config.SocketAddress())
 	if err != nil {
 		return nil, fmt.Errorf("Bad fifo path: %v", err)
 	}
 
-	return &Client{}, nil
+	return &Client{
+		conn: &connection{
+			address: config.SocketAddress(),
+			dial:    unixDialer(config.SocketAddress()),
+		},
+		Reconnect: DefaultReconnectPolicy,
+	}, nil
+}
+
+// Dial connects a new Client to a server that's already running, listening
+// on a unix socket at an address, without starting one.
+func Dial(address string) (*Client, error) {
+	if _, err := net.ResolveUnixAddr("unix", address); err != nil {
+		return nil, fmt.Errorf("Bad socket address: %v", err)
+	}
+
+	c, err := DialWith(unixDialer(address))
+	if err != nil {
+		return nil, err
+	}
+	c.conn.address = address
+
+	return c, nil
+}
+
+// DialWith connects a new Client to a server over connections from dial,
+// which is called again to reconnect. It's for servers that aren't on a unix
+// socket, like an in-memory one in tests, see the clienttest package.
+func DialWith(dial func() (net.Conn, error)) (*Client, error) {
+	c := &Client{
+		conn:      &connection{dial: dial},
+		Reconnect: DefaultReconnectPolicy,
+	}
+	if _, err := c.reconnect(nil); err != nil {
+		return nil, fmt.Errorf("Failed to connect to server: %v", err)
+	}
+
+	return c, nil
+}
+
+// unixDialer makes a dial func for a unix socket at an address.
+func unixDialer(address string) func() (net.Conn, error) {
+	return func() (net.Conn, error) {
+		return net.Dial("unix", address)
+	}
+}
+
+// WithContext gets a Client sharing this one's connection, whose calls give
+// up when ctx is done. Long-running calls, like following output with Tail,
+// stop then too.
+func (c *Client) WithContext(ctx context.Context) *Client {
+	copied := *c
+	copied.ctx = ctx
+	return &copied
+}
+
+// ServerVersion is the version the server reported when connecting.
+func (c *Client) ServerVersion() semver.Version {
+	c.conn.lock.RLock()
+	defer c.conn.lock.RUnlock()
+
+	return c.conn.serverVersion
+}
+
+// note writes a note for a person, if there's somewhere to write it.
+func (c *Client) note(format string, args ...interface{}) {
+	if c.Notes != nil {
+		fmt.Fprintf(c.Notes, format+"\n", args...)
+	}
 }
 
 // Connect tries to connect to a server. If startServer is true, and
@@ -48,31 +195,58 @@ func (c *Client) Connect(startServer bool) error {
 	// block, do it in a goroutine for correct timeout behavior
 	clientChan := make(chan *rpc.Client)
 
+	// Closed once Connect returns, so trying stops, and a client connected too
+	// late isn't left open
+	gaveUp := make(chan struct{})
+	defer close(gaveUp)
+	send := func(client *rpc.Client) {
+		select {
+		case clientChan <- client:
+		case <-gaveUp:
+			if client != nil {
+				client.Close()
+			}
+		}
+	}
+
 	log.Debug("Connecting to server")
 	go func() {
 		// Try to connect if fifo exists
-		if _, err := os.Stat(config.FifoPath); err == nil {
-			client, err := rpc.Dial("unix", config.FifoPath)
+		if err := config.StatFifo(); err == nil {
+			client, err := rpc.Dial("unix", c.conn.address)
 			if err == nil {
-				clientChan <- client
+				send(client)
 				return
 			}
 			log.Debug("Error connecting to server", "err", err)
+
+			if !startServer {
+				send(nil)
+				return
+			}
 		} else if !os.IsNotExist(err) {
 			log.Error("Problem with fifo", "err", err)
-			clientChan <- nil
+			send(nil)
 			return
 		} else if !startServer {
-			clientChan <- nil
+			send(nil)
 			return
 		}
 
 		// Pass args for config, which could have overriden file values
-		cmd := exec.Command(
-			os.Args[0],
+		args := []string{
 			"--fifo", config.FifoPath,
 			"--log", config.LogPath,
-			"init")
+		}
+		if !config.Tray {
+			args = append(args, "--no-tray")
+		}
+		if config.InstanceName != "" {
+			args = append(args, "--instance", config.InstanceName)
+		} else if config.ProjectPath != "" {
+			args = append(args, "--project", config.ProjectPath)
+		}
+		cmd := exec.Command(os.Args[0], append(args, "init")...)
 		log.Debug("Server might not running, starting one", "args", strings.Join(cmd.Args, " "))
 
 		// Set the process group ID to 0, so it'll create a new one, and
@@ -97,7 +271,7 @@ func (c *Client) Connect(startServer bool) error {
 
 		if err := cmd.Start(); err != nil {
 			log.Error("Failed to start server", "err", err)
-			clientChan <- nil
+			send(nil)
 			return
 		}
 
@@ -106,14 +280,14 @@ func (c *Client) Connect(startServer bool) error {
 
 			go func() {
 				for stdout.Scan() {
-					fmt.Println("Server: " + stdout.Text())
+					c.note("Server: %s", stdout.Text())
 				}
 				outDone <- struct{}{}
 			}()
 
 			go func() {
 				for stderr.Scan() {
-					fmt.Fprintln(os.Stderr, "Server: "+stderr.Text())
+					c.note("Server: %s", stderr.Text())
 				}
 				outDone <- struct{}{}
 			}()
@@ -123,23 +297,33 @@ func (c *Client) Connect(startServer bool) error {
 			<-outDone
 			cmd.Wait()
 
-			clientChan <- nil
+			send(nil)
 			return
 		}()
 
-		// Keep trying to connect, it might take some time
+		// Keep trying to connect, it might take some time, until Connect
+		// gives up
 		for {
-			time.Sleep(500 * time.Millisecond)
+			select {
+			case <-gaveUp:
+				return
+			case <-time.After(500 * time.Millisecond):
+			}
 
 			// Only attemp if fifo even exists
-			if _, err = os.Stat(config.FifoPath); err == nil {
-				client, err := rpc.Dial("unix", config.FifoPath)
-				if err != nil {
+			if err = config.StatFifo(); err == nil {
+				client, err := rpc.Dial("unix", c.conn.address)
+				if err != nil && config.AbstractSocket {
+					// Can't tell if an abstract socket exists without
+					// trying, so keep trying
+					continue
+				} else if err != nil {
 					log.Debug("Error connecting to server", "err", err)
 					return
 				}
 
-				clientChan <- client
+				send(client)
+				return
 			}
 		}
 	}()
@@ -152,9 +336,11 @@ func (c *Client) Connect(startServer bool) error {
 			if err := client.Call("Server.Version", false, &versionReply); err != nil {
 				return fmt.Errorf("Failed to get server version: %v", err)
 			}
-			c.ServerVersion = versionReply.Version
+			c.conn.lock.Lock()
+			defer c.conn.lock.Unlock()
 
-			c.client = client
+			c.conn.setServerVersion(versionReply)
+			c.conn.client = client
 			return nil
 		}
 	case <-time.After(5 * time.Second):
@@ -166,48 +352,235 @@ func (c *Client) Connect(startServer bool) error {
 
 // Close will end the RPC connection
 func (c *Client) Close() {
-	if c != nil && c.client != nil {
-		c.client.Close()
-		c.client = nil
+	if c == nil {
+		return
+	}
+
+	c.conn.lock.Lock()
+	defer c.conn.lock.Unlock()
+
+	if c.conn.client != nil {
+		c.conn.client.Close()
+		c.conn.client = nil
+	}
+}
+
+// reconnect replaces a dropped connection with a new one to the same server
+// address, without starting a server. If another call already replaced it,
+// that's used instead.
+func (c *Client) reconnect(dropped *rpc.Client) (*rpc.Client, error) {
+	c.conn.lock.Lock()
+	defer c.conn.lock.Unlock()
+
+	if c.conn.client != dropped && c.conn.client != nil {
+		return c.conn.client, nil
+	}
+
+	log.Debug("Reconnecting to server")
+	conn, err := c.conn.dial()
+	if err != nil {
+		return nil, err
+	}
+	client := rpc.NewClient(conn)
+
+	// In case it's a new server, update its version
+	versionReply := server.VersionResponse{}
+	if err := client.Call("Server.Version", false, &versionReply); err != nil {
+		client.Close()
+		return nil, err
 	}
+	c.conn.setServerVersion(versionReply)
+
+	if dropped != nil {
+		dropped.Close()
+	}
+	c.conn.client = client
+
+	return client, nil
+}
+
+// setServerVersion records what the server reported about itself. Must be
+// called with the lock held.
+func (c *connection) setServerVersion(versionReply server.VersionResponse) {
+	c.serverVersion = versionReply.Version
+
+	c.serverMethods = nil
+	if len(versionReply.Methods) > 0 {
+		c.serverMethods = make(map[string]bool, len(versionReply.Methods))
+		for _, method := range versionReply.Methods {
+			c.serverMethods[method] = true
+		}
+	}
+}
+
+func (c *Client) getRPCClient() *rpc.Client {
+	c.conn.lock.RLock()
+	defer c.conn.lock.RUnlock()
+
+	return c.conn.client
+}
+
+// Supports checks if the server has an RPC method, like "Server.TailV2".
+// Servers too old to say what they have are assumed to have all unversioned
+// methods, but none of the versioned ones.
+func (c *Client) Supports(method string) bool {
+	c.conn.lock.RLock()
+	defer c.conn.lock.RUnlock()
+
+	if c.conn.serverMethods == nil {
+		_, version := server.MethodVersion(method)
+		return version == 1
+	}
+
+	return c.conn.serverMethods[method]
 }
 
 // Call wraps a regular rpc.Call to give more user-friendly error messages in
-// some cases.
+// some cases. It gives up when the client's context is done (see
+// WithContext), or after the --timeout, if one was given.
 func (c *Client) Call(method string, args interface{}, reply interface{}) error {
-	if c == nil {
-		return fmt.Errorf("Failed to initialize server connection")
+	ctx, cancel := c.callContext()
+	defer cancel()
+
+	return c.CallContext(ctx, method, args, reply)
+}
+
+// CallContext is like Call, but gives up when ctx is done instead.
+func (c *Client) CallContext(ctx context.Context, method string, args interface{}, reply interface{}) error {
+	if c == nil || c.conn == nil {
+		return ErrNotConnected
 	}
 
+	c.conn.lock.RLock()
+	serverVersion := c.conn.serverVersion
+	serverMethods := c.conn.serverMethods
+	c.conn.lock.RUnlock()
+
 	// Notify user about version mismatches
-	if config.Version.LT(c.ServerVersion) {
-		fmt.Fprintf(os.Stderr, "Note: client version (%s) is behind server version (%s). Upgrade client.\n", config.Version, c.ServerVersion)
-	} else if config.Version.GT(c.ServerVersion) {
-		fmt.Fprintf(os.Stderr, "Note: client version (%s) is ahead of server version (%s). Update server by restarting it.\n", config.Version, c.ServerVersion)
+	if config.Version.LT(serverVersion) {
+		c.note("Note: client version (%s) is behind server version (%s). Upgrade client.", config.Version, serverVersion)
+	} else if config.Version.GT(serverVersion) {
+		c.note("Note: client version (%s) is ahead of server version (%s). Update server by restarting it.", config.Version, serverVersion)
 	}
 
-	// Outright refuse to use a server that's too far ahead/behind.
-	if c.ServerVersion.Major != config.Version.Major || c.ServerVersion.Minor != config.Version.Minor {
-		return fmt.Errorf("Client & Server versions are incompatible.")
+	// Outright refuse to use a server that's a whole major version off.
+	if serverVersion.Major != config.Version.Major {
+		return &IncompatibleError{ServerVersion: serverVersion}
 	}
 
-	// On pre-release builds, refuse any mismatch - things are changing too fast
-	if !config.Version.Equals(c.ServerVersion) && (len(config.Version.Pre) > 0 || len(c.ServerVersion.Pre) > 0) {
-		return fmt.Errorf("Client & Server versions are incompatible.")
+	if serverMethods != nil {
+		// The server said what it supports, so as long as it has this
+		// method, it's fine to use, even if versions don't match.
+		if !serverMethods[method] {
+			return &IncompatibleError{ServerVersion: serverVersion, Method: method}
+		}
+	} else if serverVersion.Minor != config.Version.Minor {
+		// Too old to say what it supports, so fall back to requiring close
+		// enough versions.
+		return &IncompatibleError{ServerVersion: serverVersion}
+	} else if !config.Version.Equals(serverVersion) && (len(config.Version.Pre) > 0 || len(serverVersion.Pre) > 0) {
+		// On pre-release builds, refuse any mismatch - things are changing
+		// too fast
+		return &IncompatibleError{ServerVersion: serverVersion}
 	}
 
-	return c.CallWithoutVersionCheck(method, args, reply)
+	return c.call(ctx, method, args, reply)
 }
 
 // CallWithoutVersionCheck skips checking that the client & server versions match
 func (c *Client) CallWithoutVersionCheck(method string, args interface{}, reply interface{}) error {
-	if c == nil {
-		return fmt.Errorf("Failed to initialize server connection")
+	ctx, cancel := c.callContext()
+	defer cancel()
+
+	return c.call(ctx, method, args, reply)
+}
+
+// callContext makes a context for a call from the client's, if it has one,
+// that times out after the --timeout, if one was given.
+func (c *Client) callContext() (context.Context, context.CancelFunc) {
+	ctx := context.Background()
+	if c != nil && c.ctx != nil {
+		ctx = c.ctx
+	}
+
+	if config.CallTimeout > 0 {
+		return context.WithTimeout(ctx, config.CallTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// goCall makes an RPC call, but stops waiting on it when ctx is done. The
+// server might still finish it and fill in reply later, so reply shouldn't be
+// used after an error.
+func goCall(ctx context.Context, client *rpc.Client, method string, args interface{}, reply interface{}) error {
+	call := client.Go(method, args, reply, make(chan *rpc.Call, 1))
+
+	select {
+	case <-call.Done:
+		return call.Error
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *Client) call(ctx context.Context, method string, args interface{}, reply interface{}) error {
+	if c == nil || c.conn == nil {
+		return ErrNotConnected
+	}
+
+	client := c.getRPCClient()
+	if client == nil {
+		return ErrNotConnected
+	}
+
+	err := goCall(ctx, client, method, args, reply)
+
+	// If the connection was already shut down, the call was never sent, so
+	// it's safe to retry on a new one. If it dropped during the call, only
+	// retry calls that don't change anything. Long-running ones keep trying,
+	// backing off, since the server might be restarting.
+	attempts := 1
+	if longRunningMethods[method] || c.Reconnect.Attempts == 0 {
+		attempts = c.Reconnect.Attempts
+	}
+	delay := c.Reconnect.MinDelay
+
+	dropped := err == io.EOF || err == io.ErrUnexpectedEOF
+	for attempt := 1; attempt <= attempts && (err == rpc.ErrShutdown || (dropped && resumableMethods[method])); attempt++ {
+		if attempt > 1 {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return &ConnectionError{Method: method, GaveUp: true}
+			}
+
+			if delay *= 2; delay > c.Reconnect.MaxDelay {
+				delay = c.Reconnect.MaxDelay
+			}
+		}
+
+		log.Debug("Lost connection to server, retrying call", "method", method, "attempt", attempt, "err", err)
+		newClient, reconnectErr := c.reconnect(client)
+		if reconnectErr != nil {
+			log.Debug("Failed to reconnect to server", "err", reconnectErr)
+			continue
+		}
+
+		client = newClient
+		err = goCall(ctx, client, method, args, reply)
+		dropped = err == io.EOF || err == io.ErrUnexpectedEOF
 	}
 
-	err := c.client.Call(method, args, reply)
-	if err == io.EOF || err == io.ErrUnexpectedEOF {
-		err = fmt.Errorf("Lost connection to backend server during a call to %s", method)
+	if err == context.DeadlineExceeded {
+		err = &TimeoutError{Method: method}
+	} else if dropped || err == rpc.ErrShutdown {
+		err = &ConnectionError{Method: method}
+	} else if err != nil && strings.HasPrefix(err.Error(), "gob: ") {
+		// Args or response changed in an incompatible way, which shouldn't
+		// happen, but one side can be too old to have the versioned method
+		err = &FormatError{Method: method, Err: err}
+	} else if serverErr, ok := err.(rpc.ServerError); ok {
+		err = &ServerError{Method: method, Message: string(serverErr)}
 	}
 
 	return err
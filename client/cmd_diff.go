@@ -0,0 +1,16 @@
+package client
+
+import (
+	"github.com/heewa/bento/server"
+)
+
+// Diff calls the Diff cmd on the Server
+func (c *Client) Diff(serviceFilePath string) (server.DiffResponse, error) {
+	args := server.DiffArgs{
+		ServiceFilePath: serviceFilePath,
+	}
+	reply := server.DiffResponse{}
+	err := c.Call("Server.Diff", args, &reply)
+
+	return reply, err
+}
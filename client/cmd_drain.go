@@ -0,0 +1,16 @@
+package client
+
+import (
+	"github.com/heewa/bento/server"
+)
+
+// Drain calls the Drain cmd on the Server
+func (c *Client) Drain(off bool) (bool, error) {
+	args := server.DrainArgs{
+		Off: off,
+	}
+	reply := server.DrainResponse{}
+	err := c.Call("Server.Drain", args, &reply)
+
+	return reply.Draining, err
+}
@@ -0,0 +1,24 @@
+package client
+
+import (
+	"github.com/heewa/bento/server"
+	"github.com/heewa/bento/service"
+)
+
+// DumpOutput calls the DumpOutput cmd on the Server
+func (c *Client) DumpOutput(name string, pid int) ([]service.OutputLine, error) {
+	args := server.DumpOutputArgs{
+		Name:   name,
+		Pid:    pid,
+		Packed: true,
+	}
+	reply := server.DumpOutputResponse{}
+	if err := c.Call("Server.DumpOutput", args, &reply); err != nil {
+		return nil, err
+	}
+
+	if reply.Packed != nil {
+		return service.UnpackLines(reply.Packed)
+	}
+	return reply.Lines, nil
+}
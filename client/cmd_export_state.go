@@ -0,0 +1,25 @@
+package client
+
+import (
+	"github.com/heewa/bento/server"
+)
+
+// ExportState calls the ExportState cmd on the Server
+func (c *Client) ExportState() (server.State, error) {
+	args := server.ExportStateArgs{}
+	reply := server.ExportStateResponse{}
+	err := c.Call("Server.ExportState", args, &reply)
+
+	return reply.State, err
+}
+
+// ImportState calls the ImportState cmd on the Server
+func (c *Client) ImportState(state server.State) (server.ImportStateResponse, error) {
+	args := server.ImportStateArgs{
+		State: state,
+	}
+	reply := server.ImportStateResponse{}
+	err := c.Call("Server.ImportState", args, &reply)
+
+	return reply, err
+}
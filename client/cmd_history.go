@@ -0,0 +1,16 @@
+package client
+
+import (
+	"github.com/heewa/bento/server"
+)
+
+// History calls the History cmd on the Server
+func (c *Client) History(name string) (server.HistoryResponse, error) {
+	args := server.HistoryArgs{
+		Name: name,
+	}
+	reply := server.HistoryResponse{}
+	err := c.Call("Server.History", args, &reply)
+
+	return reply, err
+}
@@ -1,16 +1,88 @@
 package client
 
 import (
+	"fmt"
+	"os"
+	"time"
+
 	"github.com/heewa/bento/server"
 )
 
-// LoadServices calls the LoadServices cmd on the Server
-func (c *Client) LoadServices(serviceFilePath string) (server.LoadServicesResponse, error) {
+// LoadServices calls the LoadServices cmd on the Server. If name is set,
+// only that service is loaded. If restartChanged is set, running services
+// with changes that need a restart get restarted. If progress isn't nil,
+// events are sent on it as services are loaded, and it's closed when they're
+// done.
+func (c *Client) LoadServices(serviceFilePath, name string, restartChanged bool, progress chan<- server.LoadEvent) (server.LoadServicesResponse, error) {
 	args := server.LoadServicesArgs{
 		ServiceFilePath: serviceFilePath,
+		Name:            name,
+		RestartChanged:  restartChanged,
+	}
+
+	followDone := make(chan interface{})
+	loadDone := make(chan interface{})
+	if progress != nil {
+		args.ProgressID = fmt.Sprintf("%d-%d", os.Getpid(), time.Now().UnixNano())
+		go func() {
+			defer close(followDone)
+			defer close(progress)
+			c.followLoad(args.ProgressID, progress, loadDone)
+		}()
+	} else {
+		close(followDone)
 	}
+
 	reply := server.LoadServicesResponse{}
 	err := c.Call("Server.LoadServices", args, &reply)
 
+	close(loadDone)
+	<-followDone
+
 	return reply, err
 }
+
+// followLoad sends events from a load in progress, until it's done.
+func (c *Client) followLoad(id string, progress chan<- server.LoadEvent, loadDone <-chan interface{}) {
+	args := server.LoadProgressArgs{
+		ID: id,
+	}
+
+	for {
+		// Need to make a new reply struct, otherwise we'll get the same
+		// reply as last time.
+		reply := server.LoadProgressResponse{}
+
+		// Progress is kept around for a bit after a load, so if it's not
+		// there after the load is done, the load never started.
+		loaded := false
+		select {
+		case <-loadDone:
+			loaded = true
+		default:
+		}
+
+		if err := c.Call("Server.LoadProgress", args, &reply); err != nil {
+			if loaded {
+				return
+			}
+
+			// Might have asked before the load started, so try again
+			select {
+			case <-loadDone:
+			case <-time.After(100 * time.Millisecond):
+			}
+			continue
+		}
+
+		for _, event := range reply.Events {
+			progress <- event
+		}
+
+		if reply.Done {
+			return
+		}
+
+		args.Index = reply.NextIndex
+	}
+}
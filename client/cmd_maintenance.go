@@ -0,0 +1,19 @@
+package client
+
+import (
+	"github.com/heewa/bento/server"
+	"github.com/heewa/bento/service"
+)
+
+// Maintenance calls the Maintenance cmd on the Server
+func (c *Client) Maintenance(name string, all, on bool) ([]service.Info, error) {
+	args := server.MaintenanceArgs{
+		Name: name,
+		All:  all,
+		On:   on,
+	}
+	reply := server.MaintenanceResponse{}
+	err := c.Call("Server.Maintenance", args, &reply)
+
+	return reply.Services, err
+}
@@ -0,0 +1,13 @@
+package client
+
+import (
+	"github.com/heewa/bento/server"
+)
+
+// OpenURL calls the OpenURL cmd on the Server
+func (c *Client) OpenURL(name string) error {
+	args := server.OpenURLArgs{
+		Name: name,
+	}
+	return c.Call("Server.OpenURL", args, nil)
+}
@@ -0,0 +1,16 @@
+package client
+
+import (
+	"github.com/heewa/bento/server"
+)
+
+// Pids calls the Pids cmd on the Server
+func (c *Client) Pids(name string) (server.PidsResponse, error) {
+	args := server.PidsArgs{
+		Name: name,
+	}
+	reply := server.PidsResponse{}
+	err := c.Call("Server.Pids", args, &reply)
+
+	return reply, err
+}
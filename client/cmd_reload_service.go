@@ -0,0 +1,17 @@
+package client
+
+import (
+	"github.com/heewa/bento/server"
+	"github.com/heewa/bento/service"
+)
+
+// ReloadService calls the ReloadService cmd on the Server
+func (c *Client) ReloadService(name string) (service.Info, error) {
+	args := server.ReloadServiceArgs{
+		Name: name,
+	}
+	reply := server.ReloadServiceResponse{}
+	err := c.Call("Server.ReloadService", args, &reply)
+
+	return reply.Info, err
+}
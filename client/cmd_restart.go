@@ -0,0 +1,17 @@
+package client
+
+import (
+	"github.com/heewa/bento/server"
+	"github.com/heewa/bento/service"
+)
+
+// Restart calls the Restart cmd on the Server
+func (c *Client) Restart(name string) (service.Info, error) {
+	args := server.RestartArgs{
+		Name: name,
+	}
+	reply := server.RestartResponse{}
+	err := c.Call("Server.Restart", args, &reply)
+
+	return reply.Info, err
+}
@@ -8,7 +8,7 @@ import (
 )
 
 // Run calls the Run cmd on the Server
-func (c *Client) Run(name, program string, runArgs []string, dir string, env map[string]string, cleanAfter time.Duration) (service.Info, error) {
+func (c *Client) Run(name, program string, runArgs []string, dir string, env map[string]string, cleanAfter time.Duration, notify bool) (service.Info, error) {
 	args := server.RunArgs{
 		Name:       name,
 		Program:    program,
@@ -16,6 +16,7 @@ func (c *Client) Run(name, program string, runArgs []string, dir string, env map
 		Dir:        dir,
 		Env:        env,
 		CleanAfter: cleanAfter,
+		Notify:     notify,
 	}
 	reply := server.RunResponse{}
 	err := c.Call("Server.Run", args, &reply)
@@ -0,0 +1,13 @@
+package client
+
+import (
+	"github.com/heewa/bento/server"
+)
+
+// ServerInfo calls the ServerInfo cmd on the Server
+func (c *Client) ServerInfo() (server.ServerInfoResponse, error) {
+	reply := server.ServerInfoResponse{}
+	err := c.Call("Server.ServerInfo", false, &reply)
+
+	return reply, err
+}
@@ -1,6 +1,18 @@
 package client
 
-// Shutdown calls the Exit cmd on the Server
+import (
+	"strings"
+
+	"github.com/heewa/bento/server"
+)
+
+// Shutdown calls the Exit cmd on the Server. It skips the version check, since
+// it's how a server of another version gets replaced, and servers from before
+// Exit took args get the bool they expect.
 func (c *Client) Shutdown() error {
-	return c.Call("Server.Exit", false, nil)
+	err := c.CallWithoutVersionCheck("Server.Exit", server.ExitArgs{Reason: "shutdown command"}, nil)
+	if err != nil && strings.Contains(err.Error(), "gob: ") {
+		err = c.CallWithoutVersionCheck("Server.Exit", false, nil)
+	}
+	return err
 }
@@ -0,0 +1,27 @@
+package client
+
+import (
+	"github.com/heewa/bento/server"
+)
+
+// Snapshot calls the Snapshot cmd on the Server
+func (c *Client) Snapshot(name string) (server.SnapshotResponse, error) {
+	args := server.SnapshotArgs{
+		Name: name,
+	}
+	reply := server.SnapshotResponse{}
+	err := c.Call("Server.Snapshot", args, &reply)
+
+	return reply, err
+}
+
+// Restore calls the Restore cmd on the Server
+func (c *Client) Restore(name string) (server.RestoreResponse, error) {
+	args := server.RestoreArgs{
+		Name: name,
+	}
+	reply := server.RestoreResponse{}
+	err := c.Call("Server.Restore", args, &reply)
+
+	return reply, err
+}
@@ -5,13 +5,27 @@ import (
 	"github.com/heewa/bento/service"
 )
 
-// Start calls the Start cmd on the Server
-func (c *Client) Start(name string) (service.Info, error) {
+// Start calls the Start cmd on the Server. If waitReady is true, it returns
+// once the service is ready.
+func (c *Client) Start(name string, waitReady bool) (service.Info, error) {
 	args := server.StartArgs{
-		Name: name,
+		Name:      name,
+		WaitReady: waitReady,
 	}
 	reply := server.StartResponse{}
 	err := c.Call("Server.Start", args, &reply)
 
 	return reply.Info, err
 }
+
+// StartProfile calls the StartProfile cmd on the Server
+func (c *Client) StartProfile(profile string, waitReady bool) (server.StartProfileResponse, error) {
+	args := server.StartProfileArgs{
+		Profile:   profile,
+		WaitReady: waitReady,
+	}
+	reply := server.StartProfileResponse{}
+	err := c.Call("Server.StartProfile", args, &reply)
+
+	return reply, err
+}
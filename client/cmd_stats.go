@@ -0,0 +1,19 @@
+package client
+
+import (
+	"time"
+
+	"github.com/heewa/bento/server"
+)
+
+// Stats calls the Stats cmd on the Server
+func (c *Client) Stats(name string, since time.Duration) (server.StatsResponse, error) {
+	args := server.StatsArgs{
+		Name:  name,
+		Since: since,
+	}
+	reply := server.StatsResponse{}
+	err := c.Call("Server.Stats", args, &reply)
+
+	return reply, err
+}
@@ -1,17 +1,86 @@
 package client
 
 import (
+	"fmt"
+	"os"
+	"time"
+
 	"github.com/heewa/bento/server"
 	"github.com/heewa/bento/service"
 )
 
-// Stop calls the Stop cmd on the Server
-func (c *Client) Stop(name string) (service.Info, error) {
+// Stop calls the Stop cmd on the Server. If progress isn't nil, steps taken
+// to stop the service are sent on it, and it's closed when they're done.
+func (c *Client) Stop(name string, progress chan<- string) (service.Info, error) {
 	args := server.StopArgs{
 		Name: name,
 	}
+
+	// Older servers can't report progress
+	followDone := make(chan interface{})
+	stopDone := make(chan interface{})
+	if progress != nil && c.Supports("Server.StopProgress") {
+		args.ProgressID = fmt.Sprintf("%d-%d", os.Getpid(), time.Now().UnixNano())
+		go func() {
+			defer close(followDone)
+			defer close(progress)
+			c.followStop(args.ProgressID, progress, stopDone)
+		}()
+	} else {
+		if progress != nil {
+			close(progress)
+		}
+		close(followDone)
+	}
+
 	reply := server.StopResponse{}
 	err := c.Call("Server.Stop", args, &reply)
 
+	close(stopDone)
+	<-followDone
+
 	return reply.Info, err
 }
+
+// followStop sends steps from a stop in progress, until it's done.
+func (c *Client) followStop(id string, progress chan<- string, stopDone <-chan interface{}) {
+	args := server.StopProgressArgs{
+		ID: id,
+	}
+
+	for {
+		reply := server.StopProgressResponse{}
+
+		// Progress is kept around for a bit after a stop, so if it's not
+		// there after the stop is done, it never got to stopping anything.
+		stopped := false
+		select {
+		case <-stopDone:
+			stopped = true
+		default:
+		}
+
+		if err := c.Call("Server.StopProgress", args, &reply); err != nil {
+			if stopped {
+				return
+			}
+
+			// Might have asked before the stop started, so try again
+			select {
+			case <-stopDone:
+			case <-time.After(100 * time.Millisecond):
+			}
+			continue
+		}
+
+		for _, step := range reply.Steps {
+			progress <- step
+		}
+
+		if reply.Done {
+			return
+		}
+
+		args.Index = reply.NextIndex
+	}
+}
@@ -1,17 +1,22 @@
 package client
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/heewa/bento/server"
+	"github.com/heewa/bento/service"
 )
 
-// Tail calls the Tail cmd on the Server
-func (c *Client) Tail(name string, stdout, stderr bool, follow, followRestarts bool, pid, max int) (<-chan string, <-chan string, <-chan error) {
+// Tail calls the Tail cmd on the Server. If index >= 0, output starts from that
+// line's index, otherwise it's the last max lines, or all of them if max is 0.
+func (c *Client) Tail(name string, stdout, stderr bool, follow, followRestarts bool, pid, index, max int) (<-chan service.OutputLine, <-chan service.OutputLine, <-chan error) {
 	if followRestarts {
 		follow = true
 	}
 
-	stdoutChan := make(chan string, 100)
-	stderrChan := make(chan string, 100)
+	stdoutChan := make(chan service.OutputLine, 100)
+	stderrChan := make(chan service.OutputLine, 100)
 	errChan := make(chan error, 1) // needs to be buffered cuz client might wait
 
 	args := server.TailArgs{
@@ -19,17 +24,24 @@ func (c *Client) Tail(name string, stdout, stderr bool, follow, followRestarts b
 		Pid:      pid,
 		MaxLines: max,
 		Follow:   follow,
+		Packed:   true,
 	}
 
-	if max > 0 {
+	if index >= 0 {
+		args.Index = index
+	} else if max > 0 {
 		// Start that many from end
 		args.Index = -1 * max
-	} else {
-		args.Index = -100000
 	}
 
 	reply := server.TailResponse{}
 
+	// Pid of the last line sent, to mark where a restart's output starts
+	lastPid := 0
+
+	// Lines left to get, if limited, when the server sends them in chunks
+	remaining := max
+
 	go func() {
 		defer func() {
 			close(stderrChan)
@@ -47,15 +59,67 @@ func (c *Client) Tail(name string, stdout, stderr bool, follow, followRestarts b
 				return
 			}
 
+			// An older server sends lines unpacked
+			lines := reply.Lines
+			if reply.Packed != nil {
+				var err error
+				if lines, err = service.UnpackLines(reply.Packed); err != nil {
+					errChan <- err
+					return
+				}
+			}
+
+			// Note lines that were dropped before we got to them, on stderr,
+			// so it doesn't get mixed into the service's stdout
+			if reply.Skipped > 0 {
+				marker := service.OutputLine{
+					Pid:    reply.NextPid,
+					Stderr: true,
+					Time:   time.Now(),
+					Index:  reply.NextIndex,
+					Line:   fmt.Sprintf("[bento] skipped %d lines, tail fell behind the service's output", reply.Skipped),
+				}
+				if len(lines) > 0 {
+					marker.Pid, marker.Index = lines[0].Pid, lines[0].Index
+				}
+				stderrChan <- marker
+			}
+
 			// Send lines down channels
-			for _, line := range reply.Lines {
+			for _, line := range lines {
+				if followRestarts && lastPid != 0 && line.Pid != lastPid {
+					separator := c.restartSeparator(name, lastPid, line)
+					if line.Stderr {
+						stderrChan <- separator
+					} else {
+						stdoutChan <- separator
+					}
+				}
+				lastPid = line.Pid
+
 				if line.Stderr {
-					stderrChan <- line.Line
+					stderrChan <- line
 				} else {
-					stdoutChan <- line.Line
+					stdoutChan <- line
 				}
 			}
 
+			// If the server cut the lines short to limit its response size,
+			// get the rest of what was asked for
+			if max > 0 {
+				remaining -= len(lines)
+			}
+			if reply.More && !follow {
+				args = server.TailArgs{
+					Name:     name,
+					Pid:      pid,
+					MaxLines: remaining,
+					Index:    reply.NextIndex,
+					Packed:   true,
+				}
+				continue
+			}
+
 			// If there aren't any more lines from this process, stop, unless
 			// we're following restarts.
 			if !follow {
@@ -74,9 +138,36 @@ func (c *Client) Tail(name string, stdout, stderr bool, follow, followRestarts b
 				MaxLines: 0,
 				Index:    reply.NextIndex,
 				Follow:   follow,
+				Packed:   true,
 			}
 		}
 	}()
 
 	return stdoutChan, stderrChan, errChan
 }
+
+// restartSeparator makes a line to mark where output from a restart of a
+// service starts, with how the previous run ended, if it has. It's on the same
+// stream as the line it comes before, so it stays in order with it.
+func (c *Client) restartSeparator(name string, previousPid int, next service.OutputLine) service.OutputLine {
+	ended := ""
+	if history, err := c.History(name); err == nil {
+		for _, run := range history.Runs {
+			if run.Pid != previousPid {
+				continue
+			} else if run.Signal != "" {
+				ended = fmt.Sprintf(", signal of previous: %s", run.Signal)
+			} else {
+				ended = fmt.Sprintf(", exit code of previous: %d", run.ExitCode)
+			}
+		}
+	}
+
+	return service.OutputLine{
+		Pid:    next.Pid,
+		Stderr: next.Stderr,
+		Time:   next.Time,
+		Index:  next.Index,
+		Line:   fmt.Sprintf("--- restarted, pid %d%s ---", next.Pid, ended),
+	}
+}
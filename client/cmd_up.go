@@ -0,0 +1,21 @@
+package client
+
+import (
+	"github.com/heewa/bento/server"
+)
+
+// Up calls the Up cmd on the Server
+func (c *Client) Up() (server.UpResponse, error) {
+	reply := server.UpResponse{}
+	err := c.Call("Server.Up", server.UpArgs{}, &reply)
+
+	return reply, err
+}
+
+// Down calls the Down cmd on the Server
+func (c *Client) Down() (server.DownResponse, error) {
+	reply := server.DownResponse{}
+	err := c.Call("Server.Down", server.DownArgs{}, &reply)
+
+	return reply, err
+}
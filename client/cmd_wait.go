@@ -2,16 +2,16 @@ package client
 
 import (
 	"github.com/heewa/bento/server"
-	"github.com/heewa/bento/service"
 )
 
 // Wait calls the Wait cmd on the Server
-func (c *Client) Wait(name string) (service.Info, error) {
+func (c *Client) Wait(name string, any bool) (server.WaitResponse, error) {
 	args := server.WaitArgs{
 		Name: name,
+		Any:  any,
 	}
 	reply := server.WaitResponse{}
 	err := c.Call("Server.Wait", args, &reply)
 
-	return reply.Info, err
+	return reply, err
 }
@@ -0,0 +1,16 @@
+package client
+
+import (
+	"github.com/heewa/bento/server"
+)
+
+// Which calls the Which cmd on the Server
+func (c *Client) Which(name string) (server.WhichResponse, error) {
+	args := server.WhichArgs{
+		Name: name,
+	}
+	reply := server.WhichResponse{}
+	err := c.Call("Server.Which", args, &reply)
+
+	return reply, err
+}
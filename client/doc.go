@@ -0,0 +1,27 @@
+// Package client talks to a bento server, for Go programs that want to
+// control services without shelling out to the bento command.
+//
+// Dial connects to a server that's already running, at the socket address
+// from config.SocketAddress, or one of its own. New and Connect do what the
+// bento command does, starting a server if there isn't one:
+//
+//	c, err := client.Dial(config.SocketAddress())
+//	if err != nil {
+//		return err
+//	}
+//	defer c.Close()
+//
+//	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+//	defer cancel()
+//	info, err := c.WithContext(ctx).Start("web", true)
+//
+// Calls return typed errors, like *IncompatibleError when the server is too
+// old or new to talk to, *ServerError for errors from the call itself, or
+// *TimeoutError, so callers can tell them apart. The client doesn't print
+// anything; notes meant for a person, like a version mismatch, go to Notes
+// if it's set.
+//
+// Methods are added as the server gains commands, but existing ones keep
+// working against older and newer servers, as long as their major versions
+// match, see server/protocol.go.
+package client
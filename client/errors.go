@@ -0,0 +1,79 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/blang/semver"
+)
+
+// ErrNotConnected is returned by calls on a Client that isn't connected to a
+// server.
+var ErrNotConnected = errors.New("Not connected to server")
+
+// ServerError is an error the server returned from a call, like that a
+// service wasn't found.
+type ServerError struct {
+	Method  string
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	return e.Message
+}
+
+// IncompatibleError is returned when the server's version is too far from
+// the client's to talk to it, or it doesn't have a method the client needs.
+type IncompatibleError struct {
+	ServerVersion semver.Version
+
+	// Method the server doesn't have, if that's the problem
+	Method string
+}
+
+func (e *IncompatibleError) Error() string {
+	if e.Method != "" {
+		return fmt.Sprintf(
+			"Server (version %s) doesn't support %s, update it by restarting it: bento shutdown",
+			e.ServerVersion, strings.TrimPrefix(e.Method, "Server."))
+	}
+	return "Client & Server versions are incompatible."
+}
+
+// ConnectionError is returned when the connection to the server dropped
+// during a call, and it couldn't be retried on a new one.
+type ConnectionError struct {
+	Method string
+
+	// True if it tried reconnecting until its context was done
+	GaveUp bool
+}
+
+func (e *ConnectionError) Error() string {
+	if e.GaveUp {
+		return fmt.Sprintf("Lost connection to backend server during a call to %s, and gave up reconnecting", e.Method)
+	}
+	return fmt.Sprintf("Lost connection to backend server during a call to %s", e.Method)
+}
+
+// TimeoutError is returned when the server didn't respond to a call in time.
+type TimeoutError struct {
+	Method string
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("Timed out waiting for the server to respond to %s", e.Method)
+}
+
+// FormatError is returned when the client & server disagree on a call's args
+// or response, which shouldn't happen, but can if one is too old to have a
+// versioned method.
+type FormatError struct {
+	Method string
+	Err    error
+}
+
+func (e *FormatError) Error() string {
+	return fmt.Sprintf("Client & Server disagree on the format of %s, update whichever is older: %v", e.Method, e.Err)
+}
@@ -0,0 +1,366 @@
+// Package clienttest has a fake bento server that runs in memory, for unit
+// testing programs that use the client package, without a real server, unix
+// socket, or processes.
+//
+// A test adds services to a Server, and connects real clients to it:
+//
+//	fake := clienttest.NewServer()
+//	fake.AddService(config.Service{Name: "db", Program: "postgres"})
+//	c, err := fake.Client()
+//	...
+//	info, err := c.Start("db", true)
+//
+// Starting a service only marks it running with a made-up pid, and its output
+// is whatever the test adds with AddOutput. The fake handles List, Info,
+// Start, Stop, Restart and Tail calls. Others fail with a
+// *client.IncompatibleError, like they would with a server too old to have
+// them.
+package clienttest
+
+import (
+	"fmt"
+	"net"
+	"net/rpc"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/heewa/bento/client"
+	"github.com/heewa/bento/config"
+	"github.com/heewa/bento/server"
+	"github.com/heewa/bento/service"
+)
+
+// Pids given to fake services start here, so they don't look like small,
+// real ones
+const firstPid = 100000
+
+// How long a following Tail waits for output before returning nothing, like
+// the real server
+const followTimeout = 10 * time.Second
+
+// methods the fake handles
+var methods = []string{
+	"Server.Info",
+	"Server.List",
+	"Server.Restart",
+	"Server.Start",
+	"Server.Stop",
+	"Server.Tail",
+	"Server.Version",
+}
+
+// Server is a fake bento server, kept in memory. It's safe for concurrent
+// use.
+type Server struct {
+	lock     sync.Mutex
+	services map[string]*fakeService
+	calls    []string
+	nextPid  int
+
+	// Closed & replaced when output is added or a service stops, to wake up
+	// following tails
+	changed chan interface{}
+
+	rpcServer *rpc.Server
+}
+
+type fakeService struct {
+	info   service.Info
+	output []service.OutputLine
+}
+
+// NewServer creates a fake server without any services.
+func NewServer() *Server {
+	s := &Server{
+		services:  make(map[string]*fakeService),
+		nextPid:   firstPid,
+		changed:   make(chan interface{}),
+		rpcServer: rpc.NewServer(),
+	}
+
+	// Named like the real one, so calls are the same
+	if err := s.rpcServer.RegisterName("Server", &fakeRPC{s}); err != nil {
+		panic(fmt.Sprintf("Failed to register fake server: %v", err))
+	}
+
+	return s
+}
+
+// Client connects a new client to the fake server, over an in-memory
+// connection.
+func (s *Server) Client() (*client.Client, error) {
+	return client.DialWith(func() (net.Conn, error) {
+		clientConn, serverConn := net.Pipe()
+		go s.rpcServer.ServeConn(serverConn)
+		return clientConn, nil
+	})
+}
+
+// AddService adds a stopped service, or replaces one with the same name.
+func (s *Server) AddService(conf config.Service) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.services[conf.Name] = &fakeService{
+		info: service.Info{Service: &conf},
+	}
+}
+
+// AddOutput adds lines of output to a service, as if its current process
+// wrote them, to stderr if stderr is true, otherwise to stdout.
+func (s *Server) AddOutput(name string, stderr bool, lines ...string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	srvc := s.services[name]
+	if srvc == nil {
+		return fmt.Errorf("Service '%s' not found.", name)
+	}
+
+	for _, line := range lines {
+		srvc.output = append(srvc.output, service.OutputLine{
+			Pid:    srvc.info.Pid,
+			Stderr: stderr,
+			Line:   line,
+			Time:   time.Now(),
+			Index:  len(srvc.output),
+		})
+	}
+	s.notifyChanged()
+
+	return nil
+}
+
+// Info gets what the fake says about a service, and false if there isn't one
+// by that name.
+func (s *Server) Info(name string) (service.Info, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	srvc := s.services[name]
+	if srvc == nil {
+		return service.Info{}, false
+	}
+	return srvc.info, true
+}
+
+// Calls gets the methods that clients called, in order, like "Server.Start".
+// Clients call "Server.Version" on connecting.
+func (s *Server) Calls() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]string(nil), s.calls...)
+}
+
+// called records a call, and gets the service it's for, if it has one. Must
+// be called with the lock held.
+func (s *Server) called(method, name string) (*fakeService, error) {
+	s.calls = append(s.calls, method)
+
+	if name == "" {
+		return nil, nil
+	}
+
+	srvc := s.services[name]
+	if srvc == nil {
+		return nil, fmt.Errorf("Service '%s' not found.", name)
+	}
+	return srvc, nil
+}
+
+// start marks a service running with a new pid. Must be called with the lock
+// held.
+func (s *Server) start(srvc *fakeService) {
+	if srvc.info.Running {
+		return
+	}
+
+	s.nextPid++
+	srvc.info.Running = true
+	srvc.info.Pid = s.nextPid
+	srvc.info.StartTime = time.Now()
+	srvc.info.EndTime = time.Time{}
+	srvc.info.Runtime = 0
+}
+
+// stop marks a service stopped, like it exited on an interrupt. Must be
+// called with the lock held.
+func (s *Server) stop(srvc *fakeService) {
+	if !srvc.info.Running {
+		return
+	}
+
+	srvc.info.Running = false
+	srvc.info.Succeeded = false
+	srvc.info.ExitCode = 130
+	srvc.info.Signal = "interrupt"
+	srvc.info.EndTime = time.Now()
+	srvc.info.Runtime = srvc.info.EndTime.Sub(srvc.info.StartTime)
+	s.notifyChanged()
+}
+
+// notifyChanged wakes up following tails. Must be called with the lock held.
+func (s *Server) notifyChanged() {
+	close(s.changed)
+	s.changed = make(chan interface{})
+}
+
+// fakeRPC has the fake's RPC methods, separate from Server's own, so they
+// don't get mixed up.
+type fakeRPC struct {
+	s *Server
+}
+
+func (f *fakeRPC) Version(_ bool, reply *server.VersionResponse) error {
+	f.s.lock.Lock()
+	defer f.s.lock.Unlock()
+
+	f.s.called("Server.Version", "")
+	reply.Version = config.Version
+	reply.Methods = methods
+	return nil
+}
+
+func (f *fakeRPC) List(args *server.ListArgs, reply *server.ListResponse) error {
+	f.s.lock.Lock()
+	defer f.s.lock.Unlock()
+
+	f.s.called("Server.List", "")
+	for _, srvc := range f.s.services {
+		if args.Running && !srvc.info.Running {
+			continue
+		} else if args.Temp && !srvc.info.Temp {
+			continue
+		}
+		reply.Services = append(reply.Services, srvc.info)
+	}
+	sort.Sort(service.InfoByName(reply.Services))
+
+	return nil
+}
+
+func (f *fakeRPC) Info(args *server.InfoArgs, reply *server.InfoResponse) error {
+	f.s.lock.Lock()
+	defer f.s.lock.Unlock()
+
+	srvc, err := f.s.called("Server.Info", args.Name)
+	if err != nil {
+		return err
+	}
+
+	reply.Info = srvc.info
+	return nil
+}
+
+func (f *fakeRPC) Start(args server.StartArgs, reply *server.StartResponse) error {
+	f.s.lock.Lock()
+	defer f.s.lock.Unlock()
+
+	srvc, err := f.s.called("Server.Start", args.Name)
+	if err != nil {
+		return err
+	}
+
+	f.s.start(srvc)
+	reply.Info = srvc.info
+	return nil
+}
+
+func (f *fakeRPC) Stop(args server.StopArgs, reply *server.StopResponse) error {
+	f.s.lock.Lock()
+	defer f.s.lock.Unlock()
+
+	srvc, err := f.s.called("Server.Stop", args.Name)
+	if err != nil {
+		return err
+	}
+
+	f.s.stop(srvc)
+	reply.Info = srvc.info
+	return nil
+}
+
+func (f *fakeRPC) Restart(args server.RestartArgs, reply *server.RestartResponse) error {
+	f.s.lock.Lock()
+	defer f.s.lock.Unlock()
+
+	srvc, err := f.s.called("Server.Restart", args.Name)
+	if err != nil {
+		return err
+	}
+
+	f.s.stop(srvc)
+	f.s.start(srvc)
+	reply.Info = srvc.info
+	return nil
+}
+
+func (f *fakeRPC) Tail(args *server.TailArgs, reply *server.TailResponse) error {
+	f.s.lock.Lock()
+	srvc, err := f.s.called("Server.Tail", args.Name)
+	f.s.lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	deadline := time.After(followTimeout)
+	for {
+		f.s.lock.Lock()
+		f.tail(srvc, args, reply)
+		changed := f.s.changed
+		f.s.lock.Unlock()
+
+		if !args.Follow || reply.EOF || len(reply.Lines) > 0 {
+			break
+		}
+
+		select {
+		case <-changed:
+		case <-deadline:
+			return nil
+		}
+	}
+
+	if args.Packed {
+		reply.Packed = service.PackLines(reply.Lines)
+		reply.Lines = nil
+	}
+
+	return nil
+}
+
+// tail gets lines from a service's output for a Tail call. Must be called
+// with the lock held.
+func (f *fakeRPC) tail(srvc *fakeService, args *server.TailArgs, reply *server.TailResponse) {
+	start := args.Index
+	if start < 0 {
+		start += len(srvc.output)
+	}
+	if start < 0 {
+		start = 0
+	} else if start > len(srvc.output) {
+		start = len(srvc.output)
+	}
+
+	reply.Lines = nil
+	for _, line := range srvc.output[start:] {
+		if args.Pid != 0 && line.Pid != args.Pid {
+			continue
+		}
+		reply.Lines = append(reply.Lines, line)
+		if args.MaxLines > 0 && len(reply.Lines) == args.MaxLines {
+			break
+		}
+	}
+
+	reply.NextIndex = len(srvc.output)
+	if len(reply.Lines) > 0 {
+		reply.NextIndex = reply.Lines[len(reply.Lines)-1].Index + 1
+	}
+	reply.NextPid = srvc.info.Pid
+
+	// Like the real server, a pid is done once it's not running
+	reply.EOF = args.Pid != 0 && (!srvc.info.Running || srvc.info.Pid != args.Pid) && reply.NextIndex == len(srvc.output)
+}
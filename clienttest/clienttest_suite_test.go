@@ -0,0 +1,13 @@
+package clienttest_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestClienttest(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Clienttest Suite")
+}
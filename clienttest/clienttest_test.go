@@ -0,0 +1,90 @@
+package clienttest_test
+
+import (
+	. "github.com/heewa/bento/clienttest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/heewa/bento/client"
+	"github.com/heewa/bento/config"
+	"github.com/heewa/bento/service"
+)
+
+var _ = Describe("Server", func() {
+	var (
+		fake *Server
+		c    *client.Client
+	)
+
+	BeforeEach(func() {
+		fake = NewServer()
+		fake.AddService(config.Service{Name: "db", Program: "postgres"})
+
+		var err error
+		c, err = fake.Client()
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		c.Close()
+	})
+
+	// lines reads all of a tail's lines, until it ends
+	lines := func(stdout, stderr <-chan service.OutputLine, errs <-chan error) []string {
+		var got []string
+		for stdout != nil || stderr != nil {
+			select {
+			case line, ok := <-stdout:
+				if !ok {
+					stdout = nil
+					continue
+				}
+				got = append(got, line.Line)
+			case line, ok := <-stderr:
+				if !ok {
+					stderr = nil
+					continue
+				}
+				got = append(got, "err: "+line.Line)
+			}
+		}
+		Expect(<-errs).To(BeNil())
+		return got
+	}
+
+	It("should start, tail and stop a service through a real client", func() {
+		info, err := c.Start("db", false)
+		Expect(err).To(BeNil())
+		Expect(info.Running).To(BeTrue())
+		Expect(info.Pid).ToNot(BeZero())
+
+		Expect(fake.AddOutput("db", false, "one", "two")).To(BeNil())
+		Expect(fake.AddOutput("db", true, "three")).To(BeNil())
+
+		By("tailing what's there")
+		Expect(lines(c.Tail("db", true, true, false, false, 0, -1, 2))).To(ConsistOf("two", "err: three"))
+
+		By("following it until it stops")
+		stdout, stderr, errs := c.Tail("db", true, true, true, false, info.Pid, 3, 0)
+		Expect(fake.AddOutput("db", false, "four")).To(BeNil())
+		Eventually(stdout).Should(Receive(WithTransform(func(line service.OutputLine) string { return line.Line }, Equal("four"))))
+
+		stopped, err := c.Stop("db", nil)
+		Expect(err).To(BeNil())
+		Expect(stopped.Running).To(BeFalse())
+		Expect(lines(stdout, stderr, errs)).To(BeEmpty())
+
+		current, found := fake.Info("db")
+		Expect(found).To(BeTrue())
+		Expect(current.Running).To(BeFalse())
+		Expect(fake.Calls()).To(ContainElement("Server.Start"))
+		Expect(fake.Calls()).To(ContainElement("Server.Tail"))
+		Expect(fake.Calls()).To(ContainElement("Server.Stop"))
+	})
+
+	It("should fail calls it doesn't handle like an old server", func() {
+		_, err := c.History("db")
+		Expect(err).To(BeAssignableToTypeOf(&client.IncompatibleError{}))
+	})
+})
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"gopkg.in/yaml.v2"
+)
+
+// WriteAutocompleteCache saves what tab completion uses from services, so
+// it can complete them without a running server, or parsing the services
+// file.
+func WriteAutocompleteCache(services []Service) error {
+	cached := make([]Service, 0, len(services))
+	for _, s := range services {
+		cached = append(cached, Service{
+			Name:    s.Name,
+			Program: s.Program,
+			Args:    s.Args,
+			Dir:     s.Dir,
+			Env:     s.Env,
+		})
+	}
+
+	data, err := yaml.Marshal(cached)
+	if err != nil {
+		return fmt.Errorf("Failed to encode autocomplete cache: %v", err)
+	}
+
+	// Write & move into place, so a tab completion never reads half of it
+	tmpPath := AutocompleteCachePath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("Failed to write autocomplete cache: %v", err)
+	}
+	if err := os.Rename(tmpPath, AutocompleteCachePath); err != nil {
+		return fmt.Errorf("Failed to write autocomplete cache: %v", err)
+	}
+
+	return nil
+}
+
+// LoadAutocompleteCache gets services saved by WriteAutocompleteCache
+func LoadAutocompleteCache() ([]Service, error) {
+	data, err := ioutil.ReadFile(AutocompleteCachePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var services []Service
+	if err := yaml.Unmarshal(data, &services); err != nil {
+		return nil, fmt.Errorf("Invalid autocomplete cache: %v", err)
+	}
+	return services, nil
+}
@@ -6,9 +6,12 @@ import (
 	"os"
 	"os/user"
 	"path"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/blang/semver"
+	"github.com/dustin/go-humanize"
 	log "github.com/inconshreveable/log15"
 	"gopkg.in/alecthomas/kingpin.v2"
 	"gopkg.in/yaml.v2"
@@ -18,6 +21,20 @@ const (
 	configDir         = ".bento"
 	configFile        = "config.yml"
 	serviceConfigFile = "services.yml"
+	fifoFile          = ".fifo"
+
+	// Named server instances each get a subdir of this, in the config dir
+	instancesDir = "instances"
+
+	// Log formats for the server's log
+	LogFormatLogfmt = "logfmt"
+	LogFormatJSON   = "json"
+
+	// What tray tooltips can show for a service
+	TrayTooltipOutput = "output"
+	TrayTooltipHealth = "health"
+	TrayTooltipUptime = "uptime"
+	TrayTooltipInfo   = "info"
 
 	// Just regular constants
 
@@ -34,9 +51,44 @@ const (
 # Log Level can be "crit", "error", "warn", "info", or "debug"
 #log_level: "info"
 
+# The server starts a new log each day, and each time it starts, keeping old
+# ones next to it, named by when they ended. They're removed once they're
+# older than log_retention, like "7d" or "12h", and while they all take up more
+# than log_max_total. Either can be 0 for no limit.
+#log_retention: "7d"
+#log_max_total: "500MB"
+
+# Log format can be "logfmt", or "json" for one JSON object per line, like for
+# jq or log aggregators
+#log_format: "logfmt"
+
 # Path to the fifo file that the clients and server use to communicate
 #fifo: "/path/to/bento.fifo"
 
+# On Linux, use an abstract socket (named after the fifo path) instead of a
+# file, so a crashed server never leaves a stale fifo behind.
+#abstract_socket: true
+
+# Set to false to run the server without a system tray UI, like on a headless
+# machine.
+#tray: true
+
+# What each service's tooltip in the tray shows: "output" for its last lines of
+# output (how many is tray_tooltip_lines), "health", "uptime", or "info" for
+# the same line as 'bento list'.
+#tray_tooltip: "output"
+#tray_tooltip_lines: 5
+
+# Errors and warnings in the tray are cleared after this long, or when clicked.
+# The same one happening again shows a count, and starts the wait over. 0 means
+# they stay until clicked.
+#tray_error_clear_after: "5m"
+
+# Post a desktop notification when any run-once service exits, with how long
+# it ran, and if it succeeded, like for long builds. Otherwise, it's only done
+# for ones run with --notify.
+#notify_run_once: false
+
 # When temp services exit, after this duration (unless they are restarted),
 # they are auto-removed. This can be override from the cmdline for an
 # individual service when creating it.
@@ -44,6 +96,63 @@ const (
 # Values can be like "1s" (1 second), "1h" (1 hour), "1h15m10s" (1 hour, 15
 # minutes and 10 seconds)
 #clean_temp_services_after: "1h"
+
+# Output from all services is kept in memory, up to this total, after which
+# services that keep outputting drop their oldest lines. Values can be like
+# "512MB" or "2GiB".
+#max_output_memory: "512MB"
+
+# Most output a single tail call sends back, after which the client asks for
+# the rest in another call, so a huge tail -n doesn't balloon the server's or
+# client's memory all at once.
+#max_tail_response: "4MB"
+
+# A restart-on-exit service that's restarted this many times within the
+# flapping window is marked as flapping, and a warning is logged once.
+#flapping_restarts: 5
+#flapping_window: "10m"
+
+# When the server shuts down, services that haven't stopped after this long
+# are killed, so the server can exit. 0 means wait for them forever.
+#shutdown_timeout: "30s"
+
+# At most this many auto-started services start up at once, each one taking up
+# a slot until it's ready. Ones with a higher start-priority go first. 0 means
+# no limit.
+#max_parallel_starts: 0
+
+# Limits on clients, so a runaway script can't starve the server: at most
+# max_connections open at once, each user opening at most max_connection_rate
+# new ones a second, and making at most max_call_rate calls a second over
+# them. Clients over a limit get an error saying which. 0 means no limit.
+#max_connections: 256
+#max_connection_rate: 50
+#max_call_rate: 200
+
+# Services belong to the user that created them, and only they can stop,
+# restart, clean, or otherwise change them, besides admins: the server's own
+# user, root, and these users, by name or uid.
+#admins: ["alice", 1001]
+
+# Services that list profiles are only auto-started when the active profile is
+# one of them. A BENTO_PROFILE env var overrides this.
+#profile: "dev"
+
+# A Lua script that gets service events, and can start, stop, or restart
+# services in response. Relative to this dir.
+#hooks: "hooks.lua"
+
+# Colors for service info in the cli, by role, to override the defaults, like
+# for a light terminal. Each is a list of attributes like "hi-red bold", or
+# "none". Colors can be turned off with --no-color or a NO_COLOR env var.
+#colors:
+#  stopped: blue
+#  running: yellow
+#  status: hi-white bold
+#  pid: none
+#  succeeded: green
+#  failed: red
+#  symbol: white
 `
 )
 
@@ -51,21 +160,93 @@ var (
 	// Version of the package
 	Version = semver.MustParse("0.1.0-alpha.2.2")
 
+	// InstanceName is the name of the server instance to use, or empty for
+	// the default one. Each instance has its own services, fifo & log.
+	InstanceName string
+
+	// ProjectPath is the root dir of a project running its own server, with
+	// its state in a config dir there, or empty if not in local mode.
+	ProjectPath string
+
 	// ServiceConfigFile is the full path to the config file that lists
 	// services to be read on server startup. If the path doesn't exist,
 	// this'll be empty.
 	ServiceConfigFile string
 
+	// ServiceConfigPath is where the services config file is, or would be if
+	// it doesn't exist yet.
+	ServiceConfigPath string
+
 	// LogLevel determines the severity of messages that are logged.
 	LogLevel = log.LvlWarn
 
+	// LogFormat is the format of the server's log, LogFormatLogfmt or
+	// LogFormatJSON.
+	LogFormat = LogFormatLogfmt
+
+	// LogRetention is how long old server logs are kept, or 0 for forever.
+	LogRetention = 7 * 24 * time.Hour
+
+	// LogMaxTotal is the most bytes the server's logs can take up together,
+	// after which the oldest ones are removed, or 0 for no limit.
+	LogMaxTotal int64 = 500 * 1024 * 1024
+
 	// LogPath is the path to the server's log file.
 	LogPath = "bento.log"
 
+	// DumpPath is the path to a file the server writes a snapshot of its
+	// state to, when sent a SIGUSR1.
+	DumpPath = "bento.dump"
+
+	// PluginDir is the dir of plugin executables, like custom health probes
+	// and notifiers, shared by all instances.
+	PluginDir = "plugins"
+
+	// SnapshotDir is the dir snapshots of which services are running are
+	// kept in, to restore later.
+	SnapshotDir = "snapshots"
+
+	// NotifySocketDir is the dir of sockets services with a watchdog ping,
+	// one per service.
+	NotifySocketDir = "notify"
+
+	// AutocompleteCachePath is the path to a file with the server's last
+	// known services, for tab completion when it isn't running.
+	AutocompleteCachePath = "autocomplete.yml"
+
 	// FifoPath is the path to a unix named pipe that's used to communicate
 	// between clients & the server.
 	FifoPath = ".fifo"
 
+	// AbstractSocket is true if the server listens on an abstract-namespace
+	// unix socket instead of a file at FifoPath. Only supported on Linux.
+	AbstractSocket = false
+
+	// Tray is true if the server should show a system tray UI.
+	Tray = true
+
+	// TrayTooltip is what each service's tooltip in the tray shows, one of
+	// the TrayTooltip* consts, and TrayTooltipLines is how many lines of
+	// output it shows, for TrayTooltipOutput.
+	TrayTooltip      = TrayTooltipOutput
+	TrayTooltipLines = 5
+
+	// TrayErrorClearAfter is how long an error shows in the tray before it's
+	// cleared, or 0 to leave it until it's clicked.
+	TrayErrorClearAfter = 5 * time.Minute
+
+	// Color is true if the cli's output can be colored, which it still only
+	// is if it's going to a terminal.
+	Color = true
+
+	// ColorTheme sets colors for the cli's output, by role, over the
+	// defaults.
+	ColorTheme map[string]string
+
+	// CallTimeout is how long a client waits for the server to respond to
+	// each call, or 0 to wait forever.
+	CallTimeout time.Duration
+
 	// HeartbeatInterval is the frequency that the fifo file is touched to
 	// indicate a live server.
 	HeartbeatInterval = 10 * time.Second
@@ -74,18 +255,97 @@ var (
 	// service is removed.
 	CleanTempServicesAfter = 1 * time.Hour
 
+	// NotifyRunOnce is true if a desktop notification is posted when any
+	// temp service exits, not just ones run with --notify.
+	NotifyRunOnce = false
+
+	// MaxOutputMemory is the total bytes of memory that output from all
+	// services can use.
+	MaxOutputMemory int64 = 512 * 1024 * 1024
+
+	// MaxTailResponse is the most bytes of output a tail call sends back at
+	// once.
+	MaxTailResponse = 4 * 1024 * 1024
+
+	// FlappingRestarts is how many automatic restarts within FlappingWindow
+	// mark a service as flapping.
+	FlappingRestarts = 5
+	FlappingWindow   = 10 * time.Minute
+
+	// ShutdownTimeout is how long the server waits for services to stop when
+	// shutting down, before killing the rest, or 0 to wait forever.
+	ShutdownTimeout = 30 * time.Second
+
+	// Profile is the active profile, which services that list profiles have
+	// to be in to be auto-started.
+	Profile string
+
+	// HooksScript is the path to a Lua script that's run on service events,
+	// or empty for none.
+	HooksScript string
+
+	// MaxParallelStarts is how many auto-started services can be starting up
+	// at once, or 0 for no limit.
+	MaxParallelStarts = 0
+
+	// MaxConnections is how many clients can be connected to the server at
+	// once, MaxConnectionRate is how many new connections each user can make
+	// a second, and MaxCallRate is how many calls each user can make a second
+	// on them. 0 is no limit, for any of them.
+	MaxConnections    = 256
+	MaxConnectionRate = 50
+	MaxCallRate       = 200
+
+	// AdminUIDs are users that can change any service, besides the server's
+	// own user and root.
+	AdminUIDs map[int]bool
+
 	// Cmdline args that override conf:
 	verbosity = kingpin.Flag("verbose", "Increase log verbosity, can be used multiple times").Short('v').Counter()
 	fifoPath  = kingpin.Flag("fifo", "Path to fifo used to communicate between client and server").Hidden().String()
 	logPath   = kingpin.Flag("log", "Path to server's log file, or '-' for stdout").Hidden().String()
+	instance  = kingpin.Flag("name", "Name of a separate server instance to use, with its own services, fifo & log").String()
+	local     = kingpin.Flag("local", "Use a server for the project in the current dir, with its state in ./"+configDir).Bool()
+	project   = kingpin.Flag("project", "Path to the root of a project to use a local server for").Hidden().String()
+	noTray    = kingpin.Flag("no-tray", "Run the server without a system tray UI").Bool()
+	noColor   = kingpin.Flag("no-color", "Don't color output, same as setting a NO_COLOR env var").Bool()
+	timeout   = kingpin.Flag("timeout", "Give up on a call to the server that takes longer than this, like '30s', including waiting on services or for new output").HintOptions("10s", "1m", "10m").Duration()
 )
 
+func init() {
+	// The name servers are started with, by clients
+	kingpin.Flag("instance", "Same as --name").Hidden().StringVar(instance)
+}
+
 // ConfFormat is the yaml definition of the config file
 type ConfFormat struct {
-	LogLevel               string `yaml:"log_level"`
-	LogPath                string `yaml:"log"`
-	FifoPath               string `yaml:"fifo"`
-	CleanTempServicesAfter string `yaml:"clean_temp_services_after"`
+	LogLevel               string   `yaml:"log_level"`
+	LogFormat              string   `yaml:"log_format"`
+	LogRetention           string   `yaml:"log_retention"`
+	LogMaxTotal            string   `yaml:"log_max_total"`
+	LogPath                string   `yaml:"log"`
+	FifoPath               string   `yaml:"fifo"`
+	AbstractSocket         bool     `yaml:"abstract_socket"`
+	Tray                   *bool    `yaml:"tray"`
+	TrayTooltip            string   `yaml:"tray_tooltip"`
+	TrayTooltipLines       *int     `yaml:"tray_tooltip_lines"`
+	TrayErrorClearAfter    string   `yaml:"tray_error_clear_after"`
+	CleanTempServicesAfter string   `yaml:"clean_temp_services_after"`
+	NotifyRunOnce          bool     `yaml:"notify_run_once"`
+	MaxOutputMemory        string   `yaml:"max_output_memory"`
+	MaxTailResponse        string   `yaml:"max_tail_response"`
+	MaxParallelStarts      int      `yaml:"max_parallel_starts"`
+	MaxConnections         *int     `yaml:"max_connections"`
+	MaxConnectionRate      *int     `yaml:"max_connection_rate"`
+	MaxCallRate            *int     `yaml:"max_call_rate"`
+	Admins                 []string `yaml:"admins"`
+	Profile                string   `yaml:"profile"`
+	Hooks                  string   `yaml:"hooks"`
+	ShutdownTimeout        string   `yaml:"shutdown_timeout"`
+	FlappingRestarts       int      `yaml:"flapping_restarts"`
+	FlappingWindow         string   `yaml:"flapping_window"`
+
+	Colors map[string]string `yaml:"colors"`
 }
 
 // Load reads the config file and populates the global conf. It also handles
@@ -100,12 +360,70 @@ func Load(isServer bool) error {
 	if err != nil {
 		return fmt.Errorf("Failed to determine full config file path: %v", err)
 	}
+	legacyDirPath, err := getLegacyConfPath()
+	if err != nil {
+		return fmt.Errorf("Failed to determine legacy config dir path: %v", err)
+	}
 
-	// Create the config dir if it doesn't exist. Make with user-only
+	// Create the config dirs if they don't exist. Make with user-only
 	// permissions, cuz fifo exists there, which can be used to control
 	// server. Also saved service details could be sensitive.
-	if os.Mkdir(dirPath, 0700); err != nil {
-		return fmt.Errorf("Failed to create config dir (%s): %v", dirPath, err)
+	for _, kind := range allDirKinds {
+		baseDirPath, err := getBaseDir(kind)
+		if err != nil {
+			return fmt.Errorf("Failed to determine full config dir path: %v", err)
+		}
+		if err := os.MkdirAll(baseDirPath, 0700); err != nil {
+			return fmt.Errorf("Failed to create config dir (%s): %v", baseDirPath, err)
+		}
+	}
+
+	// If using XDG dirs, bring over conf files from before
+	if err := migrateLegacyConf(); err != nil {
+		log.Warn("Failed to move config files to XDG config dir", "from", legacyDirPath, "to", dirPath, "err", err)
+	}
+
+	// Projects keep their files in their own dir. Either explicitly asked
+	// for, or found by a services file in a config dir in the current dir or
+	// one of its parents.
+	if (*local || *project != "") && *instance != "" {
+		return fmt.Errorf("Can't use both a named instance and a local project server")
+	} else if *project != "" {
+		ProjectPath = *project
+	} else if *local {
+		if ProjectPath, err = os.Getwd(); err != nil {
+			return fmt.Errorf("Failed to determine project dir: %v", err)
+		}
+	} else if *instance == "" {
+		ProjectPath = findProjectPath(dirPath, legacyDirPath)
+	}
+
+	if ProjectPath != "" {
+		projectConfPath, err := getInstancePath(confKind)
+		if err != nil {
+			return fmt.Errorf("Failed to determine project config dir path: %v", err)
+		}
+		if err := os.MkdirAll(projectConfPath, 0700); err != nil {
+			return fmt.Errorf("Failed to create project config dir (%s): %v", projectConfPath, err)
+		}
+	}
+
+	// Named instances get their own dir for their files
+	if *instance != "" {
+		if strings.ContainsAny(*instance, "/\\") || *instance == "." || *instance == ".." {
+			return fmt.Errorf("Invalid instance name: %s", *instance)
+		}
+		InstanceName = *instance
+
+		for _, kind := range allDirKinds {
+			instancePath, err := getInstancePath(kind)
+			if err != nil {
+				return fmt.Errorf("Failed to determine instance dir path: %v", err)
+			}
+			if err := os.MkdirAll(instancePath, 0700); err != nil {
+				return fmt.Errorf("Failed to create instance dir (%s): %v", instancePath, err)
+			}
+		}
 	}
 
 	// Try opening the conf file, on most runs it'll already exist
@@ -142,26 +460,116 @@ func Load(isServer bool) error {
 		LogLevel = log.LvlWarn
 	}
 
+	switch conf.LogFormat {
+	case "":
+		LogFormat = LogFormatLogfmt
+	case LogFormatLogfmt, LogFormatJSON:
+		LogFormat = conf.LogFormat
+	default:
+		return fmt.Errorf("Invalid log format '%s', should be '%s' or '%s'", conf.LogFormat, LogFormatLogfmt, LogFormatJSON)
+	}
+
+	if conf.LogRetention != "" {
+		dur, err := parseDuration(conf.LogRetention)
+		if err != nil {
+			return fmt.Errorf("Invalid duration for log retention")
+		}
+		LogRetention = dur
+	}
+
+	if conf.LogMaxTotal != "" {
+		bytes, err := humanize.ParseBytes(conf.LogMaxTotal)
+		if err != nil {
+			return fmt.Errorf("Invalid size for log max total")
+		}
+		LogMaxTotal = int64(bytes)
+	}
+
+	// Paths in the conf file are for the default instance, named ones and
+	// projects always use their own dir.
 	if *logPath != "" {
 		LogPath = *logPath
-	} else if conf.LogPath != "" {
+	} else if conf.LogPath != "" && InstanceName == "" && ProjectPath == "" {
 		LogPath = conf.LogPath
 	} else {
-		if LogPath, err = getFullConfPath("log"); err != nil {
+		if LogPath, err = getInstancePath(stateKind, "log"); err != nil {
 			return fmt.Errorf("Failed to build log file path: %v", err)
 		}
 	}
 
+	if DumpPath, err = getInstancePath(stateKind, "dump"); err != nil {
+		return fmt.Errorf("Failed to build dump file path: %v", err)
+	}
+
+	if PluginDir, err = getFullConfPath("plugins"); err != nil {
+		return fmt.Errorf("Failed to build plugins dir path: %v", err)
+	}
+
+	if SnapshotDir, err = getInstancePath(stateKind, "snapshots"); err != nil {
+		return fmt.Errorf("Failed to build snapshots dir path: %v", err)
+	}
+
+	if NotifySocketDir, err = getInstancePath(runtimeKind, "notify"); err != nil {
+		return fmt.Errorf("Failed to build notify sockets dir path: %v", err)
+	}
+
+	if AutocompleteCachePath, err = getInstancePath(stateKind, "autocomplete.yml"); err != nil {
+		return fmt.Errorf("Failed to build autocomplete cache path: %v", err)
+	}
+
 	if *fifoPath != "" {
 		FifoPath = *fifoPath
-	} else if conf.FifoPath != "" {
+	} else if conf.FifoPath != "" && InstanceName == "" && ProjectPath == "" {
 		FifoPath = conf.FifoPath
 	} else {
-		if FifoPath, err = getFullConfPath(FifoPath); err != nil {
+		if FifoPath, err = getInstancePath(runtimeKind, fifoFile); err != nil {
 			return fmt.Errorf("Failed to build fifo file path: %v", err)
 		}
 	}
 
+	if conf.AbstractSocket && !abstractSocketSupported {
+		log.Warn("Abstract sockets aren't supported on this platform, using fifo file")
+	} else {
+		AbstractSocket = conf.AbstractSocket
+	}
+
+	if *noTray {
+		Tray = false
+	} else if conf.Tray != nil {
+		Tray = *conf.Tray
+	}
+
+	switch conf.TrayTooltip {
+	case "":
+	case TrayTooltipOutput, TrayTooltipHealth, TrayTooltipUptime, TrayTooltipInfo:
+		TrayTooltip = conf.TrayTooltip
+	default:
+		return fmt.Errorf("Invalid tray tooltip '%s', should be '%s', '%s', '%s' or '%s'", conf.TrayTooltip, TrayTooltipOutput, TrayTooltipHealth, TrayTooltipUptime, TrayTooltipInfo)
+	}
+
+	if conf.TrayTooltipLines != nil {
+		if *conf.TrayTooltipLines <= 0 {
+			return fmt.Errorf("Invalid tray tooltip lines, should be more than 0")
+		}
+		TrayTooltipLines = *conf.TrayTooltipLines
+	}
+
+	if conf.TrayErrorClearAfter != "" {
+		dur, err := time.ParseDuration(conf.TrayErrorClearAfter)
+		if err != nil || dur < 0 {
+			return fmt.Errorf("Invalid duration for clearing tray errors")
+		}
+		TrayErrorClearAfter = dur
+	}
+
+	CallTimeout = *timeout
+
+	// Any value of NO_COLOR turns colors off, see no-color.org
+	if *noColor || os.Getenv("NO_COLOR") != "" {
+		Color = false
+	}
+	ColorTheme = conf.Colors
+
 	if conf.CleanTempServicesAfter != "" {
 		dur, err := time.ParseDuration(conf.CleanTempServicesAfter)
 		if err != nil {
@@ -169,14 +577,103 @@ func Load(isServer bool) error {
 		}
 		CleanTempServicesAfter = dur
 	}
+	NotifyRunOnce = conf.NotifyRunOnce
+
+	if conf.MaxOutputMemory != "" {
+		bytes, err := humanize.ParseBytes(conf.MaxOutputMemory)
+		if err != nil {
+			return fmt.Errorf("Invalid size for max output memory")
+		}
+		MaxOutputMemory = int64(bytes)
+	}
+
+	if conf.MaxTailResponse != "" {
+		size, err := ParseSize(conf.MaxTailResponse)
+		if err != nil {
+			return fmt.Errorf("Invalid max_tail_response: %v", err)
+		}
+		MaxTailResponse = size
+	}
+
+	if conf.ShutdownTimeout != "" {
+		dur, err := time.ParseDuration(conf.ShutdownTimeout)
+		if err != nil {
+			return fmt.Errorf("Invalid duration for shutdown timeout")
+		}
+		ShutdownTimeout = dur
+	}
+
+	if conf.FlappingRestarts < 0 {
+		return fmt.Errorf("Invalid flapping restarts, can't be negative")
+	} else if conf.FlappingRestarts > 0 {
+		FlappingRestarts = conf.FlappingRestarts
+	}
+
+	if conf.FlappingWindow != "" {
+		dur, err := time.ParseDuration(conf.FlappingWindow)
+		if err != nil {
+			return fmt.Errorf("Invalid duration for flapping window")
+		}
+		FlappingWindow = dur
+	}
+
+	if conf.MaxParallelStarts < 0 {
+		return fmt.Errorf("Invalid max parallel starts, can't be negative")
+	}
+	MaxParallelStarts = conf.MaxParallelStarts
+
+	if conf.MaxConnections != nil {
+		if *conf.MaxConnections < 0 {
+			return fmt.Errorf("Invalid max connections, can't be negative")
+		}
+		MaxConnections = *conf.MaxConnections
+	}
+	if conf.MaxConnectionRate != nil {
+		if *conf.MaxConnectionRate < 0 {
+			return fmt.Errorf("Invalid max connection rate, can't be negative")
+		}
+		MaxConnectionRate = *conf.MaxConnectionRate
+	}
+	if conf.MaxCallRate != nil {
+		if *conf.MaxCallRate < 0 {
+			return fmt.Errorf("Invalid max call rate, can't be negative")
+		}
+		MaxCallRate = *conf.MaxCallRate
+	}
+
+	AdminUIDs = make(map[int]bool)
+	for _, admin := range conf.Admins {
+		uid, err := strconv.Atoi(admin)
+		if err != nil {
+			usr, err := user.Lookup(admin)
+			if err != nil {
+				return fmt.Errorf("Invalid admin '%s': %v", admin, err)
+			}
+			if uid, err = strconv.Atoi(usr.Uid); err != nil {
+				return fmt.Errorf("Invalid admin '%s', uid isn't a number: %s", admin, usr.Uid)
+			}
+		}
+		AdminUIDs[uid] = true
+	}
+
+	Profile = conf.Profile
+	if profile := os.Getenv("BENTO_PROFILE"); profile != "" {
+		Profile = profile
+	}
+
+	HooksScript = conf.Hooks
+	if HooksScript != "" && !path.IsAbs(HooksScript) {
+		HooksScript = path.Join(dirPath, HooksScript)
+	}
 
 	// After conf file stuff is all handled, do config related to other stuff
 
 	// Set the path to services conf file only if it exists
-	path, err := getFullConfPath(serviceConfigFile)
+	path, err := getInstancePath(confKind, serviceConfigFile)
 	if err != nil {
 		return fmt.Errorf("Failed to get path to services config file: %v", err)
 	}
+	ServiceConfigPath = path
 	_, err = os.Stat(path)
 	if err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("Failed to open services config file: %v", err)
@@ -186,20 +683,118 @@ func Load(isServer bool) error {
 
 	log.Debug(
 		"Config file loaded",
+		"InstanceName", InstanceName,
+		"ProjectPath", ProjectPath,
 		"LogPath", LogPath,
+		"LogFormat", LogFormat,
+		"LogRetention", LogRetention,
+		"LogMaxTotal", LogMaxTotal,
 		"FifoPath", FifoPath,
-		"CleanTempServicesAfter", CleanTempServicesAfter)
+		"AbstractSocket", AbstractSocket,
+		"Tray", Tray,
+		"CleanTempServicesAfter", CleanTempServicesAfter,
+		"MaxOutputMemory", MaxOutputMemory,
+		"MaxTailResponse", MaxTailResponse,
+		"MaxParallelStarts", MaxParallelStarts,
+		"ShutdownTimeout", ShutdownTimeout,
+		"FlappingRestarts", FlappingRestarts,
+		"FlappingWindow", FlappingWindow)
 	return nil
 }
 
+// parseDuration parses a duration like time.ParseDuration, but also in days,
+// like "7d".
+func parseDuration(value string) (time.Duration, error) {
+	if days := strings.TrimSuffix(value, "d"); days != value {
+		num, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid number of days: %s", value)
+		}
+		return time.Duration(num) * 24 * time.Hour, nil
+	}
+
+	return time.ParseDuration(value)
+}
+
+// SocketAddress gets the unix socket address that clients & the server
+// communicate over. It's FifoPath, or an abstract name derived from it.
+func SocketAddress() string {
+	if AbstractSocket {
+		return "@" + FifoPath
+	}
+	return FifoPath
+}
+
+// StatFifo checks whether there's a fifo to connect to, returning an error
+// like os.Stat does. Abstract sockets can't be checked without connecting,
+// so they always look like they exist.
+func StatFifo() error {
+	if AbstractSocket {
+		return nil
+	}
+
+	_, err := os.Stat(FifoPath)
+	return err
+}
+
 func getFullConfPath(pathParts ...string) (string, error) {
-	usr, err := user.Current()
+	dirPath, err := getBaseDir(confKind)
 	if err != nil {
 		return "", err
 	}
 
-	pathParts = append([]string{usr.HomeDir, configDir}, pathParts...)
+	pathParts = append([]string{dirPath}, pathParts...)
 	fullPath := path.Join(pathParts...)
 
 	return fullPath, nil
 }
+
+// getInstancePath is like getFullConfPath, but for files specific to a server
+// instance, in the base dir for their kind. The default instance's files are
+// right in the base dir, named instances each get a subdir, and projects have
+// a config dir of their own for all kinds of files.
+func getInstancePath(kind dirKind, pathParts ...string) (string, error) {
+	if ProjectPath != "" {
+		pathParts = append([]string{ProjectPath, configDir}, pathParts...)
+		return path.Join(pathParts...), nil
+	} else if InstanceName != "" {
+		pathParts = append([]string{instancesDir, InstanceName}, pathParts...)
+	}
+
+	dirPath, err := getBaseDir(kind)
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(append([]string{dirPath}, pathParts...)...), nil
+}
+
+// findProjectPath looks for a services file in a config dir in the current
+// dir, or its parents, and returns the dir it's in. The user's own config
+// dirs aren't projects, so those are skipped.
+func findProjectPath(userConfPaths ...string) string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+
+	for {
+		confPath := path.Join(dir, configDir)
+		isUserConf := false
+		for _, userConfPath := range userConfPaths {
+			isUserConf = isUserConf || confPath == userConfPath
+		}
+
+		if !isUserConf {
+			if _, err := os.Stat(path.Join(confPath, serviceConfigFile)); err == nil {
+				return dir
+			}
+		}
+
+		parent := path.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
@@ -0,0 +1,157 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule is a cron-style schedule, like "0 4 * * *", with fields for
+// minute, hour, day of month, month, and day of week.
+type CronSchedule struct {
+	minutes, hours, days, months, weekdays uint64
+
+	// If both days of month & week are restricted, either can match, like
+	// with cron
+	anyDay, anyWeekday bool
+}
+
+// cronFields are the ranges of values of each field in a cron schedule
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 6},
+}
+
+// ParseCronSchedule gets a schedule from a cron-style string like
+// "0 4 * * *". Fields can be "*", numbers, ranges like "1-5", steps like
+// "*/15", and lists of those like "0,30".
+func ParseCronSchedule(schedule string) (CronSchedule, error) {
+	fields := strings.Fields(schedule)
+	if len(fields) != len(cronFields) {
+		return CronSchedule{}, fmt.Errorf("Invalid schedule '%s', should have 5 fields like '0 4 * * *'", schedule)
+	}
+
+	var bits [5]uint64
+	for i, field := range fields {
+		var err error
+		if bits[i], err = parseCronField(field, cronFields[i].min, cronFields[i].max); err != nil {
+			return CronSchedule{}, fmt.Errorf("Invalid %s in schedule: %v", cronFields[i].name, err)
+		}
+	}
+
+	return CronSchedule{
+		minutes:    bits[0],
+		hours:      bits[1],
+		days:       bits[2],
+		months:     bits[3],
+		weekdays:   bits[4],
+		anyDay:     fields[2] == "*",
+		anyWeekday: fields[4] == "*",
+	}, nil
+}
+
+// parseCronField gets a bitmask of the values in a field of a cron schedule
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("Bad step '%s'", part[i+1:])
+			}
+			part = part[:i]
+		}
+
+		low, high := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+
+			var err error
+			if low, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("Bad value '%s'", bounds[0])
+			}
+			high = low
+			if len(bounds) == 2 {
+				if high, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("Bad value '%s'", bounds[1])
+				}
+			}
+
+			if low < min || high > max || low > high {
+				return 0, fmt.Errorf("'%s' is out of range %d-%d", part, min, max)
+			}
+		}
+
+		for value := low; value <= high; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+
+	return bits, nil
+}
+
+// Matches returns true if the schedule includes the minute of t.
+func (c CronSchedule) Matches(t time.Time) bool {
+	return c.matchesDay(t) &&
+		c.hours&(1<<uint(t.Hour())) != 0 &&
+		c.minutes&(1<<uint(t.Minute())) != 0
+}
+
+// Next gets the first time the schedule includes after t, or the zero time
+// if it's never, like on Feb 31st.
+func (c CronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	// Any schedule that can happen does within a few years
+	for end := t.AddDate(5, 0, 0); t.Before(end); {
+		if !c.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		} else if c.hours&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		} else if c.minutes&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+		} else {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// matchesDay returns true if the schedule includes any time on t's day.
+func (c CronSchedule) matchesDay(t time.Time) bool {
+	if c.months&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+
+	day := c.days&(1<<uint(t.Day())) != 0
+	weekday := c.weekdays&(1<<uint(t.Weekday())) != 0
+	if !c.anyDay && !c.anyWeekday {
+		return day || weekday
+	}
+	return day && weekday
+}
+
+// NextScheduledRestart gets the next time after t that the service should be
+// restarted on its restart-schedule, or the zero time if it doesn't have one.
+func (s *Service) NextScheduledRestart(t time.Time) time.Time {
+	if s.RestartSchedule == "" {
+		return time.Time{}
+	}
+
+	schedule, err := ParseCronSchedule(s.RestartSchedule)
+	if err != nil {
+		// Sanitize catches this, so it shouldn't happen
+		return time.Time{}
+	}
+
+	return schedule.Next(t)
+}
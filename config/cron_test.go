@@ -0,0 +1,63 @@
+package config_test
+
+import (
+	. "github.com/heewa/bento/config"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"time"
+)
+
+var _ = Describe("CronSchedule", func() {
+	// May 1st, 2016 is a Sunday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2016, 5, day, hour, minute, 0, 0, time.Local)
+	}
+
+	Describe("ParseCronSchedule()", func() {
+		It("should error on a malformed schedule", func() {
+			for _, schedule := range []string{"", "0 4 * *", "60 * * * *", "* 24 * * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+				_, err := ParseCronSchedule(schedule)
+				Expect(err).ToNot(BeNil(), schedule)
+			}
+		})
+	})
+
+	Describe("Matches()", func() {
+		It("should match lists, ranges & steps", func() {
+			schedule, err := ParseCronSchedule("0,30 9-17/2 * * *")
+			Expect(err).To(BeNil())
+
+			Expect(schedule.Matches(at(1, 9, 0))).To(BeTrue())
+			Expect(schedule.Matches(at(1, 11, 30))).To(BeTrue())
+			Expect(schedule.Matches(at(1, 10, 0))).To(BeFalse())
+			Expect(schedule.Matches(at(1, 9, 15))).To(BeFalse())
+			Expect(schedule.Matches(at(1, 19, 0))).To(BeFalse())
+		})
+
+		It("should match either day when both days of month & week are set", func() {
+			schedule, _ := ParseCronSchedule("0 0 15 * 1")
+			Expect(schedule.Matches(at(2, 0, 0))).To(BeTrue())
+			Expect(schedule.Matches(at(15, 0, 0))).To(BeTrue())
+			Expect(schedule.Matches(at(3, 0, 0))).To(BeFalse())
+		})
+	})
+
+	Describe("Next()", func() {
+		It("should get the next time later today", func() {
+			schedule, _ := ParseCronSchedule("0 4 * * *")
+			Expect(schedule.Next(at(1, 1, 30))).To(Equal(at(1, 4, 0)))
+		})
+
+		It("should get the next time on a later day", func() {
+			schedule, _ := ParseCronSchedule("0 4 * * *")
+			Expect(schedule.Next(at(1, 4, 0))).To(Equal(at(2, 4, 0)))
+		})
+
+		It("should be zero for a schedule that never happens", func() {
+			schedule, _ := ParseCronSchedule("0 0 31 2 *")
+			Expect(schedule.Next(at(1, 0, 0)).IsZero()).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// safeFields are the names of Service fields that can be changed on a running
+// service without restarting it.
+var safeFields = []string{
+	"Description",
+	"Tags",
+	"AutoStart",
+	"Profiles",
+	"RestartOnExit",
+	"AutoApply",
+	"KillMode",
+	"ReloadSignal",
+	"RestartStrategy",
+	"OpenURL",
+	"Ports",
+	"StartPriority",
+	"StopTimeout",
+	"RestartWindow",
+	"RestartSchedule",
+	"Temp",
+	"CleanAfter",
+	"NotifyDone",
+}
+
+// FieldDiff is a field that differs between two confs of a service
+type FieldDiff struct {
+	// Name of the field in a services file
+	Field string
+
+	// Values in each conf, formatted for display
+	Current string
+	New     string
+
+	// True if it can be changed on a running service without restarting it
+	Safe bool
+}
+
+// Diff gets the fields that differ between the service's conf and a new one,
+// in the order they're declared. Fields that aren't set in a services file,
+// like whether a service is temporary, are left out.
+func (s *Service) Diff(s2 *Service) []FieldDiff {
+	safe := make(map[string]bool, len(safeFields))
+	for _, name := range safeFields {
+		safe[name] = true
+	}
+
+	current := reflect.ValueOf(s).Elem()
+	changed := reflect.ValueOf(s2).Elem()
+
+	var diffs []FieldDiff
+	for i := 0; i < current.NumField(); i++ {
+		field := current.Type().Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+
+		if reflect.DeepEqual(current.Field(i).Interface(), changed.Field(i).Interface()) {
+			continue
+		}
+
+		diffs = append(diffs, FieldDiff{
+			Field:   name,
+			Current: formatField(current.Field(i)),
+			New:     formatField(changed.Field(i)),
+			Safe:    safe[field.Name],
+		})
+	}
+
+	return diffs
+}
+
+// formatField formats a conf field's value to show on one line, with "-" for
+// one that isn't set.
+func formatField(value reflect.Value) string {
+	if value.IsZero() {
+		return "-"
+	}
+
+	switch value.Kind() {
+	case reflect.Slice, reflect.Map:
+		data, err := json.Marshal(value.Interface())
+		if err != nil {
+			return fmt.Sprintf("%v", value.Interface())
+		}
+		return string(data)
+	case reflect.Ptr, reflect.Struct:
+		// Like "port: 8080, plugin: probe", with the names in a services file
+		data, err := yaml.Marshal(value.Interface())
+		if err != nil {
+			return fmt.Sprintf("%v", value.Interface())
+		}
+		return strings.Join(strings.Split(strings.TrimSpace(string(data)), "\n"), ", ")
+	default:
+		return fmt.Sprintf("%v", value.Interface())
+	}
+}
@@ -0,0 +1,149 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+
+	log "github.com/inconshreveable/log15"
+)
+
+const (
+	dotEnvFile = ".env"
+	envrcFile  = ".envrc"
+)
+
+// LoadDirEnv gets the env vars a dir sets up for programs run in it, from a
+// .env file, and a .envrc through direnv, if it's installed, like a shell in
+// that dir would have. Vars from .envrc win. Missing files are fine.
+func LoadDirEnv(dir string) (map[string]string, error) {
+	env := make(map[string]string)
+
+	data, err := ioutil.ReadFile(path.Join(dir, dotEnvFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Failed to read %s: %v", dotEnvFile, err)
+	} else if err == nil {
+		if env, err = ParseDotEnv(data); err != nil {
+			return nil, fmt.Errorf("Failed to parse %s: %v", dotEnvFile, err)
+		}
+	}
+
+	if _, err := os.Stat(path.Join(dir, envrcFile)); err != nil {
+		return env, nil
+	}
+
+	envrc, err := direnvExport(dir)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range envrc {
+		env[key] = value
+	}
+
+	return env, nil
+}
+
+// direnvExport gets what direnv would set for a dir. It only evaluates a
+// .envrc that's been allowed with `direnv allow`.
+func direnvExport(dir string) (map[string]string, error) {
+	direnv, err := exec.LookPath("direnv")
+	if err != nil {
+		log.Warn("Found an .envrc, but not direnv to evaluate it, so leaving it out", "dir", dir)
+		return nil, nil
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(direnv, "export", "json")
+	cmd.Dir = dir
+	cmd.Stderr = &stderr
+
+	// Leave out direnv's own vars, from its shell hook having loaded an
+	// .envrc already, or it only prints what changed since then, which is
+	// usually nothing
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "DIRENV_") {
+			cmd.Env = append(cmd.Env, kv)
+		}
+	}
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to evaluate %s with direnv: %v: %s", envrcFile, err, strings.TrimSpace(stderr.String()))
+	} else if len(bytes.TrimSpace(out)) == 0 {
+		// Nothing to change, or it isn't allowed, which direnv says on stderr
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			log.Warn("direnv didn't load .envrc", "dir", dir, "msg", msg)
+		}
+		return nil, nil
+	}
+
+	// Unset vars are null
+	var changes map[string]*string
+	if err := json.Unmarshal(out, &changes); err != nil {
+		return nil, fmt.Errorf("Failed to parse direnv's output: %v", err)
+	}
+
+	env := make(map[string]string)
+	for key, value := range changes {
+		// Leave out direnv's own bookkeeping
+		if value != nil && !strings.HasPrefix(key, "DIRENV_") {
+			env[key] = *value
+		}
+	}
+	return env, nil
+}
+
+// ParseDotEnv parses a .env file's KEY=value lines. Lines can start with
+// `export`, values can be in single quotes, taken as-is, or double quotes,
+// with escapes like \n, and # starts a comment outside of quotes.
+func ParseDotEnv(data []byte) (map[string]string, error) {
+	env := make(map[string]string)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+
+		parts := strings.SplitN(line, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("Line %d isn't like KEY=value", lineNum)
+		}
+
+		value := strings.TrimSpace(parts[1])
+		switch {
+		case strings.HasPrefix(value, "'"):
+			end := strings.Index(value[1:], "'")
+			if end < 0 {
+				return nil, fmt.Errorf("Line %d has an unclosed quote", lineNum)
+			}
+			value = value[1 : end+1]
+		case strings.HasPrefix(value, `"`):
+			unquoted, err := strconv.QuotedPrefix(value)
+			if err != nil {
+				return nil, fmt.Errorf("Line %d has a bad quoted value", lineNum)
+			}
+			if value, err = strconv.Unquote(unquoted); err != nil {
+				return nil, fmt.Errorf("Line %d has a bad quoted value", lineNum)
+			}
+		default:
+			if comment := strings.Index(value, " #"); comment >= 0 {
+				value = strings.TrimSpace(value[:comment])
+			}
+		}
+
+		env[key] = value
+	}
+
+	return env, scanner.Err()
+}
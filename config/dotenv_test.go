@@ -0,0 +1,36 @@
+package config_test
+
+import (
+	. "github.com/heewa/bento/config"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseDotEnv()", func() {
+	It("should parse plain, quoted & exported values", func() {
+		env, err := ParseDotEnv([]byte(`
+# A comment
+PLAIN=value # trailing comment
+export EXPORTED=yes
+SINGLE='a # b $HOME'
+DOUBLE="line\nnext"
+EMPTY=
+`))
+		Expect(err).To(BeNil())
+		Expect(env).To(Equal(map[string]string{
+			"PLAIN":    "value",
+			"EXPORTED": "yes",
+			"SINGLE":   "a # b $HOME",
+			"DOUBLE":   "line\nnext",
+			"EMPTY":    "",
+		}))
+	})
+
+	It("should error on malformed lines", func() {
+		for _, data := range []string{"NOEQUALS", "=value", "A B=c", "A='open", `A="open`} {
+			_, err := ParseDotEnv([]byte(data))
+			Expect(err).ToNot(BeNil(), data)
+		}
+	})
+})
@@ -7,32 +7,180 @@ import (
 	"io/ioutil"
 	"os"
 	"os/user"
+	"path/filepath"
 	"reflect"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/dustin/go-humanize"
+	log "github.com/inconshreveable/log15"
 	"gopkg.in/yaml.v2"
 )
 
+// ReadyCondition is what a service's process has to do to be considered ready
+type ReadyCondition struct {
+	// Listening on this port on localhost
+	Port int `yaml:"port,omitempty"`
+
+	// A probe plugin in the plugins dir exits with 0
+	Plugin string `yaml:"plugin,omitempty"`
+}
+
+// HostCondition is which machines a service applies to. Each field that's set
+// has to match.
+type HostCondition struct {
+	OS       string `yaml:"os,omitempty"`
+	Hostname string `yaml:"hostname,omitempty"`
+}
+
+// Matches checks if the condition holds on a machine
+func (c *HostCondition) Matches(vars HostVars) bool {
+	return (c.OS == "" || c.OS == vars.OS) && (c.Hostname == "" || c.Hostname == vars.Hostname)
+}
+
+// PortAuto has the server pick a free port for a service
+const PortAuto = "auto"
+
+// Kill modes, for how a service's process is stopped
+const (
+	// KillGroup stops the service's process, falling back to its whole
+	// process group, including any children, if that doesn't work.
+	KillGroup = "group"
+
+	// KillProcess only ever stops the service's process, leaving any children
+	// it spawned running.
+	KillProcess = "process"
+)
+
+// Restart strategies, for how a running service is restarted
+const (
+	// RestartStopStart stops the service's process, then starts a new one.
+	RestartStopStart = "stop-start"
+
+	// RestartOverlap starts a new process, and only stops the old one once
+	// the new one is ready.
+	RestartOverlap = "overlap"
+)
+
 // Service is the settings a service is made from
 type Service struct {
 	Name string `yaml:"name"`
 
+	// What the service is for, and labels to find it by, like "backend"
+	Description string   `yaml:"description,omitempty"`
+	Tags        []string `yaml:"tags,omitempty"`
+
 	// What to run
 	Program string   `yaml:"program"`
 	Args    []string `yaml:"args,omitempty"`
 
+	// Only load the service on matching machines
+	OnlyOn *HostCondition `yaml:"only-on,omitempty"`
+
 	// Runtime env
 	Dir string            `yaml:"dir,omitempty"`
 	Env map[string]string `yaml:"env,omitempty"`
 
+	// Address for the server to listen on, passing the socket on to the
+	// service, like "tcp://:8080" or "unix:///tmp/app.sock"
+	Listen string `yaml:"listen,omitempty"`
+
+	// Port for the service, passed to it in the PORT env var, either a
+	// number or PortAuto for the server to pick one.
+	Port string `yaml:"port,omitempty"`
+
+	// Other ports the service listens on, which have to be free for it to
+	// start
+	Ports []int `yaml:"ports,omitempty"`
+
+	// Modes of the stack the service is part of, like "dev", for auto-starts
+	// and starting them together
+	Profiles []string `yaml:"profiles,omitempty"`
+
 	// Behavior
-	AutoStart     bool `yaml:"auto-start,omitempty"`
-	RestartOnExit bool `yaml:"restart-on-exit,omitempty"`
+	AutoStart     bool   `yaml:"auto-start,omitempty"`
+	RestartOnExit bool   `yaml:"restart-on-exit,omitempty"`
+	AutoApply     bool   `yaml:"auto-apply,omitempty"`
+	KillMode      string `yaml:"kill-mode,omitempty"`
+	ReloadSignal  string `yaml:"reload-signal,omitempty"`
+
+	RestartStrategy string          `yaml:"restart-strategy,omitempty"`
+	ReadyWhen       *ReadyCondition `yaml:"ready-when,omitempty"`
+	StartTimeout    time.Duration   `yaml:"start-timeout,omitempty"`
+
+	// Daily hours automatic restarts are allowed in, like "22:00-06:00"
+	RestartWindow string `yaml:"restart-window,omitempty"`
+
+	// Cron-style schedule to restart the service on while it's running, like
+	// "0 4 * * *"
+	RestartSchedule string `yaml:"restart-schedule,omitempty"`
+
+	// How long to wait for the service to exit after each signal when
+	// stopping it, before escalating to a more urgent one
+	StopTimeout time.Duration `yaml:"stop-timeout,omitempty"`
+
+	// If set, the service has to ping its watchdog at least this often, like
+	// with systemd's sd_notify(3) WATCHDOG=1, or it's restarted as hung
+	Watchdog time.Duration `yaml:"watchdog,omitempty"`
+
+	// If true, the service's processes can dump core when they crash, by
+	// lifting their core size limit
+	CoreDumps bool `yaml:"core-dumps,omitempty"`
+
+	// Auto-starts with a higher priority go first, when only so many
+	// services can start at once
+	StartPriority int `yaml:"start-priority,omitempty"`
+
+	// URL to open once the service is ready after a start
+	OpenURL string `yaml:"open-url,omitempty"`
+
+	// Most lines of output per second to keep, like "1000 lines/s", beyond
+	// which lines are dropped
+	OutputRateLimit string `yaml:"output-rate-limit,omitempty"`
+
+	// Most output to keep from stdout & stderr each, like "10MB", so a flood
+	// of one can't push out the other's lines
+	MaxStdout string `yaml:"max-stdout,omitempty"`
+	MaxStderr string `yaml:"max-stderr,omitempty"`
+
+	// How long to keep output for, like "24h", after which it's dropped, so
+	// a quiet service doesn't hold onto old output for as long as it runs
+	OutputRetention time.Duration `yaml:"output-retention,omitempty"`
 
 	// Temp is true if this config isn't loaded from a file, created at runtime
 	Temp       bool          `yaml:",omitempty"`
 	CleanAfter time.Duration `yaml:",omitempty"`
+
+	// NotifyDone is true if a desktop notification is posted when this temp
+	// service exits
+	NotifyDone bool `yaml:",omitempty"`
+}
+
+// HasTag checks if the service has a tag
+func (s *Service) HasTag(tag string) bool {
+	for _, t := range s.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// InProfile checks if the service is part of a profile
+func (s *Service) InProfile(profile string) bool {
+	for _, p := range s.Profiles {
+		if p == profile {
+			return true
+		}
+	}
+	return false
+}
+
+// ShouldAutoStart checks if the service should be auto-started with an active
+// profile. Ones without profiles always are, if they're set to auto-start.
+func (s *Service) ShouldAutoStart(activeProfile string) bool {
+	return s.AutoStart && (len(s.Profiles) == 0 || s.InProfile(activeProfile))
 }
 
 // ServiceByName implements the sort interface
@@ -51,8 +199,10 @@ func (s *Service) Sanitize() error {
 	case s.Program:
 		return fmt.Errorf("Service needs a program to run")
 	case s.Dir:
-		// Try the user's home dir
-		if usr, err := user.Current(); err == nil {
+		// Try the project's dir, then the user's home dir
+		if ProjectPath != "" {
+			s.Dir = ProjectPath
+		} else if usr, err := user.Current(); err == nil {
 			s.Dir = usr.HomeDir
 		} else {
 			// I guess root?
@@ -60,15 +210,171 @@ func (s *Service) Sanitize() error {
 		}
 	}
 
+	switch s.KillMode {
+	case "":
+		s.KillMode = KillGroup
+	case KillGroup, KillProcess:
+	default:
+		return fmt.Errorf("Invalid kill-mode '%s', should be '%s' or '%s'", s.KillMode, KillGroup, KillProcess)
+	}
+
+	switch s.RestartStrategy {
+	case "":
+		s.RestartStrategy = RestartStopStart
+	case RestartStopStart, RestartOverlap:
+	default:
+		return fmt.Errorf("Invalid restart-strategy '%s', should be '%s' or '%s'", s.RestartStrategy, RestartStopStart, RestartOverlap)
+	}
+
+	for _, tag := range s.Tags {
+		if tag == "" || strings.ContainsAny(tag, ", \t\n") {
+			return fmt.Errorf("Invalid tag '%s', can't be empty or have commas or spaces", tag)
+		}
+	}
+
+	if s.Port != "" && s.Port != PortAuto {
+		if port, err := strconv.Atoi(s.Port); err != nil || port <= 0 || port > 65535 {
+			return fmt.Errorf("Invalid port '%s', should be '%s' or a number", s.Port, PortAuto)
+		}
+	}
+
+	for _, port := range s.Ports {
+		if port <= 0 || port > 65535 {
+			return fmt.Errorf("Invalid port %d in ports", port)
+		}
+	}
+
+	if s.Watchdog < 0 {
+		return fmt.Errorf("Invalid watchdog, can't be negative")
+	}
+
+	if s.OnlyOn != nil && s.OnlyOn.OS == "" && s.OnlyOn.Hostname == "" {
+		return fmt.Errorf("Invalid only-on, needs an os or hostname")
+	}
+
+	if s.ReadyWhen != nil && s.ReadyWhen.Plugin != "" {
+		if s.ReadyWhen.Port != 0 {
+			return fmt.Errorf("Invalid ready-when, needs a port or a plugin, not both")
+		} else if strings.ContainsAny(s.ReadyWhen.Plugin, "/\\") || strings.HasPrefix(s.ReadyWhen.Plugin, ".") {
+			return fmt.Errorf("Invalid ready-when plugin '%s', should be the name of a file in the plugins dir", s.ReadyWhen.Plugin)
+		}
+	} else if s.ReadyWhen != nil && (s.ReadyWhen.Port <= 0 || s.ReadyWhen.Port > 65535) {
+		return fmt.Errorf("Invalid ready-when, needs a port or a plugin")
+	}
+
+	if s.Listen != "" {
+		if _, _, err := ParseListenAddress(s.Listen); err != nil {
+			return fmt.Errorf("Invalid listen address: %v", err)
+		}
+	}
+
+	if s.RestartWindow != "" {
+		if _, err := ParseTimeWindow(s.RestartWindow); err != nil {
+			return fmt.Errorf("Invalid restart-window: %v", err)
+		}
+	}
+
+	if s.RestartSchedule != "" {
+		if _, err := ParseCronSchedule(s.RestartSchedule); err != nil {
+			return fmt.Errorf("Invalid restart-schedule: %v", err)
+		}
+	}
+
+	if s.OutputRateLimit != "" {
+		if _, err := ParseRateLimit(s.OutputRateLimit); err != nil {
+			return fmt.Errorf("Invalid output-rate-limit: %v", err)
+		}
+	}
+
+	if _, err := ParseSize(s.MaxStdout); err != nil {
+		return fmt.Errorf("Invalid max-stdout: %v", err)
+	}
+	if _, err := ParseSize(s.MaxStderr); err != nil {
+		return fmt.Errorf("Invalid max-stderr: %v", err)
+	}
+
+	if s.OutputRetention < 0 {
+		return fmt.Errorf("Invalid output-retention, can't be negative: %v", s.OutputRetention)
+	}
+
+	if s.ReloadSignal == "" {
+		s.ReloadSignal = "HUP"
+	} else if _, err := ParseSignal(s.ReloadSignal); err != nil {
+		return fmt.Errorf("Invalid reload-signal: %v", err)
+	}
+
 	if s.Temp && s.CleanAfter == 0 {
 		s.CleanAfter = CleanTempServicesAfter
 	} else if !s.Temp {
 		s.CleanAfter = 0
 	}
 
+	if s.Temp && NotifyRunOnce {
+		s.NotifyDone = true
+	} else if !s.Temp {
+		s.NotifyDone = false
+	}
+
 	return nil
 }
 
+// ParseListenAddress splits an address like "tcp://:8080" or
+// "unix:///tmp/app.sock" into a network & address. Without a scheme, it's tcp.
+func ParseListenAddress(listen string) (network, address string, err error) {
+	parts := strings.SplitN(listen, "://", 2)
+	if len(parts) == 1 {
+		return "tcp", listen, nil
+	}
+
+	switch parts[0] {
+	case "tcp", "tcp4", "tcp6", "unix":
+		return parts[0], parts[1], nil
+	}
+
+	return "", "", fmt.Errorf("Unsupported network '%s' to listen on", parts[0])
+}
+
+// ParseRateLimit parses a rate of lines per second, like "1000 lines/s",
+// "1000/s", or just "1000". An empty one is 0, for no limit.
+func ParseRateLimit(limit string) (int, error) {
+	if limit == "" {
+		return 0, nil
+	}
+
+	number := strings.TrimSpace(limit)
+	for _, suffix := range []string{"lines/s", "/s"} {
+		if strings.HasSuffix(number, suffix) {
+			number = strings.TrimSpace(strings.TrimSuffix(number, suffix))
+			break
+		}
+	}
+
+	rate, err := strconv.Atoi(number)
+	if err != nil || rate <= 0 {
+		return 0, fmt.Errorf("Bad rate '%s', should be like '1000 lines/s'", limit)
+	}
+
+	return rate, nil
+}
+
+// ParseSize parses a size of output, like "10MB". An empty one is 0, for no
+// limit.
+func ParseSize(size string) (int, error) {
+	if size == "" {
+		return 0, nil
+	}
+
+	bytes, err := humanize.ParseBytes(size)
+	if err != nil || bytes == 0 || bytes > maxInt {
+		return 0, fmt.Errorf("Bad size '%s', should be like '10MB'", size)
+	}
+
+	return int(bytes), nil
+}
+
+// maxInt is the biggest an int can be, to check sizes fit in one
+const maxInt = uint64(^uint(0) >> 1)
+
 // EqualIgnoringSafeFields returns true if the service config equals another,
 // ignoring fields that can be safely changed on a running service.
 func (s *Service) EqualIgnoringSafeFields(s2 *Service) bool {
@@ -89,38 +395,174 @@ func (s *Service) EqualIgnoringSafeFields(s2 *Service) bool {
 	}
 
 	// Clear white-list fields
-	s2Copy.AutoStart = s.AutoStart
-	s2Copy.RestartOnExit = s.RestartOnExit
-	s2Copy.Temp = s.Temp
-	s2Copy.CleanAfter = s.CleanAfter
+	copied := reflect.ValueOf(&s2Copy).Elem()
+	current := reflect.ValueOf(s).Elem()
+	for _, name := range safeFields {
+		copied.FieldByName(name).Set(current.FieldByName(name))
+	}
 
 	return reflect.DeepEqual(s, &s2Copy)
 }
 
-// LoadServiceFile reads a file for a list of service confs, sanitizing them
-// all
-func LoadServiceFile(path string) ([]Service, error) {
+// LoadServiceFile reads a file for a list of service confs, filling in
+// template vars (see HostVars), merging in its override file if there is one,
+// sanitizing them all, and leaving out ones that are only-on other machines.
+// Services that are broken, or share a name with another, are returned as bad
+// instead of failing the whole file, so the rest can still be loaded.
+func LoadServiceFile(path string) ([]Service, []BadService, error) {
+	confs, vars, err := readServiceFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Entries sharing a name can't tell which one is meant, so none of them
+	// are used
+	names := make(map[string]int, len(confs))
+	for i := range confs {
+		names[confs[i].Name]++
+	}
+
+	services := make([]Service, 0, len(confs))
+	var bad []BadService
+	reported := make(map[string]bool)
+	for i := range confs {
+		if name := confs[i].Name; name != "" && names[name] > 1 {
+			if !reported[name] {
+				reported[name] = true
+				bad = append(bad, BadService{name, fmt.Errorf("Service name='%s' is defined %d times", name, names[name])})
+			}
+			continue
+		}
+
+		if err := confs[i].Sanitize(); err != nil {
+			bad = append(bad, BadService{confs[i].Name, fmt.Errorf("Bad service definition for name='%s': %v", confs[i].Name, err)})
+			continue
+		}
+
+		if confs[i].OnlyOn != nil && !confs[i].OnlyOn.Matches(vars) {
+			log.Debug("Skipping service that's not for this machine", "service", confs[i].Name, "only-on", *confs[i].OnlyOn)
+			continue
+		}
+		services = append(services, confs[i])
+	}
+
+	return services, bad, nil
+}
+
+// BadService is a service in a services file that couldn't be loaded
+type BadService struct {
+	Name string
+	Err  error
+}
+
+// LoadServiceFromFile reads a single service's conf from a file, like
+// LoadServiceFile, but without the rest of the file's services needing to be
+// valid. Returns nil if it's not in the file, or it's only on other machines.
+func LoadServiceFromFile(path, name string) (*Service, error) {
+	confs, vars, err := readServiceFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range confs {
+		if confs[i].Name != name {
+			continue
+		}
+
+		if err := confs[i].Sanitize(); err != nil {
+			return nil, fmt.Errorf("Bad service definition for name='%s': %v", name, err)
+		}
+
+		if confs[i].OnlyOn != nil && !confs[i].OnlyOn.Matches(vars) {
+			log.Debug("Skipping service that's not for this machine", "service", name, "only-on", *confs[i].OnlyOn)
+			return nil, nil
+		}
+		return &confs[i], nil
+	}
+
+	return nil, nil
+}
+
+// readServiceFile reads the unsanitized service confs from a file, filling in
+// template vars and merging in its override file.
+func readServiceFile(path string) ([]Service, HostVars, error) {
+	vars := CurrentHostVars()
+
 	f, err := os.Open(path)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to read service conf (%s): %v", path, err)
+		return nil, vars, fmt.Errorf("Failed to read service conf (%s): %v", path, err)
 	}
 	defer f.Close()
 
 	data, err := ioutil.ReadAll(f)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to read service conf (%s): %v", path, err)
+		return nil, vars, fmt.Errorf("Failed to read service conf (%s): %v", path, err)
 	}
 
-	var services []Service
-	if err := yaml.Unmarshal(data, &services); err != nil {
-		return nil, fmt.Errorf("Invalid service conf (%s): %v", path, err)
+	data = renderTemplate(data, vars)
+
+	var confs []Service
+	if err := yaml.Unmarshal(data, &confs); err != nil {
+		return nil, vars, fmt.Errorf("Invalid service conf (%s): %v", path, err)
+	}
+
+	if confs, err = applyOverrides(OverridePath(path), confs, vars); err != nil {
+		return nil, vars, err
+	}
+
+	return confs, vars, nil
+}
+
+// OverridePath gets the path to a services file's override file, like
+// services.override.yml for services.yml
+func OverridePath(path string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + ".override" + ext
+}
+
+// applyOverrides merges services from an override file, if it exists, over
+// ones from the main file. Fields set in an override replace the service's,
+// except for env, which is merged by key. Services that aren't in the main
+// file are added.
+func applyOverrides(path string, confs []Service, vars HostVars) ([]Service, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return confs, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Failed to read service override conf (%s): %v", path, err)
 	}
 
-	for _, service := range services {
-		if err := service.Sanitize(); err != nil {
-			return nil, fmt.Errorf("Bad service definition for name='%s': %v", service.Name, err)
+	data = renderTemplate(data, vars)
+
+	var overrides []map[string]interface{}
+	if err := yaml.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("Invalid service override conf (%s): %v", path, err)
+	}
+
+	for _, override := range overrides {
+		name, _ := override["name"].(string)
+		if name == "" {
+			return nil, fmt.Errorf("Invalid service override conf (%s): every service needs a name", path)
+		}
+
+		// Decoding onto an existing conf only sets the fields in the override
+		overrideData, err := yaml.Marshal(override)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid service override conf (%s): %v", path, err)
+		}
+
+		i := 0
+		for i < len(confs) && confs[i].Name != name {
+			i++
+		}
+		if i == len(confs) {
+			confs = append(confs, Service{})
+		}
+
+		if err := yaml.Unmarshal(overrideData, &confs[i]); err != nil {
+			return nil, fmt.Errorf("Invalid service override for name='%s': %v", name, err)
 		}
 	}
 
-	return services, nil
+	return confs, nil
 }
@@ -8,6 +8,9 @@ import (
 
 	"bytes"
 	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"runtime"
 	"time"
 )
 
@@ -57,6 +60,69 @@ var _ = Describe("Service", func() {
 			})
 		})
 
+		Context("When there's no KillMode", func() {
+			It("should default to the process group", func() {
+				Expect(aService.Sanitize()).To(BeNil())
+				Expect(aService.KillMode).To(Equal(KillGroup))
+			})
+		})
+
+		Context("When KillMode is invalid", func() {
+			It("should error", func() {
+				aService.KillMode = "everything"
+				Expect(aService.Sanitize()).ToNot(BeNil())
+			})
+		})
+
+		Context("When a tag has a space", func() {
+			It("should error", func() {
+				aService.Tags = []string{"back end"}
+				Expect(aService.Sanitize()).ToNot(BeNil())
+			})
+		})
+
+		Context("When Port is invalid", func() {
+			It("should error", func() {
+				aService.Port = "http"
+				Expect(aService.Sanitize()).ToNot(BeNil())
+			})
+		})
+
+		Context("When one of Ports is invalid", func() {
+			It("should error", func() {
+				aService.Ports = []int{5432, 70000}
+				Expect(aService.Sanitize()).ToNot(BeNil())
+			})
+		})
+
+		Context("When ReloadSignal is invalid", func() {
+			It("should error", func() {
+				aService.ReloadSignal = "SIGNOPE"
+				Expect(aService.Sanitize()).ToNot(BeNil())
+			})
+		})
+
+		Context("When RestartWindow is invalid", func() {
+			It("should error", func() {
+				aService.RestartWindow = "nightly"
+				Expect(aService.Sanitize()).ToNot(BeNil())
+			})
+		})
+
+		Context("When OutputRateLimit is invalid", func() {
+			It("should error", func() {
+				aService.OutputRateLimit = "lots"
+				Expect(aService.Sanitize()).ToNot(BeNil())
+			})
+		})
+
+		Context("When MaxStderr is invalid", func() {
+			It("should error", func() {
+				aService.MaxStderr = "big"
+				Expect(aService.Sanitize()).ToNot(BeNil())
+			})
+		})
+
 		Describe("Temp Services", func() {
 			Context("When there's no CleanAfter on a temp Service", func() {
 				It("should set it to the default", func() {
@@ -116,5 +182,174 @@ var _ = Describe("Service", func() {
 				Expect(aService.EqualIgnoringSafeFields(&anotherService)).To(Equal(true))
 			})
 		})
+
+		Context("When only the description & tags are different", func() {
+			It("returns true", func() {
+				anotherService.Description = "Echoes"
+				anotherService.Tags = []string{"backend"}
+				Expect(aService.EqualIgnoringSafeFields(&anotherService)).To(Equal(true))
+			})
+		})
+	})
+
+	Describe("Diff()", func() {
+		It("lists changed fields by their file names, and whether they're safe", func() {
+			changed := aService
+			changed.Args = []string{"nay"}
+			changed.Description = "Echoes"
+			changed.ReadyWhen = &ReadyCondition{Port: 8080}
+
+			Expect(aService.Diff(&changed)).To(Equal([]FieldDiff{
+				{Field: "description", Current: "-", New: "Echoes", Safe: true},
+				{Field: "args", Current: `["yay"]`, New: `["nay"]`, Safe: false},
+				{Field: "ready-when", Current: "-", New: "port: 8080", Safe: false},
+			}))
+		})
+
+		It("leaves out fields that aren't in a services file", func() {
+			changed := aService
+			changed.Temp = true
+
+			Expect(aService.Diff(&changed)).To(BeEmpty())
+		})
+	})
+
+	Describe("ShouldAutoStart()", func() {
+		BeforeEach(func() {
+			aService.AutoStart = true
+		})
+
+		It("auto-starts without profiles", func() {
+			Expect(aService.ShouldAutoStart("dev")).To(BeTrue())
+		})
+
+		It("only auto-starts in an active profile", func() {
+			aService.Profiles = []string{"dev", "test"}
+			Expect(aService.ShouldAutoStart("test")).To(BeTrue())
+			Expect(aService.ShouldAutoStart("")).To(BeFalse())
+		})
+	})
+
+	Describe("LoadServiceFile()", func() {
+		var path string
+
+		writeFile := func(content string) {
+			f, err := ioutil.TempFile("", "services.yml")
+			Expect(err).To(BeNil())
+			defer f.Close()
+
+			_, err = f.WriteString(content)
+			Expect(err).To(BeNil())
+			path = f.Name()
+		}
+
+		AfterEach(func() {
+			os.Remove(path)
+			os.Remove(OverridePath(path))
+		})
+
+		Context("With host template vars", func() {
+			It("fills them in", func() {
+				writeFile("- name: app\n  program: /bin/echo\n  args: ['{{.OS}}']\n  dir: {{.Home}}\n")
+
+				services, _, err := LoadServiceFile(path)
+				Expect(err).To(BeNil())
+				Expect(services).To(HaveLen(1))
+				Expect(services[0].Args).To(Equal([]string{runtime.GOOS}))
+				Expect(services[0].Dir).To(Equal(CurrentHostVars().Home))
+			})
+		})
+
+		Context("With services only on some machines", func() {
+			It("leaves out ones for other machines", func() {
+				writeFile("- name: here\n  program: /bin/echo\n  only-on: {os: '{{.OS}}'}\n" +
+					"- name: there\n  program: /bin/echo\n  only-on: {os: plan9, hostname: '{{.Hostname}}'}\n")
+
+				services, _, err := LoadServiceFile(path)
+				Expect(err).To(BeNil())
+				Expect(services).To(HaveLen(1))
+				Expect(services[0].Name).To(Equal("here"))
+			})
+		})
+
+		Context("With a broken service", func() {
+			BeforeEach(func() {
+				writeFile("- name: good\n  program: /bin/echo\n" +
+					"- name: broken\n  program: /bin/echo\n  kill-mode: sometimes\n")
+			})
+
+			It("reports it as bad, and still loads the others", func() {
+				services, bad, err := LoadServiceFile(path)
+				Expect(err).To(BeNil())
+				Expect(services).To(HaveLen(1))
+				Expect(services[0].Name).To(Equal("good"))
+				Expect(bad).To(HaveLen(1))
+				Expect(bad[0].Name).To(Equal("broken"))
+			})
+
+			It("can still load the other services one at a time", func() {
+				service, err := LoadServiceFromFile(path, "good")
+				Expect(err).To(BeNil())
+				Expect(service.Program).To(Equal("/bin/echo"))
+
+				_, err = LoadServiceFromFile(path, "broken")
+				Expect(err).NotTo(BeNil())
+
+				service, err = LoadServiceFromFile(path, "missing")
+				Expect(err).To(BeNil())
+				Expect(service).To(BeNil())
+			})
+		})
+
+		Context("With two services with the same name", func() {
+			It("reports the name as bad once, and loads neither", func() {
+				writeFile("- name: twin\n  program: /bin/echo\n" +
+					"- name: other\n  program: /bin/echo\n" +
+					"- name: twin\n  program: /bin/true\n")
+
+				services, bad, err := LoadServiceFile(path)
+				Expect(err).To(BeNil())
+				Expect(services).To(HaveLen(1))
+				Expect(services[0].Name).To(Equal("other"))
+				Expect(bad).To(HaveLen(1))
+				Expect(bad[0].Name).To(Equal("twin"))
+			})
+		})
+
+		Context("With an override file", func() {
+			It("merges it over the services", func() {
+				writeFile("- name: app\n  program: /bin/echo\n  port: '80'\n  env: {A: a, B: b}\n")
+				Expect(ioutil.WriteFile(OverridePath(path), []byte(
+					"- name: app\n  port: '8080'\n  env: {B: local}\n"+
+						"- name: extra\n  program: /bin/echo\n"), 0600)).To(BeNil())
+
+				services, _, err := LoadServiceFile(path)
+				Expect(err).To(BeNil())
+				Expect(services).To(HaveLen(2))
+				Expect(services[0].Program).To(Equal("/bin/echo"))
+				Expect(services[0].Port).To(Equal("8080"))
+				Expect(services[0].Env).To(Equal(map[string]string{"A": "a", "B": "local"}))
+				Expect(services[1].Name).To(Equal("extra"))
+			})
+		})
+
+		Context("With other {{ }} in it", func() {
+			It("leaves them as is", func() {
+				writeFile("- name: app\n  program: docker\n  args: [ps, --format, '{{.Names}} {{.OS}}']\n")
+
+				services, _, err := LoadServiceFile(path)
+				Expect(err).To(BeNil())
+				Expect(services).To(HaveLen(1))
+				Expect(services[0].Args).To(Equal([]string{"ps", "--format", "{{.Names}} " + runtime.GOOS}))
+			})
+
+			It("can escape a known var", func() {
+				writeFile("- name: app\n  program: /bin/echo\n  args: ['{{\"{{\"}}.Home}}']\n")
+
+				services, _, err := LoadServiceFile(path)
+				Expect(err).To(BeNil())
+				Expect(services[0].Args).To(Equal([]string{"{{.Home}}"}))
+			})
+		})
 	})
 })
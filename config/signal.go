@@ -0,0 +1,61 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+// signalsByName are signals that can be named in confs, without the SIG prefix
+var signalsByName = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"TERM": syscall.SIGTERM,
+	"KILL": syscall.SIGKILL,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+// crashSignalNames are signals a program gets for crashing, which don't make
+// sense to send it on purpose, so only get named
+var crashSignalNames = map[syscall.Signal]string{
+	syscall.SIGSEGV: "SEGV",
+	syscall.SIGBUS:  "BUS",
+	syscall.SIGILL:  "ILL",
+	syscall.SIGFPE:  "FPE",
+	syscall.SIGABRT: "ABRT",
+	syscall.SIGTRAP: "TRAP",
+	syscall.SIGSYS:  "SYS",
+	syscall.SIGPIPE: "PIPE",
+}
+
+// IsCrashSignal is true for signals a program gets for crashing, rather than
+// ones something else sent it
+func IsCrashSignal(sig syscall.Signal) bool {
+	_, ok := crashSignalNames[sig]
+	return ok
+}
+
+// ParseSignal gets a signal from a name like "HUP" or "SIGHUP"
+func ParseSignal(name string) (syscall.Signal, error) {
+	sig, ok := signalsByName[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+	if !ok {
+		return 0, fmt.Errorf("Unknown signal '%s'", name)
+	}
+
+	return sig, nil
+}
+
+// SignalName gets a signal's name, like "SIGHUP"
+func SignalName(sig syscall.Signal) string {
+	for name, named := range signalsByName {
+		if named == sig {
+			return "SIG" + name
+		}
+	}
+	if name, ok := crashSignalNames[sig]; ok {
+		return "SIG" + name
+	}
+	return sig.String()
+}
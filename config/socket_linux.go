@@ -0,0 +1,8 @@
+//go:build linux
+// +build linux
+
+package config
+
+// Linux supports unix sockets in an abstract namespace, which aren't backed
+// by a file, and go away when the server's process does.
+const abstractSocketSupported = true
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"os"
+	"os/user"
+	"regexp"
+	"runtime"
+)
+
+// HostVars are what a services file can use in templates, like
+// {{.Hostname}}, so one file can adapt to the machine it's loaded on.
+type HostVars struct {
+	Hostname string
+	User     string
+	Home     string
+	OS       string
+}
+
+// CurrentHostVars gets the template vars for this machine. Ones that can't
+// be found are left empty.
+func CurrentHostVars() HostVars {
+	vars := HostVars{
+		OS: runtime.GOOS,
+	}
+
+	vars.Hostname, _ = os.Hostname()
+	if usr, err := user.Current(); err == nil {
+		vars.User = usr.Username
+		vars.Home = usr.HomeDir
+	}
+
+	return vars
+}
+
+// templateVar matches a known template var, like {{.Home}}, or {{"{{"}}, which
+// is how to write a literal {{ before one
+var templateVar = regexp.MustCompile(`\{\{\s*(\.Hostname|\.User|\.Home|\.OS|"\{\{")\s*\}\}`)
+
+// renderTemplate fills in a services file's template vars. Only the known
+// ones are, and everything else is left as is, so things like a docker
+// --format '{{.Names}}' arg don't need escaping.
+func renderTemplate(data []byte, vars HostVars) []byte {
+	return templateVar.ReplaceAllFunc(data, func(match []byte) []byte {
+		switch name := templateVar.FindSubmatch(match)[1]; string(name) {
+		case ".Hostname":
+			return []byte(vars.Hostname)
+		case ".User":
+			return []byte(vars.User)
+		case ".Home":
+			return []byte(vars.Home)
+		case ".OS":
+			return []byte(vars.OS)
+		default:
+			return []byte("{{")
+		}
+	})
+}
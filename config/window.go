@@ -0,0 +1,61 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TimeWindow is a daily span of time, like "22:00-06:00", which can wrap
+// around midnight
+type TimeWindow struct {
+	// Minutes since midnight
+	Start, End int
+}
+
+// ParseTimeWindow gets a window from a string like "22:00-06:00"
+func ParseTimeWindow(window string) (TimeWindow, error) {
+	parts := strings.Split(window, "-")
+	if len(parts) != 2 {
+		return TimeWindow{}, fmt.Errorf("Invalid time window '%s', should be like '22:00-06:00'", window)
+	}
+
+	var minutes [2]int
+	for i, part := range parts {
+		t, err := time.Parse("15:04", strings.TrimSpace(part))
+		if err != nil {
+			return TimeWindow{}, fmt.Errorf("Invalid time '%s' in window, should be like '22:00'", part)
+		}
+		minutes[i] = t.Hour()*60 + t.Minute()
+	}
+
+	return TimeWindow{Start: minutes[0], End: minutes[1]}, nil
+}
+
+// Contains returns true if the time of day of t is within the window.
+func (w TimeWindow) Contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+
+	if w.Start <= w.End {
+		return minute >= w.Start && minute < w.End
+	}
+
+	// Wraps around midnight
+	return minute >= w.Start || minute < w.End
+}
+
+// InRestartWindow returns true if the service can be automatically restarted
+// at time t, which is any time if it doesn't have a restart-window.
+func (s *Service) InRestartWindow(t time.Time) bool {
+	if s.RestartWindow == "" {
+		return true
+	}
+
+	window, err := ParseTimeWindow(s.RestartWindow)
+	if err != nil {
+		// Sanitize catches this, so it shouldn't happen
+		return true
+	}
+
+	return window.Contains(t)
+}
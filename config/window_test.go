@@ -0,0 +1,49 @@
+package config_test
+
+import (
+	. "github.com/heewa/bento/config"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"time"
+)
+
+var _ = Describe("TimeWindow", func() {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2016, 5, 1, hour, minute, 0, 0, time.Local)
+	}
+
+	Describe("ParseTimeWindow()", func() {
+		It("should parse a window", func() {
+			window, err := ParseTimeWindow("09:30-17:00")
+			Expect(err).To(BeNil())
+			Expect(window).To(Equal(TimeWindow{Start: 9*60 + 30, End: 17 * 60}))
+		})
+
+		It("should error on a malformed window", func() {
+			for _, window := range []string{"", "09:30", "09:30-", "9am-5pm", "25:00-06:00"} {
+				_, err := ParseTimeWindow(window)
+				Expect(err).ToNot(BeNil(), window)
+			}
+		})
+	})
+
+	Describe("Contains()", func() {
+		It("should contain times within a window", func() {
+			window, _ := ParseTimeWindow("09:30-17:00")
+			Expect(window.Contains(at(9, 30))).To(BeTrue())
+			Expect(window.Contains(at(12, 0))).To(BeTrue())
+			Expect(window.Contains(at(9, 29))).To(BeFalse())
+			Expect(window.Contains(at(17, 0))).To(BeFalse())
+		})
+
+		It("should handle windows that wrap around midnight", func() {
+			window, _ := ParseTimeWindow("22:00-06:00")
+			Expect(window.Contains(at(23, 0))).To(BeTrue())
+			Expect(window.Contains(at(2, 0))).To(BeTrue())
+			Expect(window.Contains(at(6, 0))).To(BeFalse())
+			Expect(window.Contains(at(12, 0))).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,144 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path"
+
+	log "github.com/inconshreveable/log15"
+)
+
+const (
+	// Env vars from the XDG base directory spec. When set, they move files
+	// out of the legacy config dir in the user's home.
+	xdgConfigHome = "XDG_CONFIG_HOME"
+	xdgStateHome  = "XDG_STATE_HOME"
+	xdgRuntimeDir = "XDG_RUNTIME_DIR"
+
+	// Name of bento's dir within XDG base dirs
+	xdgAppDir = "bento"
+)
+
+// dirKind is a kind of file, that determines which base dir it lives in
+type dirKind int
+
+const (
+	confKind    dirKind = iota // config.yml & services.yml
+	stateKind                  // log & dump
+	runtimeKind                // fifo
+)
+
+var allDirKinds = []dirKind{confKind, stateKind, runtimeKind}
+
+// getBaseDir gets the dir that files of a kind live in. Without XDG env vars
+// that's the legacy config dir in the user's home for all of them. State &
+// runtime files fall back to the config dir if only that's set.
+func getBaseDir(kind dirKind) (string, error) {
+	var envVar string
+	switch kind {
+	case stateKind:
+		envVar = xdgStateHome
+	case runtimeKind:
+		envVar = xdgRuntimeDir
+	}
+
+	// The spec says to ignore relative paths
+	if dir := os.Getenv(envVar); envVar != "" && path.IsAbs(dir) {
+		return path.Join(dir, xdgAppDir), nil
+	} else if dir := os.Getenv(xdgConfigHome); path.IsAbs(dir) {
+		return path.Join(dir, xdgAppDir), nil
+	}
+
+	return getLegacyConfPath()
+}
+
+// getLegacyConfPath gets the path to the config dir in the user's home, which
+// was the only place for files before XDG support.
+func getLegacyConfPath(pathParts ...string) (string, error) {
+	usr, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+
+	pathParts = append([]string{usr.HomeDir, configDir}, pathParts...)
+	return path.Join(pathParts...), nil
+}
+
+// migrateLegacyConf copies conf files from the legacy config dir to the XDG
+// config dir, if there is one and it doesn't have a config file yet. The
+// originals are left, so an older bento, or a server that's still running,
+// keeps working with them. The config file is copied last, so if any copy
+// fails, it's all tried again next time. State & runtime files are left, since
+// they're recreated as needed.
+func migrateLegacyConf() error {
+	legacyPath, err := getLegacyConfPath()
+	if err != nil {
+		return err
+	}
+	confPath, err := getFullConfPath()
+	if err != nil {
+		return err
+	}
+
+	if legacyPath == confPath {
+		return nil
+	} else if _, err := os.Stat(path.Join(confPath, configFile)); !os.IsNotExist(err) {
+		return err
+	} else if _, err := os.Stat(legacyPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	// Files to copy, relative to either config dir
+	var files []string
+	if instances, err := os.Open(path.Join(legacyPath, instancesDir)); err == nil {
+		names, _ := instances.Readdirnames(-1)
+		instances.Close()
+
+		for _, name := range names {
+			files = append(files, path.Join(instancesDir, name, serviceConfigFile))
+		}
+	}
+	files = append(files, serviceConfigFile, configFile)
+
+	for _, file := range files {
+		from, to := path.Join(legacyPath, file), path.Join(confPath, file)
+		if _, err := os.Stat(from); os.IsNotExist(err) {
+			continue
+		}
+
+		if err := copyConfFile(from, to); err != nil {
+			return fmt.Errorf("Failed to copy %s to %s: %v", from, to, err)
+		}
+
+		log.Warn("Copied config file to XDG config dir, which is used from now on", "from", from, "to", to)
+	}
+
+	return nil
+}
+
+// copyConfFile copies a file, through a temp file, so a failed copy doesn't
+// leave a partial one.
+func copyConfFile(from, to string) error {
+	data, err := ioutil.ReadFile(from)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(path.Dir(to), 0700); err != nil {
+		return err
+	}
+
+	tmpPath := to + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0600); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, to); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,210 @@
+// Package hooks runs a Lua script on service events, for policies that are
+// too complex for the services file, like restarting one service when another
+// crashes twice.
+//
+// The script defines a global on_event(event) function, which gets a table
+// with the event's fields, like event.event, event.service, and
+// event.exit_code. It can call back into bento through the bento table:
+//
+//	bento.start(name), bento.stop(name), bento.restart(name)
+//	bento.set_env(name, key, value)  -- for the service's next start only
+//	bento.notify(message)            -- sent to notifier plugins
+//	bento.log(message)
+//
+// Calls that can fail return true, or nil and an error message. Globals last
+// between events, so the script can keep counts.
+package hooks
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	lua "github.com/yuin/gopher-lua"
+
+	"github.com/heewa/bento/plugin"
+)
+
+const (
+	// How many events can wait for the script before new ones are dropped
+	eventBuffer = 100
+
+	// How long the script can take on one event before it's stopped
+	eventTimeout = 5 * time.Second
+
+	// Name of the function the script defines to get events
+	handlerName = "on_event"
+)
+
+// API is what a script can do to services
+type API interface {
+	Start(name string) error
+	Stop(name string) error
+	Restart(name string) error
+	SetNextEnv(name, key, value string) error
+}
+
+// Runner runs a hooks script, handing it events one at a time
+type Runner struct {
+	path   string
+	api    API
+	state  *lua.LState
+	events chan plugin.Event
+	stop   chan interface{}
+	done   chan interface{}
+}
+
+// Load loads a hooks script, and starts handing it events
+func Load(path string, api API) (*Runner, error) {
+	runner := &Runner{
+		path:   path,
+		api:    api,
+		state:  newState(),
+		events: make(chan plugin.Event, eventBuffer),
+		stop:   make(chan interface{}),
+		done:   make(chan interface{}),
+	}
+	runner.state.SetGlobal("bento", runner.apiTable())
+
+	if err := runner.state.DoFile(path); err != nil {
+		runner.state.Close()
+		return nil, fmt.Errorf("Failed to load hooks script: %v", err)
+	}
+	if runner.state.GetGlobal(handlerName).Type() != lua.LTFunction {
+		runner.state.Close()
+		return nil, fmt.Errorf("Hooks script doesn't define an %s function", handlerName)
+	}
+
+	go runner.run()
+
+	return runner, nil
+}
+
+// newState makes a lua state with only the libs a hook needs, without ones
+// like os & io that reach outside bento.
+func newState() *lua.LState {
+	state := lua.NewState(lua.Options{SkipOpenLibs: true})
+
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		state.Push(state.NewFunction(lib.open))
+		state.Push(lua.LString(lib.name))
+		state.Call(1, 0)
+	}
+
+	// Base has a few that load other files
+	for _, name := range []string{"dofile", "loadfile", "require"} {
+		state.SetGlobal(name, lua.LNil)
+	}
+
+	return state
+}
+
+// Send queues an event for the script. It never blocks, so it's safe to call
+// while handling service updates, which the script's calls can cause.
+func (r *Runner) Send(event plugin.Event) {
+	select {
+	case r.events <- event:
+	default:
+		log.Warn("Hooks script isn't keeping up, dropping event", "event", event.Kind, "service", event.Service)
+	}
+}
+
+// Close stops handing events to the script, waiting for one it's on
+func (r *Runner) Close() {
+	close(r.stop)
+	<-r.done
+	r.state.Close()
+}
+
+func (r *Runner) run() {
+	defer close(r.done)
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case event := <-r.events:
+			if err := r.handle(event); err != nil {
+				log.Warn("Hooks script failed on event", "script", r.path, "event", event.Kind, "service", event.Service, "err", err)
+			}
+		}
+	}
+}
+
+func (r *Runner) handle(event plugin.Event) error {
+	ctx, cancel := context.WithTimeout(context.Background(), eventTimeout)
+	defer cancel()
+	r.state.SetContext(ctx)
+	defer r.state.RemoveContext()
+
+	table := r.state.NewTable()
+	table.RawSetString("event", lua.LString(event.Kind))
+	table.RawSetString("service", lua.LString(event.Service))
+	table.RawSetString("pid", lua.LNumber(event.Pid))
+	table.RawSetString("time", lua.LNumber(event.Time.Unix()))
+	table.RawSetString("exit_code", lua.LNumber(event.ExitCode))
+	table.RawSetString("succeeded", lua.LBool(event.Succeeded))
+	table.RawSetString("health", lua.LString(event.Health))
+	table.RawSetString("duration", lua.LNumber(event.Duration))
+	table.RawSetString("temp", lua.LBool(event.Temp))
+	table.RawSetString("flapping", lua.LBool(event.Flapping))
+
+	return r.state.CallByParam(lua.P{
+		Fn:      r.state.GetGlobal(handlerName),
+		NRet:    0,
+		Protect: true,
+	}, table)
+}
+
+// apiTable makes the bento table of functions the script can call
+func (r *Runner) apiTable() *lua.LTable {
+	byName := func(call func(string) error) lua.LGFunction {
+		return func(state *lua.LState) int {
+			return result(state, call(state.CheckString(1)))
+		}
+	}
+
+	return r.state.SetFuncs(r.state.NewTable(), map[string]lua.LGFunction{
+		"start":   byName(r.api.Start),
+		"stop":    byName(r.api.Stop),
+		"restart": byName(r.api.Restart),
+		"set_env": func(state *lua.LState) int {
+			return result(state, r.api.SetNextEnv(state.CheckString(1), state.CheckString(2), state.CheckString(3)))
+		},
+		"notify": func(state *lua.LState) int {
+			message := state.CheckString(1)
+			log.Info("Hooks script notification", "script", r.path, "message", message)
+			plugin.Notify(plugin.Event{
+				Kind:    plugin.EventNotify,
+				Time:    time.Now(),
+				Message: message,
+			})
+			return 0
+		},
+		"log": func(state *lua.LState) int {
+			log.Info("Hooks script", "script", r.path, "message", state.CheckString(1))
+			return 0
+		},
+	})
+}
+
+// result pushes the lua convention for an outcome: true, or nil & a message
+func result(state *lua.LState, err error) int {
+	if err != nil {
+		state.Push(lua.LNil)
+		state.Push(lua.LString(err.Error()))
+		return 2
+	}
+
+	state.Push(lua.LTrue)
+	return 1
+}
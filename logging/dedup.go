@@ -0,0 +1,30 @@
+package logging
+
+import (
+	log "github.com/inconshreveable/log15"
+)
+
+// DedupHandler returns a Handler that drops context values whose keys are set
+// again later in the same record, so a value passed to a log call, like a
+// "pid", overrides one from the logger's context.
+func DedupHandler(h log.Handler) log.Handler {
+	return log.FuncHandler(func(r *log.Record) error {
+		last := make(map[interface{}]int, len(r.Ctx)/2)
+		for i := 0; i+1 < len(r.Ctx); i += 2 {
+			last[r.Ctx[i]] = i
+		}
+		if len(last)*2 == len(r.Ctx)-len(r.Ctx)%2 {
+			return h.Log(r)
+		}
+
+		ctx := make([]interface{}, 0, len(last)*2)
+		for i := 0; i+1 < len(r.Ctx); i += 2 {
+			if last[r.Ctx[i]] == i {
+				ctx = append(ctx, r.Ctx[i], r.Ctx[i+1])
+			}
+		}
+		r.Ctx = ctx
+
+		return h.Log(r)
+	})
+}
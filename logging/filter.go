@@ -0,0 +1,38 @@
+package logging
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// HasField checks if a line from the server's log has a field set to a value,
+// like service=redis, or "service":"redis" in the json format.
+func HasField(line, key, value string) bool {
+	if strings.HasPrefix(line, "{") {
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &fields); err == nil {
+			fieldValue, ok := fields[key].(string)
+			return ok && fieldValue == value
+		}
+	}
+
+	for _, formatted := range []string{value, strconv.Quote(value)} {
+		field := key + "=" + formatted
+
+		for index := strings.Index(line, field); index >= 0; {
+			end := index + len(field)
+			if (index == 0 || line[index-1] == ' ') && (end == len(line) || line[end] == ' ') {
+				return true
+			}
+
+			next := strings.Index(line[index+1:], field)
+			if next < 0 {
+				break
+			}
+			index += 1 + next
+		}
+	}
+
+	return false
+}
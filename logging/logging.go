@@ -1,18 +1,31 @@
 package logging
 
 import (
+	"os"
+
 	log "github.com/inconshreveable/log15"
+
+	"github.com/heewa/bento/config"
 )
 
-// Config sets up logging. It's ok to call multiple times.
-func Config(isServer bool, logPath string, lvl log.Lvl) error {
+// Config sets up logging. It's ok to call multiple times. The server's log is
+// in the given format, one of config's LogFormat values.
+func Config(isServer bool, logPath string, lvl log.Lvl, format string) error {
 	// Set client's logging to stdout, and server's if no path, or path of '-'
 	logHandler := log.StdoutHandler
-	if isServer && logPath != "" && logPath != "-" {
-		var err error
-		logHandler, err = log.FileHandler(logPath, log.LogfmtFormat())
-		if err != nil {
-			return err
+	if isServer {
+		logFormat := log.LogfmtFormat()
+		if format == config.LogFormatJSON {
+			logFormat = log.JsonFormat()
+		}
+
+		logHandler = log.StreamHandler(os.Stdout, logFormat)
+		if logPath != "" && logPath != "-" {
+			var err error
+			logHandler, err = RotatingFileHandler(logPath, logFormat)
+			if err != nil {
+				return err
+			}
 		}
 	}
 
@@ -21,7 +34,9 @@ func Config(isServer bool, logPath string, lvl log.Lvl) error {
 		log.LvlFilterHandler(lvl,
 			// Add call stack to Crit calls. See log15.stack.Call.Format()
 			LvlStackHandler(log.LvlCrit,
-				logHandler)))
+				// Let values passed to log calls override context ones
+				DedupHandler(
+					logHandler))))
 
 	return nil
 }
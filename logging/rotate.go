@@ -0,0 +1,124 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/heewa/bento/config"
+)
+
+// Suffix added to old logs, with when they ended
+const rotatedLayout = "20060102-150405.000"
+
+// rotatingFile is a log file that's started fresh each day, keeping old ones
+// next to it until they're pruned.
+type rotatingFile struct {
+	lock sync.Mutex
+	path string
+	file *os.File
+
+	// Day the current file was opened, as YYYYMMDD
+	day string
+}
+
+// RotatingFileHandler returns a Handler that writes to a file, starting a new
+// one now and each day, and pruning old ones by config's LogRetention &
+// LogMaxTotal.
+func RotatingFileHandler(path string, format log.Format) (log.Handler, error) {
+	r := &rotatingFile{path: path}
+	if err := r.rotate(time.Now()); err != nil {
+		return nil, err
+	}
+
+	// Like log15's own file handler, evaluate lazy values before writing
+	return log.LazyHandler(log.FuncHandler(func(record *log.Record) error {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+
+		if record.Time.Format("20060102") != r.day {
+			if err := r.rotate(record.Time); err != nil {
+				return err
+			}
+		}
+
+		_, err := r.file.Write(format.Format(record))
+		return err
+	})), nil
+}
+
+// rotate moves the current log aside, if it has anything in it, and opens a
+// new one. Must be called with the lock held.
+func (r *rotatingFile) rotate(now time.Time) error {
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+
+	if stat, err := os.Stat(r.path); err == nil && stat.Size() > 0 {
+		if err := os.Rename(r.path, r.path+"."+now.Format(rotatedLayout)); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	r.file = file
+	r.day = now.Format("20060102")
+
+	prune(r.path, now)
+
+	return nil
+}
+
+// LogFiles lists the server's logs at a path, oldest first, ending with the
+// current one.
+func LogFiles(path string) []string {
+	matches, _ := filepath.Glob(path + ".*")
+
+	var files []string
+	for _, match := range matches {
+		if _, err := time.Parse(rotatedLayout, strings.TrimPrefix(match, path+".")); err == nil {
+			files = append(files, match)
+		}
+	}
+	sort.Strings(files)
+
+	return append(files, path)
+}
+
+// prune removes old logs that are past the retention age, or are the oldest
+// ones while all logs take up more than the max total.
+func prune(path string, now time.Time) {
+	files := LogFiles(path)
+
+	var total int64
+	sizes := make([]int64, len(files))
+	for i, file := range files {
+		if stat, err := os.Stat(file); err == nil {
+			sizes[i] = stat.Size()
+			total += sizes[i]
+
+			if i < len(files)-1 && config.LogRetention > 0 && now.Sub(stat.ModTime()) > config.LogRetention {
+				if os.Remove(file) == nil {
+					total -= sizes[i]
+					sizes[i] = 0
+				}
+			}
+		}
+	}
+
+	// Never remove the current one
+	for i := 0; i < len(files)-1 && config.LogMaxTotal > 0 && total > config.LogMaxTotal; i++ {
+		if sizes[i] > 0 && os.Remove(files[i]) == nil {
+			total -= sizes[i]
+		}
+	}
+}
@@ -1,50 +1,85 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"os/signal"
 	"os/user"
+	"path"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
+	"github.com/dustin/go-humanize"
 	log "github.com/inconshreveable/log15"
 	"gopkg.in/alecthomas/kingpin.v2"
+	"gopkg.in/yaml.v2"
 
 	"github.com/heewa/bento/client"
 	"github.com/heewa/bento/config"
 	"github.com/heewa/bento/logging"
+	"github.com/heewa/bento/plugin"
 	"github.com/heewa/bento/server"
 	"github.com/heewa/bento/service"
 	"github.com/heewa/bento/tray"
 )
 
 var (
+	quiet   = kingpin.Flag("quiet", "Only output what scripts need: the service's name from run-once, and nothing from start, stop & restart").Short('q').Bool()
+	noRetry = kingpin.Flag("no-retry", "Fail right away if the connection to the server drops, instead of reconnecting, which long calls like tail -f and wait keep trying for a while").Bool()
+
 	// Main use-case commands
 
 	listCmd     = kingpin.Command("list", "List services").Alias("ls")
 	listRunning = listCmd.Flag("running", "List only running services").Bool()
 	listTemp    = listCmd.Flag("temp", "List only temp services").Bool()
 	listLong    = listCmd.Flag("long", "List more info").Short('l').Bool()
+	listTag     = listCmd.Flag("tag", "List only services with this tag").String()
 
 	startCmd     = kingpin.Command("start", "Start an existing service")
 	startTail    = startCmd.Flag("tail", "Tail output after starting the service").Bool()
-	startService = startCmd.Arg("service", "Service to start").Required().HintAction(autocompleteServices).String()
+	startReady   = startCmd.Flag("wait-ready", "Wait for the service to be ready, as set by its ready-when").Bool()
+	startProfile = startCmd.Flag("profile", "Start all services in this profile, instead of one service").String()
+	startTag     = startCmd.Flag("tag", "Start all services with this tag, instead of one service").String()
+	startService = startCmd.Arg("service", "Service to start").HintAction(autocompleteServices).String()
 
 	stopCmd     = kingpin.Command("stop", "Stop a running service")
 	stopTail    = stopCmd.Flag("tail", "Tail output of the service while stopping").Bool()
-	stopService = stopCmd.Arg("service", "Service to stop").Required().HintAction(autocompleteServices).String()
+	stopTag     = stopCmd.Flag("tag", "Stop all services with this tag, instead of one service").String()
+	stopService = stopCmd.Arg("service", "Service to stop").HintAction(autocompleteServices).String()
+
+	restartCmd     = kingpin.Command("restart", "Restart a service, or start it if it's stopped")
+	restartTag     = restartCmd.Flag("tag", "Restart all services with this tag, instead of one service").String()
+	restartService = restartCmd.Arg("service", "Service to restart").HintAction(autocompleteServices).String()
+
+	reloadCmd            = kingpin.Command("reload", "Reload services conf file")
+	reloadRestartChanged = reloadCmd.Flag("restart-changed", "Restart running services with changes that can't be applied while they run, instead of failing to update them").Bool()
+	reloadService        = reloadCmd.Arg("service", "Only reload this service, leaving the rest alone, even if their confs are broken").HintAction(autocompleteServices).String()
+
+	diffCmd     = kingpin.Command("diff", "Show how the services conf file differs from what the server is running with, without changing anything")
+	diffService = diffCmd.Arg("service", "Only show this service").HintAction(autocompleteServices).String()
 
-	reloadCmd = kingpin.Command("reload", "Reload services conf file")
+	reloadServiceCmd     = kingpin.Command("reload-service", "Send a running service its reload signal, to reload its own config without restarting")
+	reloadServiceService = reloadServiceCmd.Arg("service", "Service to reload").Required().HintAction(autocompleteServices).String()
 
 	runCmd        = kingpin.Command("run-once", "Create a new, temporary service and start it")
 	runCleanAfter = runCmd.Flag("clean-after", "Remove service after it's finished running for this long. Overrides config value for this service.").HintOptions("1s", "10m", "7d").Duration()
-	runName       = runCmd.Flag("name", "Set a name for the service").HintAction(autocompleteServices).String()
+	runName       = runCmd.Flag("service-name", "Set a name for the service").HintAction(autocompleteServices).String()
 	runDir        = runCmd.Flag("dir", "Directory to run the service from").HintAction(autocompleteDirs).ExistingDir()
 	runEnv        = runCmd.Flag("env", "Env vars to pass on to service").HintAction(autocompleteEnvs).StringMap()
+	runDirEnv     = runCmd.Flag("dir-env", "Pass on env vars from a .env file in the run dir, and its .envrc, through direnv if it's installed. --env ones win.").Bool()
 	runProg       = runCmd.Arg("program", "Program to run").Required().HintAction(autocompletePrograms).String()
 	runTail       = runCmd.Flag("tail", "Tail output after starting the service").Bool()
+	runAttach     = runCmd.Flag("attach", "Tail output until the service exits, then exit with its exit code").Bool()
+	runNotify     = runCmd.Flag("notify", "Post a desktop notification when the service exits, with how long it ran, and its exit code").Bool()
 	runArgs       = runCmd.Arg("args", "Args to pass to program, with -- prefix to prevent args from being processed here").HintAction(autocompleteArgs).Strings()
 
 	cleanCmd     = kingpin.Command("clean", "Remove one or multiple stopped temporary services")
@@ -54,21 +89,63 @@ var (
 	// Other service commands
 
 	tailCmd            = kingpin.Command("tail", "Tail stdout and/or stderr of a service")
-	tailNum            = tailCmd.Flag("num", "Number of lines from end to output").Short('n').Default("10").Int()
+	tailNum            = tailCmd.Flag("num", "Number of lines from end to output, or 'all'").Short('n').Default("10").String()
+	tailFromIndex      = tailCmd.Flag("from-index", "Output from the line with this index onwards, like one more than the last from --show-index").PlaceHolder("N").Default("-1").Int()
 	tailFollow         = tailCmd.Flag("follow", "Continuously output new lines from service").Short('f').Bool()
 	tailFollowRestarts = tailCmd.Flag("follow-restarts", "Continuously output new lines from service, even after it exits and starts again").Short('F').Bool()
 	tailStdout         = tailCmd.Flag("stdout", "Tail just stdout").Bool()
 	tailStderr         = tailCmd.Flag("stderr", "Tail just stderr").Bool()
 	tailPid            = tailCmd.Flag("pid", "Tail just output from this pid").Int()
-	tailService        = tailCmd.Arg("service", "Service to tail").Required().HintAction(autocompleteServices).String()
+	tailTimestamps     = tailCmd.Flag("timestamps", "Show when each line was output").Short('t').Bool()
+	tailShowIndex      = tailCmd.Flag("show-index", "Show each line's index, to resume from with --from-index").Bool()
+	tailTmux           = tailCmd.Flag("tmux", "Open a tmux window following each of these services, like 'api,worker', or 'profile:dev' for ones in a profile").PlaceHolder("SERVICES").String()
+	tailService        = tailCmd.Arg("service", "Service to tail").HintAction(autocompleteServices).String()
+
+	dumpOutputCmd        = kingpin.Command("dump-output", "Write all of a service's output that's kept to a file")
+	dumpOutputPid        = dumpOutputCmd.Flag("pid", "Just output from this pid").Int()
+	dumpOutputTimestamps = dumpOutputCmd.Flag("timestamps", "Include when each line was output").Short('t').Bool()
+	dumpOutputService    = dumpOutputCmd.Arg("service", "Service to get output of").Required().HintAction(autocompleteServices).String()
+	dumpOutputFile       = dumpOutputCmd.Arg("file", "File to write to, instead of stdout").String()
+
+	foregroundCmd      = kingpin.Command("foreground", "Start services and stream all their output here, each line prefixed with its service, stopping the ones it started on Ctrl-C")
+	foregroundServices = foregroundCmd.Arg("services", "Services to start").Required().HintAction(autocompleteServices).Strings()
 
 	infoCmd     = kingpin.Command("info", "Output info on a service")
 	infoService = infoCmd.Arg("service", "Service to get info about").Required().HintAction(autocompleteServices).String()
 
+	historyCmd     = kingpin.Command("history", "List past runs of a service, and how they ended")
+	historyService = historyCmd.Arg("service", "Service to list runs of").Required().HintAction(autocompleteServices).String()
+
+	statsCmd     = kingpin.Command("stats", "Summarize a service's cpu & memory use over time")
+	statsSince   = statsCmd.Flag("since", "Only include usage from this far back, like '1h'. Defaults to all of it, up to a day.").HintOptions("10m", "1h", "12h").Duration()
+	statsService = statsCmd.Arg("service", "Service to get stats of").Required().HintAction(autocompleteServices).String()
+
 	waitCmd     = kingpin.Command("wait", "Waits for a service to stop and exits with 0 if succeeded, != 0 otherwise")
-	waitService = waitCmd.Arg("service", "Service to wait for").Required().HintAction(autocompleteServices).String()
+	waitAny     = waitCmd.Flag("any", "With a pattern, wait for the first matching service to stop, exiting with 0 if it succeeded").Bool()
+	waitAll     = waitCmd.Flag("all", "With a pattern, wait for all matching services to stop, exiting with 0 if they all succeeded").Bool()
+	waitCode    = waitCmd.Flag("exit-code", "Exit with the service's exit code, or 128 + the signal that killed it, instead of just 0 or 1").Bool()
+	waitService = waitCmd.Arg("service", "Service, or pattern of services like 'worker-*', to wait for").Required().HintAction(autocompleteServices).String()
+
+	notifyWhenDoneCmd     = kingpin.Command("notify-when-done", "Wait for a service to exit, then run a command, with BENTO_SERVICE_NAME, BENTO_EXIT_CODE and BENTO_SUCCEEDED env vars, or ring the terminal bell")
+	notifyWhenDoneBell    = notifyWhenDoneCmd.Flag("bell", "Ring the terminal bell, which is the default without a command or --desktop").Bool()
+	notifyWhenDoneDesktop = notifyWhenDoneCmd.Flag("desktop", "Post a desktop notification").Bool()
+	notifyWhenDoneService = notifyWhenDoneCmd.Arg("service", "Service to wait for").Required().HintAction(autocompleteServices).String()
+	notifyWhenDoneCommand = notifyWhenDoneCmd.Arg("command", "Command to run when it's done, with -- before it to keep its flags from being processed here").Strings()
+
+	openCmd     = kingpin.Command("open", "Open a service's open-url")
+	openService = openCmd.Arg("service", "Service to open").Required().HintAction(autocompleteServices).String()
+
+	maintenanceCmd     = kingpin.Command("maintenance", "Pause or resume restarting a service when it exits, to stop or poke at it without bento fighting back")
+	maintenanceState   = maintenanceCmd.Arg("state", "'on' to pause restarts, 'off' to resume them").Required().Enum("on", "off")
+	maintenanceService = maintenanceCmd.Arg("service", "Service to put in or out of maintenance").HintAction(autocompleteServices).String()
+	maintenanceAll     = maintenanceCmd.Flag("all", "Put all services in or out of maintenance").Bool()
+
+	whichCmd     = kingpin.Command("which", "Output the full path the server resolves a service's program to, and the PATH it uses")
+	whichService = whichCmd.Arg("service", "Service to look up the program of").Required().HintAction(autocompleteServices).String()
 
 	pidCmd     = kingpin.Command("pid", "Output the process id for a running service")
+	pidPgid    = pidCmd.Flag("pgid", "Output the process group id instead").Bool()
+	pidAll     = pidCmd.Flag("all", "Output the pids of the service's process and all its descendants, one per line").Bool()
 	pidService = pidCmd.Arg("service", "Service to get pid of").Required().HintAction(autocompleteServices).String()
 
 	// Server and management
@@ -79,22 +156,69 @@ var (
 
 	versionCmd = kingpin.Command("version", "List client & server versions")
 
+	serverInfoCmd = kingpin.Command("server-info", "Output stats about the server process itself")
+
+	serverLogsCmd     = kingpin.Command("server-logs", "Output the server's log")
+	serverLogsService = serverLogsCmd.Flag("service", "Only output lines about this service").HintAction(autocompleteServices).String()
+
+	exportStateCmd  = kingpin.Command("export-state", "Write all services, including temp ones, whether they're in maintenance, and their past runs to a file, to import on another machine or after losing them")
+	exportStateFile = exportStateCmd.Arg("file", "File to write to, instead of stdout").String()
+
+	importStateCmd  = kingpin.Command("import-state", "Bring back services from a file written by export-state")
+	importStateFile = importStateCmd.Arg("file", "File to read from").Required().String()
+
+	snapshotCmd  = kingpin.Command("snapshot", "Save which services are running, to bring that set back later with restore")
+	snapshotName = snapshotCmd.Arg("name", "Name to save the snapshot as").Required().String()
+
+	restoreCmd  = kingpin.Command("restore", "Bring back the set of services that were running in a snapshot, stopping any others")
+	restoreName = restoreCmd.Arg("name", "Snapshot to restore").Required().String()
+
+	upCmd   = kingpin.Command("up", "Load the services file, and start all its services in order of start-priority, each one ready before the next")
+	downCmd = kingpin.Command("down", "Stop all services from the services file, and remove them until the next up or reload")
+
+	selftestCmd = kingpin.Command("selftest", "Check that bento works here, by running a service on a throwaway server, and reporting how each step went")
+
+	drainCmd = kingpin.Command("drain", "Stop the server from starting anything new, leaving running services alone, like before a shutdown")
+	drainOff = drainCmd.Flag("off", "Stop draining, and start services as usual again").Bool()
+
 	// Function table for commands
 	commandTable = map[string](func(*client.Client) error){
 		"shutdown": handleShutdown,
 
-		"version":  handleVersion,
-		"list":     handleList,
-		"reload":   handleReload,
-		"run-once": handleRun,
-		"clean":    handleClean,
-
-		"start": handleStart,
-		"stop":  handleStop,
-		"tail":  handleTail,
-		"info":  handleInfo,
-		"wait":  handleWait,
-		"pid":   handlePid,
+		"version":      handleVersion,
+		"server-info":  handleServerInfo,
+		"server-logs":  handleServerLogs,
+		"drain":        handleDrain,
+		"selftest":     handleSelftest,
+		"export-state": handleExportState,
+		"import-state": handleImportState,
+		"snapshot":     handleSnapshot,
+		"restore":      handleRestore,
+		"up":           handleUp,
+		"down":         handleDown,
+		"list":         handleList,
+		"reload":       handleReload,
+		"diff":         handleDiff,
+		"run-once":     handleRun,
+		"clean":        handleClean,
+
+		"start":            handleStart,
+		"stop":             handleStop,
+		"tail":             handleTail,
+		"dump-output":      handleDumpOutput,
+		"foreground":       handleForeground,
+		"info":             handleInfo,
+		"stats":            handleStats,
+		"wait":             handleWait,
+		"notify-when-done": handleNotifyWhenDone,
+		"pid":              handlePid,
+		"which":            handleWhich,
+		"history":          handleHistory,
+
+		"restart":        handleRestart,
+		"reload-service": handleReloadService,
+		"open":           handleOpen,
+		"maintenance":    handleMaintenance,
 	}
 )
 
@@ -112,24 +236,32 @@ func main() {
 	kingpin.CommandLine.Author("Heewa Barfchin")
 	kingpin.Version(config.Version.String())
 
-	cmd := kingpin.Parse()
+	runCmd.PreAction(checkRunName)
+
+	cmd := kingpin.MustParse(kingpin.CommandLine.Parse(os.Args[1:]))
 
 	// Set up logging twice, cuz conf might change it, but it also logs
-	exitOnErr(logging.Config(cmd == "init", "-", log.LvlInfo))
+	exitOnErr(logging.Config(cmd == "init", "-", log.LvlInfo, config.LogFormatLogfmt))
 	exitOnErr(config.Load(cmd == "init"))
-	exitOnErr(logging.Config(cmd == "init", config.LogPath, config.LogLevel))
+	exitOnErr(logging.Config(cmd == "init", config.LogPath, config.LogLevel, config.LogFormat))
 
 	// All other command besides init require a connection to the server
 	if cmd == "init" {
 		exitOnErr(handleInit())
 	} else {
+		exitOnErr(service.SetColors(config.Color, config.ColorTheme))
+
 		clnt, err := client.New()
 		exitOnErr(err)
 		defer clnt.Close()
+		clnt.Notes = os.Stderr
+		if *noRetry {
+			clnt.Reconnect = client.ReconnectPolicy{}
+		}
 
 		// Don't start a server for some commands
 		switch cmd {
-		case "version", "shutdown":
+		case "version", "shutdown", "server-info", "server-logs", "drain", "selftest":
 			if clnt.Connect(false) != nil {
 				clnt = nil
 			}
@@ -139,7 +271,7 @@ func main() {
 
 		// Check the services conf for changes, to notify user
 		switch cmd {
-		case "version", "shutdown", "reload":
+		case "version", "shutdown", "server-info", "server-logs", "drain", "selftest", "reload", "diff", "up", "down":
 			// Not relevant
 		default:
 			checkForServiceConfChanges(clnt)
@@ -166,9 +298,14 @@ func handleInit() error {
 		}
 	}
 
-	// Start the UI
-	tray.Init()
-	defer tray.Quit()
+	// Start the UI, unless running headless
+	useTray := config.Tray && tray.Available
+	if useTray {
+		tray.Init()
+		defer tray.Quit()
+	} else {
+		log.Info("Running without system tray")
+	}
 
 	// Create a Server
 	srvr, serviceUpdates, err := server.New()
@@ -177,8 +314,10 @@ func handleInit() error {
 	}
 
 	// Hook Tray and Server together
-	if err := tray.SetServer(srvr, serviceUpdates); err != nil {
-		return err
+	if useTray {
+		if err := tray.SetServer(srvr, serviceUpdates); err != nil {
+			return err
+		}
 	}
 
 	// Start the server
@@ -195,12 +334,16 @@ func handleInit() error {
 		reply := server.LoadServicesResponse{}
 		if err := srvr.LoadServices(args, &reply); err != nil {
 			// Shut the server down before leaving
-			if shutdownErr := srvr.Exit(false, nil); shutdownErr != nil {
+			if shutdownErr := srvr.Exit(server.ExitArgs{Reason: "failed to load services file"}, nil); shutdownErr != nil {
 				log.Error("Failed to shut down server", "err", shutdownErr)
 			}
 
 			return err
 		}
+
+		for _, failure := range reply.Failed {
+			log.Error("Failed to load service", "service", failure.Name, "err", failure.Err)
+		}
 	}
 
 	// Block on server exit
@@ -213,10 +356,9 @@ func handleShutdown(client *client.Client) error {
 		return nil
 	}
 
-	// Call the RPC directly, to avoid version-mismatch checks. The shutdown cmd
-	// sould maintain a stable interface, and it's supposed to be used to update
+	// Without version-mismatch checks, since it's supposed to be used to update
 	// the server specifically -during- a mismatch.
-	return client.CallWithoutVersionCheck("Server.Exit", false, nil)
+	return client.Shutdown()
 }
 
 func handleVersion(client *client.Client) error {
@@ -226,11 +368,12 @@ func handleVersion(client *client.Client) error {
 		// No server, so the version would be our version when we run it
 		fmt.Printf("server version: %s\n", config.Version)
 	} else {
-		fmt.Printf("server version: %s\n", client.ServerVersion)
+		serverVersion := client.ServerVersion()
+		fmt.Printf("server version: %s\n", serverVersion)
 
-		if config.Version.GT(client.ServerVersion) {
+		if config.Version.GT(serverVersion) {
 			fmt.Println("Client is ahead of server - restart server to upgrade.")
-		} else if config.Version.LT(client.ServerVersion) {
+		} else if config.Version.LT(serverVersion) {
 			fmt.Println("Server is ahead of client - maybe you're running an old client from a different path?")
 		}
 	}
@@ -238,9 +381,233 @@ func handleVersion(client *client.Client) error {
 	return nil
 }
 
+func handleServerInfo(client *client.Client) error {
+	if client == nil {
+		fmt.Println("No server running.")
+		return nil
+	}
+
+	info, err := client.ServerInfo()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("server version: %s\n", info.Version)
+	fmt.Printf("pid: %d\n", info.Pid)
+	fmt.Printf("uptime: %s (started %s)\n", info.Uptime, humanize.Time(info.StartTime))
+	fmt.Printf("goroutines: %d\n", info.Goroutines)
+	fmt.Printf("memory: %s allocated, %s from OS\n", humanize.Bytes(info.MemAlloc), humanize.Bytes(info.MemSys))
+	fmt.Printf("connections served: %d\n", info.ConnectionsServed)
+	if info.Draining {
+		fmt.Println("draining: not starting anything new")
+	}
+	fmt.Printf("output memory: %s of %s\n", humanize.Bytes(uint64(info.OutputMemory)), humanize.Bytes(uint64(info.MaxOutputMemory)))
+
+	if len(info.Services) > 0 {
+		fmt.Println("service output buffers:")
+		for _, srvc := range info.Services {
+			fmt.Printf("  %-15s %8d lines  %s\n", srvc.Name, srvc.OutputLines, humanize.Bytes(uint64(srvc.OutputBytes)))
+		}
+	}
+
+	return nil
+}
+
+func handleServerLogs(client *client.Client) error {
+	if config.LogPath == "" || config.LogPath == "-" {
+		return fmt.Errorf("Server logs to stdout, not a file")
+	}
+
+	// Old logs first, to keep lines in order
+	for _, path := range logging.LogFiles(config.LogPath) {
+		if err := outputServerLog(path, *serverLogsService); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// outputServerLog outputs a server log file's lines, or just ones about a
+// service if one's given.
+func outputServerLog(path, service string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("Failed to open server log: %v", err)
+	}
+	defer file.Close()
+
+	lines := bufio.NewScanner(file)
+	lines.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lines.Scan() {
+		line := lines.Text()
+		if service == "" || logging.HasField(line, "service", service) {
+			fmt.Println(line)
+		}
+	}
+
+	return lines.Err()
+}
+
+func handleDrain(client *client.Client) error {
+	if client == nil {
+		fmt.Println("No server running.")
+		return nil
+	}
+
+	draining, err := client.Drain(*drainOff)
+	if err != nil {
+		return err
+	}
+
+	if draining {
+		fmt.Println("Draining, not starting anything new. Running services are left alone.")
+	} else {
+		fmt.Println("Not draining, starting services as usual.")
+	}
+
+	return nil
+}
+
+// handleSelftest runs a service on a throwaway server, in this process on a
+// temp socket, so it doesn't touch the real server or services.
+func handleSelftest(_ *client.Client) error {
+	dir, err := ioutil.TempDir("", "bento-selftest")
+	if err != nil {
+		return fmt.Errorf("Failed to make a temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	const name = "selftest"
+	socket := path.Join(dir, "sock")
+	var (
+		srvr *server.Server
+		clnt *client.Client
+		info service.Info
+	)
+
+	steps := []struct {
+		name string
+		fn   func() error
+	}{
+		{"server", func() error {
+			embedded, err := server.NewEmbedded(server.Options{SocketPath: socket, StateDir: dir})
+			if err != nil {
+				return err
+			} else if err := embedded.Serve(); err != nil {
+				return err
+			}
+			srvr = embedded
+			return nil
+		}},
+		{"connect", func() (err error) {
+			clnt, err = client.Dial(socket)
+			return err
+		}},
+		{"run-once", func() (err error) {
+			info, err = clnt.Run(name, "sh", []string{"-c", "echo out; echo err >&2; exec sleep 60"}, dir, nil, 0, false)
+			if err == nil && !info.Running {
+				err = fmt.Errorf("Service isn't running: %s", info.LongString())
+			}
+			return err
+		}},
+		{"tail", func() error {
+			// Output shows up asynchronously, so give it a bit
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				var stdout, stderr []string
+				outChan, errChan, tailErrs := clnt.Tail(name, true, true, false, false, info.Pid, 0, 0)
+				for line := range outChan {
+					stdout = append(stdout, line.Line)
+				}
+				for line := range errChan {
+					stderr = append(stderr, line.Line)
+				}
+				if err := <-tailErrs; err != nil {
+					return err
+				}
+
+				if reflect.DeepEqual(stdout, []string{"out"}) && reflect.DeepEqual(stderr, []string{"err"}) {
+					return nil
+				} else if time.Now().After(deadline) {
+					return fmt.Errorf("Expected 'out' on stdout & 'err' on stderr, got %q and %q", stdout, stderr)
+				}
+				time.Sleep(100 * time.Millisecond)
+			}
+		}},
+		{"stop", func() error {
+			stopped, err := clnt.Stop(name, nil)
+			if err == nil && stopped.Running {
+				err = fmt.Errorf("Service is still running: %s", stopped.LongString())
+			}
+			return err
+		}},
+		{"clean", func() error {
+			cleaned, failed, err := clnt.Clean(name, 0)
+			if err != nil {
+				return err
+			} else if len(failed) > 0 {
+				return fmt.Errorf("Failed to remove service: %s", failed[0].Err)
+			} else if len(cleaned) != 1 {
+				return fmt.Errorf("Expected to remove 1 service, removed %d", len(cleaned))
+			}
+
+			services, err := clnt.List(false, false)
+			if err == nil && len(services) > 0 {
+				err = fmt.Errorf("Service is still there after cleaning it")
+			}
+			return err
+		}},
+	}
+
+	// Once a step fails, the rest are skipped, but the server is always shut
+	// down, since it'd stop any services left running
+	var failed []string
+	run := func(step string, fn func() error) {
+		start := time.Now()
+		err := fn()
+		took := time.Since(start).Round(time.Millisecond)
+
+		if err != nil {
+			fmt.Printf("FAIL  %-10s %v (%s)\n", step, err, took)
+			failed = append(failed, step)
+		} else {
+			fmt.Printf("ok    %-10s (%s)\n", step, took)
+		}
+	}
+
+	for _, step := range steps {
+		if run(step.name, step.fn); len(failed) > 0 {
+			break
+		}
+	}
+	if clnt != nil {
+		clnt.Close()
+	}
+	if srvr != nil {
+		run("shutdown", srvr.Shutdown)
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("Selftest failed at: %s", strings.Join(failed, ", "))
+	}
+	fmt.Println("All good.")
+	return nil
+}
+
 func handleList(client *client.Client) error {
 	services, err := client.List(*listRunning, *listTemp)
 
+	if *listTag != "" {
+		var tagged []service.Info
+		for _, info := range services {
+			if info.HasTag(*listTag) {
+				tagged = append(tagged, info)
+			}
+		}
+		services = tagged
+	}
+
 	// Sort short list by activity, and long list by name, cuz long list is
 	// more of a clerical thing, and short list is more a status-check.
 	if *listLong {
@@ -261,41 +628,217 @@ func handleList(client *client.Client) error {
 }
 
 func handleReload(client *client.Client) error {
-	reply, err := client.LoadServices(config.ServiceConfigFile)
+	// Show services as they're loaded, since with lots of auto-starts, it can
+	// take a while.
+	progress := make(chan server.LoadEvent)
+	progressDone := make(chan interface{})
+	failed := 0
+	go func() {
+		defer close(progressDone)
+
+		for event := range progress {
+			switch event.Kind {
+			case server.LoadAdded, server.LoadUpdated, server.LoadDeprecated:
+				fmt.Printf("%-10s%s\n", event.Kind, event.Info)
+			case server.LoadRemoved:
+				fmt.Printf("%-10s  - %s\n", event.Kind, event.Name)
+			case server.LoadFailed:
+				fmt.Printf("%-10s  - %s: %s\n", event.Kind, event.Name, event.Err)
+				failed++
+			}
+		}
+	}()
 
-	if len(reply.NewServices) > 0 {
-		fmt.Printf("Added %d new services:\n", len(reply.NewServices))
-		for _, srvc := range reply.NewServices {
-			fmt.Println(srvc)
+	_, err := client.LoadServices(config.ServiceConfigFile, *reloadService, *reloadRestartChanged, progress)
+	<-progressDone
+
+	if err == nil && failed > 0 {
+		err = fmt.Errorf("Failed to load %d services", failed)
+	}
+
+	return err
+}
+
+func handleDiff(client *client.Client) error {
+	diff, err := client.Diff(config.ServiceConfigFile)
+	if err != nil {
+		return err
+	}
+
+	shown := 0
+	for _, serv := range diff.Services {
+		if *diffService != "" && serv.Name != *diffService {
+			continue
+		}
+		shown++
+
+		note := ""
+		if serv.NeedsRestart() {
+			note = " (running, needs a restart to apply)"
+		} else if serv.Kind == server.LoadDeprecated {
+			note = " (running, removed once it exits)"
+		} else if serv.Running {
+			note = " (running, safe to apply)"
+		}
+		fmt.Printf("%-10s  - %s%s\n", serv.Kind, serv.Name, note)
+
+		for _, field := range serv.Fields {
+			safety := "needs restart"
+			if field.Safe {
+				safety = "safe"
+			}
+			fmt.Printf("              %s: %s -> %s  [%s]\n", field.Field, field.Current, field.New, safety)
 		}
-		fmt.Println("")
 	}
 
+	if shown == 0 && !*quiet {
+		fmt.Println("No differences.")
+	}
+
+	return nil
+}
+
+func handleExportState(client *client.Client) error {
+	state, err := client.ExportState()
+	if err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("Failed to encode state: %v", err)
+	}
+
+	if *exportStateFile == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	} else if err := ioutil.WriteFile(*exportStateFile, data, 0600); err != nil {
+		return fmt.Errorf("Failed to write state: %v", err)
+	}
+
+	if !*quiet {
+		fmt.Printf("Exported %d services to %s\n", len(state.Services), *exportStateFile)
+	}
+	return nil
+}
+
+func handleImportState(client *client.Client) error {
+	data, err := ioutil.ReadFile(*importStateFile)
+	if err != nil {
+		return fmt.Errorf("Failed to read state: %v", err)
+	}
+
+	var state server.State
+	if err := yaml.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("Invalid state file (%s): %v", *importStateFile, err)
+	}
+
+	reply, err := client.ImportState(state)
+	if err != nil {
+		return err
+	}
+
+	if reply.WroteServiceFile {
+		fmt.Printf("Wrote services to %s\n", config.ServiceConfigPath)
+	}
+	if len(reply.NewServices) > 0 {
+		fmt.Printf("Added %d services:\n", len(reply.NewServices))
+		for _, info := range reply.NewServices {
+			fmt.Println(info)
+		}
+	}
 	if len(reply.UpdatedServices) > 0 {
 		fmt.Printf("Updated %d existing services:\n", len(reply.UpdatedServices))
-		for _, srvc := range reply.UpdatedServices {
-			fmt.Println(srvc)
+		for _, info := range reply.UpdatedServices {
+			fmt.Println(info)
 		}
-		fmt.Println("")
+	}
+	for _, failure := range reply.Failed {
+		fmt.Printf("Failed to import %s: %s\n", failure.Name, failure.Err)
+	}
+
+	if len(reply.Failed) > 0 {
+		return fmt.Errorf("Failed to import %d services", len(reply.Failed))
+	}
+	return nil
+}
+
+func handleSnapshot(client *client.Client) error {
+	reply, err := client.Snapshot(*snapshotName)
+	if err != nil {
+		return err
 	}
 
-	if len(reply.DeprecatedServices) > 0 {
-		fmt.Printf("Marked %d running, but removed services for removal after exit:\n", len(reply.DeprecatedServices))
-		for _, srvc := range reply.DeprecatedServices {
-			fmt.Println(srvc)
+	if !*quiet {
+		fmt.Printf("Saved %d running services as %s:\n", len(reply.Services), *snapshotName)
+		for _, info := range reply.Services {
+			fmt.Println(info)
 		}
-		fmt.Println("")
+	}
+	return nil
+}
+
+func handleRestore(client *client.Client) error {
+	reply, err := client.Restore(*restoreName)
+	if err != nil {
+		return err
 	}
 
-	if len(reply.RemovedServices) > 0 {
-		fmt.Printf("Removed %d services:\n", len(reply.RemovedServices))
-		for _, name := range reply.RemovedServices {
-			fmt.Printf("  - %s\n", name)
+	if !*quiet {
+		for _, info := range reply.Stopped {
+			fmt.Println(info)
 		}
-		fmt.Println("")
+		for _, info := range reply.Started {
+			fmt.Println(info)
+		}
+	}
+	for _, failure := range reply.Failed {
+		fmt.Printf("Failed to restore %s: %s\n", failure.Name, failure.Err)
 	}
 
-	return err
+	if len(reply.Failed) > 0 {
+		return fmt.Errorf("Failed to restore %d services", len(reply.Failed))
+	}
+	return nil
+}
+
+func handleUp(client *client.Client) error {
+	if config.ServiceConfigFile == "" {
+		return fmt.Errorf("No services file to bring up")
+	}
+
+	reply, err := client.Up()
+	if err != nil {
+		return err
+	}
+
+	if !*quiet {
+		for _, info := range reply.Services {
+			fmt.Println(info)
+		}
+	}
+	for _, failure := range reply.Failed {
+		fmt.Printf("Failed to bring up %s: %s\n", failure.Name, failure.Err)
+	}
+
+	if len(reply.Failed) > 0 {
+		return fmt.Errorf("Failed to bring up %d services", len(reply.Failed))
+	}
+	return nil
+}
+
+func handleDown(client *client.Client) error {
+	reply, err := client.Down()
+	if err != nil {
+		return err
+	}
+
+	if !*quiet {
+		for _, name := range reply.Removed {
+			fmt.Printf("Removed %s\n", name)
+		}
+	}
+	return nil
 }
 
 func handleRun(client *client.Client) error {
@@ -306,16 +849,75 @@ func handleRun(client *client.Client) error {
 		*runDir, _ = os.Getwd()
 	}
 
-	info, err := client.Run(*runName, *runProg, *runArgs, *runDir, *runEnv, *runCleanAfter)
-	if err == nil && !*runTail {
-		fmt.Println(info)
-	} else if err == nil {
-		*tailService = info.Name
-		*tailFollow = true
-		*tailPid = info.Pid
-		err = handleTail(client)
+	// The server's env is nearly empty, so bring along what a shell in the
+	// dir would have set up
+	env := *runEnv
+	if *runDirEnv {
+		dirEnv, err := config.LoadDirEnv(*runDir)
+		if err != nil {
+			return err
+		}
+		for key, value := range *runEnv {
+			dirEnv[key] = value
+		}
+		env = dirEnv
 	}
-	return err
+
+	info, err := client.Run(*runName, *runProg, *runArgs, *runDir, env, *runCleanAfter, *runNotify)
+	if err != nil {
+		return err
+	} else if !*runTail && !*runAttach {
+		if *quiet {
+			// Scripts need the name to refer to it later, if it was generated
+			fmt.Println(info.Name)
+		} else {
+			fmt.Println(info)
+		}
+		return nil
+	}
+
+	*tailService = info.Name
+	*tailFollow = true
+	*tailPid = info.Pid
+	if err := handleTail(client); err != nil || !*runAttach {
+		return err
+	}
+
+	// Output ends when the process does, but make sure it's done
+	wait, err := client.Wait(info.Name, false)
+	if err != nil {
+		return err
+	}
+	os.Exit(processExitCode(wait.Info))
+
+	return nil
+}
+
+// checkRunName catches a --name after run-once, which used to name the
+// service, but is the server instance's name everywhere now, so it shouldn't
+// quietly start a service in some other server.
+func checkRunName(context *kingpin.ParseContext) error {
+	afterCmd := false
+	for _, element := range context.Elements {
+		switch clause := element.Clause.(type) {
+		case *kingpin.CmdClause:
+			afterCmd = afterCmd || clause == runCmd
+		case *kingpin.FlagClause:
+			if afterCmd && clause.Model().Name == "name" {
+				return fmt.Errorf("Use --service-name to name a run-once service, --name is for the server instance")
+			}
+		}
+	}
+	return nil
+}
+
+// processExitCode is the code to exit with on behalf of a service, which is
+// never 0 if it didn't succeed, like when it was killed by a signal.
+func processExitCode(info service.Info) int {
+	if !info.Succeeded && info.ExitCode == 0 {
+		return 1
+	}
+	return info.ExitCode
 }
 
 func handleClean(client *client.Client) error {
@@ -339,9 +941,21 @@ func handleClean(client *client.Client) error {
 }
 
 func handleStart(client *client.Client) error {
-	info, err := client.Start(*startService)
+	if *startProfile != "" && *startTag != "" {
+		return fmt.Errorf("Can't use both --profile and --tag")
+	} else if *startProfile != "" {
+		return handleStartProfile(client)
+	} else if *startTag != "" {
+		return handleStartTag(client)
+	} else if *startService == "" {
+		return fmt.Errorf("Need a service to start, or a --profile or --tag")
+	}
+
+	info, err := client.Start(*startService, *startReady)
 	if err == nil {
-		fmt.Println(info)
+		if !*quiet {
+			fmt.Println(info)
+		}
 
 		if *startTail {
 			*tailService = info.Name
@@ -357,16 +971,134 @@ func handleStart(client *client.Client) error {
 	return err
 }
 
-func handleStop(client *client.Client) error {
-	// Start the tail before telling the stop, so we get that output, but
-	// also wait for the output to finishe before returning.
-	var done sync.WaitGroup
-	if *stopTail {
-		*tailService = *stopService
-		*tailFollow = true
-		*tailNum = 10
+func handleStartProfile(client *client.Client) error {
+	if *startService != "" {
+		return fmt.Errorf("Can't use both a service and --profile")
+	} else if *startTail {
+		return fmt.Errorf("Can't tail a whole profile")
+	}
 
-		done.Add(1)
+	reply, err := client.StartProfile(*startProfile, *startReady)
+	if !*quiet {
+		for _, info := range reply.Services {
+			fmt.Println(info)
+		}
+	}
+	for _, failure := range reply.Failed {
+		fmt.Printf("Failed to start %s: %s\n", failure.Name, failure.Err)
+	}
+
+	if err == nil && len(reply.Failed) > 0 {
+		err = fmt.Errorf("Failed to start %d services", len(reply.Failed))
+	}
+	return err
+}
+
+func handleStartTag(client *client.Client) error {
+	if *startService != "" {
+		return fmt.Errorf("Can't use both a service and --tag")
+	} else if *startTail {
+		return fmt.Errorf("Can't tail all services with a tag")
+	}
+
+	services, err := taggedServices(client, *startTag)
+	if err != nil {
+		return err
+	}
+
+	return forEachTagged(services, "start", func(info service.Info) (service.Info, error) {
+		if info.Running {
+			return info, nil
+		}
+		return client.Start(info.Name, *startReady)
+	})
+}
+
+// taggedServices gets services with a tag, in the order they'd be started:
+// ones with a higher start priority first.
+func taggedServices(client *client.Client, tag string) ([]service.Info, error) {
+	services, err := client.List(false, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var tagged []service.Info
+	for _, info := range services {
+		if info.HasTag(tag) {
+			tagged = append(tagged, info)
+		}
+	}
+	if len(tagged) == 0 {
+		return nil, fmt.Errorf("No services tagged '%s'", tag)
+	}
+
+	sort.Slice(tagged, func(a, b int) bool {
+		if tagged[a].StartPriority != tagged[b].StartPriority {
+			return tagged[a].StartPriority > tagged[b].StartPriority
+		}
+		return tagged[a].Name < tagged[b].Name
+	})
+	return tagged, nil
+}
+
+// forEachTagged does something to each of a tag's services, going on after
+// ones that fail, and outputting how each ended up.
+func forEachTagged(services []service.Info, verb string, do func(service.Info) (service.Info, error)) error {
+	failed := 0
+	for _, info := range services {
+		result, err := do(info)
+		if err != nil {
+			fmt.Printf("Failed to %s %s: %v\n", verb, info.Name, err)
+			failed++
+		} else if !*quiet {
+			fmt.Println(result)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("Failed to %s %d services", verb, failed)
+	}
+	return nil
+}
+
+func handleStop(client *client.Client) error {
+	if *stopTag != "" {
+		if *stopService != "" {
+			return fmt.Errorf("Can't use both a service and --tag")
+		} else if *stopTail {
+			return fmt.Errorf("Can't tail all services with a tag")
+		}
+
+		services, err := taggedServices(client, *stopTag)
+		if err != nil {
+			return err
+		}
+
+		// Stop in the reverse order of starting, like on shutdown
+		for a, b := 0, len(services)-1; a < b; a, b = a+1, b-1 {
+			services[a], services[b] = services[b], services[a]
+		}
+
+		return forEachTagged(services, "stop", func(info service.Info) (service.Info, error) {
+			if !info.Running {
+				return info, nil
+			}
+			return client.Stop(info.Name, nil)
+		})
+	} else if *stopService == "" {
+		return fmt.Errorf("Need a service to stop, or a --tag")
+	}
+
+	// Start the tail before telling the stop, so we get that output, but
+	// also wait for the output to finishe before returning.
+	var done sync.WaitGroup
+	if *stopTail {
+		*tailService = *stopService
+		*tailFollow = true
+		*tailNum = "10"
+		*tailFromIndex = -1
+
+		done.Add(1)
 		go func() {
 			defer done.Done()
 
@@ -374,8 +1106,23 @@ func handleStop(client *client.Client) error {
 		}()
 	}
 
-	info, err := client.Stop(*stopService)
-	if err == nil {
+	// Signals are escalated over a while if the service doesn't stop, so show
+	// what's happening along the way.
+	progress := make(chan string)
+	progressDone := make(chan interface{})
+	go func() {
+		defer close(progressDone)
+
+		for step := range progress {
+			if !*quiet {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", *stopService, step)
+			}
+		}
+	}()
+
+	info, err := client.Stop(*stopService, progress)
+	<-progressDone
+	if err == nil && !*quiet {
 		fmt.Println(info)
 	}
 
@@ -383,7 +1130,78 @@ func handleStop(client *client.Client) error {
 	return err
 }
 
+func handleRestart(client *client.Client) error {
+	if *restartTag != "" {
+		if *restartService != "" {
+			return fmt.Errorf("Can't use both a service and --tag")
+		}
+
+		services, err := taggedServices(client, *restartTag)
+		if err != nil {
+			return err
+		}
+
+		return forEachTagged(services, "restart", func(info service.Info) (service.Info, error) {
+			return client.Restart(info.Name)
+		})
+	} else if *restartService == "" {
+		return fmt.Errorf("Need a service to restart, or a --tag")
+	}
+
+	info, err := client.Restart(*restartService)
+	if err == nil && !*quiet {
+		fmt.Println(info)
+	}
+	return err
+}
+
+func handleReloadService(client *client.Client) error {
+	info, err := client.ReloadService(*reloadServiceService)
+	if err == nil {
+		fmt.Println(info)
+	}
+	return err
+}
+
+func handleOpen(client *client.Client) error {
+	return client.OpenURL(*openService)
+}
+
+func handleMaintenance(client *client.Client) error {
+	if *maintenanceService == "" && !*maintenanceAll {
+		return fmt.Errorf("Need a service, or --all")
+	} else if *maintenanceService != "" && *maintenanceAll {
+		return fmt.Errorf("Can't use both a service and --all")
+	}
+
+	services, err := client.Maintenance(*maintenanceService, *maintenanceAll, *maintenanceState == "on")
+	for _, info := range services {
+		fmt.Println(info)
+	}
+
+	return err
+}
+
 func handleTail(client *client.Client) error {
+	if *tailTmux != "" {
+		return handleTailTmux(client)
+	} else if *tailService == "" {
+		return fmt.Errorf("Need a service to tail, or --tmux")
+	}
+
+	// All lines is no max, from the start of what's kept, which is also what
+	// other commands that tail get, without the flag's default
+	num, index := 0, *tailFromIndex
+	if *tailNum == "all" || *tailNum == "" {
+		if index < 0 {
+			index = 0
+		}
+	} else if n, err := strconv.Atoi(*tailNum); err != nil || n < 0 {
+		return fmt.Errorf("Invalid number of lines '%s', should be a number or 'all'", *tailNum)
+	} else {
+		num = n
+	}
+
 	stdoutChan, stderrChan, errChan := client.Tail(
 		*tailService,
 		*tailStdout || !*tailStderr,
@@ -391,7 +1209,8 @@ func handleTail(client *client.Client) error {
 		*tailFollow,
 		*tailFollowRestarts,
 		*tailPid,
-		*tailNum)
+		index,
+		num)
 
 	// Keep outputting until done
 	var wait sync.WaitGroup
@@ -399,13 +1218,13 @@ func handleTail(client *client.Client) error {
 	go func() {
 		defer wait.Done()
 		for line := range stdoutChan {
-			fmt.Println(line)
+			fmt.Println(formatOutputLine(line, *tailTimestamps, *tailShowIndex))
 		}
 	}()
 	go func() {
 		defer wait.Done()
 		for line := range stderrChan {
-			fmt.Fprintln(os.Stderr, line)
+			fmt.Fprintln(os.Stderr, formatOutputLine(line, *tailTimestamps, *tailShowIndex))
 		}
 	}()
 
@@ -418,6 +1237,255 @@ func handleTail(client *client.Client) error {
 	return nil
 }
 
+// handleTailTmux opens a tmux window for each service, following its output
+// with tail -F. Inside tmux, they're added to the current session, otherwise
+// a new session is made and attached to.
+func handleTailTmux(client *client.Client) error {
+	if *tailService != "" {
+		return fmt.Errorf("Can't use both a service and --tmux")
+	}
+
+	names, err := tmuxServices(client, *tailTmux)
+	if err != nil {
+		return err
+	}
+
+	tmux, err := exec.LookPath("tmux")
+	if err != nil {
+		return fmt.Errorf("Need tmux for --tmux: %v", err)
+	}
+
+	// Each window's tail talks to this same server
+	bento, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("Failed to find bento's path: %v", err)
+	}
+	baseArgs := []string{bento, "--fifo", config.FifoPath}
+	if config.InstanceName != "" {
+		baseArgs = append(baseArgs, "--instance", config.InstanceName)
+	} else if config.ProjectPath != "" {
+		baseArgs = append(baseArgs, "--project", config.ProjectPath)
+	}
+
+	tailCommand := func(name string) string {
+		args := append(append([]string{}, baseArgs...), "tail", "-F", "-n", *tailNum, name)
+		for i, arg := range args {
+			args[i] = shellQuote(arg)
+		}
+		return strings.Join(args, " ")
+	}
+
+	if os.Getenv("TMUX") != "" {
+		for _, name := range names {
+			if out, err := exec.Command(tmux, "new-window", "-n", name, tailCommand(name)).CombinedOutput(); err != nil {
+				return fmt.Errorf("Failed to open tmux window for %s: %v: %s", name, err, strings.TrimSpace(string(out)))
+			}
+		}
+		return nil
+	}
+
+	out, err := exec.Command(tmux, "new-session", "-d", "-P", "-F", "#{session_name}", "-n", names[0], tailCommand(names[0])).Output()
+	if err != nil {
+		return fmt.Errorf("Failed to start tmux session: %v", err)
+	}
+	session := strings.TrimSpace(string(out))
+
+	for _, name := range names[1:] {
+		if out, err := exec.Command(tmux, "new-window", "-t", session+":", "-n", name, tailCommand(name)).CombinedOutput(); err != nil {
+			return fmt.Errorf("Failed to open tmux window for %s: %v: %s", name, err, strings.TrimSpace(string(out)))
+		}
+	}
+
+	// Hand the terminal over to tmux
+	return syscall.Exec(tmux, []string{"tmux", "attach-session", "-t", session}, os.Environ())
+}
+
+// tmuxServices gets service names from a list like 'api,worker', where
+// 'profile:name' is all the services in a profile. Not '@name', since kingpin
+// reads args from a file with that.
+func tmuxServices(client *client.Client, spec string) ([]string, error) {
+	var names []string
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		} else if !strings.HasPrefix(item, "profile:") {
+			names = append(names, item)
+			continue
+		}
+
+		profile := strings.TrimPrefix(item, "profile:")
+		services, err := client.List(false, false)
+		if err != nil {
+			return nil, err
+		}
+
+		found := false
+		for _, info := range services {
+			if info.InProfile(profile) {
+				names = append(names, info.Name)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("No services in profile '%s'", profile)
+		}
+	}
+
+	if len(names) == 0 {
+		return nil, fmt.Errorf("No services to tail in '%s'", spec)
+	}
+	return names, nil
+}
+
+// shellQuote quotes an arg for a shell, like tmux runs commands with
+func shellQuote(arg string) string {
+	return "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+}
+
+// formatOutputLine gets a line of output to show, with its index and when it
+// was output if asked for. Lines from old servers don't have a time.
+func formatOutputLine(line service.OutputLine, timestamps, showIndex bool) string {
+	text := line.Line
+	if timestamps && !line.Time.IsZero() {
+		text = fmt.Sprintf("%s %s", line.Time.Format("2006-01-02 15:04:05.000"), text)
+	}
+	if showIndex {
+		text = fmt.Sprintf("%d %s", line.Index, text)
+	}
+	return text
+}
+
+func handleForeground(client *client.Client) error {
+	width := 0
+	for _, name := range *foregroundServices {
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+
+	// Output from ones that were already running is shown too, but they're
+	// left running when this stops
+	var infos, started []service.Info
+	for _, name := range *foregroundServices {
+		info, err := client.Start(name, false)
+		if err == nil {
+			started = append(started, info)
+		} else if running, infoErr := client.Info(name); infoErr == nil && running.Running {
+			info, err = running, nil
+		}
+		if err != nil {
+			foregroundStop(client, started)
+			return fmt.Errorf("Failed to start %s: %v", name, err)
+		}
+		infos = append(infos, info)
+	}
+
+	// Lines from all services are mixed, so don't let them interleave
+	var outputLock sync.Mutex
+	var wait sync.WaitGroup
+	tailErrs := make(chan error, len(infos))
+	for i, info := range infos {
+		prefix := service.PrefixColor(i)(fmt.Sprintf("%-*s |", width, info.Name))
+
+		// From the start of the run that was just started, or is already
+		// running
+		stdoutChan, stderrChan, errChan := client.Tail(info.Name, true, true, true, info.RestartOnExit, info.Pid, 0, 0)
+
+		wait.Add(2)
+		go func() {
+			defer wait.Done()
+			for line := range stdoutChan {
+				outputLock.Lock()
+				fmt.Println(prefix, line.Line)
+				outputLock.Unlock()
+			}
+		}()
+		go func() {
+			defer wait.Done()
+			for line := range stderrChan {
+				outputLock.Lock()
+				fmt.Fprintln(os.Stderr, prefix, line.Line)
+				outputLock.Unlock()
+			}
+			if err, ok := <-errChan; ok && err != nil {
+				tailErrs <- err
+			}
+		}()
+	}
+
+	tailsDone := make(chan interface{})
+	go func() {
+		wait.Wait()
+		close(tailsDone)
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	// Run until they've all exited, or asked to stop
+	select {
+	case <-tailsDone:
+	case <-signals:
+		fmt.Fprintln(os.Stderr, "Stopping services")
+		foregroundStop(client, started)
+		return nil
+	}
+
+	select {
+	case err := <-tailErrs:
+		return err
+	default:
+		return nil
+	}
+}
+
+// foregroundStop stops services started by foreground, all at once
+func foregroundStop(client *client.Client, infos []service.Info) {
+	var wait sync.WaitGroup
+	for _, info := range infos {
+		wait.Add(1)
+		go func(name string) {
+			defer wait.Done()
+			if _, err := client.Stop(name, nil); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to stop %s: %v\n", name, err)
+			}
+		}(info.Name)
+	}
+	wait.Wait()
+}
+
+func handleDumpOutput(client *client.Client) error {
+	lines, err := client.DumpOutput(*dumpOutputService, *dumpOutputPid)
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if *dumpOutputFile != "" {
+		if out, err = os.Create(*dumpOutputFile); err != nil {
+			return fmt.Errorf("Failed to create output file: %v", err)
+		}
+		defer out.Close()
+	}
+
+	// Both streams, in the order they were output
+	writer := bufio.NewWriter(out)
+	for _, line := range lines {
+		fmt.Fprintln(writer, formatOutputLine(line, *dumpOutputTimestamps, false))
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("Failed to write output: %v", err)
+	}
+
+	if *dumpOutputFile != "" && !*quiet {
+		fmt.Printf("Wrote %d lines to %s\n", len(lines), *dumpOutputFile)
+	}
+
+	return nil
+}
+
 func handleInfo(client *client.Client) error {
 	info, err := client.Info(*infoService)
 	if err == nil {
@@ -426,13 +1494,132 @@ func handleInfo(client *client.Client) error {
 	return err
 }
 
+func handleHistory(client *client.Client) error {
+	history, err := client.History(*historyService)
+	if err != nil {
+		return err
+	}
+
+	if len(history.Runs) == 0 && !history.Info.Running {
+		fmt.Printf("%s hasn't run yet.\n", *historyService)
+		return nil
+	}
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(table, "STARTED\tDURATION\tPID\tPEAK MEM\tCPU TIME\tOUTCOME")
+	for _, run := range history.Runs {
+		peakMem, cpuTime := "-", "-"
+		if run.PeakRSS != 0 || run.CPUTime != 0 {
+			peakMem, cpuTime = humanize.Bytes(run.PeakRSS), run.CPUTime.Round(time.Millisecond).String()
+		}
+
+		fmt.Fprintf(
+			table, "%s\t%s\t%d\t%s\t%s\t%s\n",
+			run.StartTime.Format("2006-01-02 15:04:05"),
+			run.EndTime.Sub(run.StartTime).Round(time.Millisecond),
+			run.Pid,
+			peakMem,
+			cpuTime,
+			run.Outcome())
+	}
+	if info := history.Info; info.Running {
+		fmt.Fprintf(
+			table, "%s\t%s\t%d\t%s\t%s\t%s\n",
+			info.StartTime.Format("2006-01-02 15:04:05"),
+			info.Runtime.Round(time.Millisecond),
+			info.Pid,
+			"-",
+			"-",
+			"running")
+	}
+
+	return table.Flush()
+}
+
+func handleStats(client *client.Client) error {
+	stats, err := client.Stats(*statsService, *statsSince)
+	if err != nil {
+		return err
+	}
+
+	samples := stats.Samples
+	if len(samples) == 0 {
+		fmt.Printf("No usage recorded for %s yet, it's sampled every %s while running.\n", *statsService, stats.Interval)
+		return nil
+	}
+
+	cpus := make([]float64, len(samples))
+	rsses := make([]float64, len(samples))
+	var cpuTotal, cpuMax float64
+	var rssTotal, rssMin, rssMax uint64 = 0, samples[0].RSS, 0
+	for i, sample := range samples {
+		cpus[i] = sample.CPU
+		rsses[i] = float64(sample.RSS)
+
+		cpuTotal += sample.CPU
+		if sample.CPU > cpuMax {
+			cpuMax = sample.CPU
+		}
+
+		rssTotal += sample.RSS
+		if sample.RSS < rssMin {
+			rssMin = sample.RSS
+		}
+		if sample.RSS > rssMax {
+			rssMax = sample.RSS
+		}
+	}
+	first, last := samples[0], samples[len(samples)-1]
+
+	rssChange := "+" + humanize.Bytes(last.RSS-first.RSS)
+	if last.RSS < first.RSS {
+		rssChange = "-" + humanize.Bytes(first.RSS-last.RSS)
+	}
+
+	fmt.Printf("[%s] %d samples over %s, since %s\n", *statsService, len(samples), last.Time.Sub(first.Time).Round(time.Second), humanize.Time(first.Time))
+	fmt.Printf(
+		"  cpu:    now %.1f%%, avg %.1f%%, max %.1f%%\n          %s\n",
+		last.CPU, cpuTotal/float64(len(samples)), cpuMax,
+		service.Sparkline(cpus, 60))
+	fmt.Printf(
+		"  memory: now %s, min %s, avg %s, max %s (%s over the period)\n          %s\n",
+		humanize.Bytes(last.RSS), humanize.Bytes(rssMin), humanize.Bytes(rssTotal/uint64(len(samples))), humanize.Bytes(rssMax), rssChange,
+		service.Sparkline(rsses, 60))
+
+	return nil
+}
+
 func handleWait(client *client.Client) error {
-	info, err := client.Wait(*waitService)
+	isPattern := strings.ContainsAny(*waitService, "*?[")
+	if *waitAny && *waitAll {
+		return fmt.Errorf("Can't use both --any and --all")
+	} else if isPattern && !*waitAny && !*waitAll {
+		return fmt.Errorf("Waiting on a pattern needs --any or --all")
+	}
+
+	wait, err := client.Wait(*waitService, *waitAny)
 	if err != nil {
 		return err
 	}
 
-	if info.Succeeded {
+	succeeded := wait.Info.Succeeded
+	code := processExitCode(wait.Info)
+	if isPattern && *waitAny {
+		fmt.Println(wait.Info)
+	} else if isPattern {
+		// Exit like the first one that failed, if any did
+		succeeded, code = true, 0
+		for _, info := range wait.Services {
+			fmt.Println(info)
+			if succeeded && !info.Succeeded {
+				succeeded, code = false, processExitCode(info)
+			}
+		}
+	}
+
+	if *waitCode {
+		os.Exit(code)
+	} else if succeeded {
 		os.Exit(0)
 	}
 	os.Exit(1)
@@ -440,12 +1627,104 @@ func handleWait(client *client.Client) error {
 	return nil
 }
 
+func handleNotifyWhenDone(client *client.Client) error {
+	wait, err := client.Wait(*notifyWhenDoneService, false)
+	if err != nil {
+		return err
+	}
+	info := wait.Info
+
+	if !*quiet {
+		fmt.Println(info)
+	}
+
+	if *notifyWhenDoneBell || (len(*notifyWhenDoneCommand) == 0 && !*notifyWhenDoneDesktop) {
+		fmt.Print("\a")
+	}
+
+	if *notifyWhenDoneDesktop {
+		title := fmt.Sprintf("%s finished", info.Name)
+		if !info.Succeeded {
+			title = fmt.Sprintf("%s failed", info.Name)
+		}
+		message := fmt.Sprintf("Ran for %s, exited with %d", info.Runtime.Round(time.Second), info.ExitCode)
+
+		if err := plugin.DesktopNotify(title, message); err != nil {
+			return fmt.Errorf("Failed to post desktop notification: %v", err)
+		}
+	}
+
+	if len(*notifyWhenDoneCommand) == 0 {
+		return nil
+	}
+
+	cmd := exec.Command((*notifyWhenDoneCommand)[0], (*notifyWhenDoneCommand)[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(
+		os.Environ(),
+		fmt.Sprintf("BENTO_SERVICE_NAME=%s", info.Name),
+		fmt.Sprintf("BENTO_EXIT_CODE=%d", info.ExitCode),
+		fmt.Sprintf("BENTO_SUCCEEDED=%t", info.Succeeded))
+
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			// Pass on how the command went, like a shell would
+			os.Exit(exitErr.ExitCode())
+		}
+		return fmt.Errorf("Failed to run command: %v", err)
+	}
+
+	return nil
+}
+
 func handlePid(client *client.Client) error {
-	info, err := client.Info(*pidService)
-	if err == nil {
-		fmt.Println(info.Pid)
+	if *pidPgid && *pidAll {
+		return fmt.Errorf("Can't use both --pgid and --all")
+	} else if !*pidPgid && !*pidAll {
+		info, err := client.Info(*pidService)
+		if err == nil {
+			fmt.Println(info.Pid)
+		}
+		return err
 	}
-	return err
+
+	pids, err := client.Pids(*pidService)
+	if err != nil {
+		return err
+	}
+
+	if *pidPgid {
+		fmt.Println(pids.Pgid)
+	} else {
+		fmt.Println(pids.Pid)
+		for _, pid := range pids.Descendants {
+			fmt.Println(pid)
+		}
+	}
+
+	return nil
+}
+
+func handleWhich(client *client.Client) error {
+	which, err := client.Which(*whichService)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("program: %s\n", which.Program)
+	if which.PathErr != "" {
+		fmt.Printf("resolves to: (not found) %s\n", which.PathErr)
+	} else {
+		fmt.Printf("resolves to: %s\n", which.Path)
+	}
+	if which.StartedPath != "" {
+		fmt.Printf("last started as: %s\n", which.StartedPath)
+	}
+	fmt.Printf("server's PATH: %s\n", which.EnvPath)
+
+	return nil
 }
 
 func autocompleteServices() []string {
@@ -504,8 +1783,8 @@ func autocompleteEnvs() []string {
 }
 
 // getServicesForAutocomplete tries to get a list of services for
-// autocompletion from a server, if possible. Otherwise tries to get from
-// config file.
+// autocompletion from a server, if possible. Otherwise tries to get from the
+// server's autocomplete cache, then config file.
 func getServicesForAutocomplete() []config.Service {
 	// First, disable logging, so even on errors, nothing is outputted, since
 	// this is being called during a tab-complete.
@@ -529,8 +1808,13 @@ func getServicesForAutocomplete() []config.Service {
 		}
 	}
 
+	// What the server last had, which is quicker than parsing the conf file
+	if confs, err := config.LoadAutocompleteCache(); err == nil {
+		return confs
+	}
+
 	// Try to get from a conf file
-	if confs, err := config.LoadServiceFile(config.ServiceConfigFile); err == nil {
+	if confs, _, err := config.LoadServiceFile(config.ServiceConfigFile); err == nil {
 		return confs
 	}
 
@@ -547,7 +1831,7 @@ func checkForServiceConfChanges(clnt *client.Client) {
 		return
 	}
 
-	localServiceConf, err := config.LoadServiceFile(config.ServiceConfigFile)
+	localServiceConf, _, err := config.LoadServiceFile(config.ServiceConfigFile)
 	if err != nil {
 		log.Debug("Failed to load services for diffing", "path", config.ServiceConfigFile, "err", err)
 		return
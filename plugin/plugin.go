@@ -0,0 +1,167 @@
+// Package plugin runs executables from the plugins dir, so integrations like
+// custom health probes and notification backends don't have to be built in.
+//
+// Probes are run by name, like `ready-when: {plugin: pg-ping}` runs
+// plugins/pg-ping, with the service's details in env vars. Exiting with 0
+// means the service is ready & healthy.
+//
+// Notifiers are all the executables in plugins/notify/. Each is run on every
+// event, with the event as JSON on stdin, and in env vars.
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/heewa/bento/config"
+)
+
+const (
+	// Dir in the plugins dir that notifiers are in
+	notifyDir = "notify"
+
+	// How long a plugin can take before it's killed
+	probeTimeout  = 5 * time.Second
+	notifyTimeout = 30 * time.Second
+)
+
+// Event is something that happened to a service, sent to notifiers
+type Event struct {
+	Kind    string    `json:"event"`
+	Service string    `json:"service"`
+	Pid     int       `json:"pid,omitempty"`
+	Time    time.Time `json:"time"`
+
+	// Set when a service exits, with its exit code, or like a shell, 128 +
+	// the signal that killed it
+	ExitCode  int  `json:"exit_code,omitempty"`
+	Succeeded bool `json:"succeeded,omitempty"`
+
+	// Also set when a service exits, with how long it ran, in seconds
+	Duration float64 `json:"duration,omitempty"`
+
+	// True if it's a temp service, like from run-once
+	Temp bool `json:"temp,omitempty"`
+
+	// True while it's flapping, even as it's restarted
+	Flapping bool `json:"flapping,omitempty"`
+
+	// Set when its health changes
+	Health string `json:"health,omitempty"`
+
+	// Set on notifications from a hooks script, which aren't about a
+	// particular service
+	Message string `json:"message,omitempty"`
+}
+
+// Kinds of events
+const (
+	EventStarted  = "started"
+	EventExited   = "exited"
+	EventFailed   = "failed"
+	EventHealth   = "health"
+	EventFlapping = "flapping"
+	EventNotify   = "notify"
+)
+
+// validName checks a plugin name refers to a file in the plugins dir
+func validName(name string) error {
+	if name == "" || strings.ContainsAny(name, "/\\") || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("Invalid plugin name '%s'", name)
+	}
+	return nil
+}
+
+// Probe runs a probe plugin for a service, returning true if it exited with
+// 0, meaning the service is ready & healthy.
+func Probe(name, service string, pid, port int) bool {
+	if validName(name) != nil {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, path.Join(config.PluginDir, name))
+	cmd.Env = append(os.Environ(),
+		"BENTO_SERVICE_NAME="+service,
+		"BENTO_PID="+strconv.Itoa(pid),
+		"PORT="+strconv.Itoa(port))
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		log.Debug("Probe plugin failed", "plugin", name, "service", service, "err", err, "output", string(out))
+		return false
+	}
+
+	return true
+}
+
+// Notify runs all notifier plugins with an event, in the background. Failures
+// are only logged.
+func Notify(event Event) {
+	files, err := ioutil.ReadDir(path.Join(config.PluginDir, notifyDir))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warn("Failed to list notifier plugins", "err", err)
+		}
+		return
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Error("Failed to encode event for notifiers", "err", err)
+		return
+	}
+
+	for _, file := range files {
+		if file.IsDir() || file.Mode()&0111 == 0 || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+
+		go notify(file.Name(), event, data)
+	}
+}
+
+// notify runs a notifier plugin with an event
+func notify(name string, event Event, data []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, path.Join(config.PluginDir, notifyDir, name))
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Env = append(os.Environ(),
+		"BENTO_EVENT="+event.Kind,
+		"BENTO_SERVICE_NAME="+event.Service,
+		"BENTO_PID="+strconv.Itoa(event.Pid))
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		log.Warn("Notifier plugin failed", "plugin", name, "event", event.Kind, "service", event.Service, "err", err, "output", string(out))
+	}
+}
+
+// DesktopNotify posts a notification with the desktop's notification tool,
+// for ones that are built in, instead of from a notifier plugin.
+func DesktopNotify(title, message string) error {
+	cmd := exec.Command("notify-send", "--app-name=bento", title, message)
+	if runtime.GOOS == "darwin" {
+		script := fmt.Sprintf("display notification %s with title %s", strconv.Quote(message), strconv.Quote(title))
+		cmd = exec.Command("osascript", "-e", script)
+	}
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, out)
+	}
+	return nil
+}
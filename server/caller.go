@@ -0,0 +1,151 @@
+package server
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"net/rpc"
+	"os"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/heewa/bento/config"
+	"github.com/heewa/bento/service"
+)
+
+// caller is who made a call, embedded in args of calls that check it. It's
+// unexported so it's never sent by clients, only set by the server from the
+// connection the call came in on. Calls from within the server, like from the
+// tray or hooks, have no caller, and can do anything.
+type caller struct {
+	callerUID   int
+	callerKnown bool
+}
+
+func (c *caller) setCaller(uid int) {
+	c.callerUID = uid
+	c.callerKnown = true
+}
+
+// uid gets the caller's uid, or the server's own, if it has no caller.
+func (c caller) uid() int {
+	if !c.callerKnown {
+		return os.Getuid()
+	}
+	return c.callerUID
+}
+
+// isAdmin is true if the caller can change any service: the server itself,
+// its user, root, or a configured admin.
+func (c caller) isAdmin() bool {
+	uid := c.uid()
+	return uid == os.Getuid() || uid == 0 || config.AdminUIDs[uid]
+}
+
+// checkOwner returns an error if the caller isn't allowed to change a
+// service, which only its owner and admins are.
+func (c caller) checkOwner(serv *service.Service) error {
+	if c.isAdmin() || c.uid() == serv.Owner() {
+		return nil
+	}
+	return fmt.Errorf("Service '%s' belongs to another user, only they or an admin can change it", serv.Conf.Name)
+}
+
+// checkAdmin returns an error if the caller isn't an admin, for calls that
+// change services that aren't anyone's in particular, like the services file's.
+func (c caller) checkAdmin(what string) error {
+	if c.isAdmin() {
+		return nil
+	}
+	return fmt.Errorf("Only the server's user or an admin can %s", what)
+}
+
+// ownerFor gets who should own a service the caller brings back from a saved
+// state. Admins keep the saved owner, if there is one, but others can only
+// bring back services as their own.
+func (c caller) ownerFor(state ServiceState) int {
+	if state.HasOwner && c.isAdmin() {
+		return state.Owner
+	}
+	return c.uid()
+}
+
+// callerArgs are args with a caller to set.
+type callerArgs interface {
+	setCaller(uid int)
+}
+
+// callerCodec is like net/rpc's own gob codec, but sets the caller on args
+// that have one, from the uid of the process on the other end of the
+// connection. An unknown uid leaves it unset. Calls over the user's call rate
+// limit are answered with an error instead of being made.
+type callerCodec struct {
+	rwc     io.ReadWriteCloser
+	dec     *gob.Decoder
+	enc     *gob.Encoder
+	encBuf  *bufio.Writer
+	uid     int
+	limiter *connLimiter
+	closed  bool
+}
+
+func newCallerCodec(conn io.ReadWriteCloser, uid int, limiter *connLimiter) *callerCodec {
+	buf := bufio.NewWriter(conn)
+	return &callerCodec{
+		rwc:     conn,
+		dec:     gob.NewDecoder(conn),
+		enc:     gob.NewEncoder(buf),
+		encBuf:  buf,
+		uid:     uid,
+		limiter: limiter,
+	}
+}
+
+func (c *callerCodec) ReadRequestHeader(r *rpc.Request) error {
+	return c.dec.Decode(r)
+}
+
+func (c *callerCodec) ReadRequestBody(body interface{}) error {
+	if err := c.dec.Decode(body); err != nil {
+		return err
+	}
+
+	// net/rpc answers the call with this error, and keeps reading others
+	if err := c.limiter.admitCall(c.uid); err != nil {
+		log.Debug("Rejecting call", "uid", c.uid, "err", err)
+		return err
+	}
+
+	if args, ok := body.(callerArgs); ok && c.uid >= 0 {
+		args.setCaller(c.uid)
+	}
+	return nil
+}
+
+func (c *callerCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	if err := c.enc.Encode(r); err != nil {
+		if c.encBuf.Flush() == nil {
+			log.Error("Failed to encode rpc response", "err", err)
+			c.Close()
+		}
+		return err
+	}
+	if err := c.enc.Encode(body); err != nil {
+		if c.encBuf.Flush() == nil {
+			log.Error("Failed to encode rpc response body", "err", err)
+			c.Close()
+		}
+		return err
+	}
+	return c.encBuf.Flush()
+}
+
+func (c *callerCodec) Close() error {
+	if c.closed {
+		// Only close the connection once
+		return nil
+	}
+	c.closed = true
+	return c.rwc.Close()
+}
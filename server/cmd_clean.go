@@ -14,6 +14,8 @@ import (
 type CleanArgs struct {
 	NamePattern string
 	Age         time.Duration
+
+	caller
 }
 
 // RemoveFailure -
@@ -52,9 +54,14 @@ func (s *Server) Clean(args CleanArgs, reply *CleanResponse) (err error) {
 		info := srvc.Info()
 		matches, _ := filepath.Match(args.NamePattern, info.Name)
 
+		// Only ones the caller can change, which is all of them for most
+		if args.checkOwner(srvc) != nil {
+			continue
+		}
+
 		if info.Temp && !info.Running && matches && (args.Age == 0 || now.Sub(info.EndTime) >= args.Age) {
 			if err := s.removeService(info.Name); err != nil {
-				log.Warn("Failed to remove a service", "name", info.Name, "err", err)
+				log.Warn("Failed to remove a service", "service", info.Name, "err", err)
 				reply.Failed = append(reply.Failed, RemoveFailure{info, err.Error()})
 			} else {
 				reply.Cleaned = append(reply.Cleaned, info)
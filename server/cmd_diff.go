@@ -0,0 +1,113 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/heewa/bento/config"
+)
+
+// DiffArgs -
+type DiffArgs struct {
+	ServiceFilePath string
+}
+
+// ServiceDiff is how a service in a services file differs from the one the
+// server has, and what loading the file would do to it.
+type ServiceDiff struct {
+	Name string
+
+	// What reloading would do: add, update, deprecate or remove it
+	Kind LoadEventKind
+
+	Running bool
+
+	// Fields that differ, for an updated service
+	Fields []config.FieldDiff
+}
+
+// NeedsRestart is true if the service is running, and a changed field can't
+// be applied without restarting it.
+func (d ServiceDiff) NeedsRestart() bool {
+	if !d.Running {
+		return false
+	}
+
+	for _, field := range d.Fields {
+		if !field.Safe {
+			return true
+		}
+	}
+	return false
+}
+
+// DiffResponse -
+type DiffResponse struct {
+	// Services that differ, by name
+	Services []ServiceDiff
+}
+
+// Diff compares the services the server has with a services file, without
+// changing anything.
+func (s *Server) Diff(args DiffArgs, reply *DiffResponse) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Crit("panic", "msg", r)
+			err = fmt.Errorf("Server error: %v", r)
+		}
+	}()
+
+	confs, bad, err := config.LoadServiceFile(args.ServiceFilePath)
+	if err != nil {
+		return err
+	}
+
+	// Bad confs can't be diffed, but they'd be left alone by a reload, so
+	// they're not removed either
+	inFile := make(map[string]bool)
+	for _, badConf := range bad {
+		inFile[badConf.Name] = true
+	}
+	for i := range confs {
+		conf := &confs[i]
+		inFile[conf.Name] = true
+
+		srvc := s.getService(conf.Name)
+		if srvc == nil {
+			reply.Services = append(reply.Services, ServiceDiff{Name: conf.Name, Kind: LoadAdded})
+			continue
+		}
+
+		if fields := srvc.Conf.Diff(conf); len(fields) > 0 {
+			reply.Services = append(reply.Services, ServiceDiff{
+				Name:    conf.Name,
+				Kind:    LoadUpdated,
+				Running: srvc.Running(),
+				Fields:  fields,
+			})
+		}
+	}
+
+	// Like a reload, running services that were removed from the file are
+	// kept until they exit
+	for _, srvc := range s.listServices() {
+		if inFile[srvc.Conf.Name] || srvc.Conf.Temp {
+			continue
+		}
+
+		diff := ServiceDiff{Name: srvc.Conf.Name, Kind: LoadRemoved}
+		if srvc.Running() {
+			diff.Kind = LoadDeprecated
+			diff.Running = true
+		}
+		reply.Services = append(reply.Services, diff)
+	}
+
+	sort.Slice(reply.Services, func(a, b int) bool {
+		return reply.Services[a].Name < reply.Services[b].Name
+	})
+
+	return nil
+}
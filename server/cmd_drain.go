@@ -0,0 +1,60 @@
+package server
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	log "github.com/inconshreveable/log15"
+)
+
+// DrainArgs -
+type DrainArgs struct {
+	// Stop draining, going back to starting services as usual
+	Off bool
+
+	caller
+}
+
+// DrainResponse -
+type DrainResponse struct {
+	Draining bool
+}
+
+// errDraining is returned for requests to start something while draining
+var errDraining = fmt.Errorf("Server is draining, not starting anything new. Stop draining with: bento drain --off")
+
+// Drain stops the server from starting anything new, like for a controlled
+// shutdown, while leaving running services alone.
+func (s *Server) Drain(args DrainArgs, reply *DrainResponse) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Crit("panic", "msg", r)
+			err = fmt.Errorf("Server error: %v", r)
+		}
+	}()
+
+	if err := args.checkAdmin("drain the server"); err != nil {
+		return err
+	}
+
+	if args.Off {
+		log.Info("Done draining")
+		atomic.StoreUint32(&s.draining, 0)
+
+		// Auto-starts queued while draining can go now
+		s.dispatchStarts()
+	} else {
+		log.Info("Draining, not starting anything new")
+		atomic.StoreUint32(&s.draining, 1)
+	}
+
+	if reply != nil {
+		reply.Draining = s.isDraining()
+	}
+
+	return nil
+}
+
+func (s *Server) isDraining() bool {
+	return atomic.LoadUint32(&s.draining) != 0
+}
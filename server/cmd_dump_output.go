@@ -0,0 +1,54 @@
+package server
+
+import (
+	"fmt"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/heewa/bento/service"
+)
+
+// DumpOutputArgs -
+type DumpOutputArgs struct {
+	// Name of service to get output from
+	Name string
+
+	// If specified, restrict output to this pid
+	Pid int
+
+	// If true, lines are sent packed, like with Tail
+	Packed bool
+}
+
+// DumpOutputResponse -
+type DumpOutputResponse struct {
+	// All retained output lines, oldest first
+	Lines []service.OutputLine
+
+	// Output lines encoded with service.PackLines, instead of in Lines, if
+	// they were asked for packed
+	Packed []byte
+}
+
+// DumpOutput gets all of a service's retained output in one go
+func (s *Server) DumpOutput(args DumpOutputArgs, reply *DumpOutputResponse) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Crit("panic", "msg", r)
+			err = fmt.Errorf("Server error: %v", r)
+		}
+	}()
+
+	serv := s.getService(args.Name)
+	if serv == nil {
+		return fmt.Errorf("Service '%s' not found.", args.Name)
+	}
+
+	reply.Lines, _, _, _ = serv.Output.Get(0, args.Pid, 0)
+	if args.Packed {
+		reply.Packed = service.PackLines(reply.Lines)
+		reply.Lines = nil
+	}
+
+	return nil
+}
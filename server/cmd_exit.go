@@ -7,8 +7,16 @@ import (
 	log "github.com/inconshreveable/log15"
 )
 
+// ExitArgs -
+type ExitArgs struct {
+	// Why the server's exiting, for its log
+	Reason string
+
+	caller
+}
+
 // Exit casues server to exit
-func (s *Server) Exit(_ bool, _ *bool) (err error) {
+func (s *Server) Exit(args ExitArgs, _ *bool) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Crit("panic", "msg", r)
@@ -16,7 +24,11 @@ func (s *Server) Exit(_ bool, _ *bool) (err error) {
 		}
 	}()
 
-	log.Info("Exiting server")
+	if err := args.checkAdmin("shut down the server"); err != nil {
+		return err
+	}
+
+	log.Info("Exiting server", "reason", args.Reason)
 	select {
 	case s.stop <- struct{}{}:
 	default:
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/heewa/bento/config"
+	"github.com/heewa/bento/service"
+)
+
+// State is everything about a server's services worth keeping, to move them
+// to another server, or bring them back after losing them.
+type State struct {
+	Version  string         `yaml:"version"`
+	Exported time.Time      `yaml:"exported"`
+	Services []ServiceState `yaml:"services"`
+}
+
+// ServiceState is what's kept about a service in State
+type ServiceState struct {
+	Conf        config.Service `yaml:"conf"`
+	Maintenance bool           `yaml:"maintenance,omitempty"`
+	Runs        []service.Run  `yaml:"runs,omitempty"`
+
+	// Uid of the service's owner, if HasOwner, which states from before it
+	// was kept don't have
+	Owner    int  `yaml:"owner,omitempty"`
+	HasOwner bool `yaml:"has-owner,omitempty"`
+}
+
+// ExportStateArgs -
+type ExportStateArgs struct {
+}
+
+// ExportStateResponse -
+type ExportStateResponse struct {
+	State State
+}
+
+// ExportState gets the state of all services, including temp ones, whether
+// they're in maintenance, and their past runs.
+func (s *Server) ExportState(args ExportStateArgs, reply *ExportStateResponse) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Crit("panic", "msg", r)
+			err = fmt.Errorf("Server error: %v", r)
+		}
+	}()
+
+	reply.State.Version = config.Version.String()
+	reply.State.Exported = time.Now()
+
+	for _, srvc := range s.listServices() {
+		reply.State.Services = append(reply.State.Services, ServiceState{
+			Conf:        srvc.Conf,
+			Maintenance: srvc.InMaintenance(),
+			Runs:        srvc.History(),
+			Owner:       srvc.Owner(),
+			HasOwner:    true,
+		})
+	}
+
+	sort.Slice(reply.State.Services, func(a, b int) bool {
+		return reply.State.Services[a].Conf.Name < reply.State.Services[b].Conf.Name
+	})
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"fmt"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/heewa/bento/service"
+)
+
+// HistoryArgs -
+type HistoryArgs struct {
+	Name string
+}
+
+// HistoryResponse -
+type HistoryResponse struct {
+	// Past runs, oldest first
+	Runs []service.Run
+
+	// Current state, to include a run that's still going
+	Info service.Info
+}
+
+// History gets the past runs of a service
+func (s *Server) History(args HistoryArgs, reply *HistoryResponse) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Crit("panic", "msg", r)
+			err = fmt.Errorf("Server error: %v", r)
+		}
+	}()
+
+	serv := s.getService(args.Name)
+	if serv == nil {
+		return fmt.Errorf("Service '%s' not found.", args.Name)
+	}
+
+	reply.Runs = serv.History()
+	reply.Info = serv.Info()
+
+	return nil
+}
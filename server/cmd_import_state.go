@@ -0,0 +1,146 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+
+	log "github.com/inconshreveable/log15"
+	"gopkg.in/yaml.v2"
+
+	"github.com/heewa/bento/config"
+	"github.com/heewa/bento/service"
+)
+
+// ImportStateArgs -
+type ImportStateArgs struct {
+	State State
+
+	caller
+}
+
+// ImportStateResponse -
+type ImportStateResponse struct {
+	// Services added from the state, and existing ones it was applied to
+	NewServices     []service.Info
+	UpdatedServices []service.Info
+
+	// True if the services file was written, since there wasn't one
+	WroteServiceFile bool
+
+	// Services that failed to import. Other services are still imported.
+	Failed []LoadFailure
+}
+
+// ImportState brings back services from an exported state. If there's no
+// services file, one's written with the state's permanent services, otherwise
+// the file is left as the source of truth for them. Temp services are added if
+// there aren't any by the same name. Services that are added get their past
+// runs back, and all of them are put in or out of maintenance to match.
+func (s *Server) ImportState(args ImportStateArgs, reply *ImportStateResponse) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Crit("panic", "msg", r)
+			err = fmt.Errorf("Server error: %v", r)
+		}
+	}()
+
+	log.Info("Import state", "services", len(args.State.Services), "exported", args.State.Exported)
+
+	existing := make(map[string]bool)
+	for _, srvc := range s.listServices() {
+		existing[srvc.Conf.Name] = true
+	}
+
+	var permanent []config.Service
+	for _, state := range args.State.Services {
+		if !state.Conf.Temp {
+			permanent = append(permanent, state.Conf)
+		}
+	}
+
+	if len(permanent) > 0 && s.serviceFilePath != "" {
+		if _, err := os.Stat(s.serviceFilePath); os.IsNotExist(err) {
+			if err := args.checkAdmin("write the services file"); err != nil {
+				return err
+			} else if err := writeServiceFile(s.serviceFilePath, permanent); err != nil {
+				return err
+			}
+			reply.WroteServiceFile = true
+
+			s.serviceFile = s.serviceFilePath
+			s.reloadServiceFile()
+		}
+	}
+
+	for _, state := range args.State.Services {
+		conf := state.Conf
+
+		if s.getService(conf.Name) == nil && conf.Temp {
+			if err := conf.Sanitize(); err != nil {
+				reply.Failed = append(reply.Failed, LoadFailure{conf.Name, err.Error()})
+				continue
+			}
+
+			srvc, err := service.New(conf)
+			if err == nil {
+				srvc.SetOwner(args.ownerFor(state))
+				err = s.addService(srvc, false)
+			}
+			if err != nil {
+				reply.Failed = append(reply.Failed, LoadFailure{conf.Name, err.Error()})
+				continue
+			}
+		}
+
+		srvc := s.getService(conf.Name)
+		if srvc == nil {
+			// Permanent service that isn't in the services file
+			continue
+		} else if err := args.checkOwner(srvc); err != nil {
+			reply.Failed = append(reply.Failed, LoadFailure{conf.Name, err.Error()})
+			continue
+		}
+
+		srvc.RestoreHistory(state.Runs)
+		srvc.SetMaintenance(state.Maintenance)
+
+		info := srvc.Info()
+		select {
+		case s.serviceUpdates <- info:
+		default:
+		}
+
+		if existing[conf.Name] {
+			reply.UpdatedServices = append(reply.UpdatedServices, info)
+		} else {
+			reply.NewServices = append(reply.NewServices, info)
+		}
+	}
+
+	sort.Sort(service.InfoByName(reply.NewServices))
+	sort.Sort(service.InfoByName(reply.UpdatedServices))
+
+	return nil
+}
+
+// writeServiceFile writes service confs to a new services file
+func writeServiceFile(filePath string, confs []config.Service) error {
+	data, err := yaml.Marshal(confs)
+	if err != nil {
+		return fmt.Errorf("Failed to encode services: %v", err)
+	}
+
+	if err := os.MkdirAll(path.Dir(filePath), 0700); err != nil {
+		return fmt.Errorf("Failed to create dir for services file: %v", err)
+	}
+
+	if err := ioutil.WriteFile(filePath, data, 0600); err != nil {
+		return fmt.Errorf("Failed to write services file: %v", err)
+	}
+
+	log.Info("Wrote services file from imported state", "file", filePath, "services", len(confs))
+	return nil
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"fmt"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/heewa/bento/service"
+)
+
+// LoadEventKind is what happened to a service during a load
+type LoadEventKind string
+
+// Kinds of load events
+const (
+	LoadAdded      LoadEventKind = "added"
+	LoadUpdated    LoadEventKind = "updated"
+	LoadDeprecated LoadEventKind = "deprecated"
+	LoadRemoved    LoadEventKind = "removed"
+	LoadFailed     LoadEventKind = "failed"
+)
+
+// LoadEvent is progress on loading a single service
+type LoadEvent struct {
+	Kind LoadEventKind
+	Name string
+
+	// Set for added, updated & deprecated services
+	Info service.Info
+
+	// Set for failed services
+	Err string
+}
+
+// LoadProgressArgs -
+type LoadProgressArgs struct {
+	// ProgressID given in LoadServicesArgs
+	ID string
+
+	// Index of next event to get, from a previous call
+	Index int
+}
+
+// LoadProgressResponse -
+type LoadProgressResponse struct {
+	Events []LoadEvent
+
+	// Index to use for a followup call to resume from the next event
+	NextIndex int
+
+	// True if the load is done, and there won't be more events
+	Done bool
+}
+
+// LoadProgress gets events from an ongoing LoadServices call, waiting for a
+// bit for new ones if there aren't any yet.
+func (s *Server) LoadProgress(args LoadProgressArgs, reply *LoadProgressResponse) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Crit("panic", "msg", r)
+			err = fmt.Errorf("Server error: %v", r)
+		}
+	}()
+
+	progress := s.getProgress(args.ID)
+	if progress == nil {
+		return fmt.Errorf("Load '%s' not found.", args.ID)
+	}
+
+	var events []interface{}
+	events, reply.NextIndex, reply.Done = progress.wait(args.Index)
+	for _, event := range events {
+		if loadEvent, ok := event.(LoadEvent); ok {
+			reply.Events = append(reply.Events, loadEvent)
+		}
+	}
+
+	return nil
+}
@@ -3,6 +3,8 @@ package server
 import (
 	"fmt"
 	"reflect"
+	"sort"
+	"sync"
 
 	log "github.com/inconshreveable/log15"
 
@@ -13,6 +15,26 @@ import (
 // LoadServicesArgs -
 type LoadServicesArgs struct {
 	ServiceFilePath string
+
+	// If set, only this service is loaded from the file, leaving the rest
+	// alone, even if their confs are broken
+	Name string
+
+	// If set, running services with changes that can't be applied while
+	// they run are restarted with their new confs, instead of failing
+	RestartChanged bool
+
+	// If set, progress can be followed with LoadProgress calls using this ID
+	// while the load is going on.
+	ProgressID string
+
+	caller
+}
+
+// LoadFailure -
+type LoadFailure struct {
+	Name string
+	Err  string
 }
 
 // LoadServicesResponse -
@@ -21,9 +43,24 @@ type LoadServicesResponse struct {
 	UpdatedServices    []service.Info
 	DeprecatedServices []service.Info
 	RemovedServices    []string
+
+	// Services that failed to load. Other services are still loaded.
+	Failed []LoadFailure
 }
 
-// LoadServices will start a new, temp service
+// loadResult is what happened when loading a single service's conf
+type loadResult int
+
+const (
+	loadUnchanged loadResult = iota
+	loadNew
+	loadUpdated
+	loadNeedsRestart
+)
+
+// LoadServices loads a services conf file, adding, updating and removing
+// services to match it. Each service is loaded concurrently, and one failing
+// doesn't stop the others, so failures are reported in the reply.
 func (s *Server) LoadServices(args LoadServicesArgs, reply *LoadServicesResponse) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -32,102 +69,305 @@ func (s *Server) LoadServices(args LoadServicesArgs, reply *LoadServicesResponse
 		}
 	}()
 
+	if err := args.checkAdmin("load services"); err != nil {
+		return err
+	}
+
 	log.Info("Load services", "file", args.ServiceFilePath)
-	confs, err := config.LoadServiceFile(args.ServiceFilePath)
+
+	progress, finish := s.trackProgress(args.ProgressID)
+	defer finish()
+
+	if args.Name != "" {
+		return s.loadOneService(args, reply, progress)
+	}
+
+	confs, bad, err := config.LoadServiceFile(args.ServiceFilePath)
 	if err != nil {
 		return err
 	}
 
-	confsToLoad := make(map[string]*config.Service)
+	// Queue up auto-starts from the whole file before starting any, so they
+	// go in order of priority
+	releaseStarts := s.holdStarts()
+	defer releaseStarts()
+
+	confsToLoad := make(map[string]bool)
 
-	log.Debug("Loaded service confs", "num", len(confs))
+	log.Debug("Loaded service confs", "num", len(confs), "bad", len(bad))
+
+	// Existing services with a bad conf are kept as they are, instead of
+	// being removed like they're no longer in the file
+	for _, badConf := range bad {
+		confsToLoad[badConf.Name] = true
+		addLoadResult(reply, progress, badConf.Name, loadUnchanged, service.Info{}, badConf.Err)
+	}
+
+	var replyLock sync.Mutex
+	var wait sync.WaitGroup
+	var restarts []config.Service
 	for _, conf := range confs {
-		confsToLoad[conf.Name] = &conf
+		confsToLoad[conf.Name] = true
 
-		if srvc := s.getService(conf.Name); srvc == nil {
-			log.Debug("Adding a new service", "conf", conf)
+		wait.Add(1)
+		go func(conf config.Service) {
+			defer wait.Done()
 
-			newSrvc, err := service.New(conf)
-			if err != nil {
-				return fmt.Errorf("Failed to create a new service (%s): %v", conf.Name, err)
-			}
+			result, info, err := s.loadService(conf)
+
+			replyLock.Lock()
+			defer replyLock.Unlock()
 
-			if err := s.addService(newSrvc, false); err != nil {
-				// Weird, that shouldn't happen.
-				return fmt.Errorf("Failed to add what looks like a new service (%s): %v", conf.Name, err)
+			if result == loadNeedsRestart && (args.RestartChanged || conf.AutoApply) {
+				restarts = append(restarts, conf)
+				return
 			}
+			addLoadResult(reply, progress, conf.Name, result, info, err)
+		}(conf)
+	}
+	wait.Wait()
+
+	s.restartChanged(restarts, reply, progress)
+
+	// Check for removed services
+	for _, srvc := range s.listServices() {
+		if !confsToLoad[srvc.Conf.Name] && !srvc.Conf.Temp {
+			s.unloadService(srvc, reply, progress)
+		}
+	}
+
+	// Loading happened concurrently, so make the order predictable
+	sort.Sort(service.InfoByName(reply.NewServices))
+	sort.Sort(service.InfoByName(reply.UpdatedServices))
+	sort.Sort(service.InfoByName(reply.DeprecatedServices))
+	sort.Strings(reply.RemovedServices)
+	sort.Slice(reply.Failed, func(a, b int) bool {
+		return reply.Failed[a].Name < reply.Failed[b].Name
+	})
+
+	return nil
+}
+
+// addLoadResult adds what happened loading a service to a reply & progress.
+func addLoadResult(reply *LoadServicesResponse, progress *progress, name string, result loadResult, info service.Info, err error) {
+	if err != nil {
+		log.Warn("Failed to load service", "service", name, "err", err)
+		reply.Failed = append(reply.Failed, LoadFailure{name, err.Error()})
+		progress.add(LoadEvent{Kind: LoadFailed, Name: name, Err: err.Error()})
+	} else if result == loadNew {
+		reply.NewServices = append(reply.NewServices, info)
+		progress.add(LoadEvent{Kind: LoadAdded, Name: name, Info: info})
+	} else if result == loadUpdated {
+		reply.UpdatedServices = append(reply.UpdatedServices, info)
+		progress.add(LoadEvent{Kind: LoadUpdated, Name: name, Info: info})
+	}
+}
+
+// restartChanged restarts running services with their new confs, stopping
+// ones with a lower start priority first, like on shutdown, then starting
+// ones with a higher start priority first, each ready before the next, like
+// bringing them up.
+func (s *Server) restartChanged(confs []config.Service, reply *LoadServicesResponse, progress *progress) {
+	if len(confs) == 0 {
+		return
+	}
+
+	byPriority := make(map[int][]*service.Service)
+	var priorities []int
+	for _, conf := range confs {
+		srvc := s.getService(conf.Name)
+		if srvc == nil {
+			continue
+		}
+
+		// Don't let the restart-watch bring back the old conf
+		if srvc.Conf.RestartOnExit {
+			s.removeServiceFromRestartWatch(srvc.Conf.Name)
+		}
+
+		priority := srvc.Conf.StartPriority
+		if _, ok := byPriority[priority]; !ok {
+			priorities = append(priorities, priority)
+		}
+		byPriority[priority] = append(byPriority[priority], srvc)
+	}
+	sort.Ints(priorities)
+
+	log.Info("Restarting services to apply their changes", "services", len(confs))
+	s.stopInOrder(priorities, byPriority)
 
-			reply.NewServices = append(reply.NewServices, newSrvc.Info())
-		} else if reflect.DeepEqual(srvc.Conf, conf) {
-			// Unmodified service, ignore
-		} else if !srvc.Running() {
-			// Since it's not running, ignore issue of safe changes, and just
-			// replace it.
-			log.Debug("Replacing a changed service", "current", srvc.Conf, "new", conf)
+	sort.Slice(confs, func(a, b int) bool {
+		if confs[a].StartPriority != confs[b].StartPriority {
+			return confs[a].StartPriority > confs[b].StartPriority
+		}
+		return confs[a].Name < confs[b].Name
+	})
 
-			newSrvc, err := service.New(conf)
+	for _, conf := range confs {
+		result, info, err := s.loadService(conf)
+		if err == nil && result == loadUpdated {
+			// A one-off task that finished successfully before it could be
+			// ready is fine too
+			startReply := StartResponse{}
+			err = s.Start(StartArgs{Name: conf.Name, WaitReady: true}, &startReply)
+			if err != nil && startReply.Info.Succeeded && !startReply.Info.Running {
+				err = nil
+			}
 			if err != nil {
-				return fmt.Errorf("Failed to create a changed service (%s): %v", conf.Name, err)
+				err = fmt.Errorf("Failed to start service with its new conf (%s): %v", conf.Name, err)
 			}
+			info = startReply.Info
+		}
 
-			if err := s.addService(newSrvc, true); err != nil {
-				return fmt.Errorf("Failed to add back a changed service (%s): %v", conf.Name, err)
-			}
+		addLoadResult(reply, progress, conf.Name, result, info, err)
+	}
+}
 
-			reply.UpdatedServices = append(reply.UpdatedServices, newSrvc.Info())
-		} else if srvc.Conf.EqualIgnoringSafeFields(&conf) {
-			log.Debug("Updating an running service with safe changes", "curent", srvc.Conf, "new", conf)
+// loadOneService loads a single service from a file, adding, updating or
+// removing it to match.
+func (s *Server) loadOneService(args LoadServicesArgs, reply *LoadServicesResponse, progress *progress) error {
+	name := args.Name
+	conf, err := config.LoadServiceFromFile(args.ServiceFilePath, name)
+	if err != nil {
+		return err
+	}
 
-			// If conf is adding back a service that had earlier been
-			// marked as temp because of a removal from conf, and is now
-			// being restored, un-temp-ify it.
-			if srvc.Conf.Temp && !conf.Temp && !s.changeServicePermanence(srvc.Conf.Name, false, 0) {
-				return fmt.Errorf("Failed to remove temporary status of a now-permanent service (%s)", srvc.Conf.Name)
-			}
+	if conf == nil {
+		srvc := s.getService(name)
+		if srvc == nil || srvc.Conf.Temp {
+			return fmt.Errorf("Service '%s' isn't in the services file.", name)
+		}
 
-			// Auto-start is safe to just set or clean on a conf of a service
-			// that's already running
-			srvc.Conf.AutoStart = conf.AutoStart
-
-			// Changing restart-on-exit requires some work, though
-			if !srvc.Conf.RestartOnExit && conf.RestartOnExit {
-				s.addServiceToRestartWatch(srvc)
-				srvc.Conf.RestartOnExit = true
-			} else if srvc.Conf.RestartOnExit && !conf.RestartOnExit {
-				s.removeServiceFromRestartWatch(srvc.Conf.Name)
-				srvc.Conf.RestartOnExit = false
-			}
+		s.unloadService(srvc, reply, progress)
+	} else if result, info, err := s.loadService(*conf); result == loadNeedsRestart && (args.RestartChanged || conf.AutoApply) {
+		s.restartChanged([]config.Service{*conf}, reply, progress)
+	} else {
+		addLoadResult(reply, progress, name, result, info, err)
+	}
 
-			// To be sure we didn't forget to add logic to set a safe field,
-			// check that all changes were made.
-			if !reflect.DeepEqual(srvc.Conf, conf) {
-				return fmt.Errorf("Failed to fully apply conf changes to service (%s)", srvc.Conf.Name)
-			}
+	return nil
+}
 
-			reply.UpdatedServices = append(reply.UpdatedServices, srvc.Info())
+// unloadService removes a service that's no longer in the services file, or
+// if it's still running, marks it as temp, to be removed once it exits.
+func (s *Server) unloadService(srvc *service.Service, reply *LoadServicesResponse, progress *progress) {
+	// If it's not running, just remove it
+	if !srvc.Running() {
+		log.Info("Removing service that's no longer in conf", "service", srvc.Conf.Name)
+		if err := s.removeService(srvc.Conf.Name); err != nil {
+			reply.Failed = append(reply.Failed, LoadFailure{srvc.Conf.Name, err.Error()})
+			progress.add(LoadEvent{Kind: LoadFailed, Name: srvc.Conf.Name, Err: err.Error()})
 		} else {
-			return fmt.Errorf("Cannot apply these changes to a running service (%s)", conf.Name)
+			reply.RemovedServices = append(reply.RemovedServices, srvc.Conf.Name)
+			progress.add(LoadEvent{Kind: LoadRemoved, Name: srvc.Conf.Name})
 		}
+		return
 	}
 
-	// Check for removed services
-	for _, srvc := range s.listServices() {
-		if conf := confsToLoad[srvc.Conf.Name]; conf == nil {
-			// If it's not running, just remove it
-			if !srvc.Running() {
-				log.Info("Removing service that's no longer in conf", "name", srvc.Conf.Name)
-				s.removeService(srvc.Conf.Name)
-				reply.RemovedServices = append(reply.RemovedServices, srvc.Conf.Name)
-			} else {
-				// Since it's still running, mark it as temporary with an immediate clean up
-				log.Info("Service that's no longer in conf is running, marking as temp for removal after exit", "name", srvc.Conf.Name)
-				if !s.changeServicePermanence(srvc.Conf.Name, true, 0) {
-					return fmt.Errorf("Failed to set a removed, but still running servicey (%s) as temporary for cleanup when it exits", srvc.Conf.Name)
-				}
-				reply.DeprecatedServices = append(reply.DeprecatedServices, srvc.Info())
-			}
+	// Since it's still running, mark it as temporary with an immediate clean up
+	log.Info("Service that's no longer in conf is running, marking as temp for removal after exit", "service", srvc.Conf.Name)
+	if !s.changeServicePermanence(srvc.Conf.Name, true, 0) {
+		err := fmt.Errorf("Failed to set a removed, but still running servicey (%s) as temporary for cleanup when it exits", srvc.Conf.Name)
+		reply.Failed = append(reply.Failed, LoadFailure{srvc.Conf.Name, err.Error()})
+		progress.add(LoadEvent{Kind: LoadFailed, Name: srvc.Conf.Name, Err: err.Error()})
+	} else {
+		info := srvc.Info()
+		reply.DeprecatedServices = append(reply.DeprecatedServices, info)
+		progress.add(LoadEvent{Kind: LoadDeprecated, Name: srvc.Conf.Name, Info: info})
+	}
+}
+
+// loadService adds or updates a single service from its conf.
+func (s *Server) loadService(conf config.Service) (loadResult, service.Info, error) {
+	srvc := s.getService(conf.Name)
+	if srvc == nil {
+		log.Debug("Adding a new service", "service", conf.Name, "conf", conf)
+
+		newSrvc, err := service.New(conf)
+		if err != nil {
+			return loadUnchanged, service.Info{}, fmt.Errorf("Failed to create a new service (%s): %v", conf.Name, err)
+		}
+
+		if err := s.addService(newSrvc, false); err != nil {
+			// Weird, that shouldn't happen.
+			return loadUnchanged, service.Info{}, fmt.Errorf("Failed to add what looks like a new service (%s): %v", conf.Name, err)
+		}
+
+		return loadNew, newSrvc.Info(), nil
+	} else if reflect.DeepEqual(srvc.Conf, conf) {
+		// Unmodified service, ignore
+		return loadUnchanged, srvc.Info(), nil
+	} else if !srvc.Running() {
+		// Since it's not running, ignore issue of safe changes, and just
+		// replace it.
+		log.Debug("Replacing a changed service", "service", conf.Name, "current", srvc.Conf, "new", conf)
+
+		newSrvc, err := service.New(conf)
+		if err != nil {
+			return loadUnchanged, service.Info{}, fmt.Errorf("Failed to create a changed service (%s): %v", conf.Name, err)
+		}
+		newSrvc.SetMaintenance(srvc.InMaintenance())
+
+		if err := s.addService(newSrvc, true); err != nil {
+			return loadUnchanged, service.Info{}, fmt.Errorf("Failed to add back a changed service (%s): %v", conf.Name, err)
+		}
+
+		return loadUpdated, newSrvc.Info(), nil
+	} else if srvc.Conf.EqualIgnoringSafeFields(&conf) {
+		log.Debug("Updating an running service with safe changes", "service", conf.Name, "current", srvc.Conf, "new", conf)
+
+		// If conf is adding back a service that had earlier been
+		// marked as temp because of a removal from conf, and is now
+		// being restored, un-temp-ify it.
+		if srvc.Conf.Temp && !conf.Temp && !s.changeServicePermanence(srvc.Conf.Name, false, 0) {
+			return loadUnchanged, service.Info{}, fmt.Errorf("Failed to remove temporary status of a now-permanent service (%s)", srvc.Conf.Name)
+		}
+
+		// Changes are made under the service's lock, since it's running, and
+		// its conf is read when it restarts or reports info
+		applied := false
+		restartOnExit := srvc.Conf.RestartOnExit
+		srvc.UpdateConf(func(current *config.Service) {
+			// Descriptions, tags, auto-start, auto-apply & profiles are safe
+			// to just set or clean on a conf of a service that's already
+			// running
+			current.Description = conf.Description
+			current.Tags = conf.Tags
+			current.AutoStart = conf.AutoStart
+			current.Profiles = conf.Profiles
+			current.AutoApply = conf.AutoApply
+
+			// Kill mode, reload signal, restart strategy, open url, ports,
+			// start priority, stop timeout, restart window & schedule only
+			// matter when they're used, so they're safe too
+			current.KillMode = conf.KillMode
+			current.ReloadSignal = conf.ReloadSignal
+			current.RestartStrategy = conf.RestartStrategy
+			current.OpenURL = conf.OpenURL
+			current.Ports = conf.Ports
+			current.StartPriority = conf.StartPriority
+			current.StopTimeout = conf.StopTimeout
+			current.RestartWindow = conf.RestartWindow
+			current.RestartSchedule = conf.RestartSchedule
+			current.RestartOnExit = conf.RestartOnExit
+
+			// To be sure we didn't forget to add logic to set a safe field,
+			// check that all changes were made.
+			applied = reflect.DeepEqual(*current, conf)
+		})
+		if !applied {
+			return loadUnchanged, service.Info{}, fmt.Errorf("Failed to fully apply conf changes to service (%s)", conf.Name)
+		}
+
+		// Changing restart-on-exit requires some work, though
+		if !restartOnExit && conf.RestartOnExit {
+			s.addServiceToRestartWatch(srvc)
+		} else if restartOnExit && !conf.RestartOnExit {
+			s.removeServiceFromRestartWatch(conf.Name)
 		}
+
+		return loadUpdated, srvc.Info(), nil
 	}
 
-	return nil
+	return loadNeedsRestart, service.Info{}, fmt.Errorf("Cannot apply these changes to a running service (%s), restart it to apply them, like with reload --restart-changed", conf.Name)
 }
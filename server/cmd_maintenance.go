@@ -0,0 +1,77 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/heewa/bento/service"
+)
+
+// MaintenanceArgs -
+type MaintenanceArgs struct {
+	Name string
+
+	// Instead of a single service, apply to all of them
+	All bool
+
+	// Put into maintenance if true, take out of it otherwise
+	On bool
+
+	caller
+}
+
+// MaintenanceResponse -
+type MaintenanceResponse struct {
+	Services []service.Info
+}
+
+// Maintenance puts services in or out of maintenance. While in maintenance,
+// a service isn't restarted when it exits, so it can be stopped or poked at
+// without bento fighting back.
+func (s *Server) Maintenance(args MaintenanceArgs, reply *MaintenanceResponse) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Crit("panic", "msg", r)
+			err = fmt.Errorf("Server error: %v", r)
+		}
+	}()
+
+	var services []*service.Service
+	if args.All {
+		// All the ones the caller can change
+		for _, serv := range s.listServices() {
+			if args.checkOwner(serv) == nil {
+				services = append(services, serv)
+			}
+		}
+	} else if serv := s.getService(args.Name); serv == nil {
+		return fmt.Errorf("Service '%s' not found.", args.Name)
+	} else if err := args.checkOwner(serv); err != nil {
+		return err
+	} else {
+		services = append(services, serv)
+	}
+
+	for _, serv := range services {
+		log.Info("Setting maintenance", "service", serv.Conf.Name, "on", args.On)
+		serv.SetMaintenance(args.On)
+
+		info := serv.Info()
+		select {
+		case s.serviceUpdates <- info:
+		default:
+		}
+
+		if reply != nil {
+			reply.Services = append(reply.Services, info)
+		}
+	}
+
+	if reply != nil {
+		sort.Sort(service.InfoByName(reply.Services))
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/heewa/bento/service"
+)
+
+// OpenURLArgs -
+type OpenURLArgs struct {
+	Name string
+
+	caller
+}
+
+// OpenURL opens a service's open-url, from the server, since it's running in
+// the user's desktop session.
+func (s *Server) OpenURL(args OpenURLArgs, _ *bool) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Crit("panic", "msg", r)
+			err = fmt.Errorf("Server error: %v", r)
+		}
+	}()
+
+	serv := s.getService(args.Name)
+	if serv == nil {
+		return fmt.Errorf("Service '%s' not found.", args.Name)
+	} else if err := args.checkOwner(serv); err != nil {
+		return err
+	} else if serv.Conf.OpenURL == "" {
+		return fmt.Errorf("Service '%s' doesn't have an open-url.", args.Name)
+	}
+
+	return openURL(serv.Conf.OpenURL)
+}
+
+// openURLWhenReady opens a service's open-url once its current process is
+// ready.
+func (s *Server) openURLWhenReady(serv *service.Service) {
+	if err := serv.WaitReady(); err != nil {
+		log.Debug("Not opening url for service that didn't become ready", "service", serv.Conf.Name, "err", err)
+		return
+	}
+
+	if err := openURL(serv.Conf.OpenURL); err != nil {
+		log.Warn("Failed to open url for service", "service", serv.Conf.Name, "url", serv.Conf.OpenURL, "err", err)
+	}
+}
+
+// openURL opens a url with the desktop's default handler for it
+func openURL(url string) error {
+	opener := "xdg-open"
+	if runtime.GOOS == "darwin" {
+		opener = "open"
+	}
+
+	log.Info("Opening url", "url", url)
+	if err := exec.Command(opener, url).Run(); err != nil {
+		return fmt.Errorf("Failed to open %s: %v", url, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"syscall"
+
+	log "github.com/inconshreveable/log15"
+)
+
+// PidsArgs -
+type PidsArgs struct {
+	Name string
+}
+
+// PidsResponse -
+type PidsResponse struct {
+	Pid  int
+	Pgid int
+
+	// All processes under the service's process, including ones that left
+	// its process group
+	Descendants []int
+}
+
+// Pids gets the process ids of a running service's processes
+func (s *Server) Pids(args PidsArgs, reply *PidsResponse) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Crit("panic", "msg", r)
+			err = fmt.Errorf("Server error: %v", r)
+		}
+	}()
+
+	serv := s.getService(args.Name)
+	if serv == nil {
+		return fmt.Errorf("Service '%s' not found.", args.Name)
+	}
+
+	pid := serv.Pid()
+	if !serv.Running() || pid == 0 {
+		return fmt.Errorf("Service isn't running.")
+	}
+
+	pgid, err := syscall.Getpgid(pid)
+	if err != nil {
+		return fmt.Errorf("Failed to get service's process group: %v", err)
+	}
+
+	reply.Pid = pid
+	reply.Pgid = pgid
+	reply.Descendants = serv.Descendants()
+	sort.Ints(reply.Descendants)
+
+	return nil
+}
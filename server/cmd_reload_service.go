@@ -0,0 +1,51 @@
+package server
+
+import (
+	"fmt"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/heewa/bento/service"
+)
+
+// ReloadServiceArgs -
+type ReloadServiceArgs struct {
+	Name string
+
+	caller
+}
+
+// ReloadServiceResponse -
+type ReloadServiceResponse struct {
+	Info service.Info
+}
+
+// ReloadService sends a running service its reload signal, so it can reload
+// its config without a full restart.
+func (s *Server) ReloadService(args ReloadServiceArgs, reply *ReloadServiceResponse) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Crit("panic", "msg", r)
+			err = fmt.Errorf("Server error: %v", r)
+		}
+	}()
+
+	unlock := s.lockService(args.Name)
+	defer unlock()
+
+	serv := s.getService(args.Name)
+	if serv == nil {
+		return fmt.Errorf("Service '%s' not found.", args.Name)
+	} else if err := args.checkOwner(serv); err != nil {
+		return err
+	}
+
+	log.Info("Reloading service", "service", serv.Conf.Name)
+	err = serv.Reload()
+
+	if reply != nil {
+		reply.Info = serv.Info()
+	}
+
+	return err
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/heewa/bento/service"
+)
+
+// RestartArgs -
+type RestartArgs struct {
+	Name string
+
+	// Time to wait between escalation signals to the service's process
+	EscalationInterval time.Duration
+
+	caller
+}
+
+// RestartResponse -
+type RestartResponse struct {
+	Info service.Info
+}
+
+// Restart stops and starts a service, or just starts it if it's stopped
+func (s *Server) Restart(args RestartArgs, reply *RestartResponse) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Crit("panic", "msg", r)
+			err = fmt.Errorf("Server error: %v", r)
+		}
+	}()
+
+	if s.isDraining() {
+		return errDraining
+	}
+
+	unlock := s.lockService(args.Name)
+	defer unlock()
+
+	serv := s.getService(args.Name)
+	if serv == nil {
+		return fmt.Errorf("Service '%s' not found.", args.Name)
+	} else if err := args.checkOwner(serv); err != nil {
+		return err
+	}
+
+	// Don't let the restart-watch see the old process exiting as a reason to
+	// restart it
+	if serv.Conf.RestartOnExit {
+		s.removeServiceFromRestartWatch(serv.Conf.Name)
+	}
+
+	if err := s.assignPort(serv); err != nil {
+		return err
+	}
+
+	log.Info("Restarting service", "service", serv.Conf.Name, "strategy", serv.Conf.RestartStrategy)
+	err = serv.Restart(s.serviceUpdates, args.EscalationInterval)
+
+	if serv.Running() && serv.Conf.RestartOnExit {
+		s.addServiceToRestartWatch(serv)
+	}
+
+	// Set info regardless of error
+	if reply != nil {
+		reply.Info = serv.Info()
+	}
+
+	return err
+}
@@ -19,6 +19,9 @@ type RunArgs struct {
 	Dir        string
 	Env        map[string]string
 	CleanAfter time.Duration
+	Notify     bool
+
+	caller
 }
 
 // RunResponse -
@@ -35,12 +38,16 @@ func (s *Server) Run(args *RunArgs, reply *RunResponse) (err error) {
 		}
 	}()
 
+	if s.isDraining() {
+		return errDraining
+	}
+
 	if args.Name == "" {
 		// Name it after the program, but avoid collisions by checking.
 		prog := filepath.Base(args.Program)
 		if srvc := s.getService(prog); srvc == nil {
 			args.Name = prog
-		} else if srvc.Conf.Temp && !srvc.Running() {
+		} else if srvc.Conf.Temp && !srvc.Running() && args.checkOwner(srvc) == nil {
 			// Colliding with an ended temporary service, just replace it.
 			if err := s.removeService(prog); err == nil {
 				args.Name = prog
@@ -69,6 +76,7 @@ func (s *Server) Run(args *RunArgs, reply *RunResponse) (err error) {
 
 		Temp:       true,
 		CleanAfter: args.CleanAfter,
+		NotifyDone: args.Notify,
 	}
 	if err := conf.Sanitize(); err != nil {
 		return err
@@ -78,6 +86,7 @@ func (s *Server) Run(args *RunArgs, reply *RunResponse) (err error) {
 	if err != nil {
 		return err
 	}
+	serv.SetOwner(args.uid())
 
 	if err := s.addService(serv, false); err != nil {
 		return fmt.Errorf("Failed to add service (%s): %v", conf.Name, err)
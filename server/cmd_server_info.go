@@ -0,0 +1,90 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/blang/semver"
+	log "github.com/inconshreveable/log15"
+
+	"github.com/heewa/bento/config"
+	"github.com/heewa/bento/service"
+)
+
+// ServiceMemory -
+type ServiceMemory struct {
+	Name        string
+	OutputLines int
+	OutputBytes int
+}
+
+// ServerInfoResponse -
+type ServerInfoResponse struct {
+	Version semver.Version
+	Pid     int
+
+	StartTime time.Time
+	Uptime    time.Duration
+
+	Goroutines int
+
+	// Bytes of heap allocated, and total obtained from the OS
+	MemAlloc uint64
+	MemSys   uint64
+
+	// Number of client connections accepted since start
+	ConnectionsServed uint64
+
+	// True if it's not starting anything new
+	Draining bool
+
+	// Memory used by retained output of all services, and its budget
+	OutputMemory    int64
+	MaxOutputMemory int64
+
+	// Memory used by each service's retained output
+	Services []ServiceMemory
+}
+
+// ServerInfo gets stats about the server itself
+func (s *Server) ServerInfo(_ bool, reply *ServerInfoResponse) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Crit("panic", "msg", r)
+			err = fmt.Errorf("Server error: %v", r)
+		}
+	}()
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	reply.Version = config.Version
+	reply.Pid = os.Getpid()
+	reply.StartTime = s.startTime
+	reply.Uptime = time.Since(s.startTime)
+	reply.Goroutines = runtime.NumGoroutine()
+	reply.MemAlloc = mem.Alloc
+	reply.MemSys = mem.Sys
+	reply.ConnectionsServed = atomic.LoadUint64(&s.connsServed)
+	reply.Draining = s.isDraining()
+	reply.OutputMemory = service.OutputMemory()
+	reply.MaxOutputMemory = config.MaxOutputMemory
+
+	for _, srvc := range s.listServices() {
+		lines, bytes := srvc.Output.Size()
+		reply.Services = append(reply.Services, ServiceMemory{
+			Name:        srvc.Conf.Name,
+			OutputLines: lines,
+			OutputBytes: bytes,
+		})
+	}
+	sort.Slice(reply.Services, func(a, b int) bool {
+		return reply.Services[a].OutputBytes > reply.Services[b].OutputBytes
+	})
+
+	return nil
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"gopkg.in/yaml.v2"
+
+	"github.com/heewa/bento/config"
+	"github.com/heewa/bento/service"
+)
+
+// SnapshotArgs -
+type SnapshotArgs struct {
+	Name string
+}
+
+// SnapshotResponse -
+type SnapshotResponse struct {
+	// Services that were running, and are in the snapshot
+	Services []service.Info
+}
+
+// Snapshot saves which services are running, with the confs of temp ones, to
+// bring back with Restore.
+func (s *Server) Snapshot(args SnapshotArgs, reply *SnapshotResponse) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Crit("panic", "msg", r)
+			err = fmt.Errorf("Server error: %v", r)
+		}
+	}()
+
+	filePath, err := s.snapshotPath(args.Name)
+	if err != nil {
+		return err
+	}
+
+	state := State{
+		Version:  config.Version.String(),
+		Exported: time.Now(),
+	}
+	for _, srvc := range s.listServices() {
+		if !srvc.Running() {
+			continue
+		}
+
+		state.Services = append(state.Services, ServiceState{
+			Conf:     srvc.Conf,
+			Owner:    srvc.Owner(),
+			HasOwner: true,
+		})
+		reply.Services = append(reply.Services, srvc.Info())
+	}
+
+	data, err := yaml.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("Failed to encode snapshot: %v", err)
+	}
+
+	if err := os.MkdirAll(s.snapshotDir, 0700); err != nil {
+		return fmt.Errorf("Failed to create snapshots dir: %v", err)
+	} else if err := ioutil.WriteFile(filePath, data, 0600); err != nil {
+		return fmt.Errorf("Failed to write snapshot: %v", err)
+	}
+
+	log.Info("Saved snapshot", "name", args.Name, "services", len(state.Services))
+	return nil
+}
+
+// RestoreArgs -
+type RestoreArgs struct {
+	Name string
+
+	caller
+}
+
+// RestoreResponse -
+type RestoreResponse struct {
+	Started []service.Info
+	Stopped []service.Info
+
+	// Services that failed to be brought back or stopped. Others still are.
+	Failed []LoadFailure
+}
+
+// Restore brings back the set of services that were running when a snapshot
+// was taken: temp services that are gone are added back, ones in it are
+// started, and any others that are running are stopped.
+func (s *Server) Restore(args RestoreArgs, reply *RestoreResponse) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Crit("panic", "msg", r)
+			err = fmt.Errorf("Server error: %v", r)
+		}
+	}()
+
+	if s.isDraining() {
+		return errDraining
+	}
+
+	filePath, err := s.snapshotPath(args.Name)
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("Snapshot '%s' not found.", args.Name)
+	} else if err != nil {
+		return fmt.Errorf("Failed to read snapshot: %v", err)
+	}
+
+	var state State
+	if err := yaml.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("Invalid snapshot (%s): %v", args.Name, err)
+	}
+
+	log.Info("Restoring snapshot", "name", args.Name, "services", len(state.Services))
+
+	inSnapshot := make(map[string]bool)
+	for _, serviceState := range state.Services {
+		inSnapshot[serviceState.Conf.Name] = true
+	}
+
+	// Stop others first, so they're not in the way, like of ports
+	for _, srvc := range s.listServices() {
+		if inSnapshot[srvc.Conf.Name] || !srvc.Running() {
+			continue
+		}
+
+		stopReply := StopResponse{}
+		if err := s.Stop(StopArgs{Name: srvc.Conf.Name, caller: args.caller}, &stopReply); err != nil {
+			reply.Failed = append(reply.Failed, LoadFailure{srvc.Conf.Name, err.Error()})
+		} else {
+			reply.Stopped = append(reply.Stopped, stopReply.Info)
+		}
+	}
+
+	for _, serviceState := range state.Services {
+		conf := serviceState.Conf
+
+		srvc := s.getService(conf.Name)
+		if srvc == nil && conf.Temp {
+			// Temp services are cleaned up, so bring it back
+			if err := conf.Sanitize(); err != nil {
+				reply.Failed = append(reply.Failed, LoadFailure{conf.Name, err.Error()})
+				continue
+			}
+
+			if srvc, err = service.New(conf); err == nil {
+				srvc.SetOwner(args.ownerFor(serviceState))
+				err = s.addService(srvc, false)
+			}
+			if err != nil {
+				reply.Failed = append(reply.Failed, LoadFailure{conf.Name, err.Error()})
+				continue
+			}
+		} else if srvc == nil {
+			reply.Failed = append(reply.Failed, LoadFailure{conf.Name, "Service not found"})
+			continue
+		}
+
+		if srvc.Running() {
+			continue
+		}
+
+		startReply := StartResponse{}
+		if err := s.Start(StartArgs{Name: conf.Name, caller: args.caller}, &startReply); err != nil {
+			reply.Failed = append(reply.Failed, LoadFailure{conf.Name, err.Error()})
+		} else {
+			reply.Started = append(reply.Started, startReply.Info)
+		}
+	}
+
+	return nil
+}
+
+// snapshotPath gets the path to a snapshot's file from its name
+func (s *Server) snapshotPath(name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, "/\\") || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("Invalid snapshot name '%s'", name)
+	}
+
+	return path.Join(s.snapshotDir, name+".yml"), nil
+}
@@ -11,6 +11,11 @@ import (
 // StartArgs -
 type StartArgs struct {
 	Name string
+
+	// If true, wait for the service to be ready before returning
+	WaitReady bool
+
+	caller
 }
 
 // StartResponse -
@@ -27,16 +32,46 @@ func (s *Server) Start(args StartArgs, reply *StartResponse) (err error) {
 		}
 	}()
 
-	serv := s.getService(args.Name)
+	if s.isDraining() {
+		return errDraining
+	}
+
+	serv, err := func() (*service.Service, error) {
+		unlock := s.lockService(args.Name)
+		defer unlock()
+
+		serv := s.getService(args.Name)
+		if serv == nil {
+			return nil, fmt.Errorf("Service '%s' not found.", args.Name)
+		} else if err := args.checkOwner(serv); err != nil {
+			return nil, err
+		}
+
+		if err := s.assignPort(serv); err != nil {
+			return nil, err
+		}
+
+		err := serv.Start(s.serviceUpdates)
+
+		// If started, and it's supposed to be watched, add to watchlist
+		if err == nil && serv.Conf.RestartOnExit {
+			s.addServiceToRestartWatch(serv)
+		}
+
+		return serv, err
+	}()
 	if serv == nil {
-		return fmt.Errorf("Service '%s' not found.", args.Name)
+		return err
 	}
 
-	err = serv.Start(s.serviceUpdates)
+	if err == nil && serv.Conf.OpenURL != "" {
+		go s.openURLWhenReady(serv)
+	}
 
-	// If started, and it's supposed to be watched, add to watchlist
-	if err == nil && serv.Conf.RestartOnExit {
-		s.addServiceToRestartWatch(serv)
+	// Wait without holding the lifecycle lock, so it can still be stopped
+	if err == nil && args.WaitReady {
+		log.Debug("Waiting for service to be ready", "service", serv.Conf.Name)
+		err = serv.WaitReady()
 	}
 
 	// Set info regardless of error
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/heewa/bento/service"
+)
+
+// StartProfileArgs -
+type StartProfileArgs struct {
+	Profile string
+
+	// If true, wait for each service to be ready before starting the next
+	WaitReady bool
+
+	caller
+}
+
+// StartProfileResponse -
+type StartProfileResponse struct {
+	Services []service.Info
+
+	// Services that failed to start. Others are still started.
+	Failed []LoadFailure
+}
+
+// StartProfile starts all stopped services in a profile, ones with a higher
+// start priority first.
+func (s *Server) StartProfile(args StartProfileArgs, reply *StartProfileResponse) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Crit("panic", "msg", r)
+			err = fmt.Errorf("Server error: %v", r)
+		}
+	}()
+
+	if s.isDraining() {
+		return errDraining
+	}
+
+	var services []*service.Service
+	for _, srvc := range s.listServices() {
+		if srvc.Conf.InProfile(args.Profile) {
+			services = append(services, srvc)
+		}
+	}
+	if len(services) == 0 {
+		return fmt.Errorf("No services in profile '%s'", args.Profile)
+	}
+
+	sort.Slice(services, func(a, b int) bool {
+		if services[a].Conf.StartPriority != services[b].Conf.StartPriority {
+			return services[a].Conf.StartPriority > services[b].Conf.StartPriority
+		}
+		return services[a].Conf.Name < services[b].Conf.Name
+	})
+
+	log.Info("Starting profile", "profile", args.Profile, "services", len(services))
+	for _, srvc := range services {
+		if srvc.Running() {
+			reply.Services = append(reply.Services, srvc.Info())
+			continue
+		}
+
+		startReply := StartResponse{}
+		if err := s.Start(StartArgs{Name: srvc.Conf.Name, WaitReady: args.WaitReady, caller: args.caller}, &startReply); err != nil {
+			reply.Failed = append(reply.Failed, LoadFailure{srvc.Conf.Name, err.Error()})
+		} else {
+			reply.Services = append(reply.Services, startReply.Info)
+		}
+	}
+
+	return nil
+}
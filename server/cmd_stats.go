@@ -0,0 +1,51 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/heewa/bento/service"
+)
+
+// StatsArgs -
+type StatsArgs struct {
+	Name string
+
+	// Only get samples from this far back, or all of them if 0
+	Since time.Duration
+}
+
+// StatsResponse -
+type StatsResponse struct {
+	Samples []service.UsageSample
+
+	// Time between samples
+	Interval time.Duration
+}
+
+// Stats gets the history of a service's resource usage
+func (s *Server) Stats(args StatsArgs, reply *StatsResponse) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Crit("panic", "msg", r)
+			err = fmt.Errorf("Server error: %v", r)
+		}
+	}()
+
+	serv := s.getService(args.Name)
+	if serv == nil {
+		return fmt.Errorf("Service '%s' not found.", args.Name)
+	}
+
+	var since time.Time
+	if args.Since > 0 {
+		since = time.Now().Add(-args.Since)
+	}
+
+	reply.Samples = serv.Usage(since)
+	reply.Interval = service.UsageInterval
+
+	return nil
+}
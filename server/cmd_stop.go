@@ -15,6 +15,11 @@ type StopArgs struct {
 
 	// Time to wait between escalation signals to the service's process
 	EscalationInterval time.Duration
+
+	// If set, progress can be followed with StopProgress calls using this ID
+	ProgressID string
+
+	caller
 }
 
 // StopResponse -
@@ -31,9 +36,14 @@ func (s *Server) Stop(args StopArgs, reply *StopResponse) (err error) {
 		}
 	}()
 
+	unlock := s.lockService(args.Name)
+	defer unlock()
+
 	serv := s.getService(args.Name)
 	if serv == nil {
 		return fmt.Errorf("Service '%s' not found.", args.Name)
+	} else if err := args.checkOwner(serv); err != nil {
+		return err
 	}
 
 	// Before stopping, if it's being restart-watched, remove that so we
@@ -43,8 +53,13 @@ func (s *Server) Stop(args StopArgs, reply *StopResponse) (err error) {
 		s.removeServiceFromRestartWatch(serv.Conf.Name)
 	}
 
+	progress, finish := s.trackProgress(args.ProgressID)
+	defer finish()
+
 	log.Info("Stopping service", "service", serv.Conf.Name)
-	err = serv.Stop(args.EscalationInterval)
+	err = serv.Stop(args.EscalationInterval, func(step string) {
+		progress.add(step)
+	})
 
 	// Set info regarless of error
 	if reply != nil {
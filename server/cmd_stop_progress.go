@@ -0,0 +1,54 @@
+package server
+
+import (
+	"fmt"
+
+	log "github.com/inconshreveable/log15"
+)
+
+// StopProgressArgs -
+type StopProgressArgs struct {
+	// ProgressID given in StopArgs
+	ID string
+
+	// Index of next step to get, from a previous call
+	Index int
+}
+
+// StopProgressResponse -
+type StopProgressResponse struct {
+	// Steps taken to stop the service, like "sent SIGTERM to pid 123"
+	Steps []string
+
+	// Index to use for a followup call to resume from the next step
+	NextIndex int
+
+	// True if the stop is done, and there won't be more steps
+	Done bool
+}
+
+// StopProgress gets steps from an ongoing Stop call, waiting for a bit for
+// new ones if there aren't any yet.
+func (s *Server) StopProgress(args StopProgressArgs, reply *StopProgressResponse) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Crit("panic", "msg", r)
+			err = fmt.Errorf("Server error: %v", r)
+		}
+	}()
+
+	progress := s.getProgress(args.ID)
+	if progress == nil {
+		return fmt.Errorf("Stop '%s' not found.", args.ID)
+	}
+
+	var events []interface{}
+	events, reply.NextIndex, reply.Done = progress.wait(args.Index)
+	for _, event := range events {
+		if step, ok := event.(string); ok {
+			reply.Steps = append(reply.Steps, step)
+		}
+	}
+
+	return nil
+}
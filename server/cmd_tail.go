@@ -6,6 +6,7 @@ import (
 
 	log "github.com/inconshreveable/log15"
 
+	"github.com/heewa/bento/config"
 	"github.com/heewa/bento/service"
 )
 
@@ -29,6 +30,11 @@ type TailArgs struct {
 	// that process is done with output, the call will return, even if there
 	// isn't any output, and EOF will be true.
 	Follow bool
+
+	// If true, lines are sent packed in the response's Packed field, which
+	// is a lot faster than gob for lots of them. Older servers ignore it and
+	// send Lines.
+	Packed bool
 }
 
 // TailResponse -
@@ -36,10 +42,23 @@ type TailResponse struct {
 	// Output lines
 	Lines []service.OutputLine
 
+	// Output lines encoded with service.PackLines, instead of in Lines, if
+	// they were asked for packed
+	Packed []byte
+
 	// True if the pid asked for is done outputting. If no pid was given,
 	// true if tail has reached end of whatever is currently available.
 	EOF bool
 
+	// True if the lines were cut short to stay under the server's
+	// max_tail_response, with more available right away from NextIndex
+	More bool
+
+	// Lines that were dropped from the service's output before they could be
+	// sent, like when a following client falls behind a flood of output,
+	// which is never held up for clients
+	Skipped int
+
 	// Index & pid to use for a followup call to resume from the next line
 	// of output.
 	NextIndex int
@@ -60,7 +79,10 @@ func (s *Server) Tail(args *TailArgs, reply *TailResponse) (err error) {
 		return fmt.Errorf("Service '%s' not found.", args.Name)
 	}
 
-	reply.Lines, reply.EOF, reply.NextIndex, reply.NextPid = serv.Output.Get(args.Index, args.Pid, args.MaxLines)
+	reply.Lines, reply.EOF, reply.NextIndex, reply.NextPid, reply.More, reply.Skipped = serv.Output.GetLimited(args.Index, args.Pid, args.MaxLines, config.MaxTailResponse)
+	if reply.Skipped > 0 {
+		log.Debug("Tail fell behind output", "service", args.Name, "skipped", reply.Skipped)
+	}
 
 	// If following output, wait for some output for a bit.
 	// TODO: use a channel for a no-sleep solution
@@ -72,7 +94,14 @@ func (s *Server) Tail(args *TailArgs, reply *TailResponse) (err error) {
 		case <-time.After(500 * time.Millisecond):
 		}
 
-		reply.Lines, reply.EOF, reply.NextIndex, reply.NextPid = serv.Output.Get(reply.NextIndex, reply.NextPid, args.MaxLines)
+		var skipped int
+		reply.Lines, reply.EOF, reply.NextIndex, reply.NextPid, reply.More, skipped = serv.Output.GetLimited(reply.NextIndex, reply.NextPid, args.MaxLines, config.MaxTailResponse)
+		reply.Skipped += skipped
+	}
+
+	if args.Packed {
+		reply.Packed = service.PackLines(reply.Lines)
+		reply.Lines = nil
 	}
 
 	return nil
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/heewa/bento/service"
+)
+
+// UpArgs -
+type UpArgs struct {
+	// If set, running services with changes that can't be applied while
+	// they run are restarted with their new confs, instead of failing
+	RestartChanged bool
+
+	caller
+}
+
+// UpResponse -
+type UpResponse struct {
+	Services []service.Info
+
+	// Services that failed to load or start. Others are still started.
+	Failed []LoadFailure
+}
+
+// Up loads the services file, and starts all of its services, ones with a
+// higher start priority first, each one ready before the next is started.
+func (s *Server) Up(args UpArgs, reply *UpResponse) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Crit("panic", "msg", r)
+			err = fmt.Errorf("Server error: %v", r)
+		}
+	}()
+
+	if s.isDraining() {
+		return errDraining
+	} else if err := args.checkAdmin("bring up the services file"); err != nil {
+		return err
+	} else if s.serviceFile == "" {
+		return fmt.Errorf("No services file to bring up")
+	}
+
+	loadReply := LoadServicesResponse{}
+	loadArgs := LoadServicesArgs{
+		ServiceFilePath: s.serviceFile,
+		RestartChanged:  args.RestartChanged,
+	}
+	if err := s.LoadServices(loadArgs, &loadReply); err != nil {
+		return err
+	}
+	reply.Failed = loadReply.Failed
+
+	services := s.fileServices()
+	sort.Slice(services, func(a, b int) bool {
+		if services[a].Conf.StartPriority != services[b].Conf.StartPriority {
+			return services[a].Conf.StartPriority > services[b].Conf.StartPriority
+		}
+		return services[a].Conf.Name < services[b].Conf.Name
+	})
+
+	log.Info("Bringing up services", "services", len(services))
+	for _, srvc := range services {
+		if srvc.Running() {
+			// Might have been auto-started by the load, but still wait for
+			// it, so the next one can count on it
+			if err := srvc.WaitReady(); err != nil {
+				reply.Failed = append(reply.Failed, LoadFailure{srvc.Conf.Name, err.Error()})
+			} else {
+				reply.Services = append(reply.Services, srvc.Info())
+			}
+			continue
+		}
+
+		// A one-off task that finished successfully before it could be
+		// ready is fine too
+		startReply := StartResponse{}
+		err := s.Start(StartArgs{Name: srvc.Conf.Name, WaitReady: true}, &startReply)
+		if err != nil && startReply.Info.Succeeded && !startReply.Info.Running {
+			err = nil
+		}
+		if err != nil {
+			reply.Failed = append(reply.Failed, LoadFailure{srvc.Conf.Name, err.Error()})
+		} else {
+			reply.Services = append(reply.Services, startReply.Info)
+		}
+	}
+
+	return nil
+}
+
+// DownArgs -
+type DownArgs struct {
+	// If set, services are only stopped, and not removed
+	Keep bool
+
+	caller
+}
+
+// DownResponse -
+type DownResponse struct {
+	Removed []string
+}
+
+// Down stops all services from the services file, ones with a higher start
+// priority last, like on shutdown, then removes them, unless told to keep them.
+// Temp services are left alone.
+func (s *Server) Down(args DownArgs, reply *DownResponse) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Crit("panic", "msg", r)
+			err = fmt.Errorf("Server error: %v", r)
+		}
+	}()
+
+	if err := args.checkAdmin("take down the services file"); err != nil {
+		return err
+	}
+
+	services := s.fileServices()
+
+	byPriority := make(map[int][]*service.Service)
+	var priorities []int
+	for _, srvc := range services {
+		// Don't let the restart-watch bring it back while others stop
+		if srvc.Conf.RestartOnExit {
+			s.removeServiceFromRestartWatch(srvc.Conf.Name)
+		}
+
+		priority := srvc.Conf.StartPriority
+		if _, ok := byPriority[priority]; !ok {
+			priorities = append(priorities, priority)
+		}
+		byPriority[priority] = append(byPriority[priority], srvc)
+	}
+	sort.Ints(priorities)
+
+	log.Info("Taking down services", "services", len(services))
+	s.stopInOrder(priorities, byPriority)
+	if args.Keep {
+		return nil
+	}
+
+	for _, srvc := range services {
+		if err := s.removeService(srvc.Conf.Name); err != nil {
+			return fmt.Errorf("Failed to remove service '%s': %v", srvc.Conf.Name, err)
+		}
+		reply.Removed = append(reply.Removed, srvc.Conf.Name)
+	}
+	sort.Strings(reply.Removed)
+
+	return nil
+}
+
+// fileServices gets the services that are from the services file, which are
+// all the ones that aren't temp.
+func (s *Server) fileServices() []*service.Service {
+	var services []*service.Service
+	for _, srvc := range s.listServices() {
+		if !srvc.Conf.Temp {
+			services = append(services, srvc)
+		}
+	}
+	return services
+}
@@ -12,9 +12,14 @@ import (
 // VersionResponse -
 type VersionResponse struct {
 	Version semver.Version
+
+	// RPC methods the server supports, like "Server.List", so a client can
+	// tell what it can use instead of needing an exact version match. Older
+	// servers leave it empty.
+	Methods []string
 }
 
-// Version gets the version of the server
+// Version gets the version of the server, and which RPC methods it supports
 func (s *Server) Version(_ bool, reply *VersionResponse) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -25,6 +30,7 @@ func (s *Server) Version(_ bool, reply *VersionResponse) (err error) {
 
 	if reply != nil {
 		reply.Version = config.Version
+		reply.Methods = rpcMethods()
 	}
 
 	return nil
@@ -2,6 +2,8 @@ package server
 
 import (
 	"fmt"
+	"path/filepath"
+	"sort"
 
 	log "github.com/inconshreveable/log15"
 
@@ -10,15 +12,24 @@ import (
 
 // WaitArgs -
 type WaitArgs struct {
+	// Name of a service, or a pattern of names like "worker-*"
 	Name string
+
+	// With a pattern, return when any of the services stop, instead of all
+	Any bool
 }
 
 // WaitResponse -
 type WaitResponse struct {
+	// The service that stopped, or with a pattern, the first one that did
 	Info service.Info
+
+	// With a pattern, all the services that matched, by name
+	Services []service.Info
 }
 
-// Wait blocks until a service stops running
+// Wait blocks until a service stops running, or with a pattern, until any or
+// all matching services do.
 func (s *Server) Wait(args *WaitArgs, reply *WaitResponse) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -27,15 +38,49 @@ func (s *Server) Wait(args *WaitArgs, reply *WaitResponse) (err error) {
 		}
 	}()
 
-	serv := s.getService(args.Name)
-	if serv == nil {
+	var services []*service.Service
+	if serv := s.getService(args.Name); serv != nil {
+		services = append(services, serv)
+	} else if _, err := filepath.Match(args.Name, ""); err != nil {
+		return fmt.Errorf("Bad service name pattern: %v", err)
+	} else {
+		for _, serv := range s.listServices() {
+			if matches, _ := filepath.Match(args.Name, serv.Conf.Name); matches {
+				services = append(services, serv)
+			}
+		}
+	}
+	if len(services) == 0 {
 		return fmt.Errorf("Service '%s' not found.", args.Name)
 	}
 
-	if err = serv.Wait(); err != nil {
-		return err
+	// Wait on all of them at once, noting the order they stop in, until
+	// returning, so with --any, ones still running aren't waited on forever
+	stopped := make(chan *service.Service, len(services))
+	done := make(chan struct{})
+	defer close(done)
+	for _, serv := range services {
+		go func(serv *service.Service) {
+			select {
+			case <-serv.GetExitChan():
+				stopped <- serv
+			case <-done:
+			}
+		}(serv)
+	}
+
+	first := <-stopped
+	if !args.Any {
+		for i := 1; i < len(services); i++ {
+			<-stopped
+		}
+	}
+
+	reply.Info = first.Info()
+	for _, serv := range services {
+		reply.Services = append(reply.Services, serv.Info())
 	}
+	sort.Sort(service.InfoByName(reply.Services))
 
-	reply.Info = serv.Info()
 	return nil
 }
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	log "github.com/inconshreveable/log15"
+)
+
+// WhichArgs -
+type WhichArgs struct {
+	Name string
+}
+
+// WhichResponse -
+type WhichResponse struct {
+	// Program as configured
+	Program string
+
+	// Full path the program resolves to now, or why it doesn't
+	Path    string
+	PathErr string
+
+	// Full path it resolved to the last time it was started, if it has been
+	StartedPath string
+
+	// The server's PATH, which programs are looked up in
+	EnvPath string
+}
+
+// Which gets the full path the server resolves a service's program to, which
+// can differ from a user's shell, since the server has its own PATH.
+func (s *Server) Which(args WhichArgs, reply *WhichResponse) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Crit("panic", "msg", r)
+			err = fmt.Errorf("Server error: %v", r)
+		}
+	}()
+
+	serv := s.getService(args.Name)
+	if serv == nil {
+		return fmt.Errorf("Service '%s' not found.", args.Name)
+	}
+
+	reply.Program = serv.Conf.Program
+	if path, err := exec.LookPath(serv.Conf.Program); err != nil {
+		reply.PathErr = err.Error()
+	} else {
+		reply.Path = path
+	}
+	reply.StartedPath = serv.ProgramPath()
+	reply.EnvPath = os.Getenv("PATH")
+
+	return nil
+}
@@ -0,0 +1,26 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/heewa/bento/plugin"
+)
+
+// notifyDone posts a desktop notification that a service is done, from the
+// server, since it's running in the user's desktop session.
+func notifyDone(event plugin.Event) {
+	title := fmt.Sprintf("%s finished", event.Service)
+	if !event.Succeeded {
+		title = fmt.Sprintf("%s failed", event.Service)
+	}
+
+	ran := time.Duration(event.Duration * float64(time.Second)).Round(time.Second)
+	message := fmt.Sprintf("Ran for %s, exited with %d", ran, event.ExitCode)
+
+	if err := plugin.DesktopNotify(title, message); err != nil {
+		log.Warn("Failed to post desktop notification", "service", event.Service, "err", err)
+	}
+}
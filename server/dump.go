@@ -0,0 +1,116 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/heewa/bento/config"
+	"github.com/heewa/bento/service"
+)
+
+// dumpState writes a snapshot of the server's state, including goroutine
+// stacks, to the dump file. If the server's logging to stdout, so does the
+// dump. Errors are only logged, since this is for debugging.
+func (s *Server) dumpState() {
+	var out io.Writer = os.Stdout
+	if config.LogPath != "" && config.LogPath != "-" {
+		f, err := os.Create(config.DumpPath)
+		if err != nil {
+			log.Error("Failed to create dump file", "path", config.DumpPath, "err", err)
+			return
+		}
+		defer f.Close()
+
+		out = f
+	}
+
+	if err := s.writeState(out); err != nil {
+		log.Error("Failed to dump state", "path", config.DumpPath, "err", err)
+		return
+	}
+
+	log.Info("Dumped state", "path", config.DumpPath)
+}
+
+// dumpLockTimeout is how long the dump waits on each part of the state, so a
+// lock that's stuck being held doesn't keep the rest from being written
+const dumpLockTimeout = 2 * time.Second
+
+// writeState writes goroutine stacks first, since those are what shows why a
+// server is wedged, then what it can of the rest of the state, skipping parts
+// that are stuck behind a held lock.
+func (s *Server) writeState(out io.Writer) error {
+	fmt.Fprintf(out, "Bento server state at %s\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(out, "  version: %s\n", config.Version)
+	fmt.Fprintf(out, "  pid: %d\n\n", os.Getpid())
+
+	if err := pprof.Lookup("goroutine").WriteTo(out, 2); err != nil {
+		return err
+	}
+
+	var services []*service.Service
+	if !withTimeout(func() { services = s.listServices() }) {
+		fmt.Fprintf(out, "\nServices: stuck waiting on the services lock\n")
+		return nil
+	}
+
+	fmt.Fprintf(out, "\nServices (%d):\n", len(services))
+	sort.Slice(services, func(a, b int) bool {
+		return services[a].Conf.Name < services[b].Conf.Name
+	})
+	for _, srvc := range services {
+		var (
+			info         service.Info
+			lines, bytes int
+		)
+		if withTimeout(func() {
+			info = srvc.Info()
+			lines, bytes = srvc.Output.Size()
+		}) {
+			fmt.Fprintf(out, "%s\n      output: %d lines, %d bytes\n", info.PlainString(), lines, bytes)
+		} else {
+			fmt.Fprintf(out, "  %s: stuck waiting on its lock\n", srvc.Conf.Name)
+		}
+	}
+
+	var watched []string
+	if !withTimeout(func() {
+		s.watchLock.RLock()
+		defer s.watchLock.RUnlock()
+
+		for name := range s.watchedServices {
+			watched = append(watched, name)
+		}
+	}) {
+		fmt.Fprintf(out, "\nRestart-watched services: stuck waiting on the watch lock\n")
+		return nil
+	}
+	sort.Strings(watched)
+	fmt.Fprintf(out, "\nRestart-watched services (%d): %s\n", len(watched), strings.Join(watched, ", "))
+
+	return nil
+}
+
+// withTimeout runs fn, but gives up waiting on it after dumpLockTimeout,
+// returning false, in which case nothing fn sets should be used.
+func withTimeout(fn func()) bool {
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(dumpLockTimeout):
+		return false
+	}
+}
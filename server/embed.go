@@ -0,0 +1,125 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+
+	log "github.com/inconshreveable/log15"
+)
+
+// Options are for a Server run inside another program, see NewEmbedded.
+type Options struct {
+	// SocketPath is the unix socket clients connect on, like with
+	// client.Dial. One starting with @ is an abstract socket.
+	SocketPath string
+
+	// ServiceFile is a services file to load on Serve, and reload, if set
+	ServiceFile string
+
+	// HooksScript is a Lua script to run on service events, if set
+	HooksScript string
+
+	// StateDir is where the server keeps its own files, like snapshots, and
+	// sockets services with a watchdog ping. If unset, a temp dir is made
+	// for it, and removed on Shutdown.
+	StateDir string
+}
+
+// NewEmbedded creates a Server to run inside another program, like a test
+// harness supervising its fixtures. Unlike one from New, it only uses what's
+// in opts instead of bento's config for its socket, services file, hooks,
+// snapshots and watchdog sockets, doesn't handle the program's signals, and
+// doesn't write the autocomplete cache. Other settings, like ShutdownTimeout, are read from config as is,
+// but never changed. Run it with Serve, and stop it with Shutdown.
+func NewEmbedded(opts Options) (*Server, error) {
+	if opts.SocketPath == "" {
+		return nil, fmt.Errorf("Need a socket path for an embedded server")
+	}
+
+	addr, err := net.ResolveUnixAddr("unix", opts.SocketPath)
+	if err != nil {
+		return nil, err
+	}
+
+	stateDir := opts.StateDir
+	if stateDir == "" {
+		if stateDir, err = ioutil.TempDir("", "bento-"); err != nil {
+			return nil, fmt.Errorf("Failed to make a state dir: %v", err)
+		}
+	}
+
+	serv, _ := newServer(addr, opts.HooksScript)
+	serv.embedded = true
+	serv.snapshotDir = path.Join(stateDir, "snapshots")
+	serv.notifySocketDir = path.Join(stateDir, "notify")
+	if opts.StateDir == "" {
+		serv.tempStateDir = stateDir
+	}
+	serv.abstractSocket = strings.HasPrefix(opts.SocketPath, "@")
+	serv.serviceFile = opts.ServiceFile
+	serv.serviceFilePath = opts.ServiceFile
+	serv.done = make(chan error, 1)
+
+	return serv, nil
+}
+
+// Serve starts an embedded server, returning once clients can connect, and
+// its services file, if it has one, is loaded.
+func (s *Server) Serve() error {
+	if !s.embedded {
+		return fmt.Errorf("Only embedded servers can be run with Serve")
+	}
+
+	listener, err := s.listen()
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		s.done <- s.serve(listener)
+		close(s.done)
+	}()
+
+	if s.serviceFile != "" {
+		args := LoadServicesArgs{
+			ServiceFilePath: s.serviceFile,
+		}
+		reply := LoadServicesResponse{}
+		if err := s.LoadServices(args, &reply); err != nil {
+			if shutdownErr := s.Shutdown(); shutdownErr != nil {
+				log.Error("Failed to shut down server", "err", shutdownErr)
+			}
+			return err
+		}
+
+		for _, failure := range reply.Failed {
+			log.Error("Failed to load service", "service", failure.Name, "err", failure.Err)
+		}
+	}
+
+	return nil
+}
+
+// Shutdown stops an embedded server, and all its services, returning once
+// they've stopped. Calling it again does nothing.
+func (s *Server) Shutdown() (err error) {
+	if !s.embedded {
+		return fmt.Errorf("Only embedded servers can be stopped with Shutdown")
+	}
+
+	s.shutdownOnce.Do(func() {
+		if err = s.Exit(ExitArgs{Reason: "embedded server shut down"}, nil); err == nil {
+			err = <-s.done
+		}
+
+		if s.tempStateDir != "" {
+			os.RemoveAll(s.tempStateDir)
+		}
+	})
+
+	return err
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	log "github.com/inconshreveable/log15"
+
+	"github.com/heewa/bento/plugin"
+)
+
+// How many events a listener can fall behind by before they're dropped
+const eventBuffer = 100
+
+// Events gets a channel of events on all services, like the ones sent to
+// notifier plugins, as they happen. A listener that falls too far behind
+// misses events, rather than holding up the server.
+func (s *Server) Events() <-chan plugin.Event {
+	events := make(chan plugin.Event, eventBuffer)
+
+	s.eventListenersLock.Lock()
+	defer s.eventListenersLock.Unlock()
+	s.eventListeners = append(s.eventListeners, events)
+
+	return events
+}
+
+// sendEvent sends an event to all listeners, without blocking.
+func (s *Server) sendEvent(event plugin.Event) {
+	s.eventListenersLock.Lock()
+	defer s.eventListenersLock.Unlock()
+
+	for _, events := range s.eventListeners {
+		select {
+		case events <- event:
+		default:
+			log.Warn("Event listener is falling behind, dropping event", "event", event.Kind, "service", event.Service)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"fmt"
+)
+
+// hooksAPI is what the hooks script can do, through the same calls clients
+// make. A zero escalation interval uses the configured one.
+type hooksAPI struct {
+	s *Server
+}
+
+func (api hooksAPI) Start(name string) error {
+	return api.s.Start(StartArgs{Name: name}, nil)
+}
+
+func (api hooksAPI) Stop(name string) error {
+	return api.s.Stop(StopArgs{Name: name}, nil)
+}
+
+func (api hooksAPI) Restart(name string) error {
+	return api.s.Restart(RestartArgs{Name: name}, nil)
+}
+
+func (api hooksAPI) SetNextEnv(name, key, value string) error {
+	serv := api.s.getService(name)
+	if serv == nil {
+		return fmt.Errorf("Service '%s' not found.", name)
+	}
+
+	serv.SetNextEnv(key, value)
+	return nil
+}
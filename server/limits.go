@@ -0,0 +1,146 @@
+package server
+
+import (
+	"encoding/gob"
+	"fmt"
+	"net"
+	"net/rpc"
+	"reflect"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/heewa/bento/config"
+)
+
+// How long a rejected client has to make the call that gets it the reason
+const rejectTimeout = 1 * time.Second
+
+// connLimiter keeps clients from swamping the server, by capping how many
+// connections are open at once, how fast each user can open new ones, and how
+// fast each user can make calls on them.
+type connLimiter struct {
+	lock sync.Mutex
+	open int
+
+	// Each user's allowance of new connections and calls, by uid
+	connAllowance rateAllowance
+	callAllowance rateAllowance
+}
+
+func newConnLimiter() *connLimiter {
+	return &connLimiter{
+		connAllowance: make(rateAllowance),
+		callAllowance: make(rateAllowance),
+	}
+}
+
+// rateAllowance is how many more of something each user, by uid, can do right
+// now, refilled at a max rate up to a second's worth.
+type rateAllowance map[int]*allowance
+
+type allowance struct {
+	left      float64
+	lastCheck time.Time
+}
+
+// take uses up one of a user's allowance, returning false if there's none
+// left at the given rate a second.
+func (a rateAllowance) take(uid int, rate float64) bool {
+	now := time.Now()
+	userAllowance := a[uid]
+	if userAllowance == nil {
+		userAllowance = &allowance{left: rate}
+		a[uid] = userAllowance
+	} else if userAllowance.left += now.Sub(userAllowance.lastCheck).Seconds() * rate; userAllowance.left > rate {
+		userAllowance.left = rate
+	}
+	userAllowance.lastCheck = now
+
+	if userAllowance.left < 1 {
+		return false
+	}
+	userAllowance.left--
+	return true
+}
+
+// admit checks if a new connection from a user can be served, counting it as
+// open if so, or returns why not. An unknown uid is -1.
+func (l *connLimiter) admit(uid int) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if config.MaxConnections > 0 && l.open >= config.MaxConnections {
+		return fmt.Errorf("Server is busy with %d connections, its max_connections, try again later", l.open)
+	}
+
+	if rate := config.MaxConnectionRate; rate > 0 && !l.connAllowance.take(uid, float64(rate)) {
+		return fmt.Errorf("Too many connections to the server from this user, over its max_connection_rate of %d a second, slow down", rate)
+	}
+
+	l.open++
+	return nil
+}
+
+// admitCall checks if a user can make another call on an admitted
+// connection, or returns why not.
+func (l *connLimiter) admitCall(uid int) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if rate := config.MaxCallRate; rate > 0 && !l.callAllowance.take(uid, float64(rate)) {
+		return fmt.Errorf("Too many calls to the server from this user, over its max_call_rate of %d a second, slow down", rate)
+	}
+
+	return nil
+}
+
+// release marks an admitted connection as closed.
+func (l *connLimiter) release() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.open--
+}
+
+// serveConn serves RPC calls on a connection, knowing who they're from, if
+// it's within limits, otherwise it tells the client why it was rejected.
+func (s *Server) serveConn(conn *net.UnixConn) {
+	uid := peerUID(conn)
+	if err := s.conns.admit(uid); err != nil {
+		log.Warn("Rejecting conn", "uid", uid, "err", err)
+		rejectConn(conn, err)
+		return
+	}
+	defer s.conns.release()
+
+	s.rpcServer.ServeCodec(newCallerCodec(conn, uid, s.conns))
+}
+
+// rejectConn answers the first call on a connection with an error, so the
+// client can say why it was rejected, then closes it.
+func rejectConn(conn net.Conn, reason error) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(rejectTimeout))
+
+	// Like net/rpc's own gob codec, but only reading enough to answer
+	var req rpc.Request
+	dec := gob.NewDecoder(conn)
+	if err := dec.Decode(&req); err != nil {
+		return
+	} else if err := dec.DecodeValue(reflect.Value{}); err != nil {
+		return
+	}
+
+	resp := rpc.Response{
+		ServiceMethod: req.ServiceMethod,
+		Seq:           req.Seq,
+		Error:         reason.Error(),
+	}
+	enc := gob.NewEncoder(conn)
+	if err := enc.Encode(&resp); err != nil {
+		return
+	}
+	enc.Encode(struct{}{})
+}
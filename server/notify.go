@@ -0,0 +1,70 @@
+package server
+
+import (
+	"time"
+
+	"github.com/heewa/bento/plugin"
+	"github.com/heewa/bento/service"
+)
+
+// notify sends events for what changed between two updates on a service to
+// notifier plugins, the hooks script, if there is one, and event listeners. A
+// temp service that asked for it also gets a desktop notification when done.
+func (s *Server) notify(before, after service.Info) {
+	for _, event := range serviceEvents(before, after) {
+		plugin.Notify(event)
+		if s.hooks != nil {
+			s.hooks.Send(event)
+		}
+		s.sendEvent(event)
+
+		if after.NotifyDone && (event.Kind == plugin.EventExited || event.Kind == plugin.EventFailed) {
+			go notifyDone(event)
+		}
+	}
+}
+
+// serviceEvents makes events for what changed between two updates on a
+// service.
+func serviceEvents(before, after service.Info) []plugin.Event {
+	var events []plugin.Event
+
+	event := plugin.Event{
+		Service: after.Name,
+		Pid:     after.Pid,
+		Time:    time.Now(),
+		Temp:    after.Temp,
+
+		Flapping: !after.FlappingSince.IsZero(),
+	}
+
+	// Go by start & end times, since a quick process can start & end between
+	// updates
+	if !after.StartTime.IsZero() && !after.StartTime.Equal(before.StartTime) {
+		event.Kind = plugin.EventStarted
+		events = append(events, event)
+	}
+	if !after.Running && !after.EndTime.IsZero() && !after.EndTime.Equal(before.EndTime) {
+		event.Kind = plugin.EventFailed
+		if after.Succeeded {
+			event.Kind = plugin.EventExited
+		}
+		event.ExitCode = after.ExitCode
+		event.Succeeded = after.Succeeded
+		event.Duration = after.Runtime.Seconds()
+		events = append(events, event)
+	}
+
+	if after.Running && before.Running && after.Health != before.Health && after.Health != service.HealthUnknown {
+		event.Kind = plugin.EventHealth
+		event.Health = string(after.Health)
+		events = append(events, event)
+	}
+
+	if before.FlappingSince.IsZero() && !after.FlappingSince.IsZero() {
+		event.Kind = plugin.EventFlapping
+		events = append(events, event)
+	}
+
+	return events
+}
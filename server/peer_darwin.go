@@ -0,0 +1,29 @@
+//go:build darwin
+// +build darwin
+
+package server
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// peerUID gets the uid of the process on the other end of a connection, or
+// -1 if it can't.
+func peerUID(conn *net.UnixConn) int {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return -1
+	}
+
+	uid := -1
+	raw.Control(func(fd uintptr) {
+		cred, err := unix.GetsockoptXucred(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+		if err == nil {
+			uid = int(cred.Uid)
+		}
+	})
+
+	return uid
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/heewa/bento/config"
+	"github.com/heewa/bento/service"
+)
+
+// assignPort gives a service the port it's configured with, before it starts,
+// making sure no other running service has it. Auto ports stick with a
+// service across restarts, as long as no one else takes them.
+func (s *Server) assignPort(srvc *service.Service) error {
+	if srvc.Conf.Port == "" {
+		srvc.SetPort(0)
+		return nil
+	}
+
+	s.portsLock.Lock()
+	defer s.portsLock.Unlock()
+
+	port := srvc.Port()
+	if srvc.Conf.Port == config.PortAuto {
+		if port != 0 && s.ports[port] == srvc.Conf.Name {
+			return nil
+		}
+
+		var err error
+		if port, err = s.findFreePort(); err != nil {
+			return fmt.Errorf("Failed to find a free port for service '%s': %v", srvc.Conf.Name, err)
+		}
+	} else {
+		port, _ = strconv.Atoi(srvc.Conf.Port)
+
+		if owner := s.ports[port]; owner != "" && owner != srvc.Conf.Name {
+			if other := s.getService(owner); other != nil && other.Running() && other.Port() == port {
+				return fmt.Errorf("Port %d is already used by service '%s'", port, owner)
+			}
+		}
+	}
+
+	// Give up the service's old port, if it changed
+	if old := srvc.Port(); old != port && s.ports[old] == srvc.Conf.Name {
+		delete(s.ports, old)
+	}
+
+	log.Debug("Assigning port to service", "service", srvc.Conf.Name, "port", port)
+	s.ports[port] = srvc.Conf.Name
+	srvc.SetPort(port)
+
+	return nil
+}
+
+// releasePort frees up a service's port, like when it's removed
+func (s *Server) releasePort(srvc *service.Service) {
+	s.portsLock.Lock()
+	defer s.portsLock.Unlock()
+
+	if port := srvc.Port(); port != 0 && s.ports[port] == srvc.Conf.Name {
+		delete(s.ports, port)
+	}
+}
+
+// findFreePort asks the OS for a free port, that's not already assigned to a
+// service. Must be called with the ports lock held.
+func (s *Server) findFreePort() (int, error) {
+	for tries := 0; tries < 10; tries++ {
+		listener, err := net.Listen("tcp", ":0")
+		if err != nil {
+			return 0, err
+		}
+		port := listener.Addr().(*net.TCPAddr).Port
+		listener.Close()
+
+		if s.ports[port] == "" {
+			return port, nil
+		}
+	}
+
+	return 0, fmt.Errorf("Only found ports already assigned to services")
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// How long progress of a finished call is kept around for a client to
+	// finish reading it
+	progressRetention = 1 * time.Minute
+
+	// How long a client waiting on progress is held before being told there
+	// isn't any yet
+	progressWait = 10 * time.Second
+)
+
+// progress collects events from a long call, like a load, for clients to
+// follow along with separate calls
+type progress struct {
+	lock   sync.Mutex
+	events []interface{}
+	done   bool
+
+	// Closed & replaced when there are new events, or the call is done
+	changed chan interface{}
+}
+
+func newProgress() *progress {
+	return &progress{
+		changed: make(chan interface{}),
+	}
+}
+
+func (p *progress) add(event interface{}) {
+	if p == nil {
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.events = append(p.events, event)
+	close(p.changed)
+	p.changed = make(chan interface{})
+}
+
+func (p *progress) finish() {
+	if p == nil {
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.done = true
+	close(p.changed)
+	p.changed = make(chan interface{})
+}
+
+// get gets events from an index, the index after them, and a channel that'll
+// be closed when there are more
+func (p *progress) get(index int) ([]interface{}, int, bool, <-chan interface{}) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if index < 0 || index > len(p.events) {
+		index = len(p.events)
+	}
+
+	events := make([]interface{}, len(p.events)-index)
+	copy(events, p.events[index:])
+
+	return events, len(p.events), p.done, p.changed
+}
+
+// wait is like get, but waits a bit for new events if there aren't any yet
+func (p *progress) wait(index int) ([]interface{}, int, bool) {
+	events, next, done, changed := p.get(index)
+	if len(events) == 0 && !done {
+		select {
+		case <-changed:
+			events, next, done, _ = p.get(index)
+		case <-time.After(progressWait):
+		}
+	}
+
+	return events, next, done
+}
+
+// trackProgress starts tracking progress for a call under an ID, and returns
+// a fn to call when it's done. An empty ID means no one's following along.
+func (s *Server) trackProgress(id string) (*progress, func()) {
+	if id == "" {
+		return nil, func() {}
+	}
+
+	p := newProgress()
+
+	s.progressLock.Lock()
+	defer s.progressLock.Unlock()
+
+	s.progress[id] = p
+
+	return p, func() {
+		p.finish()
+
+		// Give the client a chance to get the last events before forgetting
+		// about it
+		time.AfterFunc(progressRetention, func() {
+			s.progressLock.Lock()
+			defer s.progressLock.Unlock()
+
+			if s.progress[id] == p {
+				delete(s.progress, id)
+			}
+		})
+	}
+}
+
+// getProgress gets progress being tracked under an ID, or nil
+func (s *Server) getProgress(id string) *progress {
+	s.progressLock.Lock()
+	defer s.progressLock.Unlock()
+
+	return s.progress[id]
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Clients and servers of different versions talk to each other, so RPC
+// methods follow a few rules to stay compatible without upgrading both at
+// once:
+//
+//   - Fields can be added to args & response structs, but never removed,
+//     renamed, or given a different type. Encoding skips fields the other
+//     side doesn't know about, and leaves ones it didn't send as zero values,
+//     so a new field's zero value has to mean the old behavior.
+//   - Methods aren't removed, and their args & response types aren't swapped
+//     for others. A change that can't be made by adding fields goes in a new
+//     method named with a version suffix, like Server.TailV2, next to the old
+//     one.
+//   - Clients ask the server which methods it has (see Version), and check
+//     for a versioned one before using it, falling back to older ones.
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// rpcMethods lists the methods on Server that net/rpc exposes, which are
+// exported ones that take args and a reply pointer, and return an error.
+func rpcMethods() []string {
+	serverType := reflect.TypeOf(&Server{})
+
+	var methods []string
+	for i := 0; i < serverType.NumMethod(); i++ {
+		method := serverType.Method(i)
+		mtype := method.Type
+		if mtype.NumIn() != 3 || mtype.NumOut() != 1 {
+			continue
+		}
+		if mtype.In(2).Kind() != reflect.Ptr || mtype.Out(0) != errorType {
+			continue
+		}
+		methods = append(methods, "Server."+method.Name)
+	}
+	sort.Strings(methods)
+
+	return methods
+}
+
+// MethodVersion splits a method name into its base name and version, like
+// "Server.TailV2" into "Server.Tail" and 2. Methods without a version
+// suffix are version 1.
+func MethodVersion(method string) (string, int) {
+	index := strings.LastIndex(method, "V")
+	if index <= strings.LastIndex(method, ".")+1 || index == len(method)-1 {
+		return method, 1
+	}
+
+	version, err := strconv.Atoi(method[index+1:])
+	if err != nil || version < 2 || method[index+1] == '0' {
+		return method, 1
+	}
+
+	return method[:index], version
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"time"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/heewa/bento/config"
+)
+
+// runRestartSchedules restarts running services when their restart-schedule
+// says to, checking at the start of every minute, until cancelled.
+func (s *Server) runRestartSchedules(cancel <-chan interface{}) {
+	for {
+		now := time.Now()
+		minute := now.Truncate(time.Minute).Add(time.Minute)
+
+		select {
+		case <-cancel:
+			return
+		case <-time.After(minute.Sub(now)):
+		}
+
+		for _, srvc := range s.listServices() {
+			if srvc.Conf.RestartSchedule == "" || !srvc.Running() {
+				continue
+			}
+
+			schedule, err := config.ParseCronSchedule(srvc.Conf.RestartSchedule)
+			if err != nil || !schedule.Matches(minute) {
+				continue
+			}
+
+			if srvc.InMaintenance() {
+				log.Info("Skipping scheduled restart of service in maintenance", "service", srvc.Conf.Name)
+				continue
+			}
+
+			go func(name string) {
+				log.Info("Restarting service on its schedule", "service", name)
+				if err := s.Restart(RestartArgs{Name: name}, nil); err != nil {
+					log.Warn("Failed scheduled restart of service", "service", name, "err", err)
+				}
+			}(srvc.Conf.Name)
+		}
+	}
+}
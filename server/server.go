@@ -6,28 +6,67 @@ import (
 	"net/rpc"
 	"os"
 	"os/signal"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	log "github.com/inconshreveable/log15"
 
 	"github.com/heewa/bento/config"
+	"github.com/heewa/bento/hooks"
+	"github.com/heewa/bento/plugin"
 	"github.com/heewa/bento/service"
 )
 
 const (
 	minRestartPause = 500 * time.Millisecond
 	maxRestartPause = 1 * time.Minute
+
+	// How often info on running services is sent to listeners, to keep
+	// things like run times fresh
+	periodicUpdateInterval = 3 * time.Second
+
+	// How often running services' health is checked
+	healthCheckInterval = 10 * time.Second
 )
 
 // Server is the backend that manages services
 type Server struct {
-	fifoAddr *net.UnixAddr
+	fifoAddr       *net.UnixAddr
+	abstractSocket bool
+
+	// Each server has its own, so embedded ones don't collide
+	rpcServer *rpc.Server
+
+	// Embedded servers leave bento's own state alone, see NewEmbedded
+	embedded bool
 
+	// The services file to load, or "" if there isn't one yet, and where one
+	// would go
+	serviceFile     string
+	serviceFilePath string
+
+	// Where snapshots are saved, and services' watchdog sockets go
+	snapshotDir     string
+	notifySocketDir string
+
+	// A temp dir made for an embedded server's state, removed on Shutdown
+	tempStateDir string
+
+	// The services map lock is only held for map access, never while
+	// working on a service. Changes to a service's lifecycle, like starting,
+	// stopping, or removing it, are serialized per service with
+	// lockService(), so a slow stop doesn't block other services.
 	services     map[string]*service.Service
 	servicesLock sync.RWMutex
 
+	lifecycleLocksLock sync.Mutex
+	lifecycleLocks     map[string]*lifecycleLock
+
 	serviceUpdates chan<- service.Info
 
 	// watchedServices is a collection of restart-watched services as a map
@@ -35,50 +74,134 @@ type Server struct {
 	watchLock       sync.RWMutex
 	watchedServices map[string]chan interface{}
 
+	// Ports assigned to services, to the service's name
+	portsLock sync.Mutex
+	ports     map[int]string
+
+	// Progress of ongoing, or recently finished, calls like LoadServices,
+	// by ID
+	progressLock sync.Mutex
+	progress     map[string]*progress
+
+	// Auto-starts waiting for a slot
+	starts startQueue
+
+	// Non-zero while draining, when nothing new is started
+	draining uint32
+
+	// Runs the hooks script on service events, if one is configured
+	hooks *hooks.Runner
+
+	// Listeners on service events, from Events()
+	eventListenersLock sync.Mutex
+	eventListeners     []chan plugin.Event
+
+	// Signals that services were added, removed, or changed, so the
+	// autocomplete cache needs rewriting
+	autocompleteStale chan interface{}
+
 	stop chan interface{}
+
+	// Gets what Init returned, for embedded servers run with Serve
+	done         chan error
+	shutdownOnce sync.Once
+
+	// Limits on client connections
+	conns *connLimiter
+
+	// Stats about the server itself
+	startTime   time.Time
+	connsServed uint64
 }
 
 // New creates a new Server
 func New() (*Server, <-chan service.Info, error) {
 	// Catch obvious address errors early
-	addr, err := net.ResolveUnixAddr("unix", config.FifoPath)
+	addr, err := net.ResolveUnixAddr("unix", config.SocketAddress())
 	if err != nil {
 		return nil, nil, err
 	}
 
+	serv, updatesOut := newServer(addr, config.HooksScript)
+	serv.abstractSocket = config.AbstractSocket
+	serv.serviceFile = config.ServiceConfigFile
+	serv.serviceFilePath = config.ServiceConfigPath
+
+	return serv, updatesOut, nil
+}
+
+// newServer creates a Server listening on addr, with a hooks script, if
+// there is one.
+func newServer(addr *net.UnixAddr, hooksScript string) (*Server, <-chan service.Info) {
 	// Make the stop channel with a buffer because the goroutine that reads
 	// from it might be blocked on listening for RPC connections, which the
 	// same entity that's stopping will need to break it out of
 	stop := make(chan interface{}, 1)
 
 	serv := &Server{
-		fifoAddr: addr,
+		fifoAddr:  addr,
+		rpcServer: rpc.NewServer(),
 
 		services:        make(map[string]*service.Service),
+		lifecycleLocks:  make(map[string]*lifecycleLock),
 		watchedServices: make(map[string]chan interface{}),
+		progress:        make(map[string]*progress),
+		ports:           make(map[int]string),
+
+		snapshotDir:     config.SnapshotDir,
+		notifySocketDir: config.NotifySocketDir,
+
+		autocompleteStale: make(chan interface{}, 1),
+		conns:             newConnLimiter(),
 
 		stop: stop,
+
+		startTime: time.Now(),
+	}
+
+	// Load hooks before watching services, so no events are missed. A broken
+	// script shouldn't keep services from running, so just log it.
+	if hooksScript != "" {
+		runner, err := hooks.Load(hooksScript, hooksAPI{serv})
+		if err != nil {
+			log.Error("Failed to load hooks script, running without it", "script", hooksScript, "err", err)
+		} else {
+			log.Info("Loaded hooks script", "script", hooksScript)
+			serv.hooks = runner
+		}
 	}
 
 	// Communicate with UI about service changes through a channel
 	var updatesOut <-chan service.Info
 	serv.serviceUpdates, updatesOut = serv.watchServices()
 
-	return serv, updatesOut, nil
+	return serv, updatesOut
 }
 
 // Init runs the server, listening for RPC calls, blocking until exit
 func (s *Server) Init(_ bool, _ *bool) error {
-	log.Debug("Registering RPC interface")
-	if err := rpc.Register(s); err != nil {
+	listener, err := s.listen()
+	if err != nil {
 		return err
 	}
 
-	log.Info("Listening on fifo", "address", s.fifoAddr)
-	listener, err := s.openFifo()
-	if err != nil {
-		return err
+	return s.serve(listener)
+}
+
+// listen registers the RPC interface and opens the fifo, so clients can
+// connect once it returns.
+func (s *Server) listen() (*net.UnixListener, error) {
+	log.Debug("Registering RPC interface")
+	if err := s.rpcServer.Register(s); err != nil {
+		return nil, err
 	}
+
+	log.Info("Listening on fifo", "address", s.fifoAddr)
+	return s.openFifo()
+}
+
+// serve accepts RPC calls on a listener, blocking until exit.
+func (s *Server) serve(listener *net.UnixListener) error {
 	defer func() {
 		if err := listener.Close(); err != nil {
 			log.Error("Failed to close listener", "err", err)
@@ -94,24 +217,31 @@ func (s *Server) Init(_ bool, _ *bool) error {
 		return err
 	}
 
-	// Handle interrupt & kill signal, to try to clean up
-	go func() {
-		signals := make(chan os.Signal, 1)
-		signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGKILL)
-		defer signal.Stop(signals)
+	cancelUpdates := make(chan interface{})
+	go s.sendPeriodicUpdates(cancelUpdates)
 
-		for {
-			sig := <-signals
-			log.Info("Got interrupt/kill signal", "signal", sig)
+	cancelSchedules := make(chan interface{})
+	go s.runRestartSchedules(cancelSchedules)
 
-			var nothing bool
-			if err := s.Exit(nothing, &nothing); err != nil {
-				log.Error("Failed to exit", "err", err)
-			} else {
-				return
-			}
-		}
-	}()
+	cancelUsage := make(chan interface{})
+	go s.sampleUsage(cancelUsage)
+
+	cancelExpire := make(chan interface{})
+	go s.expireOutput(cancelExpire)
+
+	cancelHealth := make(chan interface{})
+	go s.checkHealth(cancelHealth)
+
+	cancelWatchdogs := make(chan interface{})
+	go s.checkWatchdogs(cancelWatchdogs)
+
+	// Embedded servers don't write bento's autocomplete cache, or take over
+	// the program's signals
+	cancelAutocomplete := make(chan interface{})
+	if !s.embedded {
+		go s.writeAutocompleteCache(cancelAutocomplete)
+		go s.handleSignals()
+	}
 
 	done := false
 	for !done {
@@ -125,19 +255,142 @@ func (s *Server) Init(_ bool, _ *bool) error {
 				log.Warn("Failed to accept conn", "err", err)
 			} else {
 				log.Debug("Accepted a conn", "address", conn.RemoteAddr().String())
-				go rpc.ServeConn(conn)
+				atomic.AddUint64(&s.connsServed, 1)
+				go s.serveConn(conn)
 			}
 		}
 	}
 
 	close(cancelHeartbeat)
+	close(cancelUpdates)
+	close(cancelSchedules)
+	close(cancelUsage)
+	close(cancelExpire)
+	close(cancelHealth)
+	close(cancelWatchdogs)
+	close(cancelAutocomplete)
+
+	// Stop hooks first, so the script doesn't react to services stopping
+	if s.hooks != nil {
+		s.hooks.Close()
+	}
+
+	s.stopAll()
+
+	log.Info("All done")
+
+	return nil
+}
+
+// handleSignals handles interrupt & kill signals, to try to clean up. Hangup
+// is the conventional "reload your config" signal, so it's treated like a
+// reload, and SIGUSR1 dumps state for debugging a wedged server.
+func (s *Server) handleSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGKILL, syscall.SIGHUP, syscall.SIGUSR1)
+	defer signal.Stop(signals)
+
+	for {
+		sig := <-signals
+		if sig == syscall.SIGHUP {
+			log.Info("Got hangup signal, reloading services", "signal", sig)
+			s.reloadServiceFile()
+			continue
+		} else if sig == syscall.SIGUSR1 {
+			// Separately, so a dump stuck on a wedged lock doesn't keep
+			// other signals from being handled
+			log.Info("Got user signal, dumping state", "signal", sig)
+			go s.dumpState()
+			continue
+		}
+
+		log.Info("Got interrupt/kill signal", "signal", sig)
+
+		var nothing bool
+		if err := s.Exit(ExitArgs{Reason: "signal " + sig.String()}, &nothing); err != nil {
+			log.Error("Failed to exit", "err", err)
+		} else {
+			return
+		}
+	}
+}
+
+// stopAll stops all running services, for shutting down. Services that start
+// first, with a higher start-priority, are stopped last, so things like
+// databases aren't stopped while services using them are still flushing to
+// them. Services with the same priority are stopped concurrently. Any still
+// running after the shutdown timeout are killed.
+func (s *Server) stopAll() {
+	byPriority := make(map[int][]*service.Service)
+	var priorities []int
 
-	// Stop all services
 	var wait sync.WaitGroup
-	for _, srvc := range s.services {
+	for _, srvc := range s.listServices() {
 		srvc := srvc
 
 		if srvc.Running() {
+			priority := srvc.Conf.StartPriority
+			if _, ok := byPriority[priority]; !ok {
+				priorities = append(priorities, priority)
+			}
+			byPriority[priority] = append(byPriority[priority], srvc)
+		} else if srvc.Conf.RestartOnExit {
+			// Remove from restart-watch in case it's not running cuz it died
+			wait.Add(1)
+			go func() {
+				defer wait.Done()
+
+				s.removeServiceFromRestartWatch(srvc.Conf.Name)
+			}()
+		}
+	}
+	wait.Wait()
+
+	sort.Ints(priorities)
+
+	stopped := make(chan interface{})
+	go func() {
+		defer close(stopped)
+		s.stopInOrder(priorities, byPriority)
+	}()
+
+	var timeout <-chan time.Time
+	if config.ShutdownTimeout > 0 {
+		timeout = time.After(config.ShutdownTimeout)
+	}
+
+	select {
+	case <-stopped:
+		return
+	case <-timeout:
+	}
+
+	var killed []string
+	for _, srvc := range s.listServices() {
+		if !srvc.Running() {
+			continue
+		}
+
+		if err := srvc.Kill(); err != nil {
+			log.Error("Failed to force-kill service", "service", srvc.Conf.Name, "err", err)
+		} else {
+			killed = append(killed, srvc.Conf.Name)
+		}
+	}
+	sort.Strings(killed)
+
+	log.Error("Services didn't stop before the shutdown timeout, force-killed them", "timeout", config.ShutdownTimeout, "services", strings.Join(killed, ", "))
+}
+
+// stopInOrder stops services a priority at a time, in the given order.
+func (s *Server) stopInOrder(priorities []int, byPriority map[int][]*service.Service) {
+	var wait sync.WaitGroup
+	for _, priority := range priorities {
+		log.Debug("Stopping services", "start-priority", priority, "num", len(byPriority[priority]))
+
+		for _, srvc := range byPriority[priority] {
+			srvc := srvc
+
 			wait.Add(1)
 			go func() {
 				defer wait.Done()
@@ -145,30 +398,52 @@ func (s *Server) Init(_ bool, _ *bool) error {
 				// Shut down with a shorter escalation interval, cuz we might
 				// not have time to wait that long (like computer might be
 				// shutting down, or user logging out, or user gets impatient
-				// and sends kill signal to bento).
+				// and sends kill signal to bento). Unless the service says how
+				// long it needs.
 				args := StopArgs{
 					Name:               srvc.Conf.Name,
-					EscalationInterval: 3 * time.Second,
+					EscalationInterval: srvc.Conf.StopTimeout,
+				}
+				if args.EscalationInterval == 0 {
+					args.EscalationInterval = 3 * time.Second
 				}
 				if err := s.Stop(args, nil); err != nil {
 					log.Warn("Failed to stop service during shutdown", "service", srvc.Conf.Name, "err", err)
 				}
 			}()
-		} else if srvc.Conf.RestartOnExit {
-			// Remove from restart-watch in case it's not running cuz it died
-			wait.Add(1)
-			go func() {
-				defer wait.Done()
-
-				s.removeServiceFromRestartWatch(srvc.Conf.Name)
-			}()
 		}
+		wait.Wait()
 	}
-	wait.Wait()
+}
 
-	log.Info("All done")
+// reloadServiceFile reloads the services conf file, like a client's reload
+// command would, but just logs results since there's no one to reply to.
+func (s *Server) reloadServiceFile() {
+	if s.serviceFile == "" {
+		log.Warn("No services config file to reload")
+		return
+	}
 
-	return nil
+	args := LoadServicesArgs{
+		ServiceFilePath: s.serviceFile,
+	}
+	reply := LoadServicesResponse{}
+	if err := s.LoadServices(args, &reply); err != nil {
+		log.Error("Failed to reload services", "file", s.serviceFile, "err", err)
+		return
+	}
+
+	log.Info(
+		"Reloaded services",
+		"new", len(reply.NewServices),
+		"updated", len(reply.UpdatedServices),
+		"deprecated", len(reply.DeprecatedServices),
+		"removed", len(reply.RemovedServices),
+		"failed", len(reply.Failed))
+
+	for _, failure := range reply.Failed {
+		log.Error("Failed to load service", "service", failure.Name, "err", failure.Err)
+	}
 }
 
 func (s *Server) getService(name string) *service.Service {
@@ -194,6 +469,9 @@ func (s *Server) listServices() []*service.Service {
 
 func (s *Server) addService(serv *service.Service, replace bool) error {
 	err := func() error {
+		unlock := s.lockService(serv.Conf.Name)
+		defer unlock()
+
 		s.servicesLock.Lock()
 		defer s.servicesLock.Unlock()
 
@@ -205,9 +483,12 @@ func (s *Server) addService(serv *service.Service, replace bool) error {
 		}
 
 		s.services[serv.Conf.Name] = serv
+		serv.SetNotifySocketDir(s.notifySocketDir)
 
-		// Notify watchers
-		s.serviceUpdates <- serv.Info()
+		if current != nil {
+			s.releasePort(current)
+			current.Release()
+		}
 
 		return nil
 	}()
@@ -215,39 +496,89 @@ func (s *Server) addService(serv *service.Service, replace bool) error {
 		return err
 	}
 
-	if serv.Conf.AutoStart {
-		// Don't fail an add if the service failed to start, but do warn.
-		if err := s.Start(StartArgs{serv.Conf.Name}, nil); err != nil {
-			log.Warn("Failed to auto-start service", "service", serv.Conf.Name, "err", err)
-		}
+	// Notify watchers
+	s.serviceUpdates <- serv.Info()
+
+	if serv.Conf.ShouldAutoStart(config.Profile) {
+		s.queueAutoStart(serv.Conf.Name, serv.Conf.StartPriority)
 	}
 
 	return nil
 }
 
 func (s *Server) removeService(name string) error {
-	s.servicesLock.Lock()
-	defer s.servicesLock.Unlock()
+	unlock := s.lockService(name)
+	defer unlock()
 
-	srvc := s.services[name]
+	srvc := s.getService(name)
 	if srvc == nil {
 		return nil
 	}
 
-	if err := srvc.Stop(0); err != nil {
+	// Stop without holding the services lock, since it can take a while
+	if err := srvc.Stop(0, nil); err != nil {
 		return err
 	}
 
-	delete(s.services, name)
+	func() {
+		s.servicesLock.Lock()
+		defer s.servicesLock.Unlock()
+
+		delete(s.services, name)
+	}()
 
 	// Notify watchers
 	info := srvc.Info()
 	info.Dead = true
 	s.serviceUpdates <- info
 
+	s.releasePort(srvc)
+	srvc.Release()
+
 	return nil
 }
 
+// lifecycleLock is a per-service lock, that's removed from the server when
+// no one is using it, so temp services don't leave them behind.
+type lifecycleLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lockService locks a service's lifecycle, so changes to it, like starting,
+// stopping, or removing it, are serialized without blocking other services.
+// It returns a fn to unlock it. It's fine to lock a name that doesn't have a
+// service.
+func (s *Server) lockService(name string) (unlock func()) {
+	lock := func() *lifecycleLock {
+		s.lifecycleLocksLock.Lock()
+		defer s.lifecycleLocksLock.Unlock()
+
+		lock := s.lifecycleLocks[name]
+		if lock == nil {
+			lock = &lifecycleLock{}
+			s.lifecycleLocks[name] = lock
+		}
+		lock.refs++
+
+		return lock
+	}()
+
+	lock.Lock()
+
+	return func() {
+		lock.Unlock()
+
+		s.lifecycleLocksLock.Lock()
+		defer s.lifecycleLocksLock.Unlock()
+
+		lock.refs--
+		if lock.refs == 0 {
+			delete(s.lifecycleLocks, name)
+		}
+	}
+}
+
 func (s *Server) changeServicePermanence(name string, temp bool, cleanAfter time.Duration) bool {
 	s.servicesLock.Lock()
 	defer s.servicesLock.Unlock()
@@ -257,13 +588,13 @@ func (s *Server) changeServicePermanence(name string, temp bool, cleanAfter time
 		return false
 	}
 
-	if temp {
-		srvc.Conf.Temp = true
-		srvc.Conf.CleanAfter = cleanAfter
-	} else {
-		srvc.Conf.Temp = false
-		srvc.Conf.CleanAfter = 0
-	}
+	srvc.UpdateConf(func(conf *config.Service) {
+		conf.Temp = temp
+		conf.CleanAfter = 0
+		if temp {
+			conf.CleanAfter = cleanAfter
+		}
+	})
 
 	return true
 }
@@ -311,7 +642,31 @@ func (s *Server) addServiceToRestartWatch(srvc *service.Service) {
 						pauseTime = maxRestartPause
 					}
 
-					if err := srvc.Start(s.serviceUpdates); err != nil {
+					restart := false
+					if s.isDraining() {
+						log.Info("Not restarting service while draining", "service", srvc.Conf.Name)
+					} else if srvc.InMaintenance() {
+						log.Debug("Not restarting service in maintenance", "service", srvc.Conf.Name)
+					} else if !srvc.Conf.InRestartWindow(time.Now()) {
+						log.Debug("Not restarting service outside its restart-window", "service", srvc.Conf.Name, "window", srvc.Conf.RestartWindow)
+					} else {
+						restart = true
+					}
+
+					if !restart {
+						continue
+					}
+
+					// Only warn once when it starts flapping, not on every
+					// restart after
+					if srvc.RecordRestart() {
+						log.Warn("Service is flapping, restarting too often", "service", srvc.Conf.Name, "restarts", config.FlappingRestarts, "window", config.FlappingWindow)
+					}
+
+					if gone, err := s.restartWatched(srvc, cancel); gone {
+						log.Debug("Not restarting service that was stopped, removed, or replaced", "service", srvc.Conf.Name)
+						return
+					} else if err != nil {
 						log.Warn("Failed to restart service", "service", srvc.Conf.Name, "pause-before-next-restart", pauseTime, "err", err)
 					} else {
 						log.Debug("Restarted service", "service", srvc.Conf.Name)
@@ -323,6 +678,34 @@ func (s *Server) addServiceToRestartWatch(srvc *service.Service) {
 	}()
 }
 
+// restartWatched starts a watched service again after it exited, like Start
+// does, holding its lifecycle lock and assigning its port. It's gone if the
+// service was stopped, removed, or replaced meanwhile, so shouldn't be watched
+// anymore.
+func (s *Server) restartWatched(srvc *service.Service, cancel <-chan interface{}) (gone bool, err error) {
+	unlock := s.lockService(srvc.Conf.Name)
+	defer unlock()
+
+	select {
+	case <-cancel:
+		return true, nil
+	default:
+	}
+
+	if s.getService(srvc.Conf.Name) != srvc {
+		return true, nil
+	} else if srvc.Running() {
+		// Started by someone else while waiting on the lock
+		return false, nil
+	}
+
+	if err := s.assignPort(srvc); err != nil {
+		return false, err
+	}
+
+	return false, srvc.Start(s.serviceUpdates)
+}
+
 func (s *Server) removeServiceFromRestartWatch(name string) {
 	log.Debug("Removing service from restart-watch list", "service", name)
 
@@ -343,23 +726,36 @@ func (s *Server) watchServices() (chan<- service.Info, <-chan service.Info) {
 	// or some shit.
 
 	updatesIn := make(chan service.Info)
-	updatesOut := make(chan service.Info, 100)
+	toUI := make(chan service.Info)
+	updatesOut := coalesceUpdates(toUI)
 
 	go func() {
 		// Clean up channels
 		defer func() {
-			close(updatesOut)
+			close(toUI)
 		}()
 
 		deathWatcherCancels := make(map[string]chan interface{})
 
+		// Last update on each service, to tell notifiers & hooks what changed
+		lastInfo := make(map[string]service.Info)
+
 		for {
 			info := <-updatesIn
 
-			// Drop if UI isn't keeping up
-			select {
-			case updatesOut <- info:
-			default:
+			// Doesn't block, since updates are coalesced if UI isn't
+			// keeping up
+			toUI <- info
+
+			last, known := lastInfo[info.Name]
+			s.notify(last, info)
+			if info.Dead || !known || !reflect.DeepEqual(last.Service, info.Service) {
+				s.markAutocompleteStale()
+			}
+			if info.Dead {
+				delete(lastInfo, info.Name)
+			} else {
+				lastInfo[info.Name] = info
 			}
 
 			// Temp services need to be cleaned up after a timeout after ending
@@ -396,7 +792,165 @@ func (s *Server) watchServices() (chan<- service.Info, <-chan service.Info) {
 	return updatesIn, updatesOut
 }
 
+// sendPeriodicUpdates sends info about all running services to listeners,
+// every so often, until cancelled. One loop for all services keeps it cheap.
+func (s *Server) sendPeriodicUpdates(cancel <-chan interface{}) {
+	ticker := time.NewTicker(periodicUpdateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cancel:
+			return
+		case <-ticker.C:
+			for _, srvc := range s.listServices() {
+				if srvc.Running() {
+					s.serviceUpdates <- srvc.Info()
+				}
+			}
+		}
+	}
+}
+
+// sampleUsage periodically records the resource usage of running services,
+// until cancelled.
+func (s *Server) sampleUsage(cancel <-chan interface{}) {
+	ticker := time.NewTicker(service.UsageInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cancel:
+			return
+		case <-ticker.C:
+			for _, srvc := range s.listServices() {
+				if err := srvc.SampleUsage(); err != nil {
+					log.Debug("Failed to sample resource usage", "service", srvc.Conf.Name, "err", err)
+				}
+			}
+		}
+	}
+}
+
+// expireOutput periodically drops output older than services' retention, for
+// ones that are too quiet for new lines to do it, until cancelled.
+func (s *Server) expireOutput(cancel <-chan interface{}) {
+	ticker := time.NewTicker(service.OutputExpireInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cancel:
+			return
+		case <-ticker.C:
+			for _, srvc := range s.listServices() {
+				srvc.Output.Expire()
+			}
+		}
+	}
+}
+
+// markAutocompleteStale has the autocomplete cache rewritten, without
+// blocking.
+func (s *Server) markAutocompleteStale() {
+	select {
+	case s.autocompleteStale <- nil:
+	default:
+		// Already going to be rewritten
+	}
+}
+
+// writeAutocompleteCache rewrites the autocomplete cache whenever services
+// change, until cancelled.
+func (s *Server) writeAutocompleteCache(cancel <-chan interface{}) {
+	for {
+		select {
+		case <-cancel:
+			return
+		case <-s.autocompleteStale:
+			var confs []config.Service
+			for _, srvc := range s.listServices() {
+				confs = append(confs, srvc.Conf)
+			}
+
+			if err := config.WriteAutocompleteCache(confs); err != nil {
+				log.Warn("Failed to write autocomplete cache", "err", err)
+			}
+		}
+	}
+}
+
+// checkHealth periodically checks the health of running services, sending
+// updates for ones that changed, until cancelled.
+func (s *Server) checkHealth(cancel <-chan interface{}) {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cancel:
+			return
+		case <-ticker.C:
+			for _, srvc := range s.listServices() {
+				before := srvc.Info().Health
+				if srvc.CheckHealth() != before {
+					s.serviceUpdates <- srvc.Info()
+				}
+			}
+		}
+	}
+}
+
+// coalesceUpdates forwards service updates, but while the receiver is busy,
+// only keeps the latest update for each service. That way a slow receiver
+// catches up on current state, instead of working through stale updates, or
+// dropping some. Receiving from the returned channel is always quick.
+func coalesceUpdates(in <-chan service.Info) <-chan service.Info {
+	out := make(chan service.Info)
+
+	go func() {
+		defer close(out)
+
+		// Pending updates, and the order they should go out in
+		pending := make(map[string]service.Info)
+		var order []string
+
+		for {
+			// Only try to send if there's something to send
+			var sendChan chan<- service.Info
+			var next service.Info
+			if len(order) > 0 {
+				sendChan = out
+				next = pending[order[0]]
+			}
+
+			select {
+			case info, ok := <-in:
+				if !ok {
+					return
+				}
+
+				if _, ok := pending[info.Name]; !ok {
+					order = append(order, info.Name)
+				}
+				pending[info.Name] = info
+			case sendChan <- next:
+				delete(pending, order[0])
+				order = order[1:]
+			}
+		}
+	}()
+
+	return out
+}
+
 func (s *Server) openFifo() (*net.UnixListener, error) {
+	// Abstract sockets can't be left behind by a crashed server, so there's
+	// nothing to clean up. If another server is using it, listen will fail.
+	if s.abstractSocket {
+		return net.ListenUnix("unix", s.fifoAddr)
+	}
+
 	// Check the mod time on the fifo file. If it's pretty old, delete it
 	// so we can use that address. Fifo's can become dead like this if
 	// the server hard-crashed, or it was killed with SIGKILL.
@@ -425,6 +979,11 @@ func (s *Server) openFifo() (*net.UnixListener, error) {
 func (s *Server) startHeartbeat() (chan<- interface{}, error) {
 	cancel := make(chan interface{})
 
+	// Nothing to touch for an abstract socket
+	if s.abstractSocket {
+		return cancel, nil
+	}
+
 	go func() {
 		for {
 			select {
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+
+	"github.com/heewa/bento/config"
+)
+
+// startQueue holds auto-starts waiting for a free slot, so only so many
+// services are starting up at once.
+type startQueue struct {
+	lock sync.Mutex
+
+	// Names of services waiting to start, in the order they should start
+	pending []queuedStart
+
+	// Number of starts in progress, that haven't become ready yet
+	starting int
+
+	// While held, nothing is started, so a batch of services can be queued
+	// before picking which goes first
+	holds int
+}
+
+// startSlotTimeout is the longest an auto-start holds its slot, waiting for
+// its service to be ready, before the slot's given to the next one.
+const startSlotTimeout = 1 * time.Minute
+
+type queuedStart struct {
+	name     string
+	priority int
+}
+
+// queueAutoStart queues a service to be auto-started once there's a free
+// slot, ahead of queued services with a lower start-priority.
+func (s *Server) queueAutoStart(name string, priority int) {
+	s.starts.lock.Lock()
+
+	// Keep it sorted, with higher priorities first, otherwise in the order
+	// they were queued.
+	index := sort.Search(len(s.starts.pending), func(i int) bool {
+		return s.starts.pending[i].priority < priority
+	})
+	s.starts.pending = append(s.starts.pending, queuedStart{})
+	copy(s.starts.pending[index+1:], s.starts.pending[index:])
+	s.starts.pending[index] = queuedStart{name, priority}
+
+	s.starts.lock.Unlock()
+
+	s.dispatchStarts()
+}
+
+// holdStarts stops queued starts from being dispatched until the returned
+// function is called.
+func (s *Server) holdStarts() (release func()) {
+	s.starts.lock.Lock()
+	s.starts.holds++
+	s.starts.lock.Unlock()
+
+	return func() {
+		s.starts.lock.Lock()
+		s.starts.holds--
+		s.starts.lock.Unlock()
+
+		s.dispatchStarts()
+	}
+}
+
+// dispatchStarts starts as many queued services as there are free slots for.
+func (s *Server) dispatchStarts() {
+	s.starts.lock.Lock()
+	defer s.starts.lock.Unlock()
+
+	for s.starts.holds == 0 && !s.isDraining() && len(s.starts.pending) > 0 {
+		if config.MaxParallelStarts > 0 && s.starts.starting >= config.MaxParallelStarts {
+			return
+		}
+
+		next := s.starts.pending[0]
+		s.starts.pending = s.starts.pending[1:]
+		s.starts.starting++
+
+		go func(name string) {
+			defer func() {
+				s.starts.lock.Lock()
+				s.starts.starting--
+				s.starts.lock.Unlock()
+
+				s.dispatchStarts()
+			}()
+
+			if serv := s.getService(name); serv == nil || serv.Running() {
+				// Removed or started some other way while waiting
+				return
+			}
+
+			// Only need to hold the slot until it's ready if there's a limit
+			args := StartArgs{
+				Name:      name,
+				WaitReady: config.MaxParallelStarts > 0,
+			}
+
+			// Don't fail anything if the service failed to start, but do warn.
+			log.Debug("Auto-starting service", "service", name)
+			started := make(chan struct{})
+			go func() {
+				defer close(started)
+				if err := s.Start(args, nil); err != nil {
+					log.Warn("Failed to auto-start service", "service", name, "err", err)
+				}
+			}()
+
+			// A service that never becomes ready shouldn't hold up the rest
+			select {
+			case <-started:
+			case <-time.After(startSlotTimeout):
+				log.Warn("Auto-started service isn't ready yet, starting others without waiting for it", "service", name, "waited", startSlotTimeout)
+			}
+		}(next.name)
+	}
+}
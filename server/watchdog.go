@@ -0,0 +1,44 @@
+package server
+
+import (
+	"time"
+
+	log "github.com/inconshreveable/log15"
+)
+
+// How often services are checked for missed watchdog pings
+const watchdogCheckInterval = 1 * time.Second
+
+// checkWatchdogs restarts services that stopped pinging their watchdog, as
+// hung, until cancelled.
+func (s *Server) checkWatchdogs(cancel <-chan interface{}) {
+	ticker := time.NewTicker(watchdogCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cancel:
+			return
+		case <-ticker.C:
+		}
+
+		for _, srvc := range s.listServices() {
+			if !srvc.Hung() {
+				continue
+			} else if srvc.InMaintenance() {
+				log.Debug("Leaving hung service in maintenance alone", "service", srvc.Conf.Name)
+				continue
+			}
+
+			// So it's not seen as hung again while it restarts
+			srvc.ResetWatchdog()
+
+			go func(name string) {
+				log.Warn("Service stopped pinging its watchdog, restarting it as hung", "service", name)
+				if err := s.Restart(RestartArgs{Name: name}, nil); err != nil {
+					log.Warn("Failed to restart hung service", "service", name, "err", err)
+				}
+			}(srvc.Conf.Name)
+		}
+	}
+}
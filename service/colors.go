@@ -0,0 +1,144 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// defaultTheme has colors for each part of a service's info, by role. A theme
+// in the config file can change any of them.
+var defaultTheme = map[string]string{
+	"stopped":   "blue",
+	"running":   "yellow",
+	"status":    "hi-white bold",
+	"pid":       "none",
+	"succeeded": "green",
+	"failed":    "red",
+	"symbol":    "white",
+}
+
+// colorAttributes are names that can be used in a theme's colors
+var colorAttributes = map[string]color.Attribute{
+	"black":   color.FgBlack,
+	"red":     color.FgRed,
+	"green":   color.FgGreen,
+	"yellow":  color.FgYellow,
+	"blue":    color.FgBlue,
+	"magenta": color.FgMagenta,
+	"cyan":    color.FgCyan,
+	"white":   color.FgWhite,
+
+	"hi-black":   color.FgHiBlack,
+	"hi-red":     color.FgHiRed,
+	"hi-green":   color.FgHiGreen,
+	"hi-yellow":  color.FgHiYellow,
+	"hi-blue":    color.FgHiBlue,
+	"hi-magenta": color.FgHiMagenta,
+	"hi-cyan":    color.FgHiCyan,
+	"hi-white":   color.FgHiWhite,
+
+	"bold":      color.Bold,
+	"faint":     color.Faint,
+	"italic":    color.Italic,
+	"underline": color.Underline,
+}
+
+// prefixAttributes are cycled through to tell apart services' output when
+// it's mixed together
+var prefixAttributes = []color.Attribute{
+	color.FgCyan,
+	color.FgYellow,
+	color.FgGreen,
+	color.FgMagenta,
+	color.FgBlue,
+	color.FgHiCyan,
+	color.FgHiYellow,
+	color.FgHiGreen,
+	color.FgHiMagenta,
+	color.FgHiBlue,
+}
+
+func init() {
+	if err := SetColors(!color.NoColor, nil); err != nil {
+		panic(err)
+	}
+}
+
+// SetColors turns colored output on or off, and sets colors from a theme,
+// like {"running": "hi-yellow bold"}, over the default ones. Output is only
+// colored if it's also going to a terminal.
+func SetColors(enabled bool, theme map[string]string) error {
+	colors := make(map[string]*color.Color, len(defaultTheme))
+	for role, spec := range defaultTheme {
+		if themeSpec, ok := theme[role]; ok {
+			spec = themeSpec
+		}
+
+		c, err := parseColor(spec)
+		if err != nil {
+			return fmt.Errorf("Bad color for '%s' in theme: %v", role, err)
+		}
+		colors[role] = c
+	}
+
+	for role := range theme {
+		if _, ok := defaultTheme[role]; !ok {
+			var roles []string
+			for known := range defaultTheme {
+				roles = append(roles, known)
+			}
+			sort.Strings(roles)
+			return fmt.Errorf("Unknown color '%s' in theme, should be one of: %s", role, strings.Join(roles, ", "))
+		}
+	}
+
+	if !enabled {
+		color.NoColor = true
+	}
+
+	stoppedNameColor = colors["stopped"].SprintfFunc()
+	runningNameColor = colors["running"].SprintfFunc()
+	statusColor = colors["status"].SprintfFunc()
+	pidColor = colors["pid"].SprintfFunc()
+	succeededColor = colors["succeeded"].SprintfFunc()
+	failedColor = colors["failed"].SprintfFunc()
+
+	succeededBullet = succeededColor("✔")
+	failedBullet = failedColor("✘")
+	runningBullet = runningNameColor("⌁")
+
+	autoStartSymbol = colors["symbol"].Sprint("↑")
+	restartOnExitSymbol = colors["symbol"].Sprint("↺")
+	healthySymbol = succeededColor("♥")
+	unhealthySymbol = failedColor("♡")
+
+	return nil
+}
+
+// parseColor makes a color from names of attributes, like "hi-red bold", or
+// "none" for no color.
+func parseColor(spec string) (*color.Color, error) {
+	c := color.New()
+	for _, name := range strings.Fields(strings.ToLower(spec)) {
+		if name == "none" {
+			continue
+		}
+
+		attr, ok := colorAttributes[name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown color '%s'", name)
+		}
+		c.Add(attr)
+	}
+
+	return c, nil
+}
+
+// PrefixColor gets a color to prefix the nth service's output with, when
+// output from several is mixed together.
+func PrefixColor(n int) func(format string, a ...interface{}) string {
+	return color.New(prefixAttributes[n%len(prefixAttributes)]).SprintfFunc()
+}
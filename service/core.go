@@ -0,0 +1,95 @@
+package service
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Lifts the core size limit as far as it can go before running the program,
+// since the server's own limit is usually 0.
+const coreDumpWrapper = `ulimit -c unlimited 2>/dev/null || ulimit -c "$(ulimit -H -c)"; `
+
+// Runs the program in place of the shell, for wrappers that set things up
+// first
+const execWrapper = `exec "$0" "$@"`
+
+// corePath works out where a process that dumped core left it, from the
+// system's core pattern. If it went to a handler instead of a file, it says
+// so.
+func corePath(pid int, program, dir string) string {
+	pattern := "core"
+	switch runtime.GOOS {
+	case "darwin":
+		pattern = "/cores/core.%P"
+	case "linux":
+		data, err := ioutil.ReadFile("/proc/sys/kernel/core_pattern")
+		if err != nil {
+			return "unknown, couldn't read /proc/sys/kernel/core_pattern"
+		}
+		pattern = strings.TrimSpace(string(data))
+
+		if strings.HasPrefix(pattern, "|") {
+			handler := strings.Fields(strings.TrimPrefix(pattern, "|"))
+			if len(handler) > 0 && strings.Contains(handler[0], "systemd-coredump") {
+				return fmt.Sprintf("handled by systemd-coredump, see `coredumpctl info %d`", pid)
+			} else if len(handler) > 0 {
+				return fmt.Sprintf("piped to %s", handler[0])
+			}
+		}
+
+		// Without a pid in the pattern, it might still get one appended
+		if usesPid, err := ioutil.ReadFile("/proc/sys/kernel/core_uses_pid"); err == nil && strings.TrimSpace(string(usesPid)) == "1" && !strings.Contains(pattern, "%p") && !strings.Contains(pattern, "%P") {
+			pattern += ".%p"
+		}
+	}
+
+	// The kernel truncates the executable name to 15 chars
+	exe := filepath.Base(program)
+	if len(exe) > 15 {
+		exe = exe[:15]
+	}
+	hostname, _ := os.Hostname()
+
+	var expanded strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '%' || i+1 == len(pattern) {
+			expanded.WriteByte(pattern[i])
+			continue
+		}
+
+		i++
+		switch pattern[i] {
+		case 'p', 'P':
+			expanded.WriteString(strconv.Itoa(pid))
+		case 'e':
+			expanded.WriteString(exe)
+		case 'h':
+			expanded.WriteString(hostname)
+		case 'u':
+			expanded.WriteString(strconv.Itoa(os.Getuid()))
+		case '%':
+			expanded.WriteByte('%')
+		default:
+			// Like a timestamp, which can't be known here, so leave it
+			expanded.WriteByte('%')
+			expanded.WriteByte(pattern[i])
+		}
+	}
+
+	// Relative to the process's working dir
+	file := expanded.String()
+	if !path.IsAbs(file) {
+		if dir == "" {
+			dir, _ = os.Getwd()
+		}
+		file = path.Join(dir, file)
+	}
+
+	return file
+}
@@ -0,0 +1,39 @@
+//go:build darwin
+// +build darwin
+
+package service
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// findDescendants finds all processes under a pid, by walking parent pids
+// from the kernel's process table. Reading other processes' env isn't easy
+// on macOS, so the marker isn't used, and orphaned processes are missed.
+func findDescendants(pid int, marker string) []int {
+	procs, err := unix.SysctlKinfoProcSlice("kern.proc.all")
+	if err != nil {
+		return nil
+	}
+
+	children := make(map[int][]int)
+	for _, proc := range procs {
+		child := int(proc.Proc.P_pid)
+		ppid := int(proc.Eproc.Ppid)
+		if child != pid {
+			children[ppid] = append(children[ppid], child)
+		}
+	}
+
+	var pids []int
+	queue := []int{pid}
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+
+		pids = append(pids, children[parent]...)
+		queue = append(queue, children[parent]...)
+	}
+
+	return pids
+}
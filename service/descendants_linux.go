@@ -0,0 +1,73 @@
+//go:build linux
+// +build linux
+
+package service
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// findDescendants finds all processes under a pid, by walking parent pids in
+// /proc. Processes that were orphaned, like by double-forking, are found by
+// the marker in their env, which they inherit from the service.
+func findDescendants(pid int, marker string) []int {
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+
+	children := make(map[int][]int)
+	found := make(map[int]bool)
+	for _, entry := range entries {
+		child, err := strconv.Atoi(entry.Name())
+		if err != nil || child == pid {
+			continue
+		}
+
+		// Format is "pid (comm) state ppid ...", where comm can have spaces
+		// and parens, so look after the last paren.
+		stat, err := ioutil.ReadFile(path.Join("/proc", entry.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+		if len(fields) < 2 {
+			continue
+		}
+		if ppid, err := strconv.Atoi(fields[1]); err == nil {
+			children[ppid] = append(children[ppid], child)
+		}
+
+		if marker != "" {
+			environ, err := ioutil.ReadFile(path.Join("/proc", entry.Name(), "environ"))
+			if err == nil && bytes.Contains(append([]byte{0}, environ...), []byte("\x00"+marker+"\x00")) {
+				found[child] = true
+			}
+		}
+	}
+
+	// Walk down from the pid
+	queue := []int{pid}
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+
+		for _, child := range children[parent] {
+			if !found[child] {
+				found[child] = true
+				queue = append(queue, child)
+			}
+		}
+	}
+
+	pids := make([]int, 0, len(found))
+	for child := range found {
+		pids = append(pids, child)
+	}
+
+	return pids
+}
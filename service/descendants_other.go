@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package service
+
+// findDescendants isn't supported on this platform, so it relies on stopping
+// the process group.
+func findDescendants(pid int, marker string) []int {
+	return nil
+}
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/heewa/bento/config"
+)
+
+// How many past runs of a service are kept
+const maxRuns = 100
+
+// Run is a past run of a service's process
+type Run struct {
+	// Which run of the service it was, counting from 1, as logged in the
+	// server's log as run-id
+	ID int
+
+	Pid       int
+	StartTime time.Time
+	EndTime   time.Time
+
+	// Exit code, or -1 if it was killed by a signal
+	ExitCode int
+	Signal   string
+
+	// If it dumped core, and where to, or how to get it
+	CoreDumped bool
+	CorePath   string
+
+	// Why it ended, besides exiting on its own
+	UserStopped   bool
+	StartTimedOut bool
+	Replaced      bool
+
+	// Most resident memory it used at once, in bytes, and its total cpu time,
+	// along with its descendants'
+	PeakRSS uint64
+	CPUTime time.Duration
+
+	// If something other than bento killed it, like kill from another
+	// terminal, and if that was the kernel's OOM killer
+	KilledExternally bool
+	OOMKilled        bool
+}
+
+// Outcome describes how the run ended
+func (r Run) Outcome() string {
+	switch {
+	case r.Replaced:
+		return "replaced by a restart"
+	case r.StartTimedOut:
+		return "stopped, didn't become ready"
+	case r.UserStopped:
+		return "stopped"
+	case r.OOMKilled:
+		return "killed by the OOM killer"
+	case r.KilledExternally:
+		return fmt.Sprintf("killed externally, %s", r.Signal)
+	case r.CoreDumped:
+		return fmt.Sprintf("crashed, %s, core dumped: %s", r.Signal, r.CorePath)
+	case r.Signal != "":
+		return fmt.Sprintf("crashed, %s", r.Signal)
+	case r.ExitCode == 0:
+		return "exited"
+	default:
+		return fmt.Sprintf("crashed, exit code %d", r.ExitCode)
+	}
+}
+
+// newRun makes a run from a process that exited
+func newRun(state *os.ProcessState, id int, startTime, endTime time.Time) Run {
+	run := Run{
+		ID:        id,
+		Pid:       state.Pid(),
+		StartTime: startTime,
+		EndTime:   endTime,
+		ExitCode:  state.ExitCode(),
+	}
+
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		run.Signal = config.SignalName(status.Signal())
+		run.CoreDumped = status.CoreDump()
+	}
+
+	return run
+}
+
+// killedBySignal gets the signal that killed a process, if one did.
+func killedBySignal(state *os.ProcessState) (syscall.Signal, bool) {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return status.Signal(), true
+	}
+	return 0, false
+}
+
+// exitCode gets a process's exit code, or like a shell, 128 + the signal that
+// killed it.
+func exitCode(state *os.ProcessState) int {
+	if sig, ok := killedBySignal(state); ok {
+		return 128 + int(sig)
+	}
+	return state.ExitCode()
+}
+
+// History gets past runs of the service, oldest first.
+func (s *Service) History() []Run {
+	s.stateLock.RLock()
+	defer s.stateLock.RUnlock()
+
+	return append([]Run(nil), s.runs...)
+}
+
+// RestoreHistory sets past runs of the service, like ones exported from
+// another server, if it doesn't have any of its own yet. Later runs are
+// numbered after them.
+func (s *Service) RestoreHistory(runs []Run) bool {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	if len(s.runs) > 0 || len(runs) == 0 {
+		return false
+	}
+
+	if len(runs) > maxRuns {
+		runs = runs[len(runs)-maxRuns:]
+	}
+	s.runs = append([]Run(nil), runs...)
+
+	if last := int64(runs[len(runs)-1].ID); last > atomic.LoadInt64(&s.lastRunID) {
+		atomic.StoreInt64(&s.lastRunID, last)
+	}
+
+	return true
+}
+
+// addRun records a past run, forgetting the oldest ones past the limit. Must
+// be called with the state lock held.
+func (s *Service) addRun(run Run) {
+	if len(s.runs) >= maxRuns {
+		s.runs = append(s.runs[:0], s.runs[1:]...)
+	}
+	s.runs = append(s.runs, run)
+}
@@ -2,18 +2,29 @@ package service
 
 import (
 	"fmt"
+	"os/user"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/dustin/go-humanize"
-	"github.com/fatih/color"
 	"gopkg.in/yaml.v2"
 
 	"github.com/heewa/bento/config"
 )
 
+// Health of a running service, from checking its ready-when condition
+type Health string
+
+// Health states
+const (
+	HealthUnknown Health = ""
+	Healthy       Health = "healthy"
+	Unhealthy     Health = "unhealthy"
+)
+
 // Info holds info about a service
 type Info struct {
 	*config.Service `yaml:"config"`
@@ -23,6 +34,46 @@ type Info struct {
 	Succeeded bool `yaml:"succeeded"`
 	Dead      bool `yaml:"dead,omitempty"`
 
+	// Exit code of the last run, or like a shell, 128 + the signal that
+	// killed it
+	ExitCode int `yaml:"exit-code,omitempty"`
+
+	// Name of the signal that killed the last run, if one did, and where its
+	// core dump went, if it dumped core
+	Signal   string `yaml:"signal,omitempty"`
+	CorePath string `yaml:"core-path,omitempty"`
+
+	// Most resident memory the last run used at once, in bytes, and its total
+	// cpu time
+	PeakRSS uint64        `yaml:"peak-rss,omitempty"`
+	CPUTime time.Duration `yaml:"cpu-time,omitempty"`
+
+	// If the last run was killed by something other than bento, like kill
+	// from another terminal, and if that was the OOM killer
+	KilledExternally bool `yaml:"killed-externally,omitempty"`
+	OOMKilled        bool `yaml:"oom-killed,omitempty"`
+
+	// True if it was stopped for not becoming ready within its start-timeout
+	StartTimedOut bool `yaml:"start-timed-out,omitempty"`
+
+	// Result of the last health check, if it's running
+	Health Health `yaml:"health,omitempty"`
+
+	// When it started restarting too often, if it still is
+	FlappingSince time.Time `yaml:"flapping-since,omitempty"`
+
+	// True if it's in maintenance, so it's not restarted on exit
+	Maintenance bool `yaml:"maintenance,omitempty"`
+
+	// Port assigned to the service, if it has one
+	Port int `yaml:"port,omitempty"`
+
+	// Uid of the user that created the service
+	Owner int `yaml:"owner"`
+
+	// When it'll next be restarted on its restart-schedule, if it has one
+	NextRestart time.Time `yaml:"next-restart,omitempty"`
+
 	StartTime time.Time     `yaml:"start-time,omitempty"`
 	EndTime   time.Time     `yaml:"end-time,omitempty"`
 	Runtime   time.Duration `yaml:"run-time,omitempty"`
@@ -38,6 +89,7 @@ func (i InfoByName) Swap(a, b int)      { i[b], i[a] = i[a], i[b] }
 func (i InfoByName) Less(a, b int) bool { return strings.Compare(i[a].Name, i[b].Name) < 0 }
 
 // InfoByActivity implements the sort interface, so that the order is:
+//   - unhealthy
 //   - running & recently started
 //   - running & started longer ago than above
 //   - stopped & rececently exited
@@ -47,6 +99,10 @@ type InfoByActivity []Info
 func (i InfoByActivity) Len() int      { return len(i) }
 func (i InfoByActivity) Swap(a, b int) { i[b], i[a] = i[a], i[b] }
 func (i InfoByActivity) Less(a, b int) bool {
+	if (i[a].Health == Unhealthy) != (i[b].Health == Unhealthy) {
+		return i[a].Health == Unhealthy
+	}
+
 	return (
 	// [a] running
 	(i[a].Running && (!i[b].Running || i[a].StartTime.After(i[b].StartTime))) ||
@@ -54,20 +110,27 @@ func (i InfoByActivity) Less(a, b int) bool {
 		(!i[a].Running && !i[b].Running && i[a].EndTime.After(i[b].EndTime)))
 }
 
+// Colors & symbols for info, set by SetColors
 var (
-	stoppedNameColor = color.New(color.FgBlue).SprintfFunc()
-	runningNameColor = color.New(color.FgYellow).SprintfFunc()
-	statusColor      = color.New(color.FgHiWhite, color.Bold).SprintfFunc()
-	pidColor         = color.New().SprintfFunc()
+	stoppedNameColor func(string, ...interface{}) string
+	runningNameColor func(string, ...interface{}) string
+	statusColor      func(string, ...interface{}) string
+	pidColor         func(string, ...interface{}) string
+	succeededColor   func(string, ...interface{}) string
+	failedColor      func(string, ...interface{}) string
 
 	unstartedBullet = "●"
-	succeededBullet = color.GreenString("✔")
-	failedBullet    = color.RedString("✘")
-	runningBullet   = color.YellowString("⌁")
+	succeededBullet string
+	failedBullet    string
+	runningBullet   string
 
-	autoStartSymbol     = color.WhiteString("↑")
-	restartOnExitSymbol = color.WhiteString("↺")
+	autoStartSymbol     string
+	restartOnExitSymbol string
+	healthySymbol       string
+	unhealthySymbol     string
+)
 
+var (
 	colorPattern      = regexp.MustCompile("\x1b[^m]*m")
 	multiSpacePattern = regexp.MustCompile("   *")
 )
@@ -105,6 +168,9 @@ func (i Info) String() string {
 			"%s pid:%s",
 			statusColor("failed %s", humanize.Time(i.EndTime)),
 			pidColor("%d", i.Pid))
+		if i.StartTimedOut {
+			stateInfo += " (start timed out)"
+		}
 	}
 
 	autoStart := " "
@@ -117,6 +183,25 @@ func (i Info) String() string {
 		restartOnExit = restartOnExitSymbol
 	}
 
+	health := " "
+	if i.Health == Healthy {
+		health = healthySymbol
+	} else if i.Health == Unhealthy {
+		health = unhealthySymbol
+	}
+
+	if i.Port != 0 {
+		stateInfo = fmt.Sprintf("%s port:%d", stateInfo, i.Port)
+	}
+
+	if !i.FlappingSince.IsZero() {
+		stateInfo += " " + failedColor("(flapping)")
+	}
+
+	if i.Maintenance {
+		stateInfo += " (maintenance)"
+	}
+
 	// For a short string, just grab the command's file part
 	cmd := filepath.Base(i.Program)
 	if len(i.Args) > 0 {
@@ -127,10 +212,10 @@ func (i Info) String() string {
 	}
 
 	return fmt.Sprintf(
-		"  %s %s %s %s  %s cmd:'%s'",
+		"  %s %s %s %s %s  %s cmd:'%s'",
 		state,
 		nameColor("%-15s", i.Name),
-		autoStart, restartOnExit,
+		autoStart, restartOnExit, health,
 		stateInfo,
 		cmd)
 }
@@ -155,10 +240,22 @@ func (i Info) LongString() string {
 	exitStatus := "(hasn't exited yet)"
 	exitBullet := unstartedBullet
 	if i.Succeeded {
-		exitStatus = color.GreenString("succeeded")
+		exitStatus = succeededColor("succeeded")
 		exitBullet = succeededBullet
+	} else if i.StartTimedOut {
+		exitStatus = failedColor("failed, didn't become ready within start-timeout")
+		exitBullet = failedBullet
+	} else if !i.EndTime.IsZero() && i.OOMKilled {
+		exitStatus = failedColor("failed, killed by the OOM killer")
+		exitBullet = failedBullet
+	} else if !i.EndTime.IsZero() && i.KilledExternally {
+		exitStatus = failedColor("failed, killed externally by %s", i.Signal)
+		exitBullet = failedBullet
+	} else if !i.EndTime.IsZero() && i.Signal != "" {
+		exitStatus = failedColor("failed, crashed with %s", i.Signal)
+		exitBullet = failedBullet
 	} else if !i.EndTime.IsZero() {
-		exitStatus = color.RedString("failed")
+		exitStatus = failedColor("failed, exit code %d", i.ExitCode)
 		exitBullet = failedBullet
 	} else {
 		exitTime = "-"
@@ -181,9 +278,61 @@ func (i Info) LongString() string {
 		restartOnExit = restartOnExitSymbol
 	}
 
+	flapping := "no"
+	if !i.FlappingSince.IsZero() {
+		flapping = failedColor("yes, restarting too often since %s", humanize.Time(i.FlappingSince))
+	}
+
+	maintenance := ""
+	if i.Maintenance {
+		maintenance = " (paused for maintenance)"
+	}
+
+	healthBullet, health := "-", "unknown"
+	if i.Health == Healthy {
+		healthBullet, health = healthySymbol, succeededColor("healthy")
+	} else if i.Health == Unhealthy {
+		healthBullet, health = unhealthySymbol, failedColor("unhealthy, ready-when isn't met")
+	}
+
+	nextRestart := "-"
+	if !i.NextRestart.IsZero() {
+		nextRestart = fmt.Sprintf("%s, %v", humanize.Time(i.NextRestart), i.NextRestart)
+	}
+
+	description := "-"
+	if i.Description != "" {
+		description = i.Description
+	}
+
+	tags := "-"
+	if len(i.Tags) > 0 {
+		tags = strings.Join(i.Tags, ", ")
+	}
+
+	owner := strconv.Itoa(i.Owner)
+	if usr, err := user.LookupId(owner); err == nil {
+		owner = fmt.Sprintf("%s (uid %d)", usr.Username, i.Owner)
+	}
+
+	resources := "-"
+	if i.PeakRSS != 0 || i.CPUTime != 0 {
+		resources = fmt.Sprintf("%s peak memory, %s cpu time", humanize.Bytes(i.PeakRSS), i.CPUTime.Round(time.Millisecond))
+	}
+
+	core := "-"
+	if i.CorePath != "" {
+		core = i.CorePath
+	}
+
+	port := "-"
+	if i.Port != 0 {
+		port = fmt.Sprintf("%d", i.Port)
+	}
+
 	var conf string
 	if bytes, err := yaml.Marshal(i.Service); err != nil {
-		conf = color.RedString(" %v", err)
+		conf = failedColor(" %v", err)
 	} else {
 		for _, line := range strings.Split(string(bytes), "\n") {
 			conf = fmt.Sprintf("%s\n      %s", conf, line)
@@ -192,21 +341,39 @@ func (i Info) LongString() string {
 
 	return fmt.Sprintf(
 		"[%s]\n"+
+			"  - description: %s\n"+
+			"  - tags: %s\n"+
+			"  - owner: %s\n"+
 			"  %s %s\n"+
 			"  %s last exit status: %s\n"+
 			"  - last exit time: %s\n"+
+			"  - last run used: %s\n"+
+			"  - core dump: %s\n"+
 			"  - last start time: %s\n"+
 			"  - run time: %s\n"+
+			"  %s health: %s\n"+
+			"  - flapping: %s\n"+
+			"  - port: %s\n"+
+			"  - next scheduled restart: %s\n"+
 			"  %s auto-start: %v\n"+
-			"  %s restart-on-exit: %v\n"+
+			"  %s restart-on-exit: %v%s\n"+
 			"  - config:%s",
 		stateColor(i.Name),
+		description,
+		tags,
+		owner,
 		stateBullet, state,
 		exitBullet, exitStatus,
 		exitTime,
+		resources,
+		core,
 		startTime,
 		runTime,
+		healthBullet, health,
+		flapping,
+		port,
+		nextRestart,
 		autoStart, i.AutoStart,
-		restartOnExit, i.RestartOnExit,
+		restartOnExit, i.RestartOnExit, maintenance,
 		conf)
 }
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/heewa/bento/config"
+)
+
+// Runs the program with LISTEN_PID set to its own pid, which isn't known
+// until it's running, and which socket activation libs check.
+const listenPidWrapper = `LISTEN_PID=$$ exec "$0" "$@"`
+
+// listenFile gets the service's listening socket, as a file to pass on to its
+// process, opening it the first time. It stays open across restarts, so
+// connections aren't dropped in between. Must be called with the state lock
+// held.
+func (s *Service) listenFile() (*os.File, error) {
+	if s.listener != nil {
+		return s.listener, nil
+	}
+
+	network, address, err := config.ParseListenAddress(s.Conf.Listen)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to listen on %s: %v", s.Conf.Listen, err)
+	}
+
+	// Get a dup of the socket, then close our copy, since we never accept
+	// on it ourselves
+	var file *os.File
+	switch l := listener.(type) {
+	case *net.TCPListener:
+		file, err = l.File()
+	case *net.UnixListener:
+		// Leave the socket file for the service to keep using
+		l.SetUnlinkOnClose(false)
+		file, err = l.File()
+	}
+	listener.Close()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get listening socket for %s: %v", s.Conf.Listen, err)
+	}
+
+	s.log.Info("Listening for service", "address", s.Conf.Listen)
+	s.listener = file
+
+	return file, nil
+}
+
+// closeListener closes the service's listening socket, if it has one. Must
+// be called with the state lock held.
+func (s *Service) closeListener() {
+	if s.listener == nil {
+		return
+	}
+
+	s.listener.Close()
+	s.listener = nil
+
+	if network, address, err := config.ParseListenAddress(s.Conf.Listen); err == nil && network == "unix" {
+		os.Remove(address)
+	}
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// oomKilled checks the kernel log for the OOM killer having killed a process
+// since it started. Reading it can be restricted to root, in which case this
+// can't tell.
+func oomKilled(pid int, started time.Time) bool {
+	// The kernel log is timestamped with time since boot, on the same clock
+	// as CLOCK_MONOTONIC, so find when the process started on that clock, to
+	// skip entries from before it, about some other process that had the pid.
+	var now unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &now); err != nil {
+		return false
+	}
+	since := (time.Duration(now.Nano()) - time.Since(started)).Seconds()
+
+	out, err := exec.Command("dmesg").Output()
+	if err != nil {
+		return false
+	}
+
+	// Like "[ 1234.567890] Out of memory: Killed process 1234 (prog) ..." or
+	// from a cgroup's limit, "Memory cgroup out of memory: Killed process 1234"
+	killed := fmt.Sprintf("Killed process %d ", pid)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.Contains(line, killed) {
+			continue
+		}
+
+		// Without a timestamp, there's no telling which process it was about
+		if stamp, ok := kernelLogTime(line); ok && stamp >= since {
+			return true
+		}
+	}
+
+	return false
+}
+
+// kernelLogTime parses the seconds since boot from a line of dmesg output.
+func kernelLogTime(line string) (float64, bool) {
+	if !strings.HasPrefix(line, "[") {
+		return 0, false
+	}
+
+	end := strings.Index(line, "]")
+	if end < 0 {
+		return 0, false
+	}
+
+	stamp, err := strconv.ParseFloat(strings.TrimSpace(line[1:end]), 64)
+	if err != nil {
+		return 0, false
+	}
+	return stamp, true
+}
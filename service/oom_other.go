@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package service
+
+import (
+	"time"
+)
+
+// oomKilled can't tell if the OOM killer killed a process outside of linux.
+func oomKilled(pid int, started time.Time) bool {
+	return false
+}
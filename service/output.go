@@ -2,7 +2,35 @@ package service
 
 import (
 	"bufio"
+	"fmt"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/heewa/bento/config"
+)
+
+const (
+	// Lines to start an output's ring buffer with, which grows as needed
+	minOutputLines = 64
+
+	// OutputExpireInterval is how often output older than a service's
+	// output-retention is dropped, besides as new lines are added
+	OutputExpireInterval = time.Minute
+
+	// Memory used by a slot in the ring buffer, not counting the line's text
+	lineOverhead = int(unsafe.Sizeof(OutputLine{}))
+)
+
+// outputMemory is the total memory used by output across all services, to
+// keep within config.MaxOutputMemory, and outputLineMemory is the part of it
+// used by retained lines, their text and a slot each, which is what dropping
+// lines frees. Accessed atomically.
+var (
+	outputMemory     int64
+	outputLineMemory int64
 )
 
 // OutputLine is a line of output, eithet to stdout or stderr
@@ -15,37 +43,93 @@ type OutputLine struct {
 
 	// The output line
 	Line string
+
+	// When the line was captured
+	Time time.Time
+
+	// Index of the line in all of the service's output, across restarts,
+	// which can be used to get output from it onwards
+	Index int
+
+	// True if it was dropped to keep its stream under its limit, but still
+	// has a slot in the ring buffer
+	dropped bool
 }
 
 // output manages output from a service
 type output struct {
 	lock sync.RWMutex
 
-	// Output lines from all the processes related to a service, across restarts
-	lines       []OutputLine
-	indexOffset int
+	// Output lines from all the processes related to a service, across
+	// restarts, in a ring buffer. The oldest line is at lines[head]. Each line
+	// has a global index, in order, and the next one added gets nextIndex.
+	// Lines dropped from the middle leave gaps in the indexes.
+	lines     []OutputLine
+	head      int
+	count     int
+	nextIndex int
+
+	// Total size of text in retained lines, and memory used including the
+	// ring buffer's slots, which is also counted in outputMemory, and the
+	// part of it used by retained lines, counted in outputLineMemory.
+	size       int
+	memory     int
+	lineMemory int
+
+	// Size of text in retained stdout & stderr lines, and the most each can
+	// be, or 0 for no limit of their own, so a flood of one can't push out
+	// the other. Lines dropped to stay under it keep their slots until
+	// there are enough to compact, and the oldest line of each stream is at
+	// or after its streamOldest index.
+	streamSize   [2]int
+	streamMax    [2]int
+	streamOldest [2]int
+	dropped      int
+
+	// Lines from a process are contiguous, so they're tracked as runs of
+	// global indexes, oldest first, and by pid for quick lookups.
+	runs    []*outputRun
+	pidRuns map[int]*outputRun
 
 	// Pid of the streams currently being watched. If both streams are closed,
 	// this will be set to 0, even if the process itself is still going. That
-	// doesn't concern this struct.
+	// doesn't concern this struct. Lines from other pids are dropped.
 	pid int
 
-	// Used internally to cancel output watchers if
-	cancel chan interface{}
+	// Most lines per second to keep from the current process, or 0 for no
+	// limit. Lines over it in a second are dropped & counted, then noted in a
+	// marker line.
+	rateLimit   int
+	rateWindow  time.Time
+	rateLines   int
+	rateDropped int
+
+	// How long to keep lines for, or 0 to keep them until they're pushed out
+	retention time.Duration
+}
+
+// outputRun is a range of global line indexes, [start, end), from a pid
+type outputRun struct {
+	pid        int
+	start, end int
 }
 
-func (out *output) followNewProcess(pid int, stdout, stderr *bufio.Scanner) *sync.WaitGroup {
+func (out *output) followNewProcess(pid int, stdout, stderr *bufio.Scanner, rateLimit, maxStdout, maxStderr int, retention time.Duration) *sync.WaitGroup {
 	out.lock.Lock()
 	defer out.lock.Unlock()
 
-	// Cancel outputWatchers if they're still going.
-	if out.cancel != nil {
-		close(out.cancel)
-	}
-	out.cancel = make(chan interface{})
+	out.streamMax = [2]int{maxStdout, maxStderr}
+	out.retention = retention
+
+	out.addDroppedMarker(out.pid)
+	out.rateLimit = rateLimit
+	out.rateWindow = time.Time{}
+	out.rateLines = 0
 
-	// It's ok if we race with the previous watchPid(), the lock & its check
-	// should be safe
+	// Watchers of a previous process keep draining its output, so it doesn't
+	// block on a full pipe, like during an overlapping restart, but its lines
+	// are dropped. It's ok if we race with the previous watchPid(), the lock
+	// & its check should be safe.
 	out.pid = pid
 
 	// Spin up watchers, 2 that use the sync group to indicate when they're
@@ -59,16 +143,89 @@ func (out *output) followNewProcess(pid int, stdout, stderr *bufio.Scanner) *syn
 	return outputDone
 }
 
+// refollow goes back to keeping output from a previous process whose
+// watchers are still going, like when an overlapping restart is undone.
+func (out *output) refollow(pid int) {
+	out.lock.Lock()
+	defer out.lock.Unlock()
+
+	out.pid = pid
+}
+
+// Size gets the number of lines of output currently retained, and the bytes
+// of memory they use.
+func (out *output) Size() (lines, bytes int) {
+	out.lock.RLock()
+	defer out.lock.RUnlock()
+
+	return out.count, out.memory
+}
+
+// OutputMemory gets the total memory used by output across all services.
+func OutputMemory() int64 {
+	return atomic.LoadInt64(&outputMemory)
+}
+
+// release drops all output, giving its memory back to the server-wide
+// budget. Used when a service is removed.
+func (out *output) release() {
+	out.lock.Lock()
+	defer out.lock.Unlock()
+
+	out.addMemory(-out.memory)
+	out.addLineMemory(-out.lineMemory)
+
+	out.lines = nil
+	out.head = 0
+	out.count = 0
+	out.size = 0
+	out.streamSize = [2]int{}
+	out.dropped = 0
+	out.runs = nil
+	out.pidRuns = nil
+}
+
+// addMemory accounts for a change in memory used, locally & server-wide. Must
+// be called with the lock held.
+func (out *output) addMemory(delta int) {
+	out.memory += delta
+	atomic.AddInt64(&outputMemory, int64(delta))
+}
+
+// addLineMemory accounts for a change in memory used by retained lines,
+// locally & server-wide. Must be called with the lock held.
+func (out *output) addLineMemory(delta int) {
+	out.lineMemory += delta
+	atomic.AddInt64(&outputLineMemory, int64(delta))
+}
+
+// overBudget is true if output is using more memory than it's allowed
+// server-wide. Spare slots of other services' ring buffers don't count, since
+// dropping this one's lines can't free them. Must be called with the lock
+// held.
+func (out *output) overBudget() bool {
+	spare := out.memory - out.lineMemory
+	return atomic.LoadInt64(&outputLineMemory)+int64(spare) > config.MaxOutputMemory
+}
+
 // GetTail is a convenience wrapper aroung Get().
 func (out *output) GetTail(pid, num int) (lines []OutputLine, eof bool, nextIndex, nextPid int) {
 	return out.Get(-1*num, pid, num)
 }
 
-// Get gets lines of output.
+// Get gets lines of output, see GetLimited.
+func (out *output) Get(index, pid, max int) (lines []OutputLine, eof bool, nextIndex, nextPid int) {
+	lines, eof, nextIndex, nextPid, _, _ = out.GetLimited(index, pid, max, 0)
+	return
+}
+
+// GetLimited gets lines of output.
 //   index: If >= 0, the line # to start from. If < 0, that # of lines from
 //	        the end of output
 //	 pid: If 0, lines are from any process, otherwise restricted to this pid's
 //   max: If > 0, limit # lines returned
+//   maxBytes: If > 0, limit the total size of lines returned, though at
+//             least one line is, however big
 // Returns:
 //   lines: A slice of lines
 //   eof: True if pid != 0 && that process has no more output & never will
@@ -76,61 +233,101 @@ func (out *output) GetTail(pid, num int) (lines []OutputLine, eof bool, nextInde
 //              where this Get() call left off
 //   nextPid: A pid that can be used on a subsequent call to continue from where
 //            this Get() call left off
-func (out *output) Get(index, pid, max int) (lines []OutputLine, eof bool, nextIndex, nextPid int) {
+//   truncated: True if lines were cut short by maxBytes, with more available
+//              right away from nextIndex
+//   skipped: # lines from index on that were already dropped, like if the
+//            caller fell behind
+func (out *output) GetLimited(index, pid, max, maxBytes int) (lines []OutputLine, eof bool, nextIndex, nextPid int, truncated bool, skipped int) {
 	out.lock.RLock()
 	defer out.lock.RUnlock()
 
-	// Translate a global or reverse index to an index into the window we have in out.lines
-	if index >= 0 {
-		// Global index
-		index = index - out.indexOffset
-	} else {
-		// Negative index means that many from end
-		end := len(out.lines)
-
-		// If they're asking for a specific pid, and it's not the current proc,
-		// find that proc's end, otherwise if it's the current proc, and it
-		// hasn't yet outputted anything, we'll skip where it would go, and
-		// think it's done.
-		if pid > 0 && pid != out.pid {
-			for end > 0 && pid != out.lines[end-1].Pid {
-				end--
-			}
+	// Work in global indexes, with the window we have being [first, last)
+	first, last := out.firstIndex(), out.nextIndex
+
+	// The run of lines for the pid the caller cares about. If they want a pid
+	// we don't have lines from, their window is empty, at the end for the
+	// current proc, otherwise at the oldest line we have.
+	var run *outputRun
+	if pid > 0 {
+		run = out.pidRuns[pid]
+		if run == nil && pid == out.pid {
+			// Current proc that hasn't outputted yet, so it'll be at the end
+			run = &outputRun{pid: pid, start: last, end: last}
+		} else if run == nil {
+			run = &outputRun{pid: pid, start: first, end: first}
 		}
+	}
 
-		if end > 0 {
-			// Find the start by scanning back from end
-			num := -1 * index
-			index = end
-			for index > 0 && end-index < num && (pid == 0 || out.lines[index-1].Pid == pid) {
-				index--
+	if index < 0 {
+		// Negative index means that many from end, of the pid's lines if
+		// they're asking for a specific pid. Count back over retained lines,
+		// since there can be gaps.
+		end := last
+		if run != nil {
+			end = run.end
+		}
+
+		pos := out.position(end)
+		for remaining := -index; remaining > 0 && pos > 0; {
+			pos--
+			if !out.slot(pos).dropped {
+				remaining--
 			}
 		}
-	}
 
-	// If the caller falls behind, just clamp them to what we have. If they
-	// care about a particular pid, that'll be handled regardless.
-	if index < 0 {
-		index = 0
+		index = end
+		if pos < out.count && out.slot(pos).Index < end {
+			index = out.slot(pos).Index
+		}
 	}
 
-	// Scan for how many lines are from the same process, up to requested max
-	end := index
-	for end < len(out.lines) && (max == 0 || end-index < max) && (pid == 0 || pid == out.lines[end].Pid) {
-		end++
+	// If the caller falls behind, clamp them to what we have, counting what
+	// they missed, or to the start of the pid's lines if they care about a
+	// particular one.
+	if index < first {
+		skipped = first - index
+		index = first
+	}
+	if run != nil && index < run.start {
+		index = run.start
 	}
 
-	// Copy
-	if end-index > 0 {
-		lines = out.lines[index:end]
+	// Up to the requested max, from the same process
+	end := last
+	if pid > 0 {
+		end = index
+		if run != nil && index >= run.start && index < run.end {
+			end = run.end
+		}
 	}
 
-	// Set the returned global next index
-	nextIndex = out.indexOffset + end
+	// Copy, cuz the ring buffer's lines can be overwritten after we unlock.
+	// Next index is after the last line returned, or the end if all of them
+	// were.
+	nextIndex = end
+	size := 0
+	for pos := out.position(index); pos < out.count; pos++ {
+		line := out.slot(pos)
+		if line.Index >= end {
+			break
+		} else if line.dropped {
+			continue
+		} else if max > 0 && len(lines) == max {
+			nextIndex = line.Index
+			break
+		} else if maxBytes > 0 && len(lines) > 0 && size+len(line.Line) > maxBytes {
+			nextIndex = line.Index
+			truncated = true
+			break
+		}
+
+		lines = append(lines, *line)
+		size += len(line.Line)
+	}
 
 	// Next pid from next line, if there is one
-	if end < len(out.lines) {
-		nextPid = out.lines[end].Pid
+	if nextIndex < last {
+		nextPid = out.runAt(nextIndex).pid
 	} else {
 		// No more lines, so use what's going to output next, even if that's 0
 		nextPid = out.pid
@@ -144,48 +341,300 @@ func (out *output) Get(index, pid, max int) (lines []OutputLine, eof bool, nextI
 	return
 }
 
-// watchOutput reads from stdout or stderr & puts lines on a capped slice
-func (out *output) watchOutput(outScanner *bufio.Scanner, isStderr bool, pid int, done *sync.WaitGroup) {
-	defer done.Done()
+// runAt finds the run containing a global index, which must be in the window
+// of retained lines.
+func (out *output) runAt(index int) *outputRun {
+	i := sort.Search(len(out.runs), func(i int) bool {
+		return out.runs[i].end > index
+	})
+	return out.runs[i]
+}
 
-	size := 0
+// slot gets the line at a position in the ring buffer, from the oldest. Must
+// be called with the lock held.
+func (out *output) slot(pos int) *OutputLine {
+	return &out.lines[(out.head+pos)%len(out.lines)]
+}
 
-	for outScanner.Scan() {
-		// Checking cancel here is not really that responsive, since the Scan()
-		// call above blocks. But that's the interface we have to the output
-		// stream ¯\_(ツ)_/¯ But we do need it, so we don't interleave lines
-		// from different procs, and mess up the EOF logic, or what a tailer
-		// expects.
-		select {
-		case <-out.cancel:
+// position finds the position in the ring buffer of the first line at or
+// after a global index. Must be called with the lock held.
+func (out *output) position(index int) int {
+	return sort.Search(out.count, func(pos int) bool {
+		return out.slot(pos).Index >= index
+	})
+}
+
+// firstIndex gets the global index of the oldest line, or the next one if
+// there aren't any. Must be called with the lock held.
+func (out *output) firstIndex() int {
+	if out.count == 0 {
+		return out.nextIndex
+	}
+	return out.slot(0).Index
+}
+
+// streamOf gets which stream a line's from, 0 for stdout, 1 for stderr
+func streamOf(line OutputLine) int {
+	if line.Stderr {
+		return 1
+	}
+	return 0
+}
+
+// add puts a line at the end of the ring buffer, and drops old lines to stay
+// under the max size. Must be called with the lock held.
+func (out *output) add(line OutputLine) {
+	if out.count == len(out.lines) {
+		if out.dropped > out.count/2 {
+			// Enough dropped lines to make room by compacting
+			out.resize(len(out.lines))
+		} else {
+			out.grow()
+		}
+	}
+
+	line.Index = out.nextIndex
+	out.nextIndex++
+
+	*out.slot(out.count) = line
+	out.count++
+	out.size += len(line.Line)
+	out.streamSize[streamOf(line)] += len(line.Line)
+	out.addMemory(len(line.Line))
+	out.addLineMemory(len(line.Line) + lineOverhead)
+
+	if n := len(out.runs); n > 0 && out.runs[n-1].pid == line.Pid {
+		out.runs[n-1].end = out.nextIndex
+	} else {
+		run := &outputRun{pid: line.Pid, start: line.Index, end: out.nextIndex}
+		out.runs = append(out.runs, run)
+
+		if out.pidRuns == nil {
+			out.pidRuns = make(map[int]*outputRun)
+		}
+		out.pidRuns[line.Pid] = run
+	}
+
+	// Keep the stream under its own limit, if it has one, by dropping its
+	// oldest lines, leaving the other stream's alone.
+	stream := streamOf(line)
+	for out.streamMax[stream] > 0 && out.streamSize[stream] > out.streamMax[stream] {
+		if !out.dropOldestOf(stream) {
+			break
+		}
+	}
+
+	// Cut down by total size, cuz output could be a binary stream, and we
+	// care about size more than # lines anyway. If all services together are
+	// over budget, whoever is outputting gives up their old lines, so a
+	// verbose service can't push out everyone else's output. Slots count
+	// too, so they're given back as lines are dropped.
+	for out.count > 1 && (out.size > maxOutputSize || out.overBudget()) {
+		out.dropOldest()
+		out.shrink()
+	}
+
+	out.dropExpired(line.Time)
+	out.shrink()
+}
+
+// shrink gives back memory if lots of lines were dropped. Must be called with
+// the lock held.
+func (out *output) shrink() {
+	if len(out.lines) > minOutputLines && out.count-out.dropped < len(out.lines)/4 {
+		out.resize(len(out.lines) / 2)
+	}
+}
+
+// Expire drops lines older than the output's retention, if it has one.
+func (out *output) Expire() {
+	out.lock.Lock()
+	defer out.lock.Unlock()
+
+	out.dropExpired(time.Now())
+	out.shrink()
+}
+
+// dropExpired drops lines older than the retention as of a time. Must be
+// called with the lock held.
+func (out *output) dropExpired(now time.Time) {
+	if out.retention <= 0 {
+		return
+	}
+
+	cutoff := now.Add(-out.retention)
+	for out.count > 0 && out.slot(0).Time.Before(cutoff) {
+		out.dropOldest()
+	}
+}
+
+// addLimited adds a line, unless the process has outputted more than its rate
+// limit in the last second, in which case it's dropped & counted. Must be
+// called with the lock held.
+func (out *output) addLimited(line OutputLine) {
+	if out.rateLimit > 0 {
+		if now := time.Now(); now.Sub(out.rateWindow) >= time.Second {
+			out.addDroppedMarker(line.Pid)
+			out.rateWindow = now
+			out.rateLines = 0
+		}
+
+		out.rateLines++
+		if out.rateLines > out.rateLimit {
+			out.rateDropped++
 			return
-		default:
 		}
+	}
+
+	out.add(line)
+}
+
+// addDroppedMarker adds a line noting how many were dropped for going over the
+// rate limit, if any were. Must be called with the lock held.
+func (out *output) addDroppedMarker(pid int) {
+	if out.rateDropped == 0 || pid == 0 {
+		return
+	}
+
+	out.add(OutputLine{
+		Pid:    pid,
+		Stderr: true,
+		Time:   time.Now(),
+		Line:   fmt.Sprintf("[bento] dropped %d lines over the output-rate-limit of %d lines/s", out.rateDropped, out.rateLimit),
+	})
+	out.rateDropped = 0
+}
+
+// dropOldest removes the oldest line. Must be called with the lock held.
+func (out *output) dropOldest() {
+	out.removeFirst()
+
+	// Dropped lines at the front don't need their slots anymore
+	for out.count > 0 && out.slot(0).dropped {
+		out.removeFirst()
+	}
+
+	// Forget runs that don't have lines left
+	first := out.firstIndex()
+	for len(out.runs) > 0 && out.runs[0].end <= first {
+		run := out.runs[0]
+		if out.pidRuns[run.pid] == run {
+			delete(out.pidRuns, run.pid)
+		}
+		out.runs[0] = nil
+		out.runs = out.runs[1:]
+	}
+	if len(out.runs) > 0 && out.runs[0].start < first {
+		out.runs[0].start = first
+	}
+}
+
+// removeFirst takes the oldest line out of the ring buffer. Must be called
+// with the lock held.
+func (out *output) removeFirst() {
+	line := out.slot(0)
+	if line.dropped {
+		out.dropped--
+	} else {
+		out.size -= len(line.Line)
+		out.streamSize[streamOf(*line)] -= len(line.Line)
+		out.addMemory(-len(line.Line))
+		out.addLineMemory(-len(line.Line) - lineOverhead)
+	}
+
+	*line = OutputLine{}
+	out.head = (out.head + 1) % len(out.lines)
+	out.count--
+}
+
+// dropOldestOf drops the oldest line of a stream, leaving a gap in the middle
+// if it's not the oldest line overall, but never the newest line. Returns
+// false if there wasn't one to drop. Must be called with the lock held.
+func (out *output) dropOldestOf(stream int) bool {
+	pos := out.position(out.streamOldest[stream])
+	for ; pos < out.count-1; pos++ {
+		if line := out.slot(pos); !line.dropped && streamOf(*line) == stream {
+			break
+		}
+	}
+	if pos >= out.count-1 {
+		return false
+	}
+
+	line := out.slot(pos)
+	out.streamOldest[stream] = line.Index + 1
+
+	if pos == 0 {
+		out.dropOldest()
+		return true
+	}
+
+	out.size -= len(line.Line)
+	out.streamSize[stream] -= len(line.Line)
+	out.addMemory(-len(line.Line))
+	out.addLineMemory(-len(line.Line) - lineOverhead)
+	line.Line = ""
+	line.dropped = true
+	out.dropped++
+
+	return true
+}
+
+// grow makes room for more lines in the ring buffer. Must be called with the
+// lock held.
+func (out *output) grow() {
+	capacity := 2 * len(out.lines)
+	if capacity < minOutputLines {
+		capacity = minOutputLines
+	}
+
+	out.resize(capacity)
+}
+
+// resize replaces the ring buffer with one of a different capacity, which must
+// fit all current lines that weren't dropped, unwrapping it & removing dropped
+// ones in the process. Must be called with the lock held.
+func (out *output) resize(capacity int) {
+	lines := make([]OutputLine, capacity)
+	count := 0
+	for pos := 0; pos < out.count; pos++ {
+		if line := out.slot(pos); !line.dropped {
+			lines[count] = *line
+			count++
+		}
+	}
+
+	out.addMemory((capacity - len(out.lines)) * lineOverhead)
 
-		func(line string) {
+	out.lines = lines
+	out.head = 0
+	out.count = count
+	out.dropped = 0
+}
+
+// watchOutput reads from stdout or stderr & puts lines on a capped slice
+func (out *output) watchOutput(outScanner *bufio.Scanner, isStderr bool, pid int, done *sync.WaitGroup) {
+	defer done.Done()
+
+	for outScanner.Scan() {
+		func(line string, captured time.Time) {
 			out.lock.Lock()
 			defer out.lock.Unlock()
 
-			// Don't write lines if process has already been replaced
+			// Don't write lines if process has already been replaced, so we
+			// don't interleave lines from different procs, and mess up the
+			// EOF logic, or what a tailer expects.
 			if pid != out.pid {
 				return
 			}
 
-			size += len(line)
-			out.lines = append(out.lines, OutputLine{
+			out.addLimited(OutputLine{
 				Pid:    pid,
 				Stderr: isStderr,
 				Line:   line,
+				Time:   captured,
 			})
-
-			// Cut down by total size, cuz output could be a binary stream, and we
-			// care about size more than # lines anyway.
-			for len(out.lines) > 1 && size > maxOutputSize {
-				size -= len(out.lines[0].Line)
-				out.lines = out.lines[1:]
-				out.indexOffset++
-			}
-		}(outScanner.Text())
+		}(outScanner.Text(), time.Now())
 	}
 }
 
@@ -200,6 +649,7 @@ func (out *output) watchPid(currentPid int, outputDone *sync.WaitGroup) {
 	// Only clear if it's the one we started with (can race between done
 	// & lock).
 	if out.pid == currentPid {
+		out.addDroppedMarker(currentPid)
 		out.pid = 0
 	}
 }
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// Flags on a packed line
+const (
+	packedStderr = 1 << iota
+	packedHasTime
+)
+
+// PackLines encodes lines of output compactly, for sending lots of them at
+// once, which gob is slow at as structs. Each line is the length of its text,
+// the text, then flags, its pid, and its index & time as differences from the
+// previous line's, as varints.
+func PackLines(lines []OutputLine) []byte {
+	size := 0
+	for _, line := range lines {
+		size += len(line.Line)
+	}
+	data := make([]byte, 0, size+len(lines)*16)
+
+	var buf [binary.MaxVarintLen64]byte
+	putUvarint := func(value uint64) {
+		data = append(data, buf[:binary.PutUvarint(buf[:], value)]...)
+	}
+	putVarint := func(value int64) {
+		data = append(data, buf[:binary.PutVarint(buf[:], value)]...)
+	}
+
+	var lastIndex int
+	var lastTime int64
+	for _, line := range lines {
+		putUvarint(uint64(len(line.Line)))
+		data = append(data, line.Line...)
+
+		var flags uint64
+		if line.Stderr {
+			flags |= packedStderr
+		}
+		if !line.Time.IsZero() {
+			flags |= packedHasTime
+		}
+		putUvarint(flags)
+
+		putVarint(int64(line.Pid))
+		putVarint(int64(line.Index - lastIndex))
+		lastIndex = line.Index
+
+		if !line.Time.IsZero() {
+			nanos := line.Time.UnixNano()
+			putVarint(nanos - lastTime)
+			lastTime = nanos
+		}
+	}
+
+	return data
+}
+
+// UnpackLines decodes lines encoded by PackLines.
+func UnpackLines(data []byte) ([]OutputLine, error) {
+	var lines []OutputLine
+
+	pos := 0
+	var err error
+	uvarint := func() uint64 {
+		value, n := binary.Uvarint(data[pos:])
+		if n <= 0 {
+			err = fmt.Errorf("Bad packed output at byte %d", pos)
+			return 0
+		}
+		pos += n
+		return value
+	}
+	varint := func() int64 {
+		value, n := binary.Varint(data[pos:])
+		if n <= 0 {
+			err = fmt.Errorf("Bad packed output at byte %d", pos)
+			return 0
+		}
+		pos += n
+		return value
+	}
+
+	var lastIndex int
+	var lastTime int64
+	for pos < len(data) && err == nil {
+		length := uvarint()
+		if err == nil && length > uint64(len(data)-pos) {
+			return nil, fmt.Errorf("Bad packed output at byte %d, line is longer than what's left", pos)
+		}
+		line := OutputLine{Line: string(data[pos : pos+int(length)])}
+		pos += int(length)
+
+		flags := uvarint()
+		line.Stderr = flags&packedStderr != 0
+		line.Pid = int(varint())
+		line.Index = lastIndex + int(varint())
+		lastIndex = line.Index
+
+		if flags&packedHasTime != 0 {
+			lastTime += varint()
+			line.Time = time.Unix(0, lastTime)
+		}
+
+		lines = append(lines, line)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return lines, nil
+}
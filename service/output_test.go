@@ -0,0 +1,353 @@
+package service
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"fmt"
+	"time"
+
+	"github.com/heewa/bento/config"
+)
+
+var _ = Describe("output", func() {
+	var out *output
+
+	// addLines adds num lines from a pid, numbered from 0
+	addLines := func(pid, num int) {
+		out.lock.Lock()
+		defer out.lock.Unlock()
+
+		for i := 0; i < num; i++ {
+			out.add(OutputLine{Pid: pid, Line: fmt.Sprintf("%d-%d", pid, i)})
+		}
+	}
+
+	texts := func(lines []OutputLine) []string {
+		strs := make([]string, 0, len(lines))
+		for _, line := range lines {
+			strs = append(strs, line.Line)
+		}
+		return strs
+	}
+
+	BeforeEach(func() {
+		out = &output{}
+	})
+
+	Describe("Get()", func() {
+		Context("When empty", func() {
+			It("returns no lines", func() {
+				lines, eof, nextIndex, nextPid := out.Get(-10, 0, 10)
+				Expect(lines).To(BeEmpty())
+				Expect(eof).To(BeFalse())
+				Expect(nextIndex).To(Equal(0))
+				Expect(nextPid).To(Equal(0))
+			})
+		})
+
+		Context("With lines from multiple processes", func() {
+			BeforeEach(func() {
+				addLines(1, 3)
+				addLines(2, 100)
+				out.pid = 2
+			})
+
+			It("gets the tail of all output", func() {
+				lines, _, nextIndex, _ := out.Get(-2, 0, 2)
+				Expect(texts(lines)).To(Equal([]string{"2-98", "2-99"}))
+				Expect(nextIndex).To(Equal(103))
+			})
+
+			It("gets the tail of an old process, up to its end", func() {
+				lines, eof, nextIndex, nextPid := out.Get(-10, 1, 10)
+				Expect(texts(lines)).To(Equal([]string{"1-0", "1-1", "1-2"}))
+				Expect(eof).To(BeTrue())
+				Expect(nextIndex).To(Equal(3))
+				Expect(nextPid).To(Equal(2))
+			})
+
+			It("resumes from a global index", func() {
+				lines, eof, nextIndex, nextPid := out.Get(1, 0, 3)
+				Expect(texts(lines)).To(Equal([]string{"1-1", "1-2", "2-0"}))
+				Expect(eof).To(BeFalse())
+				Expect(nextIndex).To(Equal(4))
+				Expect(nextPid).To(Equal(2))
+			})
+
+			It("starts at a process's first line from before it", func() {
+				lines, eof, nextIndex, _ := out.Get(0, 2, 2)
+				Expect(texts(lines)).To(Equal([]string{"2-0", "2-1"}))
+				Expect(lines[0].Index).To(Equal(3))
+				Expect(eof).To(BeFalse())
+				Expect(nextIndex).To(Equal(5))
+			})
+
+			It("cuts lines short at a max size, to continue from", func() {
+				// Each line is 3 bytes
+				lines, eof, nextIndex, _, truncated, _ := out.GetLimited(0, 0, 0, 7)
+				Expect(texts(lines)).To(Equal([]string{"1-0", "1-1"}))
+				Expect(eof).To(BeFalse())
+				Expect(truncated).To(BeTrue())
+				Expect(nextIndex).To(Equal(2))
+
+				lines, _, _, _, truncated, _ = out.GetLimited(nextIndex, 0, 2, 1)
+				Expect(texts(lines)).To(Equal([]string{"1-2"}))
+				Expect(truncated).To(BeTrue())
+
+				lines, _, _, _, truncated, _ = out.GetLimited(-2, 0, 2, 100)
+				Expect(texts(lines)).To(Equal([]string{"2-98", "2-99"}))
+				Expect(truncated).To(BeFalse())
+			})
+
+			It("returns nothing for the current process before it outputs", func() {
+				out.pid = 3
+				lines, eof, nextIndex, nextPid := out.Get(-10, 3, 10)
+				Expect(lines).To(BeEmpty())
+				Expect(eof).To(BeFalse())
+				Expect(nextIndex).To(Equal(103))
+				Expect(nextPid).To(Equal(3))
+			})
+		})
+
+		Context("When old output has been dropped", func() {
+			BeforeEach(func() {
+				addLines(1, 10)
+				addLines(2, 10)
+
+				out.lock.Lock()
+				defer out.lock.Unlock()
+				for i := 0; i < 12; i++ {
+					out.dropOldest()
+				}
+			})
+
+			It("clamps to the oldest line", func() {
+				lines, _, nextIndex, _ := out.Get(0, 0, 0)
+				Expect(lines).To(HaveLen(8))
+				Expect(lines[0].Line).To(Equal("2-2"))
+				Expect(nextIndex).To(Equal(20))
+			})
+
+			It("counts lines a caller fell behind on", func() {
+				lines, _, _, _, _, skipped := out.GetLimited(5, 0, 0, 0)
+				Expect(lines[0].Line).To(Equal("2-2"))
+				Expect(skipped).To(Equal(7))
+
+				_, _, _, _, _, skipped = out.GetLimited(-100, 0, 0, 0)
+				Expect(skipped).To(Equal(0))
+			})
+
+			It("forgets processes with no lines left", func() {
+				lines, eof, _, _ := out.Get(-10, 1, 10)
+				Expect(lines).To(BeEmpty())
+				Expect(eof).To(BeTrue())
+			})
+
+			It("keeps track of size", func() {
+				lines, bytes := out.Size()
+				Expect(lines).To(Equal(8))
+				Expect(bytes).To(Equal(8*len("2-2") + minOutputLines*lineOverhead))
+			})
+		})
+	})
+
+	Describe("memory", func() {
+		var startMemory, startMax int64
+
+		BeforeEach(func() {
+			startMemory = OutputMemory()
+			startMax = config.MaxOutputMemory
+		})
+
+		AfterEach(func() {
+			config.MaxOutputMemory = startMax
+			out.release()
+		})
+
+		// overBudget is whether the output counts as over the server-wide
+		// budget, which other outputs' spare slots don't count towards
+		overBudget := func() bool {
+			out.lock.RLock()
+			defer out.lock.RUnlock()
+			return out.overBudget()
+		}
+
+		It("counts towards the server-wide total", func() {
+			addLines(1, 10)
+			_, bytes := out.Size()
+			Expect(OutputMemory() - startMemory).To(Equal(int64(bytes)))
+
+			out.release()
+			Expect(OutputMemory()).To(Equal(startMemory))
+		})
+
+		It("gives back memory after dropping lines", func() {
+			addLines(1, 10*minOutputLines)
+			_, bigBytes := out.Size()
+
+			config.MaxOutputMemory = OutputMemory() - int64(bigBytes)/2
+			addLines(2, 1)
+
+			_, bytes := out.Size()
+			Expect(bytes).To(BeNumerically("<", bigBytes/2))
+			Expect(OutputMemory()).To(BeNumerically("<=", config.MaxOutputMemory))
+		})
+
+		It("keeps lines when its slots alone are over budget", func() {
+			addLines(1, 10*minOutputLines)
+			out.lock.RLock()
+			slots := int64(len(out.lines) * lineOverhead)
+			out.lock.RUnlock()
+
+			config.MaxOutputMemory = OutputMemory() - int64(out.size) - slots/4
+			addLines(2, 1)
+
+			lines, _ := out.Size()
+			Expect(lines).To(BeNumerically(">", minOutputLines))
+			Expect(overBudget()).To(BeFalse())
+		})
+
+		It("drops old lines when over the server-wide budget", func() {
+			addLines(1, 10)
+			config.MaxOutputMemory = OutputMemory()
+
+			addLines(2, 5)
+			Expect(overBudget()).To(BeFalse())
+
+			lines, _, _, _ := out.Get(-5, 0, 5)
+			Expect(texts(lines)).To(Equal([]string{"2-0", "2-1", "2-2", "2-3", "2-4"}))
+		})
+	})
+
+	Describe("rate limit", func() {
+		BeforeEach(func() {
+			out.pid = 1
+			out.rateLimit = 3
+		})
+
+		AfterEach(func() {
+			out.release()
+		})
+
+		It("drops lines over the limit, then notes how many", func() {
+			out.lock.Lock()
+			for i := 0; i < 5; i++ {
+				out.addLimited(OutputLine{Pid: 1, Line: fmt.Sprintf("1-%d", i)})
+			}
+
+			// Start the next second
+			out.rateWindow = out.rateWindow.Add(-time.Second)
+			out.addLimited(OutputLine{Pid: 1, Line: "1-5"})
+			out.lock.Unlock()
+
+			lines, _, _, _ := out.Get(0, 0, 10)
+			Expect(texts(lines)).To(Equal([]string{
+				"1-0", "1-1", "1-2",
+				"[bento] dropped 2 lines over the output-rate-limit of 3 lines/s",
+				"1-5",
+			}))
+		})
+	})
+
+	Describe("stream limits", func() {
+		BeforeEach(func() {
+			out.pid = 1
+			out.streamMax = [2]int{30, 0}
+		})
+
+		AfterEach(func() {
+			out.release()
+		})
+
+		It("keeps stderr lines through a flood of stdout", func() {
+			out.lock.Lock()
+			out.add(OutputLine{Pid: 1, Stderr: true, Line: "error"})
+			out.lock.Unlock()
+
+			addLines(1, 1000)
+
+			// 30 bytes fits 6 of the last stdout lines
+			lines, _, nextIndex, _ := out.Get(-10, 0, 10)
+			Expect(texts(lines)).To(Equal([]string{"error", "1-994", "1-995", "1-996", "1-997", "1-998", "1-999"}))
+			Expect(nextIndex).To(Equal(1001))
+
+			lines, _, _, _ = out.Get(0, 1, 2)
+			Expect(texts(lines)).To(Equal([]string{"error", "1-994"}))
+
+			count, _ := out.Size()
+			Expect(count).To(BeNumerically("<", 2*minOutputLines))
+		})
+	})
+
+	Describe("retention", func() {
+		BeforeEach(func() {
+			out.pid = 1
+			out.retention = time.Hour
+		})
+
+		AfterEach(func() {
+			out.release()
+		})
+
+		It("drops lines older than it", func() {
+			now := time.Now()
+			out.lock.Lock()
+			out.add(OutputLine{Pid: 1, Line: "old", Time: now.Add(-2 * time.Hour)})
+			out.add(OutputLine{Pid: 1, Line: "older than it by now", Time: now.Add(-59 * time.Minute)})
+			out.add(OutputLine{Pid: 1, Line: "new", Time: now})
+			out.lock.Unlock()
+
+			lines, _, _, _ := out.Get(-10, 0, 10)
+			Expect(texts(lines)).To(Equal([]string{"older than it by now", "new"}))
+
+			out.lock.Lock()
+			out.dropExpired(now.Add(2 * time.Minute))
+			out.lock.Unlock()
+
+			lines, _, nextIndex, _ := out.Get(-10, 0, 10)
+			Expect(texts(lines)).To(Equal([]string{"new"}))
+			Expect(nextIndex).To(Equal(3))
+		})
+
+		It("drops everything from a quiet service", func() {
+			out.lock.Lock()
+			out.add(OutputLine{Pid: 1, Line: "old", Time: time.Now().Add(-2 * time.Hour)})
+			out.lock.Unlock()
+
+			out.Expire()
+
+			count, _ := out.Size()
+			Expect(count).To(Equal(0))
+		})
+	})
+
+	Describe("PackLines()", func() {
+		It("round-trips lines", func() {
+			now := time.Now()
+			lines := []OutputLine{
+				{Pid: 10, Line: "first", Time: now, Index: 5},
+				{Pid: 10, Stderr: true, Line: "", Time: now.Add(time.Millisecond), Index: 6},
+				{Pid: 11, Line: "ünïcode\x00bytes", Time: now.Add(-time.Second), Index: 42},
+				{Pid: 11, Line: "no time", Index: 43},
+			}
+
+			unpacked, err := UnpackLines(PackLines(lines))
+			Expect(err).To(BeNil())
+			Expect(unpacked).To(HaveLen(len(lines)))
+			for i, line := range unpacked {
+				Expect(line.Pid).To(Equal(lines[i].Pid))
+				Expect(line.Stderr).To(Equal(lines[i].Stderr))
+				Expect(line.Line).To(Equal(lines[i].Line))
+				Expect(line.Index).To(Equal(lines[i].Index))
+				Expect(line.Time.Equal(lines[i].Time)).To(BeTrue())
+			}
+		})
+
+		It("fails on truncated data", func() {
+			data := PackLines([]OutputLine{{Pid: 1, Line: "some text", Time: time.Now()}})
+			_, err := UnpackLines(data[:4])
+			Expect(err).NotTo(BeNil())
+		})
+	})
+})
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// checkPortsFree makes sure nothing's listening on ports a service is going
+// to, so it fails to start saying who has them, instead of crash-looping on
+// a bind error.
+func checkPortsFree(ports []int) error {
+	for _, port := range ports {
+		if portFree(port) {
+			continue
+		}
+
+		if pid, program := portOwner(port); pid != 0 {
+			return fmt.Errorf("Port %d is already in use by pid %d (%s)", port, pid, program)
+		}
+		return fmt.Errorf("Port %d is already in use", port)
+	}
+
+	return nil
+}
+
+// portFree checks if a port can be listened on, on all interfaces and on
+// localhost, since a process on either keeps a service from binding the
+// other on some platforms. Other errors, like not being allowed to bind a
+// low port, are left for the service to run into.
+func portFree(port int) bool {
+	for _, address := range []string{fmt.Sprintf(":%d", port), fmt.Sprintf("127.0.0.1:%d", port)} {
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			if addrInUse(err) {
+				return false
+			}
+			continue
+		}
+		listener.Close()
+	}
+
+	return true
+}
+
+// addrInUse is true if an error from listening is from the address already
+// being in use.
+func addrInUse(err error) bool {
+	if opErr, ok := err.(*net.OpError); ok {
+		err = opErr.Err
+	}
+	if sysErr, ok := err.(*os.SyscallError); ok {
+		err = sysErr.Err
+	}
+	return err == syscall.EADDRINUSE
+}
@@ -0,0 +1,32 @@
+//go:build darwin
+// +build darwin
+
+package service
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// portOwner finds the process listening on a port with lsof, which prints
+// fields on their own lines, like "p1234" and "cpostgres". It can only see
+// other users' processes as root, so it gives 0 for theirs.
+func portOwner(port int) (int, string) {
+	out, err := exec.Command("lsof", "-nP", fmt.Sprintf("-iTCP:%d", port), "-sTCP:LISTEN", "-Fpc").Output()
+	if err != nil {
+		return 0, ""
+	}
+
+	pid, program := 0, ""
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, "p") && pid == 0 {
+			pid, _ = strconv.Atoi(line[1:])
+		} else if strings.HasPrefix(line, "c") && program == "" {
+			program = line[1:]
+		}
+	}
+
+	return pid, program
+}
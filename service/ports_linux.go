@@ -0,0 +1,83 @@
+//go:build linux
+// +build linux
+
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// tcpListenState is the state of a listening socket in /proc/net/tcp
+const tcpListenState = "0A"
+
+// portOwner finds the process listening on a port, from its socket's inode in
+// /proc/net/tcp, and which process has that socket open in /proc/*/fd. Only
+// root can see other users' fds, so it gives 0 for their processes.
+func portOwner(port int) (int, string) {
+	inodes := make(map[string]bool)
+	for _, table := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		for _, inode := range listeningInodes(table, port) {
+			inodes[fmt.Sprintf("socket:[%s]", inode)] = true
+		}
+	}
+	if len(inodes) == 0 {
+		return 0, ""
+	}
+
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return 0, ""
+	}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		fdDir := path.Join("/proc", entry.Name(), "fd")
+		fds, err := ioutil.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			if link, err := os.Readlink(path.Join(fdDir, fd.Name())); err == nil && inodes[link] {
+				comm, _ := ioutil.ReadFile(path.Join("/proc", entry.Name(), "comm"))
+				return pid, strings.TrimSpace(string(comm))
+			}
+		}
+	}
+
+	return 0, ""
+}
+
+// listeningInodes gets the inodes of sockets listening on a port from a table
+// like /proc/net/tcp, where lines are like
+// "0: 00000000:1538 00000000:0000 0A ... uid timeout inode ...", with
+// addresses & ports in hex.
+func listeningInodes(table string, port int) []string {
+	file, err := os.Open(table)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	suffix := fmt.Sprintf(":%04X", port)
+
+	var inodes []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || fields[3] != tcpListenState || !strings.HasSuffix(fields[1], suffix) {
+			continue
+		}
+		inodes = append(inodes, fields[9])
+	}
+
+	return inodes
+}
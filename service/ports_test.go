@@ -0,0 +1,37 @@
+package service
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+)
+
+var _ = Describe("checkPortsFree()", func() {
+	It("should say which process has a port that's in use", func() {
+		if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+			Skip("Can't find a port's owner on " + runtime.GOOS)
+		}
+
+		listener, err := net.Listen("tcp", ":0")
+		Expect(err).To(BeNil())
+		defer listener.Close()
+		port := listener.Addr().(*net.TCPAddr).Port
+
+		err = checkPortsFree([]int{port})
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring(fmt.Sprintf("Port %d is already in use by pid %d", port, os.Getpid())))
+	})
+
+	It("should pass a port nothing's on", func() {
+		listener, err := net.Listen("tcp", ":0")
+		Expect(err).To(BeNil())
+		port := listener.Addr().(*net.TCPAddr).Port
+		listener.Close()
+
+		Expect(checkPortsFree([]int{port})).To(BeNil())
+	})
+})
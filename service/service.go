@@ -3,22 +3,34 @@ package service
 import (
 	"bufio"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	log "github.com/inconshreveable/log15"
 
 	"github.com/heewa/bento/config"
+	"github.com/heewa/bento/plugin"
 )
 
 const (
 	shortTailLen  = 10
 	maxOutputSize = 100 * 1024 * 1024 // 100mb
+
+	// How long a new process has to keep running to be considered ready,
+	// without a ready-when condition
+	readyGracePeriod = 1 * time.Second
+
+	// How often a ready-when condition is checked
+	readyPollInterval = 200 * time.Millisecond
 )
 
+var errStartTimeout = fmt.Errorf("Didn't become ready within start-timeout")
+
 // Service represents a loaded service config. It manages running, stopping,
 // and controlling its process.
 type Service struct {
@@ -36,7 +48,72 @@ type Service struct {
 	endTime     time.Time
 	userStopped bool
 
+	// Env var set for the current process, see envMarker()
+	marker string
+
+	// Full path of the program, as resolved for the current process
+	programPath string
+
+	// Port assigned to the service by the server, passed on in PORT
+	port int
+
+	// Env vars for just the next process, over the service's own
+	nextEnv map[string]string
+
+	// True if the current process was stopped for not becoming ready within
+	// the start-timeout
+	startTimedOut bool
+
+	// True while it's in maintenance, and shouldn't be restarted on exit
+	maintenance bool
+
+	// Uid of the user that created the service, who can change it
+	owner int
+
+	// Result of the last health check of the current process
+	health Health
+
+	// Past runs of the service's process
+	runs []Run
+
+	// Times of recent automatic restarts, and when they got frequent enough
+	// to be flapping
+	restarts      []time.Time
+	flappingSince time.Time
+
+	// Socket that's listened on for the service, passed on to its process,
+	// if it's configured to have one
+	listener *os.File
+
+	// Socket its processes ping their watchdog on, if it has one, in
+	// notifySocketDir, when they last did, and if one asked to be treated as
+	// hung
+	notifySocketDir   string
+	notifySocket      *net.UnixConn
+	lastPing          time.Time
+	watchdogTriggered bool
+
+	// Where the last process's core dump went, if it dumped core
+	corePath string
+
+	// Resources the last process used
+	peakRSS uint64
+	cpuTime time.Duration
+
+	// If the last process was killed by something other than bento, and if
+	// that was the OOM killer
+	killedExternally bool
+	oomKilled        bool
+
+	// Pid of the current process & which run of the service it is, counting
+	// from 1, for context in logs. Accessed atomically, without the state
+	// lock, since it can be held while logging.
+	logPid    int64
+	logRunID  int64
+	lastRunID int64
+
 	Output output
+	usage  usageHistory
 	log    log.Logger
 }
 
@@ -49,13 +126,23 @@ func New(conf config.Service) (*Service, error) {
 	close(exitChan)
 	startChan := make(chan interface{})
 
-	return &Service{
+	s := &Service{
 		Conf:      conf,
 		startChan: startChan,
 		exitChan:  exitChan,
+		owner:     os.Getuid(),
+
+		notifySocketDir: config.NotifySocketDir,
+	}
 
-		log: log.New("service", conf.Name),
-	}, nil
+	// Tag every log line about the service with what it is, and which
+	// process of it, so its history can be pulled out of the server's log.
+	s.log = log.New(
+		"service", conf.Name,
+		"pid", log.Lazy{Fn: func() int64 { return atomic.LoadInt64(&s.logPid) }},
+		"run-id", log.Lazy{Fn: func() int64 { return atomic.LoadInt64(&s.logRunID) }})
+
+	return s, nil
 }
 
 // Info gets info about the service
@@ -63,12 +150,16 @@ func (s *Service) Info() Info {
 	s.stateLock.RLock()
 	defer s.stateLock.RUnlock()
 
+	// A copy, so the conf can still be changed while the info's in use
+	conf := s.Conf
 	info := Info{
-		Service: &s.Conf,
+		Service: &conf,
 	}
 
 	info.Running = s.Running()
 	info.Pid = s.Pid()
+	info.Port = s.port
+	info.Owner = s.owner
 
 	info.StartTime = s.startTime
 	info.EndTime = s.endTime
@@ -82,12 +173,36 @@ func (s *Service) Info() Info {
 	// - a service stopped by a user is succesfull, regardless of result
 	// - a service that's in the restart watchlist is failed if not running
 	// - otherwise use exit status
-	info.Succeeded = !info.Running && (s.userStopped || (!s.Conf.RestartOnExit && s.state != nil && s.state.Success()))
+	info.Succeeded = !info.Running && !s.startTimedOut && (s.userStopped || (!s.Conf.RestartOnExit && s.state != nil && s.state.Success()))
+	info.StartTimedOut = s.startTimedOut
+	if !info.Running && s.state != nil {
+		info.ExitCode = exitCode(s.state)
+		if sig, ok := killedBySignal(s.state); ok {
+			info.Signal = config.SignalName(sig)
+		}
+		info.CorePath = s.corePath
+		info.PeakRSS, info.CPUTime = s.peakRSS, s.cpuTime
+		info.KilledExternally = s.killedExternally
+		info.OOMKilled = s.oomKilled
+	}
+	info.Maintenance = s.maintenance
+	if s.recentRestarts(time.Now()) >= config.FlappingRestarts {
+		info.FlappingSince = s.flappingSince
+	}
+	if info.Running {
+		info.Health = s.health
+	}
+	if info.Running {
+		info.NextRestart = s.Conf.NextScheduledRestart(time.Now())
+	}
 
-	tail, _, _, _ := s.Output.GetTail(info.Pid, 5)
-	info.Tail = make([]string, 0, len(tail))
-	for _, line := range tail {
-		info.Tail = append(info.Tail, line.Line)
+	// Only the tray's tooltips use the tail
+	if config.TrayTooltip == config.TrayTooltipOutput {
+		tail, _, _, _ := s.Output.GetTail(info.Pid, config.TrayTooltipLines)
+		info.Tail = make([]string, 0, len(tail))
+		for _, line := range tail {
+			info.Tail = append(info.Tail, line.Line)
+		}
 	}
 
 	return info
@@ -100,6 +215,16 @@ func (s *Service) Start(updates chan<- Info) error {
 	}
 	s.log.Debug("Starting service")
 
+	// Fail early if something else has a port the service binds. Restarts
+	// with an overlap skip this, since the old process still has them.
+	ports := s.Conf.Ports
+	if port := s.Port(); port != 0 {
+		ports = append([]int{port}, ports...)
+	}
+	if err := checkPortsFree(ports); err != nil {
+		return err
+	}
+
 	// Update right after starting, but before we can race with the end-watcher
 	defer func() {
 		select {
@@ -119,17 +244,152 @@ func (s *Service) Start(updates chan<- Info) error {
 	s.endTime = time.Time{}
 	s.userStopped = false
 
+	return s.startProcess(updates, true)
+}
+
+// Restart stops & starts the service. With the overlap restart strategy, a
+// new process is started first, and the old one is only stopped once the new
+// one is ready, so there's no gap between them.
+func (s *Service) Restart(updates chan<- Info, escalationInterval time.Duration) error {
+	if s.Conf.RestartStrategy != config.RestartOverlap || !s.Running() {
+		if err := s.Stop(escalationInterval, nil); err != nil {
+			return err
+		}
+		return s.Start(updates)
+	}
+	s.log.Debug("Restarting service with an overlap")
+
+	if escalationInterval == 0 {
+		escalationInterval = s.Conf.StopTimeout
+	}
+	if escalationInterval == 0 {
+		escalationInterval = config.EscalationInterval
+	}
+
+	// Replace the current process with a new one, but keep track of the old
+	// one, which is left running until the new one is ready.
+	s.stateLock.Lock()
+	oldProcess, oldExit, oldMarker, oldStartTime := s.process, s.exitChan, s.marker, s.startTime
+	oldRunID := atomic.LoadInt64(&s.logRunID)
+	// The restart handles the new process not becoming ready itself
+	if err := s.startProcess(updates, false); err != nil {
+		s.stateLock.Unlock()
+		return err
+	}
+	newPid, newExit, newMarker := s.process.Pid, s.exitChan, s.marker
+	s.stateLock.Unlock()
+
+	select {
+	case updates <- s.Info():
+	default:
+	}
+
+	if err := s.waitReady(newExit); err != nil {
+		s.log.Warn("New process didn't become ready, keeping old one", "pid", newPid, "err", err)
+
+		select {
+		case <-newExit:
+		default:
+			if err := s.stopProcess(newPid, newExit, newMarker, escalationInterval, nil); err != nil {
+				s.log.Warn("Failed to stop new process that didn't become ready", "pid", newPid, "err", err)
+			}
+		}
+
+		// Go back to the old process, like nothing happened
+		s.stateLock.Lock()
+		s.process = oldProcess
+		s.state = nil
+		s.exitChan = oldExit
+		s.marker = oldMarker
+		s.startTime = oldStartTime
+		s.endTime = time.Time{}
+		atomic.StoreInt64(&s.logPid, int64(oldProcess.Pid))
+		atomic.StoreInt64(&s.logRunID, oldRunID)
+		select {
+		case <-s.startChan:
+		default:
+			close(s.startChan)
+		}
+		s.Output.refollow(oldProcess.Pid)
+		s.stateLock.Unlock()
+
+		return fmt.Errorf("Failed to restart service, new process didn't become ready: %v", err)
+	}
+
+	if err := s.stopProcess(oldProcess.Pid, oldExit, oldMarker, escalationInterval, nil); err != nil {
+		return fmt.Errorf("Started a new process, but failed to stop the old one (pid %d): %v", oldProcess.Pid, err)
+	}
+
+	return nil
+}
+
+// startProcess starts a process for the service, replacing the current one in
+// its state, if any. If enforceTimeout is true, it's stopped if it doesn't
+// become ready within the start-timeout. Must be called with the state lock
+// held.
+func (s *Service) startProcess(updates chan<- Info, enforceTimeout bool) error {
 	programPath, err := exec.LookPath(s.Conf.Program)
 	if err != nil {
 		return err
 	}
 
+	// Each process gets its own marker, so an old process's descendants can
+	// be told apart from a new one's during an overlapping restart.
+	marker := s.envMarker()
+
 	var envItems []string
 	for key, value := range s.Conf.Env {
+		if _, ok := s.nextEnv[key]; !ok {
+			envItems = append(envItems, fmt.Sprintf("%s=%s", key, value))
+		}
+	}
+	for key, value := range s.nextEnv {
 		envItems = append(envItems, fmt.Sprintf("%s=%s", key, value))
 	}
+	s.nextEnv = nil
+	if s.port != 0 {
+		envItems = append(envItems, fmt.Sprintf("PORT=%d", s.port))
+	}
+	envItems = append(envItems, marker)
+
+	// Some settings need a shell to set them up before the program replaces
+	// it
+	var wrapper string
+	var extraFiles []*os.File
+
+	// Pass a listening socket like systemd's socket activation does
+	if s.Conf.Listen != "" {
+		listener, err := s.listenFile()
+		if err != nil {
+			return err
+		}
+
+		wrapper = listenPidWrapper
+		extraFiles = []*os.File{listener}
+		envItems = append(envItems, fmt.Sprintf("LISTEN_FDS=%d", len(extraFiles)), "LISTEN_FDNAMES="+s.Conf.Name)
+	}
+
+	if s.Conf.CoreDumps {
+		if wrapper == "" {
+			wrapper = execWrapper
+		}
+		wrapper = coreDumpWrapper + wrapper
+	}
 
 	cmd := exec.Command(programPath, s.Conf.Args...)
+	if wrapper != "" {
+		cmd = exec.Command("/bin/sh", append([]string{"-c", wrapper, programPath}, s.Conf.Args...)...)
+	}
+	cmd.ExtraFiles = extraFiles
+
+	if s.Conf.Watchdog > 0 {
+		watchdogEnv, err := s.watchdogEnv()
+		if err != nil {
+			return err
+		}
+		envItems = append(envItems, watchdogEnv...)
+	}
+
 	cmd.Dir = s.Conf.Dir
 	cmd.Env = envItems
 
@@ -159,52 +419,190 @@ func (s *Service) Start(updates chan<- Info) error {
 	if err := cmd.Start(); err != nil {
 		return err
 	}
+	exitChan := make(chan interface{})
 	s.startTime = time.Now()
-	s.exitChan = make(chan interface{})
+	s.endTime = time.Time{}
+	s.state = nil
+	s.exitChan = exitChan
 	s.process = cmd.Process
+	s.marker = marker
+	s.startTimedOut = false
+	s.programPath = programPath
+	s.health = HealthUnknown
+	s.watchdogTriggered = false
+
+	runID := atomic.AddInt64(&s.lastRunID, 1)
+	atomic.StoreInt64(&s.logPid, int64(s.process.Pid))
+	atomic.StoreInt64(&s.logRunID, runID)
 
-	go s.sendPeriodicUpdates(updates)
+	// Already checked when the conf was loaded
+	rateLimit, _ := config.ParseRateLimit(s.Conf.OutputRateLimit)
+	maxStdout, _ := config.ParseSize(s.Conf.MaxStdout)
+	maxStderr, _ := config.ParseSize(s.Conf.MaxStderr)
 
 	// Read from stdout/err & throw in a tail-array.
-	outputDone := s.Output.followNewProcess(s.process.Pid, stdout, stderr)
-	go s.watchForExit(cmd, updates, outputDone)
+	outputDone := s.Output.followNewProcess(s.process.Pid, stdout, stderr, rateLimit, maxStdout, maxStderr, s.Conf.OutputRetention)
+	go s.watchForExit(cmd, s.startTime, int(runID), updates, outputDone, exitChan)
 
-	close(s.startChan)
+	if enforceTimeout && s.Conf.StartTimeout > 0 {
+		go s.enforceStartTimeout(cmd.Process.Pid, exitChan, marker)
+	}
+
+	// During an overlapping restart, it's already started
+	select {
+	case <-s.startChan:
+	default:
+		close(s.startChan)
+	}
 
-	s.log.Info("Started service", "pid", s.process.Pid)
+	s.log.Info("Started service")
 
 	return nil
 }
 
-// Stop stops running the service
-func (s *Service) Stop(escalationInterval time.Duration) (err error) {
+// WaitReady waits for the service's current process to be ready, returning
+// an error if it exits first.
+func (s *Service) WaitReady() error {
+	if !s.Running() {
+		return fmt.Errorf("Service isn't running.")
+	}
+
+	return s.waitReady(s.GetExitChan())
+}
+
+// waitReady waits for a newly started process to be ready, which is when its
+// ready-when condition is met, or by default, when it's still running after a
+// grace period. If it takes longer than the service's start-timeout, it
+// returns errStartTimeout.
+func (s *Service) waitReady(exitChan <-chan interface{}) error {
+	var timeout <-chan time.Time
+	if s.Conf.StartTimeout > 0 {
+		timeout = time.After(s.Conf.StartTimeout)
+	}
+
+	if s.Conf.ReadyWhen == nil {
+		select {
+		case <-exitChan:
+			return fmt.Errorf("Exited before becoming ready")
+		case <-timeout:
+			return errStartTimeout
+		case <-time.After(readyGracePeriod):
+			return nil
+		}
+	}
+
+	for {
+		if s.readyConditionMet() {
+			s.log.Debug("Service is ready", "port", s.Conf.ReadyWhen.Port)
+			return nil
+		}
+
+		select {
+		case <-exitChan:
+			return fmt.Errorf("Exited before becoming ready")
+		case <-timeout:
+			return errStartTimeout
+		case <-time.After(readyPollInterval):
+		}
+	}
+}
+
+// readyConditionMet returns true if the service's ready-when condition is
+// currently met.
+func (s *Service) readyConditionMet() bool {
+	if s.Conf.ReadyWhen.Plugin != "" {
+		return plugin.Probe(s.Conf.ReadyWhen.Plugin, s.Conf.Name, s.Pid(), s.Port())
+	}
+
+	address := fmt.Sprintf("localhost:%d", s.Conf.ReadyWhen.Port)
+	conn, err := net.DialTimeout("tcp", address, readyPollInterval)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+
+	return true
+}
+
+// CheckHealth checks if a running service still meets its ready-when
+// condition. Without one, its health is unknown.
+func (s *Service) CheckHealth() Health {
+	health := HealthUnknown
+	if s.Running() && s.Conf.ReadyWhen != nil {
+		health = Unhealthy
+		if s.readyConditionMet() {
+			health = Healthy
+		}
+	}
+
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	if health != s.health {
+		s.log.Info("Service health changed", "health", health)
+	}
+	s.health = health
+
+	return health
+}
+
+// enforceStartTimeout stops a new process that doesn't become ready within
+// the service's start-timeout, marking it as a failed start, instead of
+// leaving it running half-broken.
+func (s *Service) enforceStartTimeout(pid int, exitChan <-chan interface{}, marker string) {
+	if err := s.waitReady(exitChan); err != errStartTimeout {
+		return
+	}
+
+	// Make sure it's still the current process
+	s.stateLock.Lock()
+	if s.process == nil || s.process.Pid != pid {
+		s.stateLock.Unlock()
+		return
+	}
+	s.startTimedOut = true
+	s.stateLock.Unlock()
+
+	s.log.Warn("Service didn't become ready in time, stopping it", "pid", pid, "start-timeout", s.Conf.StartTimeout)
+	if err := s.stopProcess(pid, exitChan, marker, config.EscalationInterval, nil); err != nil {
+		s.log.Warn("Failed to stop service that didn't become ready", "pid", pid, "err", err)
+	}
+}
+
+// StopProgress is told about each step taken to stop a service, like sending
+// it a signal
+type StopProgress func(step string)
+
+func (p StopProgress) report(format string, args ...interface{}) {
+	if p != nil {
+		p(fmt.Sprintf(format, args...))
+	}
+}
+
+// Stop stops running the service. If progress isn't nil, it's told about each
+// step along the way.
+func (s *Service) Stop(escalationInterval time.Duration, progress StopProgress) (err error) {
 	if !s.Running() {
 		s.log.Debug("Service already stopped")
 		return nil
 	}
 	s.log.Debug("Stopping service")
 
+	if escalationInterval == 0 {
+		escalationInterval = s.Conf.StopTimeout
+	}
 	if escalationInterval == 0 {
 		escalationInterval = config.EscalationInterval
 	}
 
+	s.stateLock.RLock()
+	exitChan, marker := s.exitChan, s.marker
+	s.stateLock.RUnlock()
+
 	pid := s.Pid()
 	if pid == 0 {
 		s.log.Warn("Failed to get pid to stop service")
-		return fmt.Errorf("Failed to get service's pid to stop", "service", s.Conf.Name)
-	}
-
-	// Try a sequence increasingly urgent signals
-	signals := []syscall.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL}
-
-	// In case killing the process itself fails, like if one of its child
-	// processes is ignoring signals from its parent, get the PGID (process
-	// group id) that we had the parent (the process we started) create.
-	pids := []int{pid}
-	if pgid, err := syscall.Getpgid(pid); err != nil {
-		s.log.Warn("Failed to get pgid in case of a failed service stop", "pid", pid, "err", err)
-	} else {
-		pids = append(pids, -pgid)
+		return fmt.Errorf("Failed to get service's pid to stop (%s)", s.Conf.Name)
 	}
 
 	// The `userStopped` field should be set iff the process exited because
@@ -235,19 +633,73 @@ func (s *Service) Stop(escalationInterval time.Duration) (err error) {
 		}
 	}()
 
-	for _, pid := range pids {
+	return s.stopProcess(pid, exitChan, marker, escalationInterval, progress)
+}
+
+// stopProcess stops one of the service's processes, escalating signals until
+// its exitChan is closed.
+func (s *Service) stopProcess(pid int, exitChan <-chan interface{}, marker string, escalationInterval time.Duration, progress StopProgress) error {
+	// Try a sequence increasingly urgent signals
+	signals := []syscall.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL}
+
+	// In case killing the process itself fails, like if one of its child
+	// processes is ignoring signals from its parent, get the PGID (process
+	// group id) that we had the parent (the process we started) create.
+	// Unless the service wants its children left alone.
+	pids := []int{pid}
+	if s.Conf.KillMode == config.KillProcess {
+		s.log.Debug("Only stopping service's process, not its group")
+	} else if pgid, err := syscall.Getpgid(pid); err != nil {
+		s.log.Warn("Failed to get pgid in case of a failed service stop", "pid", pid, "err", err)
+	} else {
+		pids = append(pids, -pgid)
+	}
+
+	// Descendants might have left the process group, so find them while
+	// they're still connected to the service's process, to stop them after
+	// it's gone.
+	var descendants []int
+	if s.Conf.KillMode != config.KillProcess {
+		descendants = findDescendants(pid, marker)
+	}
+
+	for i, target := range pids {
 		for _, sig := range signals {
-			s.log.Debug("Sending service's proc signal", "signal", sig, "pid", pid)
-			if err := syscall.Kill(pid, sig); err != nil {
-				s.log.Warn("Failed to send signal to service", "signal", sig, "pid", pid, "err", err)
+			s.log.Debug("Sending service's proc signal", "signal", sig, "pid", target)
+			if err := syscall.Kill(target, sig); err != nil {
+				s.log.Warn("Failed to send signal to service", "signal", sig, "pid", target, "err", err)
 				return err
 			}
+			if target < 0 {
+				progress.report("sent %s to process group %d", config.SignalName(sig), -target)
+			} else {
+				progress.report("sent %s to pid %d", config.SignalName(sig), target)
+			}
 
 			// Wait a bit for process to die
 			select {
 			case <-time.After(escalationInterval):
-			case <-s.exitChan:
-				s.log.Info("Stopped service")
+			case <-exitChan:
+				s.log.Info("Stopped service", "pid", pid)
+				progress.report("pid %d exited", pid)
+				if s.Conf.KillMode != config.KillProcess {
+					s.stopDescendants(pid, descendants, marker, escalationInterval, progress)
+				}
+				return nil
+			}
+		}
+
+		// Before resorting to the group, try descendants, since ones that
+		// left the group can keep the service from seeming stopped, like by
+		// holding onto its output.
+		if i == 0 && s.Conf.KillMode != config.KillProcess {
+			s.stopDescendants(pid, descendants, marker, escalationInterval, progress)
+
+			select {
+			case <-time.After(escalationInterval):
+			case <-exitChan:
+				s.log.Info("Stopped service", "pid", pid)
+				progress.report("pid %d exited", pid)
 				return nil
 			}
 		}
@@ -257,6 +709,259 @@ func (s *Service) Stop(escalationInterval time.Duration) (err error) {
 	return fmt.Errorf("Failed to stop service")
 }
 
+// Reload sends the service's process its reload signal, for programs that can
+// reload their config without restarting
+func (s *Service) Reload() error {
+	pid := s.Pid()
+	if !s.Running() || pid == 0 {
+		return fmt.Errorf("Service isn't running.")
+	}
+
+	sig, err := config.ParseSignal(s.Conf.ReloadSignal)
+	if err != nil {
+		return err
+	}
+
+	s.log.Info("Sending service's proc reload signal", "signal", sig, "pid", pid)
+	return syscall.Kill(pid, sig)
+}
+
+// RecordRestart notes an automatic restart of the service, to detect it
+// flapping. It returns true if this restart started it flapping, which only
+// happens once, until it calms down.
+func (s *Service) RecordRestart() bool {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	now := time.Now()
+
+	// Forget old restarts, which might mean it's not flapping anymore
+	cutoff := now.Add(-config.FlappingWindow)
+	for len(s.restarts) > 0 && s.restarts[0].Before(cutoff) {
+		s.restarts = s.restarts[1:]
+	}
+	if len(s.restarts) < config.FlappingRestarts {
+		s.flappingSince = time.Time{}
+	}
+
+	s.restarts = append(s.restarts, now)
+
+	if len(s.restarts) >= config.FlappingRestarts && s.flappingSince.IsZero() {
+		s.flappingSince = now
+		return true
+	}
+	return false
+}
+
+// recentRestarts counts automatic restarts within the flapping window. Must
+// be called with the state lock held.
+func (s *Service) recentRestarts(now time.Time) int {
+	cutoff := now.Add(-config.FlappingWindow)
+
+	count := 0
+	for _, restart := range s.restarts {
+		if !restart.Before(cutoff) {
+			count++
+		}
+	}
+	return count
+}
+
+// InMaintenance returns true if the service is in maintenance, when it
+// shouldn't be restarted on exit.
+func (s *Service) InMaintenance() bool {
+	s.stateLock.RLock()
+	defer s.stateLock.RUnlock()
+
+	return s.maintenance
+}
+
+// SetMaintenance puts the service in or out of maintenance.
+func (s *Service) SetMaintenance(maintenance bool) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	s.maintenance = maintenance
+}
+
+// UpdateConf changes the service's conf in place, like for changes a reload
+// can make while it's running, without racing with anything reading it.
+func (s *Service) UpdateConf(update func(conf *config.Service)) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	update(&s.Conf)
+}
+
+// SetOwner sets the uid of the user that created the service, which is the
+// server's own user by default.
+func (s *Service) SetOwner(uid int) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	s.owner = uid
+}
+
+// SetNotifySocketDir sets the dir of the socket the service's processes ping
+// their watchdog on, which is config.NotifySocketDir by default. It takes
+// effect the next time the service is started with a watchdog.
+func (s *Service) SetNotifySocketDir(dir string) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	s.notifySocketDir = dir
+}
+
+// Owner gets the uid of the user that created the service.
+func (s *Service) Owner() int {
+	s.stateLock.RLock()
+	defer s.stateLock.RUnlock()
+
+	return s.owner
+}
+
+// ProgramPath gets the full path the service's program resolved to when it
+// was last started, or empty if it hasn't been.
+func (s *Service) ProgramPath() string {
+	s.stateLock.RLock()
+	defer s.stateLock.RUnlock()
+
+	return s.programPath
+}
+
+// Descendants gets the pids of all processes under the service's running
+// process, including ones that left its process group, where supported.
+func (s *Service) Descendants() []int {
+	s.stateLock.RLock()
+	marker := s.marker
+	s.stateLock.RUnlock()
+
+	pid := s.Pid()
+	if !s.Running() || pid == 0 {
+		return nil
+	}
+
+	return findDescendants(pid, marker)
+}
+
+// Kill immediately kills the service's process with SIGKILL, along with its
+// process group and descendants, unless its kill mode is process. It doesn't
+// wait for it to exit.
+func (s *Service) Kill() error {
+	s.stateLock.RLock()
+	marker := s.marker
+	s.stateLock.RUnlock()
+
+	pid := s.Pid()
+	if !s.Running() || pid == 0 {
+		return fmt.Errorf("Service isn't running.")
+	}
+
+	pids := []int{pid}
+	if s.Conf.KillMode != config.KillProcess {
+		if pgid, err := syscall.Getpgid(pid); err == nil {
+			pids = append(pids, -pgid)
+		}
+		pids = append(pids, findDescendants(pid, marker)...)
+	}
+
+	s.log.Warn("Killing service", "pid", pid)
+	for _, target := range pids {
+		if err := syscall.Kill(target, syscall.SIGKILL); err != nil && target == pid {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stopDescendants stops processes left over from a service's process, both
+// ones found before it was stopped, and ones orphaned since.
+func (s *Service) stopDescendants(pid int, descendants []int, marker string, escalationInterval time.Duration, progress StopProgress) {
+	// Processes orphaned by the service's process exiting are only findable
+	// by marker now
+	remaining := make(map[int]bool)
+	for _, child := range append(descendants, findDescendants(pid, marker)...) {
+		remaining[child] = true
+	}
+
+	for _, sig := range []syscall.Signal{syscall.SIGTERM, syscall.SIGKILL} {
+		for child := range remaining {
+			if err := syscall.Kill(child, sig); err != nil {
+				// Already gone
+				delete(remaining, child)
+			} else {
+				s.log.Debug("Sent leftover descendant of service a signal", "signal", sig, "pid", child)
+			}
+		}
+		if len(remaining) == 0 {
+			return
+		}
+		progress.report("sent %s to %d leftover descendants", config.SignalName(sig), len(remaining))
+
+		// Wait a bit for them to die
+		deadline := time.Now().Add(escalationInterval)
+		for len(remaining) > 0 && time.Now().Before(deadline) {
+			time.Sleep(50 * time.Millisecond)
+			for child := range remaining {
+				if syscall.Kill(child, 0) != nil {
+					delete(remaining, child)
+				}
+			}
+		}
+	}
+
+	if len(remaining) > 0 {
+		s.log.Warn("Failed to stop some descendants of service", "num", len(remaining))
+	}
+}
+
+// envMarker makes an env var to set for a new process of the service, which
+// its descendants inherit, so they can be found even if they're orphaned.
+func (s *Service) envMarker() string {
+	return fmt.Sprintf("BENTO_SERVICE=%d/%s/%d", os.Getpid(), s.Conf.Name, time.Now().UnixNano())
+}
+
+// Release frees resources held by a stopped service that's been removed,
+// like its output. Its output can't be used after.
+func (s *Service) Release() {
+	s.Output.release()
+
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	s.closeListener()
+	s.closeNotifySocket()
+}
+
+// Port gets the port assigned to the service, or 0 if it doesn't have one
+func (s *Service) Port() int {
+	s.stateLock.RLock()
+	defer s.stateLock.RUnlock()
+
+	return s.port
+}
+
+// SetPort assigns a port to the service, for the next time it starts
+func (s *Service) SetPort(port int) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	s.port = port
+}
+
+// SetNextEnv sets an env var for only the next time it starts, over one
+// from its conf
+func (s *Service) SetNextEnv(key, value string) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	if s.nextEnv == nil {
+		s.nextEnv = make(map[string]string)
+	}
+	s.nextEnv[key] = value
+}
+
 // Wait blocks until it stops running
 func (s *Service) Wait() error {
 	<-s.exitChan
@@ -316,25 +1021,9 @@ func (s *Service) signal(sig os.Signal) error {
 
 // Internal goroutines - not regular helper fns
 
-// sendPeriodicUpdates will send info about service to listeners while it's running
-func (s *Service) sendPeriodicUpdates(updates chan<- Info) {
-	tick := time.Tick(3 * time.Second)
-	for {
-		select {
-		case <-s.exitChan:
-			return
-		case <-tick:
-			select {
-			case updates <- s.Info():
-			default:
-			}
-		}
-	}
-}
-
 // watchForExit will wait for both outputs to finish, then wait for the
 // process to end, before closing the exitChan to signal everyone else
-func (s *Service) watchForExit(cmd *exec.Cmd, updates chan<- Info, outputDone *sync.WaitGroup) {
+func (s *Service) watchForExit(cmd *exec.Cmd, startTime time.Time, runID int, updates chan<- Info, outputDone *sync.WaitGroup, exitChan chan interface{}) {
 	// Completely exhaust both outputs before waiting for the cmd to exit,
 	// cuz Wait will close the pipes before we can read everything from
 	// them.
@@ -344,6 +1033,18 @@ func (s *Service) watchForExit(cmd *exec.Cmd, updates chan<- Info, outputDone *s
 	err := cmd.Wait()
 	s.log.Info("Service exited", "program", s.Conf.Program, "err", err)
 
+	// A signal that isn't from crashing, and not from stopping it, came from
+	// outside of bento
+	sig, signaled := killedBySignal(cmd.ProcessState)
+	s.stateLock.RLock()
+	external := signaled && s.process == cmd.Process && !s.userStopped && !s.startTimedOut && !config.IsCrashSignal(sig)
+	s.stateLock.RUnlock()
+
+	// For an external kill, check the kernel's log before it has a chance to
+	// roll over, and without holding the lock
+	oomKill := external && sig == syscall.SIGKILL && oomKilled(cmd.ProcessState.Pid(), startTime)
+	peakRSS, cpuTime := s.runUsage(cmd.ProcessState)
+
 	// Update after we let go of lock
 	defer func() {
 		select {
@@ -355,12 +1056,48 @@ func (s *Service) watchForExit(cmd *exec.Cmd, updates chan<- Info, outputDone *s
 	s.stateLock.Lock()
 	defer s.stateLock.Unlock()
 
-	s.endTime = time.Now()
+	run := newRun(cmd.ProcessState, runID, startTime, time.Now())
+	run.PeakRSS, run.CPUTime = peakRSS, cpuTime
+	if run.CoreDumped {
+		run.CorePath = corePath(run.Pid, s.programPath, s.Conf.Dir)
+		s.log.Warn("Service dumped core", "core", run.CorePath)
+	}
+
+	// If the process was replaced by an overlapping restart, the service's
+	// state is about the new one now.
+	if s.process != cmd.Process {
+		run.Replaced = true
+		s.addRun(run)
+		close(exitChan)
+		return
+	}
+
+	s.endTime = run.EndTime
 	s.state = cmd.ProcessState
+	s.corePath = run.CorePath
+	s.peakRSS, s.cpuTime = run.PeakRSS, run.CPUTime
+
+	run.UserStopped = s.userStopped
+	run.StartTimedOut = s.startTimedOut
+
+	// Check again, now that it's locked, in case it was stopped meanwhile
+	if signaled && !s.userStopped && !s.startTimedOut && !config.IsCrashSignal(sig) {
+		run.KilledExternally = true
+		run.OOMKilled = oomKill
+		if oomKill {
+			s.log.Warn("Service was killed by the OOM killer")
+		} else {
+			s.log.Warn("Service was killed externally", "signal", config.SignalName(sig))
+		}
+	}
+	s.killedExternally = run.KilledExternally
+	s.oomKilled = run.OOMKilled
+
+	s.addRun(run)
 
 	// Open up startChan so it can be watched for closing
 	s.startChan = make(chan interface{})
 
 	// Close exit chan last cuz it signals other goroutines
-	close(s.exitChan)
+	close(exitChan)
 }
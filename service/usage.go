@@ -0,0 +1,187 @@
+package service
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// UsageInterval is how often resource usage of running services is
+	// sampled
+	UsageInterval = 30 * time.Second
+
+	// A day's worth of samples are kept
+	maxUsageSamples = int(24 * time.Hour / UsageInterval)
+)
+
+// UsageSample is the resource usage of a service's process, along with its
+// descendants, at some point
+type UsageSample struct {
+	Time time.Time
+	Pid  int
+
+	// Percent of a cpu used since the previous sample
+	CPU float64
+
+	// Bytes of resident memory
+	RSS uint64
+}
+
+// usageHistory is a bounded history of a service's resource usage
+type usageHistory struct {
+	lock    sync.Mutex
+	samples []UsageSample
+
+	// Total cpu time of the last sample, to get the cpu use of the next
+	lastPid  int
+	lastCPU  time.Duration
+	lastTime time.Time
+
+	// Most memory seen in samples of the last pid
+	peakRSS uint64
+}
+
+// SampleUsage records the current resource usage of the service's process
+// and its descendants, if it's running.
+func (s *Service) SampleUsage() error {
+	s.stateLock.RLock()
+	marker, startTime := s.marker, s.startTime
+	s.stateLock.RUnlock()
+
+	pid := s.Pid()
+	if !s.Running() || pid == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	cpu, rss, err := processUsage(pid)
+	if err != nil {
+		return err
+	}
+
+	// Best effort for descendants, which can come & go at any time
+	for _, child := range findDescendants(pid, marker) {
+		if childCPU, childRSS, err := processUsage(child); err == nil {
+			cpu += childCPU
+			rss += childRSS
+		}
+	}
+
+	s.usage.lock.Lock()
+	defer s.usage.lock.Unlock()
+
+	// Use since the last sample, or for a new process, since it started
+	since, sinceCPU := startTime, time.Duration(0)
+	if s.usage.lastPid == pid {
+		since, sinceCPU = s.usage.lastTime, s.usage.lastCPU
+	} else {
+		s.usage.peakRSS = 0
+	}
+	if rss > s.usage.peakRSS {
+		s.usage.peakRSS = rss
+	}
+
+	sample := UsageSample{
+		Time: now,
+		Pid:  pid,
+		RSS:  rss,
+	}
+	if elapsed := now.Sub(since); elapsed > 0 && cpu > sinceCPU {
+		sample.CPU = 100 * float64(cpu-sinceCPU) / float64(elapsed)
+	}
+
+	s.usage.lastPid, s.usage.lastCPU, s.usage.lastTime = pid, cpu, now
+
+	if len(s.usage.samples) >= maxUsageSamples {
+		s.usage.samples = append(s.usage.samples[:0], s.usage.samples[1:]...)
+	}
+	s.usage.samples = append(s.usage.samples, sample)
+
+	return nil
+}
+
+// runUsage gets the peak memory and total cpu time of a process that exited,
+// from its rusage, which only counts descendants it waited for, and from
+// samples, which count all of them, but can miss short spikes.
+func (s *Service) runUsage(state *os.ProcessState) (peakRSS uint64, cpu time.Duration) {
+	cpu = state.UserTime() + state.SystemTime()
+	if usage, ok := state.SysUsage().(*syscall.Rusage); ok {
+		peakRSS = maxRSS(usage)
+	}
+
+	s.usage.lock.Lock()
+	defer s.usage.lock.Unlock()
+
+	if s.usage.lastPid == state.Pid() {
+		if s.usage.peakRSS > peakRSS {
+			peakRSS = s.usage.peakRSS
+		}
+		if s.usage.lastCPU > cpu {
+			cpu = s.usage.lastCPU
+		}
+	}
+
+	return peakRSS, cpu
+}
+
+// Usage gets the samples of resource usage taken since a time.
+func (s *Service) Usage(since time.Time) []UsageSample {
+	s.usage.lock.Lock()
+	defer s.usage.lock.Unlock()
+
+	var samples []UsageSample
+	for _, sample := range s.usage.samples {
+		if !sample.Time.Before(since) {
+			samples = append(samples, sample)
+		}
+	}
+
+	return samples
+}
+
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+// Sparkline draws values as a line of bars, at most width long, averaging
+// neighboring values together if there are too many.
+func Sparkline(values []float64, width int) string {
+	if len(values) == 0 || width <= 0 {
+		return ""
+	}
+
+	// Average values into buckets, if there are more than fit
+	if len(values) > width {
+		buckets := make([]float64, width)
+		for i := range buckets {
+			start, end := i*len(values)/width, (i+1)*len(values)/width
+			for _, value := range values[start:end] {
+				buckets[i] += value
+			}
+			buckets[i] /= float64(end - start)
+		}
+		values = buckets
+	}
+
+	min, max := values[0], values[0]
+	for _, value := range values {
+		if value < min {
+			min = value
+		}
+		if value > max {
+			max = value
+		}
+	}
+
+	var line strings.Builder
+	for _, value := range values {
+		spark := 0
+		if max > min {
+			spark = int((value - min) / (max - min) * float64(len(sparks)-1))
+		}
+		line.WriteRune(sparks[spark])
+	}
+
+	return line.String()
+}
@@ -0,0 +1,59 @@
+//go:build darwin
+// +build darwin
+
+package service
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// processUsage gets the total cpu time used so far, and the resident memory,
+// of a process from ps, since macOS doesn't expose them without cgo.
+func processUsage(pid int) (cpu time.Duration, rss uint64, err error) {
+	out, err := exec.Command("ps", "-o", "rss=,time=", "-p", strconv.Itoa(pid)).Output()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	fields := strings.Fields(string(out))
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("Unexpected output from ps: %s", out)
+	}
+
+	kb, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Unexpected rss from ps: %v", err)
+	}
+
+	// Time is like "[[dd-]hh:]mm:ss.ss"
+	days := 0
+	clock := fields[1]
+	if i := strings.Index(clock, "-"); i >= 0 {
+		if days, err = strconv.Atoi(clock[:i]); err != nil {
+			return 0, 0, fmt.Errorf("Unexpected cpu time from ps: %v", err)
+		}
+		clock = clock[i+1:]
+	}
+	var seconds float64
+	for _, part := range strings.Split(clock, ":") {
+		value, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("Unexpected cpu time from ps: %v", err)
+		}
+		seconds = seconds*60 + value
+	}
+	seconds += float64(days) * 24 * 60 * 60
+
+	return time.Duration(seconds * float64(time.Second)), kb * 1024, nil
+}
+
+// maxRSS gets the peak resident memory from a process's rusage, which macOS
+// reports in bytes.
+func maxRSS(usage *syscall.Rusage) uint64 {
+	return uint64(usage.Maxrss)
+}
@@ -0,0 +1,69 @@
+//go:build linux
+// +build linux
+
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Linux reports cpu times in clock ticks, which are practically always 100 a
+// second, and can't be looked up without cgo.
+const clockTicksPerSecond = 100
+
+// processUsage gets the total cpu time used so far, and the resident memory,
+// of a process from /proc.
+func processUsage(pid int) (cpu time.Duration, rss uint64, err error) {
+	procPath := path.Join("/proc", strconv.Itoa(pid))
+
+	stat, err := ioutil.ReadFile(path.Join(procPath, "stat"))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// The command name can have spaces, so skip past it, leaving fields
+	// starting with the 3rd one, the state
+	fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+	if len(fields) < 13 {
+		return 0, 0, fmt.Errorf("Unexpected format of %s/stat", procPath)
+	}
+	var ticks uint64
+	for _, field := range fields[11:13] {
+		value, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("Unexpected format of %s/stat: %v", procPath, err)
+		}
+		ticks += value
+	}
+	cpu = time.Duration(ticks) * time.Second / clockTicksPerSecond
+
+	statm, err := ioutil.ReadFile(path.Join(procPath, "statm"))
+	if err != nil {
+		return 0, 0, err
+	}
+	fields = strings.Fields(string(statm))
+	if len(fields) < 2 {
+		return 0, 0, fmt.Errorf("Unexpected format of %s/statm", procPath)
+	}
+	pages, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Unexpected format of %s/statm: %v", procPath, err)
+	}
+	rss = pages * uint64(os.Getpagesize())
+
+	return cpu, rss, nil
+}
+
+// maxRSS gets the peak resident memory from a process's rusage, which linux
+// reports in KB.
+func maxRSS(usage *syscall.Rusage) uint64 {
+	return uint64(usage.Maxrss) * 1024
+}
@@ -0,0 +1,21 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package service
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+)
+
+// processUsage isn't supported on this platform.
+func processUsage(pid int) (cpu time.Duration, rss uint64, err error) {
+	return 0, 0, fmt.Errorf("Resource usage isn't supported on this platform")
+}
+
+// maxRSS gets the peak resident memory from a process's rusage, which most
+// BSDs report in KB.
+func maxRSS(usage *syscall.Rusage) uint64 {
+	return uint64(usage.Maxrss) * 1024
+}
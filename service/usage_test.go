@@ -0,0 +1,34 @@
+package service
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"unicode/utf8"
+)
+
+var _ = Describe("Sparkline", func() {
+	It("should draw the lowest & highest values as the shortest & tallest bars", func() {
+		Expect(Sparkline([]float64{0, 7, 3.5, 7}, 10)).To(Equal("▁█▄█"))
+	})
+
+	It("should draw all the same values as flat", func() {
+		Expect(Sparkline([]float64{5, 5, 5}, 10)).To(Equal("▁▁▁"))
+	})
+
+	It("should average values to fit the width", func() {
+		values := make([]float64, 100)
+		for i := range values {
+			values[i] = float64(i)
+		}
+
+		line := Sparkline(values, 10)
+		Expect(utf8.RuneCountInString(line)).To(Equal(10))
+		Expect(line).To(HavePrefix("▁"))
+		Expect(line).To(HaveSuffix("█"))
+	})
+
+	It("should be empty without values", func() {
+		Expect(Sparkline(nil, 10)).To(Equal(""))
+	})
+})
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"time"
+)
+
+// watchdogEnv gets env vars that tell a process where to ping its watchdog,
+// like systemd does for sd_notify(3), listening for them first if it isn't
+// already. Must be called with the state lock held.
+func (s *Service) watchdogEnv() ([]string, error) {
+	if s.notifySocket == nil {
+		if err := os.MkdirAll(s.notifySocketDir, 0700); err != nil {
+			return nil, fmt.Errorf("Failed to make notify socket dir: %v", err)
+		}
+
+		// Left over from a server that didn't clean up
+		address := path.Join(s.notifySocketDir, s.Conf.Name+".sock")
+		os.Remove(address)
+
+		conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: address, Net: "unixgram"})
+		if err != nil {
+			return nil, fmt.Errorf("Failed to listen for watchdog pings: %v", err)
+		}
+		s.notifySocket = conn
+
+		go s.readNotifications(conn)
+	}
+
+	return []string{
+		fmt.Sprintf("NOTIFY_SOCKET=%s", s.notifySocket.LocalAddr().String()),
+		fmt.Sprintf("WATCHDOG_USEC=%d", s.Conf.Watchdog/time.Microsecond),
+	}, nil
+}
+
+// readNotifications handles messages on the notify socket until it's closed.
+// Each one is newline separated assignments, of which only WATCHDOG=1 and
+// WATCHDOG=trigger mean anything here.
+func (s *Service) readNotifications(conn *net.UnixConn) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+
+		for _, line := range bytes.Split(buf[:n], []byte("\n")) {
+			switch string(line) {
+			case "WATCHDOG=1":
+				s.stateLock.Lock()
+				s.lastPing = time.Now()
+				s.stateLock.Unlock()
+			case "WATCHDOG=trigger":
+				s.log.Warn("Service triggered its watchdog")
+				s.stateLock.Lock()
+				s.watchdogTriggered = true
+				s.stateLock.Unlock()
+			}
+		}
+	}
+}
+
+// closeNotifySocket stops listening for watchdog pings, if it was. Must be
+// called with the state lock held.
+func (s *Service) closeNotifySocket() {
+	if s.notifySocket == nil {
+		return
+	}
+
+	address := s.notifySocket.LocalAddr().String()
+	s.notifySocket.Close()
+	s.notifySocket = nil
+	os.Remove(address)
+}
+
+// Hung is true if the service is running with a watchdog, and hasn't pinged
+// it within its watchdog time since it started, or last pinged, or it
+// triggered it.
+func (s *Service) Hung() bool {
+	s.stateLock.RLock()
+	defer s.stateLock.RUnlock()
+
+	if s.Conf.Watchdog <= 0 || !s.Running() {
+		return false
+	}
+
+	last := s.startTime
+	if s.lastPing.After(last) {
+		last = s.lastPing
+	}
+	return s.watchdogTriggered || time.Since(last) > s.Conf.Watchdog
+}
+
+// ResetWatchdog counts as a ping, like while a hung service is restarted, so
+// it isn't seen as hung again in the meantime.
+func (s *Service) ResetWatchdog() {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	s.lastPing = time.Now()
+	s.watchdogTriggered = false
+}
@@ -0,0 +1,99 @@
+//go:build !notray
+// +build !notray
+
+package tray
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/getlantern/systray"
+
+	"github.com/heewa/bento/plugin"
+	"github.com/heewa/bento/service"
+)
+
+var (
+	titleLock sync.Mutex
+
+	// The tray's title is an icon for whether anything's running, and a
+	// badge with how many services are in trouble, if any are
+	titleIcon  = idleIcon
+	troubled   = make(map[string]bool)
+	shownTitle string
+)
+
+// setIcon sets the icon part of the tray's title.
+func setIcon(icon string) {
+	titleLock.Lock()
+	defer titleLock.Unlock()
+
+	titleIcon = icon
+	updateTitle()
+}
+
+// watchEvents keeps track of services that failed, are flapping, or are
+// unhealthy, for the badge, until events is closed.
+func watchEvents(events <-chan plugin.Event) {
+	for event := range events {
+		func() {
+			titleLock.Lock()
+			defer titleLock.Unlock()
+
+			switch event.Kind {
+			case plugin.EventFailed, plugin.EventFlapping:
+				troubled[event.Service] = hasService(event.Service)
+			case plugin.EventHealth:
+				troubled[event.Service] = (event.Health == string(service.Unhealthy) || event.Flapping) && hasService(event.Service)
+			case plugin.EventStarted, plugin.EventExited:
+				// Restarting doesn't get a flapping service out of trouble
+				troubled[event.Service] = event.Flapping && hasService(event.Service)
+			default:
+				return
+			}
+
+			if !troubled[event.Service] {
+				delete(troubled, event.Service)
+			}
+			updateTitle()
+		}()
+	}
+}
+
+// forgetService takes a removed service out of the badge's count.
+func forgetService(name string) {
+	titleLock.Lock()
+	defer titleLock.Unlock()
+
+	if troubled[name] {
+		delete(troubled, name)
+		updateTitle()
+	}
+}
+
+// hasService is true if a service has an item in the tray, so an event that
+// comes in after it was removed doesn't count it again.
+func hasService(name string) bool {
+	itemLock.RLock()
+	defer itemLock.RUnlock()
+
+	for _, item := range serviceItems {
+		if item.info.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// updateTitle sets the tray's title, if it changed. Needs titleLock held.
+func updateTitle() {
+	title := titleIcon
+	if len(troubled) > 0 {
+		title = fmt.Sprintf("%s %d!", titleIcon, len(troubled))
+	}
+
+	if title != shownTitle {
+		shownTitle = title
+		systray.SetTitle(title)
+	}
+}
@@ -0,0 +1,189 @@
+//go:build !notray
+// +build !notray
+
+package tray
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/getlantern/systray"
+	log "github.com/inconshreveable/log15"
+)
+
+const (
+	copyPidTitle   = "Copy PID"
+	copyPidTooltip = "Copy a running service's pid to the clipboard"
+	copyCmdTitle   = "Copy command line"
+	copyCmdTooltip = "Copy a service's command line to the clipboard"
+)
+
+// copyChoice is a pair of submenu items to copy a service's pid & command
+// line. The one at each index is for the service item at the same index.
+type copyChoice struct {
+	pid   *systray.MenuItem
+	cmd   *systray.MenuItem
+	shown bool
+}
+
+var (
+	// Submenus to copy things about services, guarded by itemLock
+	copyPidItem *systray.MenuItem
+	copyCmdItem *systray.MenuItem
+	copyChoices []*copyChoice
+)
+
+// addCopyItems adds the copy submenus. Needs itemLock held.
+func addCopyItems() {
+	copyPidItem = systray.AddMenuItem(copyPidTitle, copyPidTooltip)
+	copyCmdItem = systray.AddMenuItem(copyCmdTitle, copyCmdTooltip)
+	copyPidItem.Disable()
+	copyCmdItem.Disable()
+	systray.AddSeparator()
+}
+
+// updateCopyItems makes the copy submenus match the service items, adding
+// choices when there are more services than ever before, and hiding extras,
+// since they can't be removed. Needs itemLock held.
+func updateCopyItems() {
+	if copyPidItem == nil {
+		return
+	}
+
+	for index, item := range serviceItems {
+		if index == len(copyChoices) {
+			choice := &copyChoice{
+				pid: copyPidItem.AddSubMenuItem("", ""),
+				cmd: copyCmdItem.AddSubMenuItem("", ""),
+			}
+			copyChoices = append(copyChoices, choice)
+			go handleCopyClick(choice.pid.ClickedCh, index, false)
+			go handleCopyClick(choice.cmd.ClickedCh, index, true)
+		}
+		choice := copyChoices[index]
+
+		setTitle(choice.pid, item.info.Name)
+		setTitle(choice.cmd, item.info.Name)
+		if item.info.Running {
+			setTooltip(choice.pid, strconv.Itoa(item.info.Pid))
+			choice.pid.Enable()
+		} else {
+			setTooltip(choice.pid, "Not running")
+			choice.pid.Disable()
+		}
+		setTooltip(choice.cmd, commandLine(item.info.Program, item.info.Args))
+
+		if !choice.shown {
+			choice.pid.Show()
+			choice.cmd.Show()
+			choice.shown = true
+		}
+	}
+
+	for _, choice := range copyChoices[len(serviceItems):] {
+		if choice.shown {
+			choice.pid.Hide()
+			choice.cmd.Hide()
+			choice.shown = false
+		}
+	}
+
+	if len(serviceItems) > 0 {
+		copyPidItem.Enable()
+		copyCmdItem.Enable()
+	} else {
+		copyPidItem.Disable()
+		copyCmdItem.Disable()
+	}
+}
+
+// handleCopyClick copies the pid, or command line, of whatever service is at
+// an index when a copy choice is clicked.
+func handleCopyClick(click <-chan interface{}, index int, cmd bool) {
+	for {
+		_, ok := <-click
+		if !ok {
+			return
+		}
+		log.Debug("Click on copy item", "index", index, "cmd", cmd)
+
+		var name, text string
+		func() {
+			itemLock.RLock()
+			defer itemLock.RUnlock()
+
+			if index >= len(serviceItems) {
+				return
+			}
+			info := serviceItems[index].info
+
+			name = info.Name
+			if cmd {
+				text = commandLine(info.Program, info.Args)
+			} else if info.Running {
+				text = strconv.Itoa(info.Pid)
+			}
+		}()
+		if text == "" {
+			continue
+		}
+
+		if err := copyToClipboard(text); err != nil {
+			// Goes through the lock, so outside of it
+			SetError(NewWarning(fmt.Sprintf("Failed to copy for %s", name), err))
+		}
+	}
+}
+
+// commandLine is a program & its args, quoted so it can be pasted into a
+// shell.
+func commandLine(program string, args []string) string {
+	words := []string{quoteWord(program)}
+	for _, arg := range args {
+		words = append(words, quoteWord(arg))
+	}
+	return strings.Join(words, " ")
+}
+
+// quoteWord quotes a word for a shell, if it needs to be.
+func quoteWord(word string) string {
+	if word != "" && !strings.ContainsAny(word, " \t\n'\"\\$`|&;<>()*?[]{}~#!") {
+		return word
+	}
+	return "'" + strings.Replace(word, "'", `'\''`, -1) + "'"
+}
+
+// copyToClipboard puts text on the clipboard with whichever tool for it the
+// system has.
+func copyToClipboard(text string) error {
+	tools := [][]string{
+		{"xclip", "-selection", "clipboard"},
+		{"xsel", "--clipboard", "--input"},
+	}
+	if runtime.GOOS == "darwin" {
+		tools = [][]string{{"pbcopy"}}
+	} else if os.Getenv("WAYLAND_DISPLAY") != "" {
+		tools = append([][]string{{"wl-copy"}}, tools...)
+	}
+
+	for _, tool := range tools {
+		if _, err := exec.LookPath(tool[0]); err != nil {
+			continue
+		}
+
+		cmd := exec.Command(tool[0], tool[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		// Some of these stay in the background to serve the clipboard, so
+		// don't wait on their output
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s failed: %v", tool[0], err)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("No clipboard tool found, like pbcopy, wl-copy, xclip or xsel")
+}
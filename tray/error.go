@@ -2,103 +2,67 @@ package tray
 
 import (
 	"fmt"
+)
+
+// Severity is how bad an Error is. A less severe error doesn't replace a
+// more severe one that's showing.
+type Severity int
+
+const (
+	// SeverityWarning is for things that went wrong, but don't need action
+	SeverityWarning Severity = iota
 
-	"github.com/getlantern/systray"
-	log "github.com/inconshreveable/log15"
+	// SeverityError is for things that need the user's attention
+	SeverityError
 )
 
 // Error is a an error that's meant for display as a menu item in the tray
 type Error struct {
-	title   string
-	tooltip string
+	severity Severity
+	title    string
+	tooltip  string
+
+	// How many times this same error was set in a row
+	count int
 }
 
 // NewError creates a new UI error.
 func NewError(title string, err error) *Error {
 	return &Error{
-		title:   fmt.Sprintf("[ERR] %s -- consult cmdline for more info", title),
-		tooltip: err.Error(),
+		severity: SeverityError,
+		title:    title,
+		tooltip:  err.Error(),
 	}
 }
 
-func (e *Error) Error() string {
-	return fmt.Sprintf("%s -- %s", e.title, e.tooltip)
-}
-
-// SetError creates or updates a menu item at the top with the error txt
-func SetError(err *Error) {
-	if err == nil {
-		ClearError()
-		return
-	}
-	log.Error("Setting menu error", "err", err)
-
-	itemLock.Lock()
-	defer itemLock.Unlock()
-
-	if errorItem == nil {
-		// Shuffle items down to use whatever's at the top as errorItem, starting
-		// at the bottom and working up
-
-		// If there are dead items, use one for quit, otherwise make a new quit
-		var newQuit *systray.MenuItem
-		if len(deadItems) > 0 {
-			newQuit, deadItems = deadItems[0], deadItems[1:]
-			newQuit.SetTooltip(quitTooltip)
-		} else {
-			newQuit = systray.AddMenuItem(quitTitle, quitTooltip)
-			go handleClick(newQuit.ClickedCh, len(serviceItems)+1)
-		}
-
-		// If there are service items, swap the first one with old quit item
-		if len(serviceItems) > 0 {
-			quitItem, serviceItems[0].menu = serviceItems[0].menu, quitItem
-			serviceItems[0].Set(serviceItems[0].info)
-		}
-
-		// The leftover goes to errorItem, and fixup quitItem
-		errorItem, quitItem = quitItem, newQuit
+// NewWarning creates a new UI error that's only a warning.
+func NewWarning(title string, err error) *Error {
+	return &Error{
+		severity: SeverityWarning,
+		title:    title,
+		tooltip:  err.Error(),
 	}
-
-	errorItem.SetTitle(err.title)
-	errorItem.SetTooltip(err.tooltip)
-	errorItem.Uncheck()
 }
 
-// ClearError clears the first item in the tray if it's an error by shuffling
-// items around, similarly to RemoveService()
-func ClearError() {
-	log.Debug("Clearing error in menu")
-
-	itemLock.Lock()
-	defer itemLock.Unlock()
+// same is true if two errors would show the same thing, other than the count.
+func (e *Error) same(other *Error) bool {
+	return e.severity == other.severity && e.title == other.title && e.tooltip == other.tooltip
+}
 
-	if errorItem == nil {
-		return
+// menuTitle is the text of the error's menu item.
+func (e *Error) menuTitle() string {
+	prefix := "[ERR]"
+	if e.severity == SeverityWarning {
+		prefix = "[WARN]"
 	}
 
-	var newDead *systray.MenuItem
-
-	lastIndex := len(serviceItems) - 1
-	if lastIndex < 0 {
-		// Since there are no service items, just shift up quit
-		// over error, and quit becomes dead
-		errorItem, quitItem, newDead = nil, errorItem, quitItem
-	} else {
-		// Similar to other case, but one more item to shift
-		errorItem, serviceItems[lastIndex].menu, quitItem, newDead = nil, errorItem, serviceItems[lastIndex].menu, quitItem
-
-		serviceItems[lastIndex].Set(serviceItems[lastIndex].info)
+	title := fmt.Sprintf("%s %s -- consult cmdline for more info", prefix, e.title)
+	if e.count > 1 {
+		title = fmt.Sprintf("%s (x%d)", title, e.count)
 	}
+	return title
+}
 
-	// Fix up quit & dead's texts
-	quitItem.SetTitle(quitTitle)
-	quitItem.SetTooltip(quitTooltip)
-	quitItem.Uncheck()
-	newDead.SetTitle("")
-	newDead.SetTooltip("")
-	newDead.Uncheck()
-
-	// Push newly created dead item to front of dead list
-	deadItems = append([]*systray.MenuItem{newDead}, deadItems...)
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s -- %s", e.menuTitle(), e.tooltip)
 }
@@ -0,0 +1,139 @@
+//go:build !notray
+// +build !notray
+
+package tray
+
+import (
+	"time"
+
+	"github.com/getlantern/systray"
+	log "github.com/inconshreveable/log15"
+
+	"github.com/heewa/bento/config"
+)
+
+var (
+	// The error that's showing, if any, and a count of errors set, so a
+	// timer to clear one can tell if it's been replaced since. Both are
+	// guarded by itemLock.
+	shownError *Error
+	errorGen   int
+)
+
+// SetError creates or updates a menu item at the top with the error txt. If
+// it's the same as the error that's showing, that one's count goes up instead.
+// It's cleared when clicked, or after config.TrayErrorClearAfter.
+func SetError(err *Error) {
+	if err == nil {
+		ClearError()
+		return
+	}
+	if err.severity == SeverityWarning {
+		log.Warn("Setting menu warning", "err", err)
+	} else {
+		log.Error("Setting menu error", "err", err)
+	}
+
+	itemLock.Lock()
+	defer itemLock.Unlock()
+
+	if shownError != nil && shownError.same(err) {
+		shownError.count++
+	} else if shownError != nil && shownError.severity > err.severity {
+		// Don't hide something worse, it's in the log anyway
+		return
+	} else {
+		shownError = &Error{
+			severity: err.severity,
+			title:    err.title,
+			tooltip:  err.tooltip,
+			count:    1,
+		}
+	}
+
+	errorGen++
+	if config.TrayErrorClearAfter > 0 {
+		gen := errorGen
+		time.AfterFunc(config.TrayErrorClearAfter, func() {
+			itemLock.Lock()
+			defer itemLock.Unlock()
+
+			// Leave it if it's been set again since
+			if gen == errorGen {
+				clearError()
+			}
+		})
+	}
+
+	if errorItem == nil {
+		// Shuffle items down to use whatever's at the top as errorItem, starting
+		// at the bottom and working up
+
+		// If there are dead items, use one for quit, otherwise make a new quit
+		var newQuit *systray.MenuItem
+		if len(deadItems) > 0 {
+			newQuit, deadItems = deadItems[0], deadItems[1:]
+			setTooltip(newQuit, quitTooltip)
+		} else {
+			newQuit = systray.AddMenuItem(quitTitle, quitTooltip)
+			go handleClick(newQuit.ClickedCh, len(serviceItems)+1)
+		}
+
+		// If there are service items, swap the first one with old quit item
+		if len(serviceItems) > 0 {
+			quitItem, serviceItems[0].menu = serviceItems[0].menu, quitItem
+			serviceItems[0].Set(serviceItems[0].info)
+		}
+
+		// The leftover goes to errorItem, and fixup quitItem
+		errorItem, quitItem = quitItem, newQuit
+	}
+
+	setTitle(errorItem, shownError.menuTitle())
+	setTooltip(errorItem, shownError.tooltip)
+	errorItem.Uncheck()
+}
+
+// ClearError clears the first item in the tray if it's an error by shuffling
+// items around, similarly to RemoveService()
+func ClearError() {
+	log.Debug("Clearing error in menu")
+
+	itemLock.Lock()
+	defer itemLock.Unlock()
+
+	clearError()
+}
+
+// clearError does the work of ClearError, with itemLock held.
+func clearError() {
+	shownError = nil
+	if errorItem == nil {
+		return
+	}
+
+	var newDead *systray.MenuItem
+
+	lastIndex := len(serviceItems) - 1
+	if lastIndex < 0 {
+		// Since there are no service items, just shift up quit
+		// over error, and quit becomes dead
+		errorItem, quitItem, newDead = nil, errorItem, quitItem
+	} else {
+		// Similar to other case, but one more item to shift
+		errorItem, serviceItems[lastIndex].menu, quitItem, newDead = nil, errorItem, serviceItems[lastIndex].menu, quitItem
+
+		serviceItems[lastIndex].Set(serviceItems[lastIndex].info)
+	}
+
+	// Fix up quit & dead's texts
+	setTitle(quitItem, quitTitle)
+	setTooltip(quitItem, quitTooltip)
+	quitItem.Uncheck()
+	setTitle(newDead, "")
+	setTooltip(newDead, "")
+	newDead.Uncheck()
+
+	// Push newly created dead item to front of dead list
+	deadItems = append([]*systray.MenuItem{newDead}, deadItems...)
+}
@@ -0,0 +1,36 @@
+//go:build notray
+// +build notray
+
+package tray
+
+import (
+	"github.com/heewa/bento/server"
+	"github.com/heewa/bento/service"
+)
+
+// Available is false when built without the system tray, in which case the
+// rest of this package does nothing.
+const Available = false
+
+// Init does nothing without a system tray
+func Init() {}
+
+// SetServer does nothing without a system tray
+func SetServer(serv *server.Server, serviceUpdates <-chan service.Info) error {
+	return nil
+}
+
+// Quit does nothing without a system tray
+func Quit() {}
+
+// SetService does nothing without a system tray
+func SetService(info service.Info) {}
+
+// RemoveService does nothing without a system tray
+func RemoveService(name string) {}
+
+// SetError does nothing without a system tray
+func SetError(err *Error) {}
+
+// ClearError does nothing without a system tray
+func ClearError() {}
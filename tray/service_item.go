@@ -1,11 +1,17 @@
+//go:build !notray
+// +build !notray
+
 package tray
 
 import (
 	"fmt"
 	"strings"
+	"time"
 
+	"github.com/dustin/go-humanize"
 	"github.com/getlantern/systray"
 
+	"github.com/heewa/bento/config"
 	"github.com/heewa/bento/service"
 )
 
@@ -17,11 +23,13 @@ type ServiceItem struct {
 
 // Set updates with Service info
 func (item *ServiceItem) Set(info service.Info) {
-	if info.Running || info.Succeeded || info.Pid == 0 {
-		item.menu.SetTitle(info.Name)
+	if !info.FlappingSince.IsZero() {
+		setTitle(item.menu, fmt.Sprintf("%s <flapping>", info.Name))
+	} else if info.Running || info.Succeeded || info.Pid == 0 {
+		setTitle(item.menu, info.Name)
 	} else {
 		// If it ran and failed, mention that in title
-		item.menu.SetTitle(fmt.Sprintf("%s <failed>", info.Name))
+		setTitle(item.menu, fmt.Sprintf("%s <failed>", info.Name))
 	}
 
 	if info.Running && !item.info.Running {
@@ -30,11 +38,38 @@ func (item *ServiceItem) Set(info service.Info) {
 		item.menu.Uncheck()
 	}
 
-	if len(info.Tail) > 0 {
-		item.menu.SetTooltip(strings.Join(info.Tail, "\n"))
-	} else {
-		item.menu.SetTooltip(info.PlainString())
-	}
+	setTooltip(item.menu, tooltip(info))
 
 	item.info = info
 }
+
+// tooltip gets what a service's tooltip shows, as set by the config
+func tooltip(info service.Info) string {
+	switch config.TrayTooltip {
+	case config.TrayTooltipHealth:
+		if !info.Running {
+			return "Not running"
+		} else if info.Health == service.Healthy {
+			return "Healthy"
+		} else if info.Health == service.Unhealthy {
+			return "Unhealthy, ready-when isn't met"
+		} else if info.ReadyWhen == nil {
+			return "Running, without a ready-when to check health with"
+		}
+		return "Health unknown"
+	case config.TrayTooltipUptime:
+		if info.Running {
+			return fmt.Sprintf("Up since %s (%s)", humanize.Time(info.StartTime), info.StartTime.Format("Jan 2 15:04"))
+		} else if info.Pid == 0 {
+			return "Hasn't run yet"
+		}
+		return fmt.Sprintf("Down since %s, after running for %s", humanize.Time(info.EndTime), info.Runtime.Round(time.Second))
+	case config.TrayTooltipInfo:
+		return info.PlainString()
+	}
+
+	if len(info.Tail) > 0 {
+		return strings.Join(info.Tail, "\n")
+	}
+	return info.PlainString()
+}
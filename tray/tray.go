@@ -1,3 +1,6 @@
+//go:build !notray
+// +build !notray
+
 package tray
 
 import (
@@ -11,6 +14,10 @@ import (
 	"github.com/heewa/bento/service"
 )
 
+// Available is true when built with the system tray. Build with the notray
+// tag to leave it out, for headless machines without a GUI toolkit.
+const Available = true
+
 const (
 	activeIcon  = "🍱"
 	idleIcon    = "🍚"
@@ -30,6 +37,11 @@ var (
 	serviceItems []*ServiceItem
 	quitItem     *systray.MenuItem
 	deadItems    []*systray.MenuItem
+
+	// Last text set on each menu item, so updates that don't change what's
+	// shown don't touch the tray, which flickers and burns CPU.
+	menuTitles   = make(map[*systray.MenuItem]string)
+	menuTooltips = make(map[*systray.MenuItem]string)
 )
 
 // Init starts running the system tray. It's required before using this package
@@ -43,7 +55,7 @@ func Init() {
 
 		go systray.Run(func() {
 			// TODO: icon instead of title
-			systray.SetTitle(idleIcon)
+			setIcon(idleIcon)
 			systray.SetTooltip(mainTooltip)
 
 			// TODO: revive without dead items
@@ -51,6 +63,8 @@ func Init() {
 			itemLock.Lock()
 			defer itemLock.Unlock()
 
+			addCopyItems()
+
 			quitItem = systray.AddMenuItem(quitTitle, quitTooltip)
 			go handleClick(quitItem.ClickedCh, 0)
 
@@ -73,7 +87,8 @@ func SetServer(serv *server.Server, serviceUpdates <-chan service.Info) error {
 
 	srvr = serv
 
-	currentIcon := idleIcon
+	// Watch for services in trouble, for the title's badge
+	go watchEvents(serv.Events())
 
 	// Watch for service changes
 	go func() {
@@ -85,41 +100,33 @@ func SetServer(serv *server.Server, serviceUpdates <-chan service.Info) error {
 
 			if info.Dead {
 				RemoveService(info.Name)
+				forgetService(info.Name)
 			} else {
 				SetService(info)
 			}
 
 			// If any services are running, set title to the active icon,
 			// otherwise the idle one.
-			func() {
-				// Shortcut if this updated service is running
-				if info.Running {
-					if currentIcon != activeIcon {
-						currentIcon = activeIcon
-						systray.SetTitle(activeIcon)
-					}
-					return
-				}
-
-				itemLock.RLock()
-				defer itemLock.RUnlock()
-
-				for _, item := range serviceItems {
-					if item.info.Running {
-						if currentIcon != activeIcon {
-							currentIcon = activeIcon
-							systray.SetTitle(activeIcon)
+			running := info.Running
+			if !running {
+				func() {
+					itemLock.RLock()
+					defer itemLock.RUnlock()
+
+					for _, item := range serviceItems {
+						if item.info.Running {
+							running = true
+							return
 						}
-						return
 					}
-				}
+				}()
+			}
 
-				// None of them were running; set to idle
-				if currentIcon != idleIcon {
-					currentIcon = idleIcon
-					systray.SetTitle(idleIcon)
-				}
-			}()
+			if running {
+				setIcon(activeIcon)
+			} else {
+				setIcon(idleIcon)
+			}
 		}
 	}()
 
@@ -137,8 +144,13 @@ func Quit() {
 
 	errorItem = nil
 	serviceItems = nil
+	copyPidItem = nil
+	copyCmdItem = nil
+	copyChoices = nil
 	quitItem = nil
 	deadItems = nil
+	menuTitles = make(map[*systray.MenuItem]string)
+	menuTooltips = make(map[*systray.MenuItem]string)
 
 	srvr = nil
 
@@ -149,6 +161,7 @@ func Quit() {
 func SetService(info service.Info) {
 	itemLock.Lock()
 	defer itemLock.Unlock()
+	defer updateCopyItems()
 
 	// See if it exists already to update
 	for _, item := range serviceItems {
@@ -168,8 +181,8 @@ func SetService(info service.Info) {
 	// If there are dead slots, use one for Quit
 	if len(deadItems) > 0 {
 		quitItem, deadItems = deadItems[0], deadItems[1:]
-		quitItem.SetTitle(quitTitle)
-		quitItem.SetTooltip(quitTooltip)
+		setTitle(quitItem, quitTitle)
+		setTooltip(quitItem, quitTooltip)
 	} else {
 		quitItem = systray.AddMenuItem(quitTitle, quitTooltip)
 
@@ -187,6 +200,7 @@ func RemoveService(name string) {
 	// it out and swap with the end of the list
 	itemLock.Lock()
 	defer itemLock.Unlock()
+	defer updateCopyItems()
 
 	// Find the item
 	index := -1
@@ -214,20 +228,42 @@ func RemoveService(name string) {
 	}
 
 	// Clear and add current Quit to dead items
-	quitItem.SetTitle("")
-	quitItem.SetTooltip("")
+	setTitle(quitItem, "")
+	setTooltip(quitItem, "")
 	deadItems = append([]*systray.MenuItem{quitItem}, deadItems...)
 
 	// Use lastIndex for Quit
 	quitItem = serviceItems[lastIndex].menu
-	quitItem.SetTitle(quitTitle)
-	quitItem.SetTooltip(quitTooltip)
+	setTitle(quitItem, quitTitle)
+	setTooltip(quitItem, quitTooltip)
 	quitItem.Uncheck()
 
 	// Remove last service item from slice
 	serviceItems = serviceItems[:lastIndex]
 }
 
+// setTitle sets a menu item's title, if it's changed. Must be called with
+// itemLock held.
+func setTitle(item *systray.MenuItem, title string) {
+	if current, ok := menuTitles[item]; ok && current == title {
+		return
+	}
+
+	menuTitles[item] = title
+	item.SetTitle(title)
+}
+
+// setTooltip sets a menu item's tooltip, if it's changed. Must be called with
+// itemLock held.
+func setTooltip(item *systray.MenuItem, tooltip string) {
+	if current, ok := menuTooltips[item]; ok && current == tooltip {
+		return
+	}
+
+	menuTooltips[item] = tooltip
+	item.SetTooltip(tooltip)
+}
+
 // Since items change roles over time, look up logical item at each click
 func handleClick(click <-chan interface{}, index int) {
 	for {
@@ -276,7 +312,7 @@ func handleClick(click <-chan interface{}, index int) {
 					go Quit()
 				} else {
 					var nothing bool
-					if err := srvr.Exit(nothing, &nothing); err != nil {
+					if err := srvr.Exit(server.ExitArgs{Reason: "quit from tray"}, &nothing); err != nil {
 						log.Error("Failed to exit server", "err", err)
 
 						// Since server won't be exitting and quitting the